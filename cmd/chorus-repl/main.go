@@ -0,0 +1,85 @@
+// Command chorus-repl evaluates interpolations, expressions, transformations and templates against a case recorded with WfCase.GetHarData, e.g.
+//
+//	chorus-repl -har case.har
+//
+// The process vars are restored from the har when it has been written by WfCase.WriteHarFixture (i.e. the har-record-folder property of the
+// orchestration), the hars of the reporters do not carry them. The optional vars file is a json object with values that are added to them or
+// replace the recorded ones, e.g.
+//
+//	chorus-repl -har case.har -vars vars.json
+//
+// The environment variables referenced by the expressions are read according to the policy set by -env-secure and -env-allow, e.g.
+//
+//	chorus-repl -har case.har -env-secure -env-allow REGION,APP_*
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfrepl"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms/kz"
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	harFile := flag.String("har", "", "har file of the recorded case")
+	varsFile := flag.String("vars", "", "json file with process vars that override the recorded ones")
+	lang := flag.String("lang", wfexpressions.ExpressionLanguageGval, "default expression language (gval, cel)")
+	logLevel := flag.String("log-level", "error", "log level")
+	envSecure := flag.Bool("env-secure", false, "resolve only the env: references to the allowed environment variables")
+	envAllow := flag.String("env-allow", "", "comma separated names of the environment variables that can be read, a trailing * matches a prefix")
+	flag.Parse()
+
+	policy := wfexpressions.EnvVarsPolicy{SecureMode: *envSecure}
+	if *envAllow != "" {
+		policy.Allowed = strings.Split(*envAllow, ",")
+	}
+	wfexpressions.SetEnvVarsPolicy(policy)
+
+	if err := run(*harFile, *varsFile, *lang, *logLevel); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(harFile, varsFile, lang, logLevel string) error {
+	lvl, err := zerolog.ParseLevel(logLevel)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(lvl)
+
+	if harFile == "" {
+		return fmt.Errorf("the har file is mandatory")
+	}
+
+	var vars map[string]interface{}
+	if varsFile != "" {
+		b, err := os.ReadFile(varsFile)
+		if err != nil {
+			return err
+		}
+
+		if err = json.Unmarshal(b, &vars); err != nil {
+			return fmt.Errorf("invalid vars file %s: %w", varsFile, err)
+		}
+	}
+
+	if err = kz.InitializeKazaamRegistry(); err != nil {
+		return err
+	}
+
+	wfc, err := wfcase.NewWorkflowCaseFromHarFile(harFile, vars)
+	if err != nil {
+		return err
+	}
+	wfc.Vars.WithExpressionLanguage(lang)
+
+	return wfrepl.NewSession(wfc, os.Stdout).Run(os.Stdin)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cachelks"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/fileutil"
 	"github.com/rs/zerolog/log"
@@ -77,19 +78,64 @@ func NewCacheActivityFromYAML(b []byte /* mp interface{}*/) (Configurable, error
 }
 
 const (
-	CacheOperationSet = "set"
-	CacheOperationGet = "get"
+	CacheOperationSet      = "set"
+	CacheOperationGet      = "get"
+	CacheOperationDelete   = "delete"
+	CacheOperationExists   = "exists"
+	CacheOperationIncr     = "incr"
+	CacheOperationDecr     = "decr"
+	CacheOperationMultiGet = "multi-get"
+	CacheOperationMultiSet = "multi-set"
+	CacheOperationGetOrSet = "get-or-set"
 )
 
+type CacheItemDefinition struct {
+	Key   string `yaml:"key,omitempty" mapstructure:"key,omitempty" json:"key,omitempty"`
+	Value string `yaml:"value,omitempty" mapstructure:"value,omitempty" json:"value,omitempty"`
+}
+
 type CacheActivityDefinition struct {
 	Operation         string                         `yaml:"op-type,omitempty" json:"op-type,omitempty" mapstructure:"op-type,omitempty"`
 	Key               string                         `yaml:"key,omitempty" mapstructure:"key,omitempty" json:"key,omitempty"`
+	Keys              []string                       `yaml:"keys,omitempty" mapstructure:"keys,omitempty" json:"keys,omitempty"`
+	Items             []CacheItemDefinition          `yaml:"items,omitempty" mapstructure:"items,omitempty" json:"items,omitempty"`
+	Delta             int64                          `yaml:"delta,omitempty" mapstructure:"delta,omitempty" json:"delta,omitempty"`
+	OrchestrationId   string                         `yaml:"orchestration-id,omitempty" mapstructure:"orchestration-id,omitempty" json:"orchestration-id,omitempty"`
 	Namespace         string                         `json:"namespace,omitempty" yaml:"namespace,omitempty" mapstructure:"namespace,omitempty"`
 	Ttl               time.Duration                  `yaml:"ttl,omitempty" mapstructure:"ttl,omitempty" json:"ttl,omitempty"`
 	LinkedServiceRef  cachelks.CacheLinkedServiceRef `yaml:"broker,omitempty" mapstructure:"broker,omitempty" json:"broker,omitempty"`
 	OnResponseActions OnResponseActions              `yaml:"on-response,omitempty" json:"on-response,omitempty" mapstructure:"on-response,omitempty"`
 }
 
+func (def *CacheActivityDefinition) Validate() error {
+	switch def.Operation {
+	case CacheOperationGet, CacheOperationSet:
+		if def.Key == "" {
+			return fmt.Errorf("key is mandatory for %s operation", def.Operation)
+		}
+	case CacheOperationDelete, CacheOperationExists, CacheOperationIncr, CacheOperationDecr:
+		if def.Key == "" {
+			return fmt.Errorf("key is mandatory for %s operation", def.Operation)
+		}
+	case CacheOperationMultiGet:
+		if len(def.Keys) == 0 {
+			return fmt.Errorf("keys are mandatory for %s operation", def.Operation)
+		}
+	case CacheOperationMultiSet:
+		if len(def.Items) == 0 {
+			return fmt.Errorf("items are mandatory for %s operation", def.Operation)
+		}
+	case CacheOperationGetOrSet:
+		if def.Key == "" || def.OrchestrationId == "" {
+			return fmt.Errorf("key and orchestration-id are mandatory for %s operation", def.Operation)
+		}
+	default:
+		return fmt.Errorf("unknown cache operation %s", def.Operation)
+	}
+
+	return nil
+}
+
 func (def *CacheActivityDefinition) IsZero() bool {
 	return true
 }
@@ -133,6 +179,16 @@ func UnmarshalCacheActivityDefinition(schemaNamespace, def string, refs DataRefe
 		if err != nil {
 			return maDef, err
 		}
+
+		if maDef.Delta == 0 {
+			maDef.Delta = 1
+		}
+
+		err = maDef.Validate()
+		if err != nil {
+			log.Error().Err(err).Str("def", def).Msg(semLogContext)
+			return maDef, err
+		}
 	}
 
 	return maDef, nil
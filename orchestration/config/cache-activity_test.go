@@ -0,0 +1,36 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheActivityDefinition(t *testing.T) {
+	refs := config.DataReferences{
+		{Path: "incr.yml", Data: []byte("op-type: incr\nkey: counter\nttl: 1m\n")},
+		{Path: "incr-ns.yml", Data: []byte("op-type: incr\nkey: counter\nnamespace: rate-limits\n")},
+		{Path: "get-ns.yml", Data: []byte("op-type: get\nkey: movie\nnamespace: movies\n")},
+		{Path: "multi-get.yml", Data: []byte("op-type: multi-get\n")},
+		{Path: "get-or-set.yml", Data: []byte("op-type: get-or-set\nkey: movie\n")},
+		{Path: "unknown.yml", Data: []byte("op-type: append\nkey: movie\n")},
+	}
+
+	def, err := config.UnmarshalCacheActivityDefinition("", "incr.yml", refs)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), def.Delta)
+
+	def, err = config.UnmarshalCacheActivityDefinition("", "incr-ns.yml", refs)
+	require.NoError(t, err)
+	require.Equal(t, "rate-limits", def.Namespace)
+
+	def, err = config.UnmarshalCacheActivityDefinition("", "get-ns.yml", refs)
+	require.NoError(t, err)
+	require.Equal(t, "movies", def.Namespace)
+
+	for _, n := range []string{"multi-get.yml", "get-or-set.yml", "unknown.yml"} {
+		_, err = config.UnmarshalCacheActivityDefinition("", n, refs)
+		require.Error(t, err, n)
+	}
+}
@@ -0,0 +1,460 @@
+package config
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/fileutil"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DecisionTableHitPolicyFirst   = "first"
+	DecisionTableHitPolicyUnique  = "unique"
+	DecisionTableHitPolicyCollect = "collect"
+
+	DecisionTableCsvInputColumnPrefix  = "in:"
+	DecisionTableCsvOutputColumnPrefix = "out:"
+
+	// DecisionTableAnyValue the cell matches whatever the value of the input. An empty cell has the same meaning.
+	DecisionTableAnyValue = "-"
+)
+
+type DecisionTableInput struct {
+	Name string `yaml:"name,omitempty" json:"name,omitempty" mapstructure:"name,omitempty"`
+	// Expression gval expression evaluated against the process vars to get the value of the input. Defaults to the name of the input.
+	Expression string `yaml:"expression,omitempty" json:"expression,omitempty" mapstructure:"expression,omitempty"`
+}
+
+func (in DecisionTableInput) InputExpression() string {
+	if in.Expression != "" {
+		return in.Expression
+	}
+
+	return in.Name
+}
+
+type DecisionTableOutput struct {
+	// Name of the process var set with the value of the output.
+	Name string `yaml:"name,omitempty" json:"name,omitempty" mapstructure:"name,omitempty"`
+	// Default value used when no rule matches. Like the output cells it follows the process vars value conventions.
+	Default string `yaml:"default,omitempty" json:"default,omitempty" mapstructure:"default,omitempty"`
+}
+
+// DecisionTableRule the When cells are unary tests on the corresponding inputs: a literal ('"gold"', '100', 'true'), a comparison
+// ('> 1000', '!= "x"'), a range ('[1..10]', '(0..100]'), '-' for any value or a plain gval condition on the process vars. The Then cells are
+// interpolated and evaluated as the value of a process var.
+type DecisionTableRule struct {
+	Description string   `yaml:"description,omitempty" json:"description,omitempty" mapstructure:"description,omitempty"`
+	When        []string `yaml:"when,omitempty" json:"when,omitempty" mapstructure:"when,omitempty"`
+	Then        []string `yaml:"then,omitempty" json:"then,omitempty" mapstructure:"then,omitempty"`
+
+	conditions []string
+	tests      []unaryTest
+}
+
+// Conditions returns the gval conditions computed from the When cells. Cells matching any value have an empty condition.
+func (r DecisionTableRule) Conditions() []string {
+	return r.conditions
+}
+
+type DecisionTable struct {
+	HitPolicy string                `yaml:"hit-policy,omitempty" json:"hit-policy,omitempty" mapstructure:"hit-policy,omitempty"`
+	Inputs    []DecisionTableInput  `yaml:"inputs,omitempty" json:"inputs,omitempty" mapstructure:"inputs,omitempty"`
+	Outputs   []DecisionTableOutput `yaml:"outputs,omitempty" json:"outputs,omitempty" mapstructure:"outputs,omitempty"`
+	Rules     []DecisionTableRule   `yaml:"rules,omitempty" json:"rules,omitempty" mapstructure:"rules,omitempty"`
+}
+
+// NewDecisionTableFromCSV reads a table whose header declares the input columns as 'in:<expression>' and the output columns as 'out:<var-name>'.
+// Every other row is a rule.
+func NewDecisionTableFromCSV(data []byte) (DecisionTable, error) {
+	dt := DecisionTable{}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+	records, err := r.ReadAll()
+	if err != nil {
+		return dt, err
+	}
+
+	if len(records) == 0 {
+		return dt, errors.New("decision table is empty")
+	}
+
+	var isInput []bool
+	for _, h := range records[0] {
+		h = strings.TrimSpace(h)
+		switch {
+		case strings.HasPrefix(h, DecisionTableCsvInputColumnPrefix):
+			n := strings.TrimSpace(strings.TrimPrefix(h, DecisionTableCsvInputColumnPrefix))
+			dt.Inputs = append(dt.Inputs, DecisionTableInput{Name: n})
+			isInput = append(isInput, true)
+		case strings.HasPrefix(h, DecisionTableCsvOutputColumnPrefix):
+			n := strings.TrimSpace(strings.TrimPrefix(h, DecisionTableCsvOutputColumnPrefix))
+			dt.Outputs = append(dt.Outputs, DecisionTableOutput{Name: n})
+			isInput = append(isInput, false)
+		default:
+			return dt, fmt.Errorf("decision table column %s is neither an input nor an output", h)
+		}
+	}
+
+	for _, rec := range records[1:] {
+		rule := DecisionTableRule{}
+		for i, cell := range rec {
+			if isInput[i] {
+				rule.When = append(rule.When, strings.TrimSpace(cell))
+			} else {
+				rule.Then = append(rule.Then, strings.TrimSpace(cell))
+			}
+		}
+		dt.Rules = append(dt.Rules, rule)
+	}
+
+	return dt, nil
+}
+
+// Compile validates the table and computes the conditions of the rules. When the hit policy is unique the rules are checked for overlaps:
+// two rules overlap unless at least one of their inputs has provably disjoint cells. Cells expressed as plain gval conditions cannot be
+// analyzed and are considered to overlap with everything.
+func (dt *DecisionTable) Compile() error {
+	switch dt.HitPolicy {
+	case DecisionTableHitPolicyFirst, DecisionTableHitPolicyUnique, DecisionTableHitPolicyCollect:
+	default:
+		return fmt.Errorf("unsupported hit-policy %s", dt.HitPolicy)
+	}
+
+	if len(dt.Inputs) == 0 || len(dt.Outputs) == 0 {
+		return errors.New("decision table must have at least an input and an output")
+	}
+
+	for i, in := range dt.Inputs {
+		if in.InputExpression() == "" {
+			return fmt.Errorf("decision table input #%d has no name", i)
+		}
+	}
+
+	for i, out := range dt.Outputs {
+		if out.Name == "" {
+			return fmt.Errorf("decision table output #%d has no name", i)
+		}
+	}
+
+	for i := range dt.Rules {
+		r := &dt.Rules[i]
+		if len(r.When) != len(dt.Inputs) || len(r.Then) != len(dt.Outputs) {
+			return fmt.Errorf("decision table rule #%d has %d inputs and %d outputs, expected %d and %d", i, len(r.When), len(r.Then), len(dt.Inputs), len(dt.Outputs))
+		}
+
+		r.conditions = make([]string, len(r.When))
+		r.tests = make([]unaryTest, len(r.When))
+		for j, cell := range r.When {
+			t, err := parseUnaryTest(cell)
+			if err != nil {
+				return fmt.Errorf("decision table rule #%d, input %s: %w", i, dt.Inputs[j].Name, err)
+			}
+
+			r.tests[j] = t
+			r.conditions[j] = t.condition(dt.Inputs[j].InputExpression())
+		}
+	}
+
+	if dt.HitPolicy == DecisionTableHitPolicyUnique {
+		for i := 0; i < len(dt.Rules); i++ {
+			for j := i + 1; j < len(dt.Rules); j++ {
+				if dt.Rules[i].overlaps(dt.Rules[j]) {
+					return fmt.Errorf("decision table with unique hit-policy has overlapping rules #%d and #%d", i, j)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r DecisionTableRule) overlaps(other DecisionTableRule) bool {
+	for i := range r.tests {
+		if r.tests[i].disjoint(other.tests[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type unaryTestKind int
+
+const (
+	unaryTestAny unaryTestKind = iota
+	unaryTestEqual
+	unaryTestNotEqual
+	unaryTestInterval
+	unaryTestExpression
+)
+
+type unaryTest struct {
+	kind       unaryTestKind
+	operator   string
+	operand    string
+	value      interface{}
+	lo, hi     float64
+	loIncluded bool
+	hiIncluded bool
+}
+
+func parseUnaryTest(cell string) (unaryTest, error) {
+	cell = strings.TrimSpace(cell)
+	if cell == "" || cell == DecisionTableAnyValue {
+		return unaryTest{kind: unaryTestAny}, nil
+	}
+
+	if (strings.HasPrefix(cell, "[") || strings.HasPrefix(cell, "(")) && (strings.HasSuffix(cell, "]") || strings.HasSuffix(cell, ")")) && strings.Contains(cell, "..") {
+		bounds := strings.SplitN(cell[1:len(cell)-1], "..", 2)
+		lo, err := strconv.ParseFloat(strings.TrimSpace(bounds[0]), 64)
+		if err != nil {
+			return unaryTest{}, fmt.Errorf("invalid range %s", cell)
+		}
+		hi, err := strconv.ParseFloat(strings.TrimSpace(bounds[1]), 64)
+		if err != nil || hi < lo {
+			return unaryTest{}, fmt.Errorf("invalid range %s", cell)
+		}
+		return unaryTest{kind: unaryTestInterval, lo: lo, hi: hi, loIncluded: cell[0] == '[', hiIncluded: cell[len(cell)-1] == ']'}, nil
+	}
+
+	for _, op := range []string{">=", "<=", "!=", "==", ">", "<"} {
+		if strings.HasPrefix(cell, op) {
+			operand := strings.TrimSpace(strings.TrimPrefix(cell, op))
+			v, ok := parseLiteral(operand)
+			if !ok {
+				return unaryTest{kind: unaryTestExpression, operator: op, operand: operand}, nil
+			}
+			return newComparisonTest(op, operand, v), nil
+		}
+	}
+
+	if v, ok := parseLiteral(cell); ok {
+		return newComparisonTest("==", cell, v), nil
+	}
+
+	return unaryTest{kind: unaryTestExpression, operand: cell}, nil
+}
+
+func newComparisonTest(op string, operand string, v interface{}) unaryTest {
+	t := unaryTest{operator: op, operand: operand, value: v, lo: math.Inf(-1), hi: math.Inf(1)}
+	switch op {
+	case "==":
+		t.kind = unaryTestEqual
+	case "!=":
+		t.kind = unaryTestNotEqual
+	default:
+		f, ok := v.(float64)
+		if !ok {
+			t.kind = unaryTestExpression
+			return t
+		}
+
+		t.kind = unaryTestInterval
+		switch op {
+		case ">":
+			t.lo = f
+		case ">=":
+			t.lo, t.loIncluded = f, true
+		case "<":
+			t.hi = f
+		case "<=":
+			t.hi, t.hiIncluded = f, true
+		}
+	}
+
+	return t
+}
+
+func parseLiteral(s string) (interface{}, bool) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, true
+	}
+
+	if s == "true" || s == "false" {
+		return s == "true", true
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		if u, err := strconv.Unquote(s); err == nil {
+			return u, true
+		}
+	}
+
+	return nil, false
+}
+
+func (t unaryTest) condition(input string) string {
+	switch t.kind {
+	case unaryTestAny:
+		return ""
+	case unaryTestInterval:
+		if t.operator != "" {
+			return fmt.Sprintf("(%s) %s %s", input, t.operator, t.operand)
+		}
+
+		loOp, hiOp := ">", "<"
+		if t.loIncluded {
+			loOp = ">="
+		}
+		if t.hiIncluded {
+			hiOp = "<="
+		}
+		return fmt.Sprintf("(%s) %s %s && (%s) %s %s", input, loOp, strconv.FormatFloat(t.lo, 'f', -1, 64), input, hiOp, strconv.FormatFloat(t.hi, 'f', -1, 64))
+	case unaryTestExpression:
+		if t.operator == "" {
+			return t.operand
+		}
+	}
+
+	return fmt.Sprintf("(%s) %s %s", input, t.operator, t.operand)
+}
+
+func (t unaryTest) contains(f float64) bool {
+	if f < t.lo || (f == t.lo && !t.loIncluded) {
+		return false
+	}
+
+	if f > t.hi || (f == t.hi && !t.hiIncluded) {
+		return false
+	}
+
+	return true
+}
+
+// disjoint is true only when no value can satisfy both tests.
+func (t unaryTest) disjoint(other unaryTest) bool {
+	switch {
+	case t.kind == unaryTestEqual && other.kind == unaryTestEqual:
+		return t.value != other.value
+	case t.kind == unaryTestEqual && other.kind == unaryTestNotEqual:
+		return t.value == other.value
+	case t.kind == unaryTestNotEqual && other.kind == unaryTestEqual:
+		return other.disjoint(t)
+	case t.kind == unaryTestEqual && other.kind == unaryTestInterval:
+		f, ok := t.value.(float64)
+		return ok && !other.contains(f)
+	case t.kind == unaryTestInterval && other.kind == unaryTestEqual:
+		return other.disjoint(t)
+	case t.kind == unaryTestInterval && other.kind == unaryTestInterval:
+		lo, loIncluded := t.lo, t.loIncluded
+		if other.lo > lo || (other.lo == lo && !other.loIncluded) {
+			lo, loIncluded = other.lo, other.loIncluded
+		}
+
+		hi, hiIncluded := t.hi, t.hiIncluded
+		if other.hi < hi || (other.hi == hi && !other.hiIncluded) {
+			hi, hiIncluded = other.hi, other.hiIncluded
+		}
+
+		return lo > hi || (lo == hi && !(loIncluded && hiIncluded))
+	}
+
+	return false
+}
+
+type DecisionTableActivityDefinition struct {
+	// Table is the reference to a bundle asset (.csv, .yml or .yaml) holding the table. If empty the table is declared inline.
+	Table             string `yaml:"table,omitempty" json:"table,omitempty" mapstructure:"table,omitempty"`
+	DecisionTable     `yaml:",inline" json:",inline"`
+	OnResponseActions OnResponseActions `yaml:"on-response,omitempty" json:"on-response,omitempty" mapstructure:"on-response,omitempty"`
+}
+
+func (def *DecisionTableActivityDefinition) WriteToFile(folderName string, fileName string, writeOpts ...fileutil.WriteOption) error {
+	const semLogContext = "decision-table-activity-definition::write-to-file"
+	fn := filepath.Join(folderName, fileName)
+	log.Info().Str("file-name", fn).Msg(semLogContext)
+	b, err := yaml.Marshal(def)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return err
+	}
+
+	err = fileutil.WriteFile(fn, b, os.ModePerm, writeOpts...)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return err
+	}
+
+	return nil
+}
+
+func UnmarshalDecisionTableActivityDefinition(def string, refs DataReferences) (DecisionTableActivityDefinition, error) {
+	const semLogContext = "decision-table-activity-definition::unmarshal"
+
+	var err error
+	dtDef := DecisionTableActivityDefinition{}
+
+	data, ok := refs.Find(def)
+	if len(data) == 0 || !ok {
+		err = errors.New("cannot find activity definition")
+		log.Error().Err(err).Str("def", def).Msg(semLogContext)
+		return dtDef, err
+	}
+
+	err = yaml.Unmarshal(data, &dtDef)
+	if err != nil {
+		log.Error().Err(err).Str("def", def).Msg(semLogContext)
+		return dtDef, err
+	}
+
+	if dtDef.Table != "" {
+		err = dtDef.loadTable(refs)
+		if err != nil {
+			log.Error().Err(err).Str("def", def).Str("table", dtDef.Table).Msg(semLogContext)
+			return dtDef, err
+		}
+	}
+
+	if dtDef.HitPolicy == "" {
+		dtDef.HitPolicy = DecisionTableHitPolicyFirst
+	}
+
+	err = dtDef.Compile()
+	if err != nil {
+		log.Error().Err(err).Str("def", def).Msg(semLogContext)
+		return dtDef, err
+	}
+
+	return dtDef, nil
+}
+
+// loadTable reads the table from the asset. The hit-policy of the definition, if any, takes precedence over the one of the asset.
+func (def *DecisionTableActivityDefinition) loadTable(refs DataReferences) error {
+	data, ok := refs.Find(def.Table)
+	if len(data) == 0 || !ok {
+		return errors.New("cannot find decision table")
+	}
+
+	var dt DecisionTable
+	var err error
+	switch strings.ToLower(filepath.Ext(def.Table)) {
+	case ".csv":
+		dt, err = NewDecisionTableFromCSV(data)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &dt)
+	default:
+		err = fmt.Errorf("unsupported decision table format %s", filepath.Ext(def.Table))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if def.HitPolicy != "" {
+		dt.HitPolicy = def.HitPolicy
+	}
+	def.DecisionTable = dt
+	return nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+)
+
+type DecisionTableActivity struct {
+	Activity `yaml:",inline" json:",inline"`
+}
+
+func (c *DecisionTableActivity) WithName(n string) *DecisionTableActivity {
+	c.Nm = n
+	return c
+}
+
+func (c *DecisionTableActivity) WithActor(n string) *DecisionTableActivity {
+	c.Actr = n
+	return c
+}
+
+func (c *DecisionTableActivity) WithDescription(n string) *DecisionTableActivity {
+	c.Cm = n
+	return c
+}
+
+func (c *DecisionTableActivity) WithExpressionContext(n string) *DecisionTableActivity {
+	c.ExprContextName = n
+	return c
+}
+
+func (c *DecisionTableActivity) WithRefDefinition(n string) *DecisionTableActivity {
+	c.Definition = n
+	return c
+}
+
+func (c *DecisionTableActivity) Dup(newName string) *DecisionTableActivity {
+	actNew := DecisionTableActivity{
+		Activity: c.Activity.Dup(newName),
+	}
+
+	return &actNew
+}
+
+func NewDecisionTableActivity() *DecisionTableActivity {
+	s := DecisionTableActivity{}
+	s.Tp = DecisionTableActivityType
+	return &s
+}
+
+func NewDecisionTableActivityFromJSON(message json.RawMessage) (Configurable, error) {
+	i := NewDecisionTableActivity()
+	err := json.Unmarshal(message, i)
+	if err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func NewDecisionTableActivityFromYAML(b []byte /* mp interface{}*/) (Configurable, error) {
+	sa := NewDecisionTableActivity()
+	err := yaml.Unmarshal(b, sa)
+	if err != nil {
+		return nil, err
+	}
+
+	return sa, nil
+}
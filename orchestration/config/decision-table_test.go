@@ -0,0 +1,53 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/stretchr/testify/require"
+)
+
+const feesDecisionTable = `in:segment,in:amount,out:fee,out:channel
+"""gold""",-,0,priority
+"""silver""",[0..1000],:1.5,standard
+"""silver""",> 1000,:1,standard
+"""bronze""",< 500,:3,standard
+`
+
+func TestDecisionTableFromCSV(t *testing.T) {
+	dt, err := config.NewDecisionTableFromCSV([]byte(feesDecisionTable))
+	require.NoError(t, err)
+	require.Len(t, dt.Inputs, 2)
+	require.Len(t, dt.Outputs, 2)
+	require.Len(t, dt.Rules, 4)
+
+	dt.HitPolicy = config.DecisionTableHitPolicyUnique
+	require.NoError(t, dt.Compile())
+
+	require.Equal(t, []string{`(segment) == "gold"`, ""}, dt.Rules[0].Conditions())
+	require.Equal(t, []string{`(segment) == "silver"`, "(amount) >= 0 && (amount) <= 1000"}, dt.Rules[1].Conditions())
+	require.Equal(t, []string{`(segment) == "silver"`, "(amount) > 1000"}, dt.Rules[2].Conditions())
+}
+
+func TestDecisionTableUniqueOverlaps(t *testing.T) {
+	dt := config.DecisionTable{
+		HitPolicy: config.DecisionTableHitPolicyUnique,
+		Inputs:    []config.DecisionTableInput{{Name: "amount", Expression: "order.amount"}},
+		Outputs:   []config.DecisionTableOutput{{Name: "fee"}},
+		Rules: []config.DecisionTableRule{
+			{When: []string{"[0..1000]"}, Then: []string{":2"}},
+			{When: []string{">= 1000"}, Then: []string{":1"}},
+		},
+	}
+
+	require.Error(t, dt.Compile(), "rules share the value 1000")
+
+	dt.Rules[1].When = []string{"> 1000"}
+	require.NoError(t, dt.Compile())
+
+	dt.Rules = append(dt.Rules, config.DecisionTableRule{When: []string{"order.amount % 2 == 0"}, Then: []string{":0"}})
+	require.Error(t, dt.Compile(), "plain gval conditions cannot be proved disjoint")
+
+	dt.HitPolicy = config.DecisionTableHitPolicyFirst
+	require.NoError(t, dt.Compile())
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	EndpointAuthTypeOAuth2ClientCredentials = "oauth2-client-credentials"
+	EndpointAuthTypeBasic                   = "basic"
+	EndpointAuthTypeApiKey                  = "api-key"
+
+	EndpointAuthDefaultApiKeyHeader  = "X-API-Key"
+	EndpointAuthDefaultRefreshBefore = 30 * time.Second
+	EndpointAuthDefaultTokenTimeout  = 10 * time.Second
+)
+
+// EndpointAuth the credentials are interpolated like the headers of the endpoint so they can be taken from env vars ('{OAUTH_CLIENT_SECRET}')
+// or process vars instead of being written in the definition.
+type EndpointAuth struct {
+	Type string `yaml:"type,omitempty" json:"type,omitempty" mapstructure:"type,omitempty"`
+
+	// oauth2-client-credentials
+	TokenUrl          string        `yaml:"token-url,omitempty" json:"token-url,omitempty" mapstructure:"token-url,omitempty"`
+	Scopes            []string      `yaml:"scopes,omitempty" json:"scopes,omitempty" mapstructure:"scopes,omitempty"`
+	ClientId          string        `yaml:"client-id,omitempty" json:"client-id,omitempty" mapstructure:"client-id,omitempty"`
+	ClientSecret      string        `yaml:"client-secret,omitempty" json:"client-secret,omitempty" mapstructure:"client-secret,omitempty"`
+	CredentialsInBody bool          `yaml:"credentials-in-body,omitempty" json:"credentials-in-body,omitempty" mapstructure:"credentials-in-body,omitempty"`
+	RefreshBefore     time.Duration `yaml:"refresh-before,omitempty" json:"refresh-before,omitempty" mapstructure:"refresh-before,omitempty"`
+	Timeout           time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// basic
+	Username string `yaml:"username,omitempty" json:"username,omitempty" mapstructure:"username,omitempty"`
+	Password string `yaml:"password,omitempty" json:"password,omitempty" mapstructure:"password,omitempty"`
+
+	// api-key: sent in the header (X-API-Key by default) or, if query-param is set, in the query string.
+	ApiKey     string `yaml:"api-key,omitempty" json:"api-key,omitempty" mapstructure:"api-key,omitempty"`
+	Header     string `yaml:"header,omitempty" json:"header,omitempty" mapstructure:"header,omitempty"`
+	QueryParam string `yaml:"query-param,omitempty" json:"query-param,omitempty" mapstructure:"query-param,omitempty"`
+}
+
+func (a *EndpointAuth) Validate() error {
+	switch a.Type {
+	case EndpointAuthTypeOAuth2ClientCredentials:
+		if a.TokenUrl == "" || a.ClientId == "" {
+			return errors.New("token-url and client-id are mandatory for oauth2 client credentials")
+		}
+	case EndpointAuthTypeBasic:
+		if a.Username == "" {
+			return errors.New("username is mandatory for basic auth")
+		}
+	case EndpointAuthTypeApiKey:
+		if a.ApiKey == "" {
+			return errors.New("api-key is mandatory for api-key auth")
+		}
+	default:
+		return fmt.Errorf("unsupported auth type %s", a.Type)
+	}
+
+	return nil
+}
+
+func (a *EndpointAuth) GetRefreshBefore() time.Duration {
+	if a.RefreshBefore > 0 {
+		return a.RefreshBefore
+	}
+
+	return EndpointAuthDefaultRefreshBefore
+}
+
+func (a *EndpointAuth) GetTimeout() time.Duration {
+	if a.Timeout > 0 {
+		return a.Timeout
+	}
+
+	return EndpointAuthDefaultTokenTimeout
+}
+
+func (a *EndpointAuth) GetHeader() string {
+	if a.Header != "" {
+		return a.Header
+	}
+
+	return EndpointAuthDefaultApiKeyHeader
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/fileutil"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
@@ -16,16 +18,70 @@ type NameValuePair struct {
 	Guard string `yaml:"guard,omitempty" mapstructure:"guard,omitempty" json:"guard,omitempty"`
 }
 
-type PostData struct {
+const (
+	PostDataTypeSimple         = "simple"
+	PostDataTypeFormUrlEncoded = "form-urlencoded"
+	PostDataTypeMultipart      = "multipart"
+)
+
+// PostDataPart is a field of a form-urlencoded or multipart body. In a multipart body the part is a file when its content comes
+// from a bundle asset (external-value) or from a base64 encoded value (i.e. '{v:document}'); in that case file-name and content-type are sent
+// in the part headers.
+type PostDataPart struct {
 	Name          string `yaml:"name,omitempty" json:"name,omitempty" mapstructure:"name,omitempty"`
-	Type          string `yaml:"type,omitempty" json:"type,omitempty" mapstructure:"type,omitempty"`
-	ExternalValue string `yaml:"external-value,omitempty" json:"external-value,omitempty" mapstructure:"external-value,omitempty"`
+	Guard         string `yaml:"guard,omitempty" json:"guard,omitempty" mapstructure:"guard,omitempty"`
 	Value         string `yaml:"value,omitempty" json:"value,omitempty" mapstructure:"value,omitempty"`
+	ExternalValue string `yaml:"external-value,omitempty" json:"external-value,omitempty" mapstructure:"external-value,omitempty"`
+	Base64Value   string `yaml:"base64-value,omitempty" json:"base64-value,omitempty" mapstructure:"base64-value,omitempty"`
+	FileName      string `yaml:"file-name,omitempty" json:"file-name,omitempty" mapstructure:"file-name,omitempty"`
+	ContentType   string `yaml:"content-type,omitempty" json:"content-type,omitempty" mapstructure:"content-type,omitempty"`
+}
+
+func (p PostDataPart) IsFile() bool {
+	return p.ExternalValue != "" || p.Base64Value != ""
+}
+
+type PostData struct {
+	Name          string         `yaml:"name,omitempty" json:"name,omitempty" mapstructure:"name,omitempty"`
+	Type          string         `yaml:"type,omitempty" json:"type,omitempty" mapstructure:"type,omitempty"`
+	ExternalValue string         `yaml:"external-value,omitempty" json:"external-value,omitempty" mapstructure:"external-value,omitempty"`
+	Value         string         `yaml:"value,omitempty" json:"value,omitempty" mapstructure:"value,omitempty"`
+	Parts         []PostDataPart `yaml:"parts,omitempty" json:"parts,omitempty" mapstructure:"parts,omitempty"`
 	//Data          []byte `yaml:"data,omitempty" json:"data,omitempty" mapstructure:"data,omitempty"`
 }
 
 func (pd PostData) IsZero() bool {
-	return /* len(pd.Data) == 0 && */ pd.ExternalValue == "" && pd.Value == ""
+	return /* len(pd.Data) == 0 && */ pd.ExternalValue == "" && pd.Value == "" && len(pd.Parts) == 0
+}
+
+func (pd PostData) Validate() error {
+	switch pd.Type {
+	case PostDataTypeFormUrlEncoded, PostDataTypeMultipart:
+		if pd.Value != "" || pd.ExternalValue != "" {
+			return fmt.Errorf("%s body is built from parts, value and external-value are not allowed", pd.Type)
+		}
+	default:
+		if len(pd.Parts) != 0 {
+			return fmt.Errorf("parts are not allowed in a body of type %q", pd.Type)
+		}
+		return nil
+	}
+
+	for _, p := range pd.Parts {
+		if p.Name == "" {
+			return errors.New("body part requires a name")
+		}
+
+		if p.ExternalValue != "" && p.Base64Value != "" {
+			return fmt.Errorf("body part %s cannot have both external-value and base64-value", p.Name)
+		}
+
+		if p.IsFile() && pd.Type == PostDataTypeFormUrlEncoded {
+			return fmt.Errorf("body part %s: file parts are allowed in multipart bodies only", p.Name)
+		}
+	}
+
+	return nil
 }
 
 type HttpClientOptions struct {
@@ -38,18 +94,26 @@ type HttpClientOptions struct {
 
 type EndpointDefinition struct {
 	// Description       string             `yaml:"description,omitempty" mapstructure:"description,omitempty" json:"description,omitempty"`
-	Method                                  string             `yaml:"method,omitempty" json:"method,omitempty" mapstructure:"method,omitempty"`
-	Scheme                                  string             `yaml:"scheme,omitempty" json:"scheme,omitempty" mapstructure:"scheme,omitempty"`
-	HostName                                string             `yaml:"hostname,omitempty" json:"hostname,omitempty" mapstructure:"hostname,omitempty"`
-	Port                                    string             `yaml:"port,omitempty" json:"port,omitempty" mapstructure:"port,omitempty"`
-	Path                                    string             `yaml:"Path,omitempty" json:"Path,omitempty" mapstructure:"Path,omitempty"`
-	Headers                                 []NameValuePair    `yaml:"headers,omitempty" json:"headers,omitempty" mapstructure:"headers,omitempty"`
-	QueryString                             []NameValuePair    `yaml:"query-string,omitempty" json:"query-string,omitempty" mapstructure:"query-string,omitempty"`
-	Body                                    PostData           `yaml:"body,omitempty" json:"body,omitempty" mapstructure:"body,omitempty"`
-	OnResponseActions                       []OnResponseAction `yaml:"on-response,omitempty" json:"on-response,omitempty" mapstructure:"on-response,omitempty"`
-	IgnoreNonApplicationJsonResponseContent bool               `yaml:"ignore-non-json-response-body,omitempty" json:"ignore-non-json-response-body,omitempty" mapstructure:"ignore-non-json-response-body,omitempty"`
-	HttpClientOptions                       *HttpClientOptions `yaml:"http-client-opts,omitempty" json:"http-client-opts,omitempty" mapstructure:"http-client-opts,omitempty"`
-	CacheConfig                             CacheConfig        `yaml:"with-cache,omitempty" json:"with-cache,omitempty" mapstructure:"with-cache,omitempty"`
+	Method                                  string              `yaml:"method,omitempty" json:"method,omitempty" mapstructure:"method,omitempty"`
+	Scheme                                  string              `yaml:"scheme,omitempty" json:"scheme,omitempty" mapstructure:"scheme,omitempty"`
+	HostName                                string              `yaml:"hostname,omitempty" json:"hostname,omitempty" mapstructure:"hostname,omitempty"`
+	Port                                    string              `yaml:"port,omitempty" json:"port,omitempty" mapstructure:"port,omitempty"`
+	Path                                    string              `yaml:"Path,omitempty" json:"Path,omitempty" mapstructure:"Path,omitempty"`
+	Headers                                 []NameValuePair     `yaml:"headers,omitempty" json:"headers,omitempty" mapstructure:"headers,omitempty"`
+	QueryString                             []NameValuePair     `yaml:"query-string,omitempty" json:"query-string,omitempty" mapstructure:"query-string,omitempty"`
+	Body                                    PostData            `yaml:"body,omitempty" json:"body,omitempty" mapstructure:"body,omitempty"`
+	OnResponseActions                       []OnResponseAction  `yaml:"on-response,omitempty" json:"on-response,omitempty" mapstructure:"on-response,omitempty"`
+	IgnoreNonApplicationJsonResponseContent bool                `yaml:"ignore-non-json-response-body,omitempty" json:"ignore-non-json-response-body,omitempty" mapstructure:"ignore-non-json-response-body,omitempty"`
+	HttpClientOptions                       *HttpClientOptions  `yaml:"http-client-opts,omitempty" json:"http-client-opts,omitempty" mapstructure:"http-client-opts,omitempty"`
+	CacheConfig                             CacheConfig         `yaml:"with-cache,omitempty" json:"with-cache,omitempty" mapstructure:"with-cache,omitempty"`
+	Auth                                    *EndpointAuth       `yaml:"auth,omitempty" json:"auth,omitempty" mapstructure:"auth,omitempty"`
+	Signers                                 []EndpointSigner    `yaml:"signers,omitempty" json:"signers,omitempty" mapstructure:"signers,omitempty"`
+	Pagination                              *EndpointPagination `yaml:"pagination,omitempty" json:"pagination,omitempty" mapstructure:"pagination,omitempty"`
+
+	// AlternativeHosts replicas of the hostname (host or host:port) used according to the hedging block. When set, the metrics get the additional 'host' label
+	// with the host that answered.
+	AlternativeHosts []string         `yaml:"alternative-hosts,omitempty" json:"alternative-hosts,omitempty" mapstructure:"alternative-hosts,omitempty"`
+	Hedging          *EndpointHedging `yaml:"hedging,omitempty" json:"hedging,omitempty" mapstructure:"hedging,omitempty"`
 }
 
 func (epd *EndpointDefinition) WriteToFile(folderName string, fileName string, writeOpts ...fileutil.WriteOption) error {
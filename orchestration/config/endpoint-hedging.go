@@ -0,0 +1,95 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	EndpointHedgingModeHedge    = "hedge"
+	EndpointHedgingModeFallback = "fallback"
+
+	EndpointHedgingDefaultDelay             = 200 * time.Millisecond
+	EndpointHedgingDefaultMinSamples        = 20
+	EndpointHedgingDefaultMaxHedgedRequests = 1
+
+	// EndpointHedgingDefaultRequestTimeout applies to the hedged requests of the endpoints with no timeout in their http client options.
+	EndpointHedgingDefaultRequestTimeout = 10 * time.Second
+)
+
+// EndpointHedging sets how the alternative-hosts of an endpoint are used. In hedge mode, if the current request hasn't answered within the delay, the same
+// request is sent to the next host and the first successful answer (no error and a status below 500) wins. The delay is the given percentile of the latencies observed
+// on the endpoint once min-samples calls have been completed, the fixed delay before: the latencies are collected in hedge mode only. In fallback mode the hosts are
+// tried in order on connection errors and, with fallback-on-server-error, on a status of 500 or above. Without an hedging block the alternative hosts are used in
+// fallback mode on connection errors.
+type EndpointHedging struct {
+	Mode                  string        `yaml:"mode,omitempty" json:"mode,omitempty" mapstructure:"mode,omitempty"`
+	Delay                 time.Duration `yaml:"delay,omitempty" json:"delay,omitempty" mapstructure:"delay,omitempty"`
+	Percentile            float64       `yaml:"percentile,omitempty" json:"percentile,omitempty" mapstructure:"percentile,omitempty"`
+	MinSamples            int           `yaml:"min-samples,omitempty" json:"min-samples,omitempty" mapstructure:"min-samples,omitempty"`
+	MaxHedgedRequests     int           `yaml:"max-hedged-requests,omitempty" json:"max-hedged-requests,omitempty" mapstructure:"max-hedged-requests,omitempty"`
+	FallbackOnServerError bool          `yaml:"fallback-on-server-error,omitempty" json:"fallback-on-server-error,omitempty" mapstructure:"fallback-on-server-error,omitempty"`
+}
+
+func (h *EndpointHedging) Validate() error {
+	switch h.Mode {
+	case "", EndpointHedgingModeHedge, EndpointHedgingModeFallback:
+	default:
+		return fmt.Errorf("unsupported hedging mode %s", h.Mode)
+	}
+
+	if h.Delay < 0 || h.MinSamples < 0 || h.MaxHedgedRequests < 0 {
+		return errors.New("hedging delay, min-samples and max-hedged-requests cannot be negative")
+	}
+
+	if h.Percentile < 0 || h.Percentile >= 100 {
+		return errors.New("hedging percentile must be in the [0, 100) range")
+	}
+
+	if h.FallbackOnServerError && h.GetMode() != EndpointHedgingModeFallback {
+		return errors.New("hedging fallback-on-server-error applies to the fallback mode only")
+	}
+
+	return nil
+}
+
+func (h *EndpointHedging) GetMode() string {
+	if h == nil {
+		return EndpointHedgingModeFallback
+	}
+
+	if h.Mode != "" {
+		return h.Mode
+	}
+
+	return EndpointHedgingModeHedge
+}
+
+func (h *EndpointHedging) GetDelay() time.Duration {
+	if h.Delay > 0 {
+		return h.Delay
+	}
+
+	return EndpointHedgingDefaultDelay
+}
+
+func (h *EndpointHedging) GetMinSamples() int {
+	if h.MinSamples > 0 {
+		return h.MinSamples
+	}
+
+	return EndpointHedgingDefaultMinSamples
+}
+
+func (h *EndpointHedging) GetMaxHedgedRequests() int {
+	if h.MaxHedgedRequests > 0 {
+		return h.MaxHedgedRequests
+	}
+
+	return EndpointHedgingDefaultMaxHedgedRequests
+}
+
+func (h *EndpointHedging) IsFallbackOnServerError() bool {
+	return h != nil && h.FallbackOnServerError
+}
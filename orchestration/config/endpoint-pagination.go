@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+)
+
+const (
+	EndpointPaginationDefaultContinuationVar = "page_continuation"
+	EndpointPaginationDefaultMaxPages        = 100
+
+	// EndpointPaginationPageIndexVar and EndpointPaginationPageItemsVar are set before the evaluation of the continuation expression with the 0-based index of the
+	// page just received and the number of its items.
+	EndpointPaginationPageIndexVar = "page_index"
+	EndpointPaginationPageItemsVar = "page_items"
+)
+
+// EndpointPagination repeats the call while the continuation yields a value. The continuation is interpolated and evaluated on the response of every page
+// (i.e. '{$.next_cursor}', '{h:X-Next-Page}' or ':page_items == 50 ? (page_index + 1) * 50 : ""') and its value is stored in the continuation-var
+// process var to be referenced by the path, the query string or the body of the next request ({v:page_continuation}).
+// An empty, null or false continuation stops the iteration. If link-header is set the iteration follows the rel="next" url of the Link header instead.
+type EndpointPagination struct {
+	Continuation    string `yaml:"continuation,omitempty" json:"continuation,omitempty" mapstructure:"continuation,omitempty"`
+	ContinuationVar string `yaml:"continuation-var,omitempty" json:"continuation-var,omitempty" mapstructure:"continuation-var,omitempty"`
+	Initial         string `yaml:"initial,omitempty" json:"initial,omitempty" mapstructure:"initial,omitempty"`
+	LinkHeader      bool   `yaml:"link-header,omitempty" json:"link-header,omitempty" mapstructure:"link-header,omitempty"`
+
+	// Items JSONPath of the array of items in the page body. The items of all the pages are merged in the array returned as the body of the endpoint.
+	Items    string `yaml:"items,omitempty" json:"items,omitempty" mapstructure:"items,omitempty"`
+	MaxPages int    `yaml:"max-pages,omitempty" json:"max-pages,omitempty" mapstructure:"max-pages,omitempty"`
+	MaxItems int    `yaml:"max-items,omitempty" json:"max-items,omitempty" mapstructure:"max-items,omitempty"`
+}
+
+func (p *EndpointPagination) Validate() error {
+	if p.Continuation == "" && !p.LinkHeader {
+		return errors.New("pagination requires a continuation or the link-header")
+	}
+
+	if p.Items == "" {
+		return errors.New("pagination requires the items json-path")
+	}
+
+	if p.MaxPages < 0 || p.MaxItems < 0 {
+		return errors.New("pagination max-pages and max-items cannot be negative")
+	}
+
+	return nil
+}
+
+func (p *EndpointPagination) GetContinuationVar() string {
+	if p.ContinuationVar != "" {
+		return p.ContinuationVar
+	}
+
+	return EndpointPaginationDefaultContinuationVar
+}
+
+func (p *EndpointPagination) GetMaxPages() int {
+	if p.MaxPages > 0 {
+		return p.MaxPages
+	}
+
+	return EndpointPaginationDefaultMaxPages
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	EndpointSignerTypeHmac                 = "hmac"
+	EndpointSignerTypeJws                  = "jws"
+	EndpointSignerTypeHttpMessageSignature = "http-message-signature"
+
+	EndpointSignerEncodingBase64 = "base64"
+	EndpointSignerEncodingHex    = "hex"
+)
+
+// EndpointSigner signs the final request of an endpoint. The key is taken from a bundle asset (key-ref) or from the key property that is
+// interpolated like the headers of the endpoint (i.e. '{PARTNER_HMAC_KEY}'). Asymmetric keys are PEM encoded private keys.
+type EndpointSigner struct {
+	Type      string `yaml:"type,omitempty" json:"type,omitempty" mapstructure:"type,omitempty"`
+	Algorithm string `yaml:"algorithm,omitempty" json:"algorithm,omitempty" mapstructure:"algorithm,omitempty"`
+	KeyId     string `yaml:"key-id,omitempty" json:"key-id,omitempty" mapstructure:"key-id,omitempty"`
+	Key       string `yaml:"key,omitempty" json:"key,omitempty" mapstructure:"key,omitempty"`
+	KeyRef    string `yaml:"key-ref,omitempty" json:"key-ref,omitempty" mapstructure:"key-ref,omitempty"`
+
+	// Headers the request headers covered by the signature. For the http-message-signature type derived components like '@method' or '@path'
+	// and 'content-digest' can be listed as well.
+	Headers         []string `yaml:"headers,omitempty" json:"headers,omitempty" mapstructure:"headers,omitempty"`
+	SignatureHeader string   `yaml:"signature-header,omitempty" json:"signature-header,omitempty" mapstructure:"signature-header,omitempty"`
+	TimestampHeader string   `yaml:"timestamp-header,omitempty" json:"timestamp-header,omitempty" mapstructure:"timestamp-header,omitempty"`
+	Encoding        string   `yaml:"encoding,omitempty" json:"encoding,omitempty" mapstructure:"encoding,omitempty"`
+	Label           string   `yaml:"label,omitempty" json:"label,omitempty" mapstructure:"label,omitempty"`
+}
+
+func (s *EndpointSigner) Validate() error {
+	if s.Type == "" {
+		return errors.New("signer type is mandatory")
+	}
+
+	if s.Key == "" && s.KeyRef == "" {
+		return fmt.Errorf("signer %s has no key", s.Type)
+	}
+
+	switch s.Encoding {
+	case "", EndpointSignerEncodingBase64, EndpointSignerEncodingHex:
+	default:
+		return fmt.Errorf("unsupported signature encoding %s", s.Encoding)
+	}
+
+	return nil
+}
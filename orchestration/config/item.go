@@ -22,6 +22,7 @@ const (
 	EndpointActivityType            = "rest-activity"
 	ResponseActivityType            = "response-activity"
 	KafkaActivityType               = "kafka-activity"
+	KafkaConsumeActivityType        = "kafka-consume-activity"
 	NestedOrchestrationActivityType = "nested-orchestration-activity"
 	MongoActivityType               = "mongo-activity"
 	TransformActivityType           = "transform-activity"
@@ -29,6 +30,7 @@ const (
 	JsonSchemaActivityType          = "json-schema-activity"
 	LoopActivityType                = "loop-activity"
 	CacheActivityType               = "cache-activity"
+	DecisionTableActivityType       = "decision-table-activity"
 	GenericActivityType             = "generic-activity"
 	DatabricksActivityType          = "databricks-activity"
 
@@ -50,6 +52,7 @@ var activityTypeRegistry = map[string]ActivityTypeRegistryEntry{
 	NopActivityType:                 {Tp: NopActivityType, UnmarshallFromJSON: NewNopActivityFromJSON, UnmarshalFromYAML: NewNopActivityFromYAML},
 	EndpointActivityType:            {Tp: EndpointActivityType, UnmarshallFromJSON: NewEndpointActivityFromJSON, UnmarshalFromYAML: NewEndpointActivityFromYAML},
 	KafkaActivityType:               {Tp: KafkaActivityType, UnmarshallFromJSON: NewKafkaActivityFromJSON, UnmarshalFromYAML: NewKafkaActivityFromYAML},
+	KafkaConsumeActivityType:        {Tp: KafkaConsumeActivityType, UnmarshallFromJSON: NewKafkaConsumeActivityFromJSON, UnmarshalFromYAML: NewKafkaConsumeActivityFromYAML},
 	NestedOrchestrationActivityType: {Tp: NestedOrchestrationActivityType, UnmarshallFromJSON: NewNestedOrchestrationActivityFromJSON, UnmarshalFromYAML: NewNestedOrchestrationActivityFromYAML},
 	MongoActivityType:               {Tp: MongoActivityType, UnmarshallFromJSON: NewMongoActivityFromJSON, UnmarshalFromYAML: NewMongoActivityFromYAML},
 	TransformActivityType:           {Tp: TransformActivityType, UnmarshallFromJSON: NewTransformActivityFromJSON, UnmarshalFromYAML: NewTransformActivityFromYAML},
@@ -57,6 +60,7 @@ var activityTypeRegistry = map[string]ActivityTypeRegistryEntry{
 	JsonSchemaActivityType:          {Tp: JsonSchemaActivityType, UnmarshallFromJSON: NewJsonSchemaActivityFromJSON, UnmarshalFromYAML: NewJsonSchemaActivityFromYAML},
 	LoopActivityType:                {Tp: LoopActivityType, UnmarshallFromJSON: NewLoopActivityFromJSON, UnmarshalFromYAML: NewLoopActivityFromYAML},
 	CacheActivityType:               {Tp: CacheActivityType, UnmarshallFromJSON: NewCacheActivityFromJSON, UnmarshalFromYAML: NewCacheActivityFromYAML},
+	DecisionTableActivityType:       {Tp: DecisionTableActivityType, UnmarshallFromJSON: NewDecisionTableActivityFromJSON, UnmarshalFromYAML: NewDecisionTableActivityFromYAML},
 	DatabricksActivityType:          {Tp: DatabricksActivityType, UnmarshallFromJSON: NewGenericActivityFromJSON, UnmarshalFromYAML: NewGenericActivityFromYAML},
 }
 
@@ -189,6 +193,9 @@ type Activity struct {
 	MetricsCfg      promutil.MetricsConfigReference `yaml:"ref-metrics,omitempty" mapstructure:"ref-metrics,omitempty" json:"ref-metrics,omitempty"`
 	Definition      string                          `yaml:"ref-definition,omitempty" mapstructure:"ref-definition,omitempty" json:"ref-definition,omitempty"`
 	ExprContextName string                          `yaml:"input-source,omitempty" mapstructure:"input-source,omitempty" json:"input-source,omitempty"`
+
+	// StrictMode when set the variable references of the activity that cannot be resolved, and have no onf default, are errors instead of empty values.
+	StrictMode bool `yaml:"strict-mode,omitempty" mapstructure:"strict-mode,omitempty" json:"strict-mode,omitempty"`
 }
 
 func (c *Activity) Dup(newName string) Activity {
@@ -212,11 +219,48 @@ func (c *Activity) Dup(newName string) Activity {
 		MetricsCfg:      c.MetricsCfg,
 		Definition:      c.Definition,
 		ExprContextName: c.ExprContextName,
+		StrictMode:      c.StrictMode,
 	}
 
 	return actNew
 }
 
+// Expressions returns the gval expressions of the activity that are evaluated as they are: the enabled condition, the guards of the process vars and the
+// values marked as expressions that do not reference other variables.
+func (c *Activity) Expressions() []string {
+	var exprs []string
+	if c.En != "" {
+		exprs = append(exprs, c.En)
+	}
+
+	for _, pv := range c.ProcessVars {
+		if pv.Guard != "" {
+			exprs = append(exprs, pv.Guard)
+		}
+
+		if v, isExpr := strings.CutPrefix(pv.Value, ":"); isExpr && v != "" && !strings.Contains(v, "{") {
+			exprs = append(exprs, v)
+		}
+	}
+
+	return exprs
+}
+
+// ValidateProcessVars checks the declared types of the process vars of the activity.
+func (c *Activity) ValidateProcessVars() error {
+	for _, pv := range c.ProcessVars {
+		if err := pv.ValidateType(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Activity) IsStrictMode() bool {
+	return c.StrictMode
+}
+
 func (c *Activity) WithName(n string) *Activity {
 	c.Nm = n
 	return c
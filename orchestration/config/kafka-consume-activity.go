@@ -0,0 +1,199 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/fileutil"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type KafkaConsumeActivity struct {
+	Activity   `yaml:",inline" json:",inline"`
+	BrokerName string                            `mapstructure:"broker-name" json:"broker-name" yaml:"broker-name"`
+	PII        PersonallyIdentifiableInformation `yaml:"pii,omitempty" mapstructure:"pii,omitempty" json:"pii,omitempty"`
+}
+
+func (c *KafkaConsumeActivity) WithName(n string) *KafkaConsumeActivity {
+	c.Nm = n
+	return c
+}
+
+func (c *KafkaConsumeActivity) WithActor(n string) *KafkaConsumeActivity {
+	c.Actr = n
+	return c
+}
+
+func (c *KafkaConsumeActivity) WithDescription(n string) *KafkaConsumeActivity {
+	c.Cm = n
+	return c
+}
+
+func (c *KafkaConsumeActivity) WithExpressionContext(n string) *KafkaConsumeActivity {
+	c.ExprContextName = n
+	return c
+}
+
+func (c *KafkaConsumeActivity) WithRefDefinition(n string) *KafkaConsumeActivity {
+	c.Definition = n
+	return c
+}
+
+func (c *KafkaConsumeActivity) WithBrokerName(n string) *KafkaConsumeActivity {
+	c.BrokerName = n
+	return c
+}
+
+func (c *KafkaConsumeActivity) Dup(newName string) *KafkaConsumeActivity {
+
+	actNew := KafkaConsumeActivity{
+		Activity:   c.Activity.Dup(newName),
+		BrokerName: c.BrokerName,
+		PII:        c.PII,
+	}
+
+	return &actNew
+}
+
+func NewKafkaConsumeActivity() *KafkaConsumeActivity {
+	s := KafkaConsumeActivity{}
+	s.Tp = KafkaConsumeActivityType
+	return &s
+}
+
+func NewKafkaConsumeActivityFromJSON(message json.RawMessage) (Configurable, error) {
+	i := NewKafkaConsumeActivity()
+	err := json.Unmarshal(message, i)
+	if err != nil {
+		return nil, err
+	}
+
+	i.PII.Initialize()
+	return i, nil
+}
+
+func NewKafkaConsumeActivityFromYAML(b []byte /* mp interface{}*/) (Configurable, error) {
+	sa := NewKafkaConsumeActivity()
+	err := yaml.Unmarshal(b, sa)
+	if err != nil {
+		return nil, err
+	}
+
+	sa.PII.Initialize()
+	return sa, nil
+}
+
+const (
+	KafkaConsumeReadModeAll  = "all"
+	KafkaConsumeReadModeLast = "last"
+
+	KafkaConsumeStartOffsetEarliest = "earliest"
+
+	KafkaConsumeDefaultMaxMessages = 100
+	KafkaConsumeDefaultTimeout     = 5 * time.Second
+)
+
+// KafkaConsumeActivityDefinition describes a bounded read of a topic. The read never goes past the high watermarks
+// sampled at the beginning of the activity: it stops when those are reached, when max-messages have been collected
+// or when the timeout expires, whichever comes first.
+type KafkaConsumeActivityDefinition struct {
+	TopicName   string `yaml:"topic-name,omitempty" json:"topic-name,omitempty" mapstructure:"topic-name,omitempty"`
+	GroupId     string `yaml:"group-id,omitempty" json:"group-id,omitempty" mapstructure:"group-id,omitempty"`
+	Partition   *int32 `yaml:"partition,omitempty" json:"partition,omitempty" mapstructure:"partition,omitempty"`
+	StartOffset string `yaml:"start-offset,omitempty" json:"start-offset,omitempty" mapstructure:"start-offset,omitempty"`
+	EndOffset   string `yaml:"end-offset,omitempty" json:"end-offset,omitempty" mapstructure:"end-offset,omitempty"`
+	Key         string `yaml:"key,omitempty" json:"key,omitempty" mapstructure:"key,omitempty"`
+
+	// ReadMode: 'all' returns every matching message up to max-messages, 'last' returns only the last matching message (i.e. the snapshot
+	// of a key in a compacted topic).
+	ReadMode          string            `yaml:"read-mode,omitempty" json:"read-mode,omitempty" mapstructure:"read-mode,omitempty"`
+	MaxMessages       int               `yaml:"max-messages,omitempty" json:"max-messages,omitempty" mapstructure:"max-messages,omitempty"`
+	Timeout           time.Duration     `yaml:"timeout,omitempty" json:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+	TraceOpName       string            `yaml:"trace-op-name,omitempty" json:"trace-op-name,omitempty" mapstructure:"trace-op-name,omitempty"`
+	OnResponseActions OnResponseActions `yaml:"on-response,omitempty" json:"on-response,omitempty" mapstructure:"on-response,omitempty"`
+}
+
+func (def *KafkaConsumeActivityDefinition) WriteToFile(folderName string, fileName string, writeOpts ...fileutil.WriteOption) error {
+	const semLogContext = "kafka-consume-activity-definition::write-to-file"
+	fn := filepath.Join(folderName, fileName)
+	log.Info().Str("file-name", fn).Msg(semLogContext)
+	b, err := yaml.Marshal(def)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return err
+	}
+
+	err = fileutil.WriteFile(fn, b, os.ModePerm, writeOpts...)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return err
+	}
+
+	return nil
+}
+
+func (def *KafkaConsumeActivityDefinition) Validate() error {
+	if def.TopicName == "" {
+		return errors.New("topic-name is mandatory")
+	}
+
+	switch def.ReadMode {
+	case KafkaConsumeReadModeAll, KafkaConsumeReadModeLast:
+	default:
+		return fmt.Errorf("unsupported read-mode %s", def.ReadMode)
+	}
+
+	if def.MaxMessages < 0 {
+		return fmt.Errorf("invalid max-messages %d", def.MaxMessages)
+	}
+
+	return nil
+}
+
+func UnmarshalKafkaConsumeActivityDefinition(def string, refs DataReferences) (KafkaConsumeActivityDefinition, error) {
+	const semLogContext = "kafka-consume-activity-definition::unmarshal"
+
+	var err error
+	kcDef := KafkaConsumeActivityDefinition{}
+
+	data, ok := refs.Find(def)
+	if len(data) == 0 || !ok {
+		err = errors.New("cannot find activity definition")
+		log.Error().Err(err).Str("def", def).Msg(semLogContext)
+		return kcDef, err
+	}
+
+	err = yaml.Unmarshal(data, &kcDef)
+	if err != nil {
+		log.Error().Err(err).Str("def", def).Msg(semLogContext)
+		return kcDef, err
+	}
+
+	if kcDef.ReadMode == "" {
+		kcDef.ReadMode = KafkaConsumeReadModeAll
+	}
+
+	if kcDef.StartOffset == "" {
+		kcDef.StartOffset = KafkaConsumeStartOffsetEarliest
+	}
+
+	if kcDef.MaxMessages == 0 {
+		kcDef.MaxMessages = KafkaConsumeDefaultMaxMessages
+	}
+
+	if kcDef.Timeout == 0 {
+		kcDef.Timeout = KafkaConsumeDefaultTimeout
+	}
+
+	err = kcDef.Validate()
+	if err != nil {
+		log.Error().Err(err).Str("def", def).Msg(semLogContext)
+		return kcDef, err
+	}
+
+	return kcDef, nil
+}
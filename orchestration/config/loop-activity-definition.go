@@ -8,14 +8,38 @@ import (
 
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/fileutil"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-mongo-common/jsonops"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	LoopControlFLowFor = "for"
+	LoopControlFLowFor         = "for"
+	LoopControlFlowMongoCursor = "mongo-cursor"
+	LoopControlFlowForEach     = "foreach"
+	LoopControlFlowWhile       = "while"
+
+	LoopErrorPolicyFailFast   = "fail-fast"
+	LoopErrorPolicyCollectAll = "collect-all"
+
+	LoopAggregateArray        = "array"
+	LoopAggregateMergeObjects = "merge-objects"
+	LoopAggregateSum          = "sum"
+	LoopAggregateCount        = "count"
+	LoopAggregateMin          = "min"
+	LoopAggregateMax          = "max"
+	LoopAggregateGroupBy      = "group-by"
+	LoopAggregateReduce       = "reduce"
 )
 
+// LoopCursorDefinition references a paginated mongo find or aggregate definition. Each iteration of the loop gets a chunk of
+// page-size documents as body.
+type LoopCursorDefinition struct {
+	OpType     jsonops.MongoJsonOperationType `yaml:"op-type,omitempty" json:"op-type,omitempty" mapstructure:"op-type,omitempty"`
+	Definition string                         `yaml:"ref-definition,omitempty" json:"ref-definition,omitempty" mapstructure:"ref-definition,omitempty"`
+	Mongo      MongoActivityDefinition        `yaml:"-" json:"-" mapstructure:"-"`
+}
+
 type LoopControlFlowDefinition struct {
 	Typ            string                    `yaml:"type,omitempty" json:"type,omitempty" mapstructure:"type,omitempty"`
 	Start          string                    `yaml:"start,omitempty" json:"start,omitempty" mapstructure:"start,omitempty"`
@@ -23,12 +47,101 @@ type LoopControlFlowDefinition struct {
 	Step           string                    `yaml:"step,omitempty" json:"step,omitempty" mapstructure:"step,omitempty"`
 	BreakCondition string                    `yaml:"break-on,omitempty" json:"break-on,omitempty" mapstructure:"break-on,omitempty"`
 	XForm          xforms.TransformReference `yaml:"x-form,omitempty"  json:"x-form,omitempty" mapstructure:"x-form,omitempty"`
+	Cursor         LoopCursorDefinition      `yaml:"cursor,omitempty"  json:"cursor,omitempty" mapstructure:"cursor,omitempty"`
+
+	// Items JSONPath of the array the foreach control flow iterates over. The item is the body of the iteration and, together with its index, is set in the item-var
+	// and index-var process vars of the loop body.
+	Items    string `yaml:"items,omitempty" json:"items,omitempty" mapstructure:"items,omitempty"`
+	ItemVar  string `yaml:"item-var,omitempty" json:"item-var,omitempty" mapstructure:"item-var,omitempty"`
+	IndexVar string `yaml:"index-var,omitempty" json:"index-var,omitempty" mapstructure:"index-var,omitempty"`
+
+	// Condition of the while control flow. It is evaluated before every iteration, max-iterations is required as a safeguard and exceeding it is an error.
+	// In a foreach max-iterations is optional and limits the number of items processed.
+	Condition     string `yaml:"condition,omitempty" json:"condition,omitempty" mapstructure:"condition,omitempty"`
+	MaxIterations int    `yaml:"max-iterations,omitempty" json:"max-iterations,omitempty" mapstructure:"max-iterations,omitempty"`
+}
+
+func (cf *LoopControlFlowDefinition) Validate() error {
+	if cf.MaxIterations < 0 {
+		return errors.New("max-iterations cannot be negative")
+	}
+
+	switch cf.Typ {
+	case LoopControlFlowForEach:
+		if cf.Items == "" {
+			return errors.New("foreach control flow requires the items json-path")
+		}
+	case LoopControlFlowWhile:
+		if cf.Condition == "" {
+			return errors.New("while control flow requires a condition")
+		}
+
+		if cf.MaxIterations == 0 {
+			return errors.New("while control flow requires max-iterations")
+		}
+	}
+
+	return nil
+}
+
+// LoopAggregateDefinition sets how the responses of the iterations make the response of the loop. The default array strategy lists the json responses of all
+// the iterations, the others work on the responses of the iterations that succeeded: merge-objects merges them in order, sum, min and max operate on the numbers
+// selected by path (arrays get flattened), count counts the values selected by path or the responses, group-by groups the responses by the value selected by path
+// and reduce applies the kazaam or jq x-form to the array of the responses.
+type LoopAggregateDefinition struct {
+	Strategy string                    `yaml:"strategy,omitempty" json:"strategy,omitempty" mapstructure:"strategy,omitempty"`
+	Path     string                    `yaml:"path,omitempty" json:"path,omitempty" mapstructure:"path,omitempty"`
+	XForm    xforms.TransformReference `yaml:"x-form,omitempty" json:"x-form,omitempty" mapstructure:"x-form,omitempty"`
+}
+
+func (ag *LoopAggregateDefinition) GetStrategy() string {
+	if ag.Strategy == "" {
+		return LoopAggregateArray
+	}
+
+	return ag.Strategy
+}
+
+func (ag *LoopAggregateDefinition) Validate() error {
+	switch ag.GetStrategy() {
+	case LoopAggregateArray, LoopAggregateMergeObjects, LoopAggregateCount:
+	case LoopAggregateSum, LoopAggregateMin, LoopAggregateMax, LoopAggregateGroupBy:
+		if ag.Path == "" {
+			return fmt.Errorf("%s aggregate requires a path", ag.Strategy)
+		}
+	case LoopAggregateReduce:
+		if ag.XForm.Typ != XFormKazaam && ag.XForm.Typ != XFormJQ {
+			return errors.New("reduce aggregate requires a kazaam or jq x-form")
+		}
+	default:
+		return fmt.Errorf("unsupported aggregate strategy %s", ag.Strategy)
+	}
+
+	return nil
 }
 
 type LoopActivityDefinition struct {
 	OrchestrationId   string                    `yaml:"orchestration-id,omitempty" json:"orchestration-id,omitempty" mapstructure:"orchestration-id,omitempty"`
 	ControlFlow       LoopControlFlowDefinition `yaml:"control-flow,omitempty" json:"control-flow,omitempty" mapstructure:"control-flow,omitempty"`
 	OnResponseActions OnResponseActions         `yaml:"on-response,omitempty" json:"on-response,omitempty" mapstructure:"on-response,omitempty"`
+
+	// Parallelism is the number of iterations executed concurrently, each one in its own case. The on-response actions are processed in iteration order as the
+	// iterations complete. The while control flow and break-on depend on the outcome of the previous iterations and cannot be executed in parallel.
+	Parallelism int `yaml:"parallelism,omitempty" json:"parallelism,omitempty" mapstructure:"parallelism,omitempty"`
+
+	// OnError fail-fast stops the loop at the first iteration whose on-response actions fail, the iterations still running in parallel are cancelled before their
+	// next activity. collect-all runs all the iterations and reports the first error.
+	OnError string `yaml:"on-error,omitempty" json:"on-error,omitempty" mapstructure:"on-error,omitempty"`
+
+	Aggregate LoopAggregateDefinition `yaml:"aggregate,omitempty" json:"aggregate,omitempty" mapstructure:"aggregate,omitempty"`
+}
+
+func (def *LoopActivityDefinition) GetOnError() string {
+	if def.OnError == "" {
+		return LoopErrorPolicyFailFast
+	}
+
+	return def.OnError
 }
 
 func (def *LoopActivityDefinition) WriteToFile(folderName string, fileName string, writeOpts ...fileutil.WriteOption) error {
@@ -76,7 +189,53 @@ func UnmarshalLoopActivityDefinition(def string, refs DataReferences) (LoopActiv
 		maDef.ControlFlow.Typ = LoopControlFLowFor
 	}
 
+	err = maDef.ControlFlow.Validate()
+	if err == nil && maDef.Parallelism < 0 {
+		err = errors.New("parallelism cannot be negative")
+	}
+
+	if err == nil && maDef.Parallelism > 1 && (maDef.ControlFlow.Typ == LoopControlFlowWhile || maDef.ControlFlow.BreakCondition != "") {
+		err = errors.New("while control flow and break-on are not supported with parallelism")
+	}
+
+	if err == nil && maDef.GetOnError() != LoopErrorPolicyFailFast && maDef.GetOnError() != LoopErrorPolicyCollectAll {
+		err = fmt.Errorf("unsupported on-error policy %s", maDef.OnError)
+	}
+
+	if err == nil {
+		err = maDef.Aggregate.Validate()
+	}
+
+	if err == nil {
+		switch maDef.Aggregate.XForm.Typ {
+		case XFormKazaam:
+			err = registerKazaamXForm(refs, maDef.Aggregate.XForm)
+		case XFormJQ:
+			err = registerJQXForm(refs, maDef.Aggregate.XForm)
+		}
+	}
+
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return maDef, err
+	}
+
+	if maDef.ControlFlow.Typ == LoopControlFlowMongoCursor {
+		maDef.ControlFlow.Cursor.Mongo, err = UnmarshalMongoActivityDefinition(maDef.ControlFlow.Cursor.OpType, maDef.ControlFlow.Cursor.Definition, refs)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return maDef, err
+		}
+
+		if maDef.ControlFlow.Cursor.Mongo.Pagination == nil {
+			err = errors.New("mongo-cursor control flow requires a paginated definition")
+			log.Error().Err(err).Msg(semLogContext)
+			return maDef, err
+		}
+	}
+
 	switch maDef.ControlFlow.XForm.Typ {
+	case "":
 	case XFormKazaamDynamic:
 		b, err := loadKazaamXForm(refs, maDef.ControlFlow.XForm)
 		if err != nil {
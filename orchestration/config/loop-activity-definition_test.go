@@ -0,0 +1,65 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoopControlFlowDefinition(t *testing.T) {
+	refs := config.DataReferences{
+		{Path: "foreach.yml", Data: []byte("control-flow:\n  type: foreach\n  items: $.orders\n  item-var: order\n")},
+		{Path: "foreach-no-items.yml", Data: []byte("control-flow:\n  type: foreach\n")},
+		{Path: "while.yml", Data: []byte("control-flow:\n  type: while\n  condition: hasMore\n  max-iterations: 10\n  break-on: failed\n")},
+		{Path: "while-no-max.yml", Data: []byte("control-flow:\n  type: while\n  condition: hasMore\n")},
+		{Path: "parallel.yml", Data: []byte("parallelism: 4\non-error: collect-all\n")},
+		{Path: "parallel-bad-policy.yml", Data: []byte("parallelism: 4\non-error: ignore\n")},
+		{Path: "parallel-while.yml", Data: []byte("parallelism: 4\ncontrol-flow:\n  type: while\n  condition: hasMore\n  max-iterations: 10\n")},
+		{Path: "parallel-break-on.yml", Data: []byte("parallelism: 4\ncontrol-flow:\n  type: foreach\n  items: $.orders\n  break-on: failed\n")},
+		{Path: "aggregate-sum.yml", Data: []byte("aggregate:\n  strategy: sum\n  path: $.amount\n")},
+		{Path: "aggregate-sum-no-path.yml", Data: []byte("aggregate:\n  strategy: sum\n")},
+	}
+
+	def, err := config.UnmarshalLoopActivityDefinition("foreach.yml", refs)
+	require.NoError(t, err)
+	require.Equal(t, "$.orders", def.ControlFlow.Items)
+	require.Equal(t, "order", def.ControlFlow.ItemVar)
+
+	_, err = config.UnmarshalLoopActivityDefinition("foreach-no-items.yml", refs)
+	require.Error(t, err)
+
+	def, err = config.UnmarshalLoopActivityDefinition("while.yml", refs)
+	require.NoError(t, err)
+	require.Equal(t, 10, def.ControlFlow.MaxIterations)
+	require.Equal(t, "failed", def.ControlFlow.BreakCondition)
+
+	_, err = config.UnmarshalLoopActivityDefinition("while-no-max.yml", refs)
+	require.Error(t, err)
+
+	def, err = config.UnmarshalLoopActivityDefinition("parallel.yml", refs)
+	require.NoError(t, err)
+	require.Equal(t, 4, def.Parallelism)
+	require.Equal(t, config.LoopErrorPolicyCollectAll, def.GetOnError())
+
+	def, err = config.UnmarshalLoopActivityDefinition("foreach.yml", refs)
+	require.NoError(t, err)
+	require.Equal(t, config.LoopErrorPolicyFailFast, def.GetOnError())
+
+	_, err = config.UnmarshalLoopActivityDefinition("parallel-bad-policy.yml", refs)
+	require.Error(t, err)
+
+	// the conditions would be evaluated before the outcome of the running iterations is known.
+	_, err = config.UnmarshalLoopActivityDefinition("parallel-while.yml", refs)
+	require.Error(t, err)
+
+	_, err = config.UnmarshalLoopActivityDefinition("parallel-break-on.yml", refs)
+	require.Error(t, err)
+
+	def, err = config.UnmarshalLoopActivityDefinition("aggregate-sum.yml", refs)
+	require.NoError(t, err)
+	require.Equal(t, "$.amount", def.Aggregate.Path)
+
+	_, err = config.UnmarshalLoopActivityDefinition("aggregate-sum-no-path.yml", refs)
+	require.Error(t, err)
+}
@@ -2,12 +2,14 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/fileutil"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-mongo-common/jsonops"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -41,6 +43,49 @@ var opTypes = map[jsonops.MongoJsonOperationType]struct{}{
 	jsonops.InsertOneOperationType:        struct{}{},
 }
 
+// transactionalOpTypes are the op types that can take part in a transaction scope. These are executed by means of their write model.
+var transactionalOpTypes = map[jsonops.MongoJsonOperationType]struct{}{
+	jsonops.ReplaceOneOperationType: struct{}{},
+	jsonops.UpdateOneOperationType:  struct{}{},
+	jsonops.UpdateManyOperationType: struct{}{},
+	jsonops.DeleteManyOperationType: struct{}{},
+	jsonops.InsertOneOperationType:  struct{}{},
+}
+
+func IsMongoTransactionalOpType(opType jsonops.MongoJsonOperationType) bool {
+	_, ok := transactionalOpTypes[opType]
+	return ok
+}
+
+const (
+	MongoStatementPartQuery      jsonops.MongoJsonOperationStatementPart = "query"
+	MongoStatementPartSort       jsonops.MongoJsonOperationStatementPart = "sort"
+	MongoStatementPartProjection jsonops.MongoJsonOperationStatementPart = "projection"
+	MongoStatementPartPipeline   jsonops.MongoJsonOperationStatementPart = "pipeline"
+
+	MongoPaginationDefaultPageSize = 100
+)
+
+// paginatedOpTypes are the op types that support the pagination config with the statement parts the pagination executes on its own cursor.
+var paginatedOpTypes = map[jsonops.MongoJsonOperationType][]jsonops.MongoJsonOperationStatementPart{
+	jsonops.FindManyOperationType:     {MongoStatementPartQuery, MongoStatementPartSort, MongoStatementPartProjection},
+	jsonops.AggregateOneOperationType: {MongoStatementPartPipeline},
+}
+
+// MongoPaginationConfig splits the result set of a find or aggregate in pages. The continuation-token is resolved against the
+// expression context of the activity (i.e. "{$.token}") and identifies the page to fetch: when empty the first page is returned.
+// The token of the following page is set in the continuation-token-var process var (empty when the result set has been exhausted).
+type MongoPaginationConfig struct {
+	PageSize                 int    `yaml:"page-size,omitempty" json:"page-size,omitempty" mapstructure:"page-size,omitempty"`
+	MaxDocuments             int    `yaml:"max-documents,omitempty" json:"max-documents,omitempty" mapstructure:"max-documents,omitempty"`
+	ContinuationToken        string `yaml:"continuation-token,omitempty" json:"continuation-token,omitempty" mapstructure:"continuation-token,omitempty"`
+	ContinuationTokenVarName string `yaml:"continuation-token-var,omitempty" json:"continuation-token-var,omitempty" mapstructure:"continuation-token-var,omitempty"`
+}
+
+func (pg *MongoPaginationConfig) IsZero() bool {
+	return pg == nil || (pg.PageSize == 0 && pg.MaxDocuments == 0 && pg.ContinuationToken == "" && pg.ContinuationTokenVarName == "")
+}
+
 type MongoActivityDefinition struct {
 	OpType            jsonops.MongoJsonOperationType                     `yaml:"op-type,omitempty" json:"op-type,omitempty" mapstructure:"op-type,omitempty"`
 	LksName           string                                             `yaml:"lks-name,omitempty" json:"lks-name,omitempty" mapstructure:"lks-name,omitempty"`
@@ -48,6 +93,7 @@ type MongoActivityDefinition struct {
 	StatementData     map[jsonops.MongoJsonOperationStatementPart]string `yaml:"statement,omitempty" json:"statement,omitempty" mapstructure:"statement,omitempty"`
 	OnResponseActions OnResponseActions                                  `yaml:"on-response,omitempty" json:"on-response,omitempty" mapstructure:"on-response,omitempty"`
 	CacheConfig       CacheConfig                                        `yaml:"with-cache,omitempty" json:"with-cache,omitempty" mapstructure:"with-cache,omitempty"`
+	Pagination        *MongoPaginationConfig                             `yaml:"pagination,omitempty" json:"pagination,omitempty" mapstructure:"pagination,omitempty"`
 	Statement         interface{}                                        `yaml:"-" json:"-" mapstructure:"-"`
 }
 
@@ -79,6 +125,39 @@ func UnmarshalMongoActivityDefinition(opType jsonops.MongoJsonOperationType, def
 		maDef.CacheConfig = CacheConfig{}
 	}
 
+	if maDef.Pagination.IsZero() {
+		maDef.Pagination = nil
+	} else {
+		parts, ok := paginatedOpTypes[opType]
+		if !ok {
+			err = errors.New("pagination not supported by op-type")
+			log.Error().Err(err).Str("op-type", string(opType)).Msg(semLogContext)
+			return maDef, err
+		}
+
+		// the parts not executed by the pagination would be silently ignored.
+		for n := range maDef.StatementData {
+			if !slices.Contains(parts, n) {
+				err = fmt.Errorf("statement part %s not supported by the pagination of op-type %s", n, opType)
+				log.Error().Err(err).Str("op-type", string(opType)).Msg(semLogContext)
+				return maDef, err
+			}
+		}
+
+		if opType == jsonops.AggregateOneOperationType && maDef.StatementData[MongoStatementPartPipeline] == "" {
+			err = errors.New("paginated aggregate requires the pipeline statement part")
+			log.Error().Err(err).Str("op-type", string(opType)).Msg(semLogContext)
+			return maDef, err
+		}
+
+		if maDef.Pagination.PageSize <= 0 {
+			maDef.Pagination.PageSize = MongoPaginationDefaultPageSize
+		}
+
+		// Paginated results are not cached.
+		maDef.CacheConfig = CacheConfig{}
+	}
+
 	return maDef, nil
 }
 
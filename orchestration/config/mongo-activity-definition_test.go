@@ -0,0 +1,35 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-mongo-common/jsonops"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMongoPaginationDefinition(t *testing.T) {
+	refs := config.DataReferences{
+		{Path: "find.yml", Data: []byte("statement:\n  query: '{\"status\": \"active\"}'\n  sort: '{\"name\": 1}'\npagination:\n  max-documents: 50\n")},
+		{Path: "find-opts.yml", Data: []byte("statement:\n  query: '{}'\n  opts: '{\"skip\": 10}'\npagination:\n  page-size: 10\n")},
+		{Path: "aggregate.yml", Data: []byte("statement:\n  pipeline: '[{\"$match\": {}}]'\npagination:\n  page-size: 10\n")},
+		{Path: "aggregate-no-pipeline.yml", Data: []byte("statement:\n  query: '{}'\npagination:\n  page-size: 10\n")},
+	}
+
+	def, err := config.UnmarshalMongoActivityDefinition(jsonops.FindManyOperationType, "find.yml", refs)
+	require.NoError(t, err)
+	require.Equal(t, config.MongoPaginationDefaultPageSize, def.Pagination.PageSize)
+
+	// the parts not executed by the pagination are rejected instead of being ignored.
+	_, err = config.UnmarshalMongoActivityDefinition(jsonops.FindManyOperationType, "find-opts.yml", refs)
+	require.Error(t, err)
+
+	_, err = config.UnmarshalMongoActivityDefinition(jsonops.AggregateOneOperationType, "aggregate.yml", refs)
+	require.NoError(t, err)
+
+	_, err = config.UnmarshalMongoActivityDefinition(jsonops.AggregateOneOperationType, "aggregate-no-pipeline.yml", refs)
+	require.Error(t, err)
+
+	_, err = config.UnmarshalMongoActivityDefinition(jsonops.FindOneOperationType, "find.yml", refs)
+	require.Error(t, err)
+}
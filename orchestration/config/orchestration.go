@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -63,10 +64,29 @@ func (dr DataReferences) IsPresent(p string) bool {
 //	return len(expr.Terms) == 0
 //}
 
+const (
+	ProcessVarTypeString   = "string"
+	ProcessVarTypeInt      = "int"
+	ProcessVarTypeFloat    = "float"
+	ProcessVarTypeDecimal  = "decimal"
+	ProcessVarTypeBool     = "bool"
+	ProcessVarTypeDate     = "date"
+	ProcessVarTypeDateTime = "datetime"
+	ProcessVarTypeObject   = "object"
+	ProcessVarTypeArray    = "array"
+
+	ProcessVarDefaultDateLayout     = "2006-01-02"
+	ProcessVarDefaultDateTimeLayout = time.RFC3339
+)
+
 type ProcessVar struct {
-	Name        string        `yaml:"name,omitempty" mapstructure:"name,omitempty" json:"name,omitempty"`
-	Value       string        `yaml:"value,omitempty" mapstructure:"value,omitempty" json:"value,omitempty"`
+	Name  string `yaml:"name,omitempty" mapstructure:"name,omitempty" json:"name,omitempty"`
+	Value string `yaml:"value,omitempty" mapstructure:"value,omitempty" json:"value,omitempty"`
+
+	// Type when set the values of the variable are coerced to it, untyped if empty. Layout is the go layout of the date and datetime types.
+	// Decimals, dates and datetimes are stored as strings and compare as such in the expressions, see wfexpressions.VarType.
 	Type        string        `yaml:"type,omitempty" mapstructure:"type,omitempty" json:"type,omitempty"`
+	Layout      string        `yaml:"layout,omitempty" mapstructure:"layout,omitempty" json:"layout,omitempty"`
 	Guard       string        `yaml:"guard,omitempty" mapstructure:"guard,omitempty" json:"guard,omitempty"`
 	GlobalScope bool          `yaml:"global,omitempty" mapstructure:"global,omitempty" json:"global,omitempty"`
 	DltHeader   bool          `yaml:"dlt-header,omitempty" mapstructure:"dlt-header,omitempty" json:"dlt-header,omitempty"`
@@ -75,6 +95,36 @@ type ProcessVar struct {
 	// ParsedExpr ProcessVarDefinitionValueExpression `mapstructure:"-" yaml:"-" json:"-"`
 }
 
+func (pv ProcessVar) ValidateType() error {
+	switch pv.Type {
+	case "", ProcessVarTypeString, ProcessVarTypeInt, ProcessVarTypeFloat, ProcessVarTypeDecimal, ProcessVarTypeBool, ProcessVarTypeObject, ProcessVarTypeArray:
+		if pv.Layout != "" {
+			return fmt.Errorf("process var %s: layout is allowed for date and datetime types only", pv.Name)
+		}
+	case ProcessVarTypeDate, ProcessVarTypeDateTime:
+	default:
+		return fmt.Errorf("process var %s: unsupported type %s", pv.Name, pv.Type)
+	}
+
+	return nil
+}
+
+// LayoutWithDefault returns the layout of the date types, the default one of the type if not set.
+func (pv ProcessVar) LayoutWithDefault() string {
+	if pv.Layout != "" {
+		return pv.Layout
+	}
+
+	switch pv.Type {
+	case ProcessVarTypeDate:
+		return ProcessVarDefaultDateLayout
+	case ProcessVarTypeDateTime:
+		return ProcessVarDefaultDateTimeLayout
+	}
+
+	return ""
+}
+
 /*
 func ParseProcessVarDefinitionValueExpression(v string) (ProcessVarDefinitionValueExpression, error) {
 
@@ -119,14 +169,38 @@ type ExecBoundary struct {
 	Activities []string `yaml:"activities,omitempty" mapstructure:"activities,omitempty" json:"activities,omitempty"`
 }
 
+// TransactionScope groups mongo activities that share a session bound to the case. The transaction is committed when the
+// execution leaves the scope and it is aborted if one of the activities (or the orchestration) errors.
+type TransactionScope struct {
+	Name       string   `yaml:"name,omitempty" mapstructure:"name,omitempty" json:"name,omitempty"`
+	Activities []string `yaml:"activities,omitempty" mapstructure:"activities,omitempty" json:"activities,omitempty"`
+}
+
+func (ts TransactionScope) Contains(activityName string) bool {
+	for _, n := range ts.Activities {
+		if n == activityName {
+			return true
+		}
+	}
+
+	return false
+}
+
 /*
  * Orchestration
  */
 const (
 	OrchestrationPropertyPathSelectionPolicy = "selection-path-policy"
 	OrchestrationPropertyRequestDeadline     = "request-deadline"
+	OrchestrationPropertyStrictMode          = "strict-mode"
+	OrchestrationPropertyExpressionLanguage  = "expression-language"
 	ExactlyOne                               = "exactly-one"
 	AtLeastOne                               = "at-least-one"
+
+	// OrchestrationPropertyHarRecordFolder records the har of every execution in the folder as <case-id>.har, to be replayed with
+	// OrchestrationPropertyHarReplayFile. OrchestrationPropertyHarReplayFile serves the outbound calls of the executions from the recorded har.
+	OrchestrationPropertyHarRecordFolder = "har-record-folder"
+	OrchestrationPropertyHarReplayFile   = "har-replay-file"
 )
 
 type Orchestration struct {
@@ -138,6 +212,7 @@ type Orchestration struct {
 	Paths                Paths                             `yaml:"paths,omitempty" mapstructure:"paths,omitempty" json:"paths,omitempty"`
 	Activities           []Configurable                    `json:"-" yaml:"activities"`
 	Boundaries           []ExecBoundary                    `yaml:"boundaries,omitempty" mapstructure:"boundaries,omitempty" json:"boundaries,omitempty"`
+	Transactions         []TransactionScope                `yaml:"transactions,omitempty" mapstructure:"transactions,omitempty" json:"transactions,omitempty"`
 	RawActivities        []json.RawMessage                 `json:"activities" yaml:"-"`
 	References           DataReferences                    `yaml:"-" mapstructure:"-" json:"-"`
 	Dictionaries         Dictionaries                      `yaml:"-" mapstructure:"-" json:"-"`
@@ -266,6 +341,30 @@ func (o *Orchestration) GetPropertyAsString(n string, defaultValue string) strin
 	return defaultValue
 }
 
+func (o *Orchestration) GetPropertyAsBool(n string, defaultValue bool) bool {
+	const semLogContext = "config::orchestration-get-property-as-bool"
+
+	val := defaultValue
+	if v, ok := o.Properties[n]; ok {
+		log.Info().Interface(n, v).Msg(semLogContext)
+		switch vt := v.(type) {
+		case bool:
+			val = vt
+		case string:
+			b, err := strconv.ParseBool(vt)
+			if err != nil {
+				log.Warn().Err(err).Str("value", vt).Msg(semLogContext)
+			} else {
+				val = b
+			}
+		default:
+			log.Warn().Interface("value", v).Str("of-type", fmt.Sprintf("%T", v)).Msg(semLogContext)
+		}
+	}
+
+	return val
+}
+
 func (o *Orchestration) GetPropertyAsDuration(n string, defaultValue time.Duration) time.Duration {
 	const semLogContext = "config::orchestration-get-property-as-duration"
 
@@ -314,6 +413,16 @@ func (o *Orchestration) FindBoundaryByName(n string) (ExecBoundary, bool) {
 	return ExecBoundary{}, false
 }
 
+func (o *Orchestration) FindTransactionScopeByActivityName(n string) (TransactionScope, bool) {
+	for _, ts := range o.Transactions {
+		if ts.Contains(n) {
+			return ts, true
+		}
+	}
+
+	return TransactionScope{}, false
+}
+
 func (o *Orchestration) AddActivity(a Configurable) error {
 
 	if o.FindActivityByName(a.Name()) != nil {
@@ -456,13 +565,14 @@ func (o *Orchestration) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type orchestration Orchestration
 
 	var m struct {
-		Id          string                            `yaml:"id,omitempty" mapstructure:"id,omitempty" json:"id,omitempty"`
-		Description string                            `yaml:"description,omitempty" mapstructure:"description,omitempty" json:"description,omitempty"`
-		Paths       []Path                            `yaml:"paths,omitempty" mapstructure:"paths,omitempty" json:"paths,omitempty"`
-		Boundaries  []ExecBoundary                    `yaml:"boundaries,omitempty" mapstructure:"boundaries,omitempty" json:"boundaries,omitempty"`
-		Activities  []map[string]interface{}          `json:"activities" yaml:"activities"`
-		PII         PersonallyIdentifiableInformation `yaml:"pii,omitempty" mapstructure:"pii,omitempty" json:"pii,omitempty"`
-		Properties  map[string]interface{}            `yaml:"properties,omitempty" mapstructure:"properties,omitempty" json:"properties,omitempty"`
+		Id           string                            `yaml:"id,omitempty" mapstructure:"id,omitempty" json:"id,omitempty"`
+		Description  string                            `yaml:"description,omitempty" mapstructure:"description,omitempty" json:"description,omitempty"`
+		Paths        []Path                            `yaml:"paths,omitempty" mapstructure:"paths,omitempty" json:"paths,omitempty"`
+		Boundaries   []ExecBoundary                    `yaml:"boundaries,omitempty" mapstructure:"boundaries,omitempty" json:"boundaries,omitempty"`
+		Transactions []TransactionScope                `yaml:"transactions,omitempty" mapstructure:"transactions,omitempty" json:"transactions,omitempty"`
+		Activities   []map[string]interface{}          `json:"activities" yaml:"activities"`
+		PII          PersonallyIdentifiableInformation `yaml:"pii,omitempty" mapstructure:"pii,omitempty" json:"pii,omitempty"`
+		Properties   map[string]interface{}            `yaml:"properties,omitempty" mapstructure:"properties,omitempty" json:"properties,omitempty"`
 	}
 	m.Activities = make([]map[string]interface{}, 0)
 	err := unmarshal(&m)
@@ -474,6 +584,7 @@ func (o *Orchestration) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	o.Id = m.Id
 	o.Description = m.Description
 	o.Boundaries = m.Boundaries
+	o.Transactions = m.Transactions
 	o.PII = m.PII
 	o.Properties = m.Properties
 	for _, a := range m.Activities {
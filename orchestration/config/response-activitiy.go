@@ -23,12 +23,16 @@ type OnCacheMiss struct {
 }
 
 type Response struct {
-	Id                string          `yaml:"id,omitempty" mapstructure:"id,omitempty" json:"id,omitempty"`
-	Guard             string          `yaml:"guard,omitempty" mapstructure:"guard,omitempty" json:"guard,omitempty"`
-	RefSimpleResponse string          `yaml:"ref-simple-response,omitempty" mapstructure:"ref-simple-response,omitempty" json:"ref-simple-response,omitempty"`
-	Headers           []NameValuePair `yaml:"headers,omitempty" json:"headers,omitempty" mapstructure:"headers,omitempty"`
-	StatusCode        int             `yaml:"status-code,omitempty" mapstructure:"status-code,omitempty" json:"status-code,omitempty"`
-	Cache             CacheInfo       `yaml:"cache,omitempty" mapstructure:"cache,omitempty" json:"cache,omitempty"`
+	Id                string `yaml:"id,omitempty" mapstructure:"id,omitempty" json:"id,omitempty"`
+	Guard             string `yaml:"guard,omitempty" mapstructure:"guard,omitempty" json:"guard,omitempty"`
+	RefSimpleResponse string `yaml:"ref-simple-response,omitempty" mapstructure:"ref-simple-response,omitempty" json:"ref-simple-response,omitempty"`
+
+	// Passthrough id of the har entry (i.e. the name of an activity or the id of an endpoint) whose response body is returned as is together with its content type.
+	// Meant for binary content (pdf, images) that cannot go through the json templates. The status code defaults to the one of the entry.
+	Passthrough string          `yaml:"passthrough,omitempty" mapstructure:"passthrough,omitempty" json:"passthrough,omitempty"`
+	Headers     []NameValuePair `yaml:"headers,omitempty" json:"headers,omitempty" mapstructure:"headers,omitempty"`
+	StatusCode  int             `yaml:"status-code,omitempty" mapstructure:"status-code,omitempty" json:"status-code,omitempty"`
+	Cache       CacheInfo       `yaml:"cache,omitempty" mapstructure:"cache,omitempty" json:"cache,omitempty"`
 }
 
 type ResponseActivity struct {
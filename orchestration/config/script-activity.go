@@ -3,11 +3,13 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/fileutil"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type ScriptActivity struct {
@@ -75,11 +77,24 @@ func NewScriptActivityFromYAML(b []byte /* mp interface{}*/) (Configurable, erro
 	return sa, nil
 }
 
+const (
+	ScriptEngineTengo      = "tengo"
+	ScriptEngineStarlark   = "starlark"
+	ScriptEngineJavascript = "javascript"
+)
+
 type ScriptActivityParam struct {
 	Name  string `yaml:"name,omitempty" json:"name,omitempty" mapstructure:"name,omitempty"`
 	Value string `yaml:"value,omitempty" json:"value,omitempty" mapstructure:"value,omitempty"`
 }
 
+// ScriptModule is a tengo module of the bundle: scripts import it by name.
+type ScriptModule struct {
+	Name    string `yaml:"name,omitempty" json:"name,omitempty" mapstructure:"name,omitempty"`
+	RefPath string `yaml:"ref-path,omitempty" json:"ref-path,omitempty" mapstructure:"ref-path,omitempty"`
+	Source  []byte `yaml:"-" json:"-" mapstructure:"-"`
+}
+
 type ScriptActivityDefinition struct {
 	Engine            string                `yaml:"engine,omitempty" json:"engine,omitempty" mapstructure:"engine,omitempty"`
 	Script            string                `yaml:"script,omitempty" json:"script,omitempty" mapstructure:"script,omitempty"`
@@ -87,6 +102,27 @@ type ScriptActivityDefinition struct {
 	StdLibModules     []string              `yaml:"std-lib,omitempty" json:"std-lib,omitempty" mapstructure:"std-lib,omitempty"`
 	Params            []ScriptActivityParam `yaml:"params,omitempty" json:"params,omitempty" mapstructure:"params,omitempty"`
 	OnResponseActions OnResponseActions     `yaml:"on-response,omitempty" json:"on-response,omitempty" mapstructure:"on-response,omitempty"`
+
+	// Modules of the bundle and ModulesFolder, a shared library folder, resolve the imports of tengo scripts that are not std-lib modules. In the folder the
+	// module 'name' is the file 'name.tengo'.
+	Modules       []ScriptModule `yaml:"modules,omitempty" json:"modules,omitempty" mapstructure:"modules,omitempty"`
+	ModulesFolder string         `yaml:"modules-folder,omitempty" json:"modules-folder,omitempty" mapstructure:"modules-folder,omitempty"`
+
+	// Limits of the execution of the script, zero values mean no limit. The timeout applies to all the engines, max-allocs and max-const-objects to tengo,
+	// max-steps to starlark and max-call-stack-size to javascript.
+	MaxAllocs        int64         `yaml:"max-allocs,omitempty" json:"max-allocs,omitempty" mapstructure:"max-allocs,omitempty"`
+	MaxConstObjects  int           `yaml:"max-const-objects,omitempty" json:"max-const-objects,omitempty" mapstructure:"max-const-objects,omitempty"`
+	MaxSteps         uint64        `yaml:"max-steps,omitempty" json:"max-steps,omitempty" mapstructure:"max-steps,omitempty"`
+	MaxCallStackSize int           `yaml:"max-call-stack-size,omitempty" json:"max-call-stack-size,omitempty" mapstructure:"max-call-stack-size,omitempty"`
+	Timeout          time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+}
+
+func (def *ScriptActivityDefinition) GetEngine() string {
+	if def.Engine == "" {
+		return ScriptEngineTengo
+	}
+
+	return def.Engine
 }
 
 func (def *ScriptActivityDefinition) IsZero() bool {
@@ -145,5 +181,40 @@ func UnmarshalScriptActivityDefinition(def string, refs DataReferences) (ScriptA
 		return maDef, err
 	}
 
+	if maDef.MaxAllocs < 0 || maDef.MaxConstObjects < 0 || maDef.MaxCallStackSize < 0 || maDef.Timeout < 0 {
+		err = errors.New("script max-allocs, max-const-objects, max-call-stack-size and timeout cannot be negative")
+		log.Error().Err(err).Str("script-name", maDef.Script).Msg(semLogContext)
+		return maDef, err
+	}
+
+	switch maDef.GetEngine() {
+	case ScriptEngineTengo, ScriptEngineStarlark, ScriptEngineJavascript:
+	default:
+		err = fmt.Errorf("unsupported script engine %s", maDef.Engine)
+		log.Error().Err(err).Str("script-name", maDef.Script).Msg(semLogContext)
+		return maDef, err
+	}
+
+	if (len(maDef.Modules) > 0 || maDef.ModulesFolder != "") && maDef.GetEngine() != ScriptEngineTengo {
+		err = fmt.Errorf("script modules are not supported by the %s engine", maDef.GetEngine())
+		log.Error().Err(err).Str("script-name", maDef.Script).Msg(semLogContext)
+		return maDef, err
+	}
+
+	for i, m := range maDef.Modules {
+		if m.Name == "" {
+			err = errors.New("script module must have a name")
+			log.Error().Err(err).Str("script-name", maDef.Script).Msg(semLogContext)
+			return maDef, err
+		}
+
+		maDef.Modules[i].Source, ok = refs.Find(m.RefPath)
+		if !ok {
+			err = fmt.Errorf("cannot find script module %s at %s", m.Name, m.RefPath)
+			log.Error().Err(err).Str("script-name", maDef.Script).Msg(semLogContext)
+			return maDef, err
+		}
+	}
+
 	return maDef, nil
 }
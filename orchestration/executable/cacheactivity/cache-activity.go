@@ -14,7 +14,6 @@ import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
-	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
@@ -23,6 +22,7 @@ import (
 type CacheActivity struct {
 	executable.Activity
 	definition config.CacheActivityDefinition
+	onMiss     OnMissFunc
 }
 
 func NewCacheActivity(item config.Configurable, refs config.DataReferences) (*CacheActivity, error) {
@@ -122,11 +122,27 @@ func (a *CacheActivity) Execute(wfc *wfcase.WfCase) error {
 	}
 
 	var harResponse *har.Response
-	switch a.definition.Operation {
-	case config.CacheOperationGet:
+	switch {
+	case wfc.IsReplaying():
+		harResponse, err = a.replay(wfc)
+	case a.definition.Operation == config.CacheOperationGet:
 		harResponse, err = a.executeGet(wfc, cacheCfg)
-	case config.CacheOperationSet:
+	case a.definition.Operation == config.CacheOperationSet:
 		harResponse, err = a.executeSet(wfc, expressionCtx, cacheCfg)
+	case a.definition.Operation == config.CacheOperationDelete:
+		harResponse, err = a.executeDelete(wfc, cacheCfg)
+	case a.definition.Operation == config.CacheOperationExists:
+		harResponse, err = a.executeExists(wfc, cacheCfg)
+	case a.definition.Operation == config.CacheOperationIncr:
+		harResponse, err = a.executeIncrement(wfc, cacheCfg, a.definition.Delta)
+	case a.definition.Operation == config.CacheOperationDecr:
+		harResponse, err = a.executeIncrement(wfc, cacheCfg, -a.definition.Delta)
+	case a.definition.Operation == config.CacheOperationMultiGet:
+		harResponse, err = a.executeMultiGet(wfc, evaluator, cacheCfg)
+	case a.definition.Operation == config.CacheOperationMultiSet:
+		harResponse, err = a.executeMultiSet(wfc, evaluator, cacheCfg)
+	case a.definition.Operation == config.CacheOperationGetOrSet:
+		harResponse, err = a.executeGetOrSet(wfc, expressionCtx, cacheCfg)
 	default:
 		err = errors.New("unknown operation")
 		log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
@@ -161,6 +177,17 @@ func (a *CacheActivity) Execute(wfc *wfcase.WfCase) error {
 	return err
 }
 
+// replay serves the recorded entry of the operation. The on-miss orchestration of a get-or-set is not executed since its outcome is part of the recorded response.
+func (a *CacheActivity) replay(wfc *wfcase.WfCase) (*har.Response, error) {
+	e, err := wfc.ReplayHarEntry(a.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	_ = wfc.SetHarEntry(a.Name(), e)
+	return e.Response, nil
+}
+
 func (a *CacheActivity) executeGet(wfc *wfcase.WfCase, cacheConfig config.CacheConfig) (*har.Response, error) {
 	cacheHarEntry, err := cacheoperation.Get(
 		cacheConfig.LinkedServiceRef,
@@ -242,7 +269,17 @@ func (a *CacheActivity) resolveCacheConfig(wfc *wfcase.WfCase, resolver *wfexpre
 		}
 	}
 
-	s, _, err := varResolver.ResolveVariables(cfg.Key, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	// the broker, i.e. the linked service holding the credentials, can be given by a secret. The key is resolved without them not to store
+	// secrets in the cache.
+	restore := resolver.WithSecrets()
+	brokerName, err := resolver.Interpolate(cfg.LinkedServiceRef.Name)
+	restore()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.LinkedServiceRef.Name = brokerName
+
+	s, err := resolver.Interpolate(cfg.Key)
 	if err != nil {
 		return cfg, err
 	}
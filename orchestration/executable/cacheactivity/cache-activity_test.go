@@ -0,0 +1,37 @@
+package cacheactivity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cachelks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/secrets"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveCacheConfigSecrets(t *testing.T) {
+	secrets.SetProvider(secrets.NewFakeProvider(map[string]string{"tenant-broker": "tenant-redis"}), time.Minute)
+
+	wfc, err := wfcase.NewWorkflowCase("cache-test", "", "", "", nil, nil, nil, nil)
+	require.NoError(t, err)
+	resolver, err := wfexpressions.NewEvaluator("cache-test", wfexpressions.WithProcessVars(wfc.Vars))
+	require.NoError(t, err)
+
+	a := &CacheActivity{}
+	cfg, err := a.resolveCacheConfig(wfc, resolver, config.CacheConfig{
+		Key:              "token-{secret:tenant-broker}",
+		LinkedServiceRef: cachelks.CacheLinkedServiceRef{Name: "{secret:tenant-broker}", Typ: cachelks.RedisLinkedServiceType},
+	}, nil)
+	require.NoError(t, err)
+
+	// the secrets are resolved in the broker only, the key does not carry them.
+	require.Equal(t, "tenant-redis", cfg.LinkedServiceRef.Name)
+	require.Equal(t, "token-", cfg.Key)
+
+	s, err := resolver.Interpolate("{secret:tenant-broker}")
+	require.NoError(t, err)
+	require.Empty(t, s)
+}
@@ -0,0 +1,164 @@
+package cacheactivity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cachelks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cachelks/gocachelks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cachelks/redislks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cachelksregistry"
+	"github.com/redis/go-redis/v9"
+)
+
+// nativeCache provides the operations that cannot be built on top of the Get and Set of the linked services: a Get followed by a Set is not
+// atomic across the instances sharing a Redis and a Set cannot remove a key.
+type nativeCache interface {
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+
+	// IncrBy adds delta to the counter at key and returns the new value. A missing key counts as zero; the ttl, if any, is applied when the
+	// counter is created and it is not extended by the following increments (i.e. a fixed window for rate limits).
+	IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// getNativeCache returns the native operations of the linked service on the keys of the namespace, if any, so that they address the same entries
+// of the get and set operations.
+func getNativeCache(ref cachelks.CacheLinkedServiceRef, namespace string) (nativeCache, error) {
+	lks, err := cachelksregistry.GetLinkedServiceOfType(ref.Typ, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tlks := lks.(type) {
+	case *redislks.LinkedService:
+		return &redisNativeCache{rdb: tlks.Client(), namespace: namespace}, nil
+	case *gocachelks.LinkedService:
+		return &goCacheNativeCache{lks: tlks, namespace: namespace}, nil
+	}
+
+	return nil, fmt.Errorf("linked service %s of type %s does not support delete, exists and counter operations", ref.Name, ref.Typ)
+}
+
+// namespacedKey is the key of the entry stored by the linked services under a namespace, for the commands that do not take the cache options.
+func namespacedKey(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+
+	return namespace + ":" + key
+}
+
+// incrByScript increments and sets the expiry of a new counter in a single step: an INCRBY followed by an EXPIRE would leave a counter with
+// no expiry if the second command is lost.
+var incrByScript = redis.NewScript(`
+local v = redis.call('INCRBY', KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call('PTTL', KEYS[1]) == -1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return v
+`)
+
+type redisNativeCache struct {
+	rdb       redis.Cmdable
+	namespace string
+}
+
+func (c *redisNativeCache) Delete(ctx context.Context, key string) error {
+	return c.rdb.Del(ctx, namespacedKey(c.namespace, key)).Err()
+}
+
+func (c *redisNativeCache) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := c.rdb.Exists(ctx, namespacedKey(c.namespace, key)).Result()
+	return n > 0, err
+}
+
+func (c *redisNativeCache) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return incrByScript.Run(ctx, c.rdb, []string{namespacedKey(c.namespace, key)}, delta, ttl.Milliseconds()).Int64()
+}
+
+// goCacheCounter is the value of a counter in go-cache. The cache holds the pointer so that the increments do not need a Set, that would
+// extend the ttl of the counter, and are atomic without a lock.
+type goCacheCounter struct {
+	v atomic.Int64
+}
+
+func (c *goCacheCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.v.Load())
+}
+
+func (c *goCacheCounter) String() string {
+	return strconv.FormatInt(c.v.Load(), 10)
+}
+
+// goCacheCountersMu serializes the creation of the counters. go-cache lives in the process so the lock covers every client of the cache.
+var goCacheCountersMu sync.Mutex
+
+// goCacheStore is the part of the go-cache linked service used by the native operations.
+type goCacheStore interface {
+	Get(ctx context.Context, key string, opts cachelks.CacheOptions) (interface{}, error)
+	Set(ctx context.Context, key string, value interface{}, opts cachelks.CacheOptions) error
+	Delete(key string)
+}
+
+type goCacheNativeCache struct {
+	lks       goCacheStore
+	namespace string
+}
+
+func (c *goCacheNativeCache) Delete(ctx context.Context, key string) error {
+	c.lks.Delete(namespacedKey(c.namespace, key))
+	return nil
+}
+
+func (c *goCacheNativeCache) Exists(ctx context.Context, key string) (bool, error) {
+	v, err := c.lks.Get(ctx, key, cachelks.CacheOptions{Namespace: c.namespace})
+	return v != nil, err
+}
+
+func (c *goCacheNativeCache) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	counter, err := c.getCounter(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if counter == nil {
+		goCacheCountersMu.Lock()
+		defer goCacheCountersMu.Unlock()
+
+		// the counter may have been created while waiting for the lock.
+		counter, err = c.getCounter(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		if counter == nil {
+			counter = &goCacheCounter{}
+			err = c.lks.Set(ctx, key, counter, cachelks.CacheOptions{Namespace: c.namespace, Ttl: ttl})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return counter.v.Add(delta), nil
+}
+
+func (c *goCacheNativeCache) getCounter(ctx context.Context, key string) (*goCacheCounter, error) {
+	v, err := c.lks.Get(ctx, key, cachelks.CacheOptions{Namespace: c.namespace})
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	counter, ok := v.(*goCacheCounter)
+	if !ok {
+		return nil, fmt.Errorf("cached value of type %T is not a counter", v)
+	}
+
+	return counter, nil
+}
@@ -0,0 +1,151 @@
+package cacheactivity
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cachelks"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisNativeCache(t *testing.T) {
+	s := miniredis.RunT(t)
+	c := &redisNativeCache{rdb: redis.NewClient(&redis.Options{Addr: s.Addr()})}
+	ctx := context.Background()
+
+	v, err := c.IncrBy(ctx, "requests", 2, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), v)
+
+	// the ttl is set by the increment that creates the counter and it is not extended by the following ones.
+	s.FastForward(30 * time.Second)
+	v, err = c.IncrBy(ctx, "requests", -1, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), v)
+	require.Equal(t, 30*time.Second, s.TTL("requests"))
+
+	v, err = c.IncrBy(ctx, "no-ttl", 1, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), v)
+	require.Zero(t, s.TTL("no-ttl"))
+
+	s.FastForward(30 * time.Second)
+	ok, err := c.Exists(ctx, "requests")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, s.Set("token", "abc"))
+	ok, err = c.Exists(ctx, "token")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.NoError(t, c.Delete(ctx, "token"))
+	ok, err = c.Exists(ctx, "token")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, err = c.IncrBy(ctx, "no-ttl", 1, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Set("token", "abc"))
+	_, err = c.IncrBy(ctx, "token", 1, 0)
+	require.Error(t, err)
+
+	// the keys of a namespace are the ones of the get and set operations.
+	nc := &redisNativeCache{rdb: c.rdb, namespace: "rate-limits"}
+	v, err = nc.IncrBy(ctx, "requests", 3, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), v)
+	n, err := s.Get("rate-limits:requests")
+	require.NoError(t, err)
+	require.Equal(t, "3", n)
+
+	ok, err = nc.Exists(ctx, "token")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, nc.Delete(ctx, "requests"))
+	require.False(t, s.Exists("rate-limits:requests"))
+}
+
+type fakeGoCacheStore struct {
+	mu    sync.Mutex
+	items map[string]interface{}
+	ttls  map[string]time.Duration
+}
+
+func (s *fakeGoCacheStore) Get(ctx context.Context, key string, opts cachelks.CacheOptions) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.items[namespacedKey(opts.Namespace, key)], nil
+}
+
+func (s *fakeGoCacheStore) Set(ctx context.Context, key string, value interface{}, opts cachelks.CacheOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[namespacedKey(opts.Namespace, key)] = value
+	s.ttls[namespacedKey(opts.Namespace, key)] = opts.Ttl
+	return nil
+}
+
+func (s *fakeGoCacheStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+}
+
+func TestGoCacheNativeCache(t *testing.T) {
+	store := &fakeGoCacheStore{items: make(map[string]interface{}), ttls: make(map[string]time.Duration)}
+	c := &goCacheNativeCache{lks: store}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.IncrBy(ctx, "requests", 1, time.Minute)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	v, err := c.IncrBy(ctx, "requests", -10, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, int64(40), v)
+	require.Equal(t, time.Minute, store.ttls["requests"])
+
+	b, err := json.Marshal(store.items["requests"])
+	require.NoError(t, err)
+	require.Equal(t, "40", string(b))
+
+	ok, err := c.Exists(ctx, "requests")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.NoError(t, c.Delete(ctx, "requests"))
+	ok, err = c.Exists(ctx, "requests")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, store.Set(ctx, "token", []byte("abc"), cachelks.CacheOptions{}))
+	_, err = c.IncrBy(ctx, "token", 1, 0)
+	require.Error(t, err)
+
+	// the counters of a namespace do not clash with the keys outside of it.
+	nc := &goCacheNativeCache{lks: store, namespace: "rate-limits"}
+	v, err = nc.IncrBy(ctx, "token", 2, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), v)
+	require.Contains(t, store.items, "rate-limits:token")
+
+	require.NoError(t, nc.Delete(ctx, "token"))
+	ok, err = nc.Exists(ctx, "token")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Contains(t, store.items, "token")
+}
@@ -0,0 +1,313 @@
+package cacheactivity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cachelks"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cachelksregistry"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cacheoperation"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/rs/zerolog/log"
+)
+
+// OnMissFunc produces the value of a get-or-set operation when the key is not in cache. The response is stored when its status is 200.
+type OnMissFunc func(wfc *wfcase.WfCase, expressionCtx wfcase.HarEntryReference) (*har.Response, error)
+
+func (a *CacheActivity) OrchestrationId() string {
+	return a.definition.OrchestrationId
+}
+
+func (a *CacheActivity) SetOnMiss(f OnMissFunc) {
+	a.onMiss = f
+}
+
+func (a *CacheActivity) executeDelete(wfc *wfcase.WfCase, cacheConfig config.CacheConfig) (*har.Response, error) {
+	const semLogContext = "cache-activity::execute-delete"
+
+	c, err := getNativeCache(cacheConfig.LinkedServiceRef, cacheConfig.Namespace)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	now := time.Now()
+	st := http.StatusOK
+	err = c.Delete(context.Background(), cacheConfig.Key)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		st = http.StatusInternalServerError
+	}
+
+	return a.setOperationHarEntry(wfc, now, cacheConfig.Key, nil, st, constants.ContentTypeTextPlain, []byte(http.StatusText(st))), nil
+}
+
+func (a *CacheActivity) executeExists(wfc *wfcase.WfCase, cacheConfig config.CacheConfig) (*har.Response, error) {
+	const semLogContext = "cache-activity::execute-exists"
+
+	c, err := getNativeCache(cacheConfig.LinkedServiceRef, cacheConfig.Namespace)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	now := time.Now()
+	exists, err := c.Exists(context.Background(), cacheConfig.Key)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	st := http.StatusOK
+	if !exists {
+		st = http.StatusNotFound
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{"key": cacheConfig.Key, "exists": exists})
+	return a.setOperationHarEntry(wfc, now, cacheConfig.Key, nil, st, constants.ContentTypeApplicationJson, b), nil
+}
+
+// executeIncrement adds delta to the counter stored at key. A missing key counts as zero and the ttl of the definition is applied
+// when the counter is created.
+func (a *CacheActivity) executeIncrement(wfc *wfcase.WfCase, cacheConfig config.CacheConfig, delta int64) (*har.Response, error) {
+	const semLogContext = "cache-activity::execute-increment"
+
+	c, err := getNativeCache(cacheConfig.LinkedServiceRef, cacheConfig.Namespace)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	now := time.Now()
+	counter, err := c.IncrBy(context.Background(), cacheConfig.Key, delta, cacheConfig.Ttl)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{"key": cacheConfig.Key, "value": counter})
+	return a.setOperationHarEntry(wfc, now, cacheConfig.Key, nil, http.StatusOK, constants.ContentTypeApplicationJson, b), nil
+}
+
+// executeMultiGet returns a json object with the values of the keys. The keys not found in cache are reported as null.
+func (a *CacheActivity) executeMultiGet(wfc *wfcase.WfCase, resolver *wfexpressions.Evaluator, cacheConfig config.CacheConfig) (*har.Response, error) {
+	const semLogContext = "cache-activity::execute-multi-get"
+
+	lks, err := cachelksregistry.GetLinkedServiceOfType(cacheConfig.LinkedServiceRef.Typ, cacheConfig.LinkedServiceRef.Name)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	now := time.Now()
+	opts := cachelks.CacheOptions{Namespace: cacheConfig.Namespace}
+	values := make(map[string]json.RawMessage)
+	var keys []string
+	for _, k := range a.definition.Keys {
+		key, err := a.resolveString(wfc, resolver, k)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		v, err := lks.Get(context.Background(), key, opts)
+		if err != nil {
+			log.Error().Err(err).Str("key", key).Msg(semLogContext)
+			return nil, err
+		}
+
+		values[key] = cachedValueAsJSON(v)
+	}
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	return a.setOperationHarEntry(wfc, now, strings.Join(keys, ","), nil, http.StatusOK, constants.ContentTypeApplicationJson, b), nil
+}
+
+func cachedValueAsJSON(v interface{}) json.RawMessage {
+	var b []byte
+	switch tv := v.(type) {
+	case nil:
+		return json.RawMessage("null")
+	case []byte:
+		b = tv
+	case string:
+		b = []byte(tv)
+	default:
+		b, _ = json.Marshal(tv)
+	}
+
+	if json.Valid(b) {
+		return b
+	}
+
+	b, _ = json.Marshal(string(b))
+	return b
+}
+
+func (a *CacheActivity) executeMultiSet(wfc *wfcase.WfCase, resolver *wfexpressions.Evaluator, cacheConfig config.CacheConfig) (*har.Response, error) {
+	const semLogContext = "cache-activity::execute-multi-set"
+
+	lks, err := cachelksregistry.GetLinkedServiceOfType(cacheConfig.LinkedServiceRef.Typ, cacheConfig.LinkedServiceRef.Name)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	now := time.Now()
+	opts := cachelks.CacheOptions{Namespace: cacheConfig.Namespace, Ttl: cacheConfig.Ttl}
+	items := make(map[string]json.RawMessage)
+	var keys []string
+	st := http.StatusOK
+	for _, item := range a.definition.Items {
+		key, err := a.resolveString(wfc, resolver, item.Key)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		v, err := a.resolveString(wfc, resolver, item.Value)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return nil, err
+		}
+
+		items[key] = cachedValueAsJSON([]byte(v))
+		err = lks.Set(context.Background(), key, []byte(v), opts)
+		if err != nil {
+			log.Error().Err(err).Str("key", key).Msg(semLogContext)
+			st = http.StatusInternalServerError
+			break
+		}
+	}
+
+	b, _ := json.Marshal(items)
+	req := har.WithBody(b)
+	return a.setOperationHarEntry(wfc, now, strings.Join(keys, ","), req, st, constants.ContentTypeTextPlain, []byte(http.StatusText(st))), nil
+}
+
+// executeGetOrSet returns the cached value of key. On a miss the on-miss orchestration is executed and its response, if successful, is stored
+// with the ttl of the definition.
+func (a *CacheActivity) executeGetOrSet(wfc *wfcase.WfCase, expressionCtx wfcase.HarEntryReference, cacheConfig config.CacheConfig) (*har.Response, error) {
+	const semLogContext = "cache-activity::execute-get-or-set"
+
+	resp, err := a.executeGet(wfc, cacheConfig)
+	if err != nil {
+		log.Warn().Err(err).Str("key", cacheConfig.Key).Msg(semLogContext + " cache get failed, computing value")
+	}
+
+	if resp != nil && resp.Status == http.StatusOK {
+		return resp, nil
+	}
+
+	if a.onMiss == nil {
+		return nil, errors.New("get-or-set operation without on-miss orchestration")
+	}
+
+	now := time.Now()
+	resp, err = a.onMiss(wfc, expressionCtx)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		if resp == nil {
+			return nil, err
+		}
+	}
+
+	if resp.Status == http.StatusOK && resp.Content != nil {
+		err = cacheoperation.Set(cacheConfig.LinkedServiceRef, cacheConfig.Key, resp.Content.Data, cachelks.WithNamespace(cacheConfig.Namespace), cachelks.WithTTTL(cacheConfig.Ttl))
+		if err != nil {
+			// the value has been computed anyway: a failure in storing it is not an error of the activity.
+			log.Error().Err(err).Str("key", cacheConfig.Key).Msg(semLogContext)
+		}
+	}
+
+	mimeType := constants.ContentTypeApplicationJson
+	var data []byte
+	if resp.Content != nil {
+		mimeType = resp.Content.MimeType
+		data = resp.Content.Data
+	}
+
+	return a.setOperationHarEntry(wfc, now, cacheConfig.Key, nil, resp.Status, mimeType, data), nil
+}
+
+func (a *CacheActivity) resolveString(wfc *wfcase.WfCase, resolver *wfexpressions.Evaluator, key string) (string, error) {
+	s, err := resolver.Interpolate(key)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := wfc.ProcessTemplate(s)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (a *CacheActivity) setOperationHarEntry(wfc *wfcase.WfCase, startedAt time.Time, key string, bodyOpt har.RequestOption, st int, mimeType string, data []byte) *har.Response {
+
+	var opts []har.RequestOption
+
+	ub := har.UrlBuilder{}
+	ub.WithScheme("activity")
+	ub.WithHostname("localhost")
+	ub.WithPath(fmt.Sprintf("/%s/%s/%s", string(config.CacheActivityType), a.definition.Operation, a.Name()))
+	opts = append(opts, har.WithMethod(http.MethodPost))
+	opts = append(opts, har.WithUrl(ub.Url()))
+	opts = append(opts, har.WithHeader(har.NameValuePair{Name: "X-Cache-Key", Value: key}))
+	if bodyOpt != nil {
+		opts = append(opts, bodyOpt)
+	}
+
+	req := &har.Request{
+		HTTPVersion: "1.1",
+		Cookies:     []har.Cookie{},
+		QueryString: []har.NameValuePair{},
+		HeadersSize: -1,
+		Headers:     []har.NameValuePair{},
+		BodySize:    -1,
+	}
+	for _, o := range opts {
+		o(req)
+	}
+
+	r := har.NewResponse(st, http.StatusText(st), mimeType, data, nil)
+
+	elapsed := float64(time.Since(startedAt).Milliseconds())
+	harEntry := &har.Entry{
+		Comment:         a.Name(),
+		StartedDateTime: startedAt.Format("2006-01-02T15:04:05.999999999Z07:00"),
+		StartDateTimeTm: startedAt,
+		Request:         req,
+		Response:        r,
+		Time:            elapsed,
+		Timings: &har.Timings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+			Send:    -1,
+			Wait:    elapsed,
+			Receive: -1,
+			Ssl:     -1,
+		},
+	}
+
+	_ = wfc.SetHarEntry(a.Name(), harEntry)
+	return r
+}
@@ -0,0 +1,309 @@
+package decisiontableactivity
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+type DecisionTableActivity struct {
+	executable.Activity
+	definition config.DecisionTableActivityDefinition
+}
+
+// Decision is the outcome of the evaluation of the table. It is reported as the body of the har entry of the activity.
+type Decision struct {
+	HitPolicy    string                 `json:"hit-policy,omitempty"`
+	MatchedRules []int                  `json:"matched-rules"`
+	Outputs      map[string]interface{} `json:"outputs,omitempty"`
+}
+
+func NewDecisionTableActivity(item config.Configurable, refs config.DataReferences) (*DecisionTableActivity, error) {
+	const semLogContext = string(config.DecisionTableActivityType) + "::new"
+	var err error
+
+	dta := &DecisionTableActivity{}
+	dta.Cfg = item
+	dta.Refs = refs
+
+	dtaCfg, ok := item.(*config.DecisionTableActivity)
+	if !ok {
+		err = fmt.Errorf("this is weird %T is not %s config type", item, config.DecisionTableActivityType)
+		return nil, err
+	}
+
+	dta.definition, err = config.UnmarshalDecisionTableActivityDefinition(dtaCfg.Definition, refs)
+	if err != nil {
+		log.Error().Err(err).Str(constants.SemLogActivity, item.Name()).Msg(semLogContext)
+		return nil, err
+	}
+
+	return dta, nil
+}
+
+func (a *DecisionTableActivity) Execute(wfc *wfcase.WfCase) error {
+	const semLogContext = string(config.DecisionTableActivityType) + "::execute"
+	var err error
+
+	if !a.IsEnabled(wfc) {
+		log.Trace().Str(constants.SemLogActivity, a.Name()).Str("type", string(config.DecisionTableActivityType)).Msg(semLogContext + " activity not enabled")
+		return nil
+	}
+
+	log.Info().Str(constants.SemLogActivity, a.Name()).Msg(semLogContext + " start")
+	defer log.Info().Str(constants.SemLogActivity, a.Name()).Msg(semLogContext + " end")
+
+	tcfg, ok := a.Cfg.(*config.DecisionTableActivity)
+	if !ok {
+		err = fmt.Errorf("this is weird %T is not %s config type", a.Cfg, config.DecisionTableActivityType)
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		log.Error().Err(err).Msg(semLogContext)
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	err = tcfg.WfCaseDeadlineExceeded(wfc.RequestTiming, wfc.RequestDeadline)
+	if err != nil {
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	activityBegin := time.Now()
+	defer func(begin time.Time) {
+		wfc.RequestTiming += time.Since(begin)
+		log.Info().Str(constants.SemLogActivity, a.Name()).Float64("wfc-timing.s", wfc.RequestTiming.Seconds()).Float64("deadline.s", wfc.RequestDeadline.Seconds()).Msg(semLogContext + " - wfc timing")
+	}(activityBegin)
+
+	_, _, err = a.MetricsGroup()
+	if err != nil {
+		log.Error().Err(err).Interface("metrics-config", a.Cfg.MetricsConfig()).Msg(semLogContext + " cannot found metrics group")
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	expressionCtx, err := wfc.ResolveHarEntryReferenceByName(a.Cfg.ExpressionContextNameStringReference())
+	if err != nil {
+		log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
+		return err
+	}
+	log.Trace().Str(constants.SemLogActivity, a.Name()).Str("expr-scope", expressionCtx.Name).Msg(semLogContext)
+
+	if len(tcfg.ProcessVars) > 0 {
+		err := wfc.SetVars(expressionCtx, tcfg.ProcessVars, "", false)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+			return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+		}
+	}
+
+	beginOf := time.Now()
+	metricsLabels := a.MetricsLabels()
+	defer func() { _ = a.SetMetrics(beginOf, metricsLabels) }()
+
+	evaluator, err := a.GetEvaluator(wfc)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	req, err := a.newRequestDefinition(evaluator)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+	_ = wfc.SetHarEntryRequest(a.Name(), req, config.PersonallyIdentifiableInformation{})
+
+	decision, err := a.decide(evaluator)
+	if err != nil {
+		log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		metricsLabels[MetricIdStatusCode] = "500"
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithStep(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	for n, v := range decision.Outputs {
+		err = wfc.Vars.Set(n, v, false, 0, false)
+		if err != nil {
+			log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
+			wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+			return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+		}
+	}
+
+	st := http.StatusOK
+	if len(decision.MatchedRules) == 0 {
+		st = http.StatusNotFound
+	}
+	metricsLabels[MetricIdStatusCode] = fmt.Sprint(st)
+
+	b, err := json.Marshal(decision)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	resp := har.NewResponse(st, http.StatusText(st), constants.ContentTypeApplicationJson, b, nil)
+	_ = wfc.SetHarEntryResponse(a.Name(), resp, config.PersonallyIdentifiableInformation{})
+
+	remappedStatusCode, err := a.ProcessResponseActionByStatusCode(st, a.Name(), a.Name(), wfc, nil, wfcase.HarEntryReference{Name: a.Name(), UseResponse: true}, a.definition.OnResponseActions, false)
+	if remappedStatusCode > 0 {
+		metricsLabels[MetricIdStatusCode] = fmt.Sprint(remappedStatusCode)
+	}
+	if err != nil {
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		return err
+	}
+
+	wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), nil)
+	return nil
+}
+
+// decide evaluates the rules according to the hit policy. With the first and unique policies the outputs are the values of the matched rule
+// or the defaults of the outputs if no rule matches. With the collect policy every output is the array of the values of the matched rules.
+func (a *DecisionTableActivity) decide(evaluator *wfexpressions.Evaluator) (Decision, error) {
+	const semLogContext = string(config.DecisionTableActivityType) + "::decide"
+
+	dt := a.definition.DecisionTable
+	decision := Decision{HitPolicy: dt.HitPolicy, MatchedRules: []int{}, Outputs: make(map[string]interface{})}
+	for i, r := range dt.Rules {
+		matched, err := ruleMatches(evaluator, r)
+		if err != nil {
+			return decision, fmt.Errorf("decision table rule #%d: %w", i, err)
+		}
+
+		if !matched {
+			continue
+		}
+
+		log.Trace().Str(constants.SemLogActivity, a.Name()).Int("rule", i).Msg(semLogContext + " rule matched")
+		decision.MatchedRules = append(decision.MatchedRules, i)
+		if dt.HitPolicy == config.DecisionTableHitPolicyFirst {
+			break
+		}
+	}
+
+	if dt.HitPolicy == config.DecisionTableHitPolicyUnique && len(decision.MatchedRules) > 1 {
+		return decision, fmt.Errorf("decision table with unique hit-policy matched rules %v", decision.MatchedRules)
+	}
+
+	for j, out := range dt.Outputs {
+		if dt.HitPolicy == config.DecisionTableHitPolicyCollect {
+			values := make([]interface{}, 0, len(decision.MatchedRules))
+			for _, i := range decision.MatchedRules {
+				if cell := dt.Rules[i].Then[j]; cell != "" {
+					v, err := evaluator.InterpolateAndEval(cell)
+					if err != nil {
+						return decision, fmt.Errorf("decision table rule #%d, output %s: %w", i, out.Name, err)
+					}
+					values = append(values, v)
+				}
+			}
+			decision.Outputs[out.Name] = values
+			continue
+		}
+
+		cell := out.Default
+		if len(decision.MatchedRules) > 0 {
+			cell = dt.Rules[decision.MatchedRules[0]].Then[j]
+		}
+
+		if cell != "" {
+			v, err := evaluator.InterpolateAndEval(cell)
+			if err != nil {
+				return decision, fmt.Errorf("decision table output %s: %w", out.Name, err)
+			}
+			decision.Outputs[out.Name] = v
+		}
+	}
+
+	return decision, nil
+}
+
+func ruleMatches(evaluator *wfexpressions.Evaluator, r config.DecisionTableRule) (bool, error) {
+	for _, cond := range r.Conditions() {
+		ok, err := evaluator.EvalToBool(cond)
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (a *DecisionTableActivity) newRequestDefinition(evaluator *wfexpressions.Evaluator) (*har.Request, error) {
+	const semLogContext = string(config.DecisionTableActivityType) + "::new-request-definition"
+
+	var opts []har.RequestOption
+
+	ub := har.UrlBuilder{}
+	ub.WithPort(0)
+	ub.WithScheme("activity")
+	ub.WithHostname("localhost")
+	ub.WithPath(fmt.Sprintf("/%s/%s", string(config.DecisionTableActivityType), a.Name()))
+
+	opts = append(opts, har.WithMethod("POST"))
+	opts = append(opts, har.WithUrl(ub.Url()))
+
+	// The values of the inputs are reported for troubleshooting purposes only: an input that cannot be evaluated is reported as null.
+	inputs := make(map[string]interface{})
+	for _, in := range a.definition.Inputs {
+		v, err := evaluator.Eval(in.InputExpression())
+		if err != nil {
+			log.Warn().Err(err).Str(constants.SemLogActivity, a.Name()).Str("input", in.Name).Msg(semLogContext)
+		}
+		inputs[in.Name] = v
+	}
+
+	b, err := json.Marshal(inputs)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, har.WithBody(b))
+
+	req := har.Request{
+		HTTPVersion: "1.1",
+		Cookies:     []har.Cookie{},
+		QueryString: []har.NameValuePair{},
+		HeadersSize: -1,
+		Headers:     []har.NameValuePair{},
+		BodySize:    -1,
+	}
+	for _, o := range opts {
+		o(&req)
+	}
+
+	return &req, nil
+}
+
+const (
+	MetricIdActivityType = "type"
+	MetricIdActivityName = "name"
+	MetricIdStatusCode   = "status-code"
+)
+
+func (a *DecisionTableActivity) MetricsLabels() prometheus.Labels {
+
+	metricsLabels := prometheus.Labels{
+		MetricIdActivityType: string(a.Cfg.Type()),
+		MetricIdActivityName: a.Name(),
+		MetricIdStatusCode:   "-1",
+	}
+
+	return metricsLabels
+}
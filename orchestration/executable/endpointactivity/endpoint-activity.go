@@ -1,6 +1,7 @@
 package endpointactivity
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -12,13 +13,13 @@ import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/endpointactivity/signers"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms/kz"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
-	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
 	"github.com/prometheus/client_golang/prometheus"
@@ -43,6 +44,12 @@ type Endpoint struct {
 	Description string
 	Definition  *config.EndpointDefinition
 	PII         config.PersonallyIdentifiableInformation
+
+	// signers built at load time for the signers with a key-ref. The ones with an interpolated key are built at every invocation.
+	signers []signers.RequestSigner
+
+	// latencies of the endpoint for the percentile based hedging delay.
+	latencies *latencyTracker
 }
 
 func (ep Endpoint) FullId(activityName string) string {
@@ -78,10 +85,46 @@ func NewEndpointActivity(item config.Configurable, refs config.DataReferences) (
 			return nil, err
 		}
 
+		if epDef.Auth != nil {
+			err = epDef.Auth.Validate()
+			if err != nil {
+				return nil, fmt.Errorf("endpoint (%s:%s) auth: %w", epcfg.Id, epcfg.Name, err)
+			}
+		}
+
+		if epDef.Pagination != nil {
+			err = epDef.Pagination.Validate()
+			if err != nil {
+				return nil, fmt.Errorf("endpoint (%s:%s) pagination: %w", epcfg.Id, epcfg.Name, err)
+			}
+		}
+
+		if epDef.Hedging != nil {
+			if len(epDef.AlternativeHosts) == 0 {
+				return nil, fmt.Errorf("endpoint (%s:%s) hedging requires alternative-hosts", epcfg.Id, epcfg.Name)
+			}
+
+			err = epDef.Hedging.Validate()
+			if err != nil {
+				return nil, fmt.Errorf("endpoint (%s:%s) hedging: %w", epcfg.Id, epcfg.Name, err)
+			}
+		}
+
 		if epDef.Body.ExternalValue != "" && !refs.IsPresent(epDef.Body.ExternalValue) {
 			return nil, fmt.Errorf("cannot find endpoint (%s:%s) body reference from %s", epcfg.Id, epcfg.Name, epDef.Body.ExternalValue)
 		}
 
+		err = epDef.Body.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("endpoint (%s:%s) body: %w", epcfg.Id, epcfg.Name, err)
+		}
+
+		for _, p := range epDef.Body.Parts {
+			if p.ExternalValue != "" && !refs.IsPresent(p.ExternalValue) {
+				return nil, fmt.Errorf("cannot find endpoint (%s:%s) body part %s reference from %s", epcfg.Id, epcfg.Name, p.Name, p.ExternalValue)
+			}
+		}
+
 		for _, onRespAct := range epDef.OnResponseActions {
 			err = registerTransformations(onRespAct.Transforms, refs)
 			if err != nil {
@@ -90,6 +133,15 @@ func NewEndpointActivity(item config.Configurable, refs config.DataReferences) (
 		}
 
 		ep := Endpoint{Id: epcfg.Id, Name: epcfg.Name, Description: epcfg.Description, Definition: &epDef, PII: epcfg.PII}
+		ep.signers, err = newRequestSigners(epDef.Signers, refs)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint (%s:%s) signers: %w", epcfg.Id, epcfg.Name, err)
+		}
+
+		if epDef.Hedging != nil && epDef.Hedging.Percentile > 0 {
+			ep.latencies = newLatencyTracker()
+		}
+
 		ea.Endpoints = append(ea.Endpoints, ep)
 	}
 
@@ -201,6 +253,11 @@ func (a *EndpointActivity) Execute(wfc *wfcase.WfCase) error {
 			log.Error().Err(err).Msg(semLogContext)
 		}
 
+		if cacheEnabled && wfc.IsReplaying() {
+			log.Info().Str("endpoint", ep.Id).Msg(semLogContext + " cache bypassed in replay")
+			cacheEnabled = false
+		}
+
 		if cacheEnabled {
 			cacheCfg, err = a.resolveCacheConfig(wfc, resolver, ep.Definition.CacheConfig, a.Refs)
 			if err != nil {
@@ -218,7 +275,17 @@ func (a *EndpointActivity) Execute(wfc *wfcase.WfCase) error {
 		}
 
 		if harResponse == nil || harResponse.Status != http.StatusOK {
-			req, err := a.newRequestDefinition(wfc, ep)
+			if ep.Definition.Pagination != nil {
+				err = a.initPagination(wfc, ep)
+			}
+
+			var req *har.Request
+			if err == nil {
+				req, err = a.newRequestDefinition(wfc, ep)
+			}
+			if err == nil {
+				err = a.signRequest(wfc, ep, req)
+			}
 			if err != nil {
 				wfc.AddBreadcrumb(ep.FullId(a.Name()), ep.Description, err)
 				metricsLabels[MetricIdStatusCode] = "500"
@@ -228,11 +295,30 @@ func (a *EndpointActivity) Execute(wfc *wfcase.WfCase) error {
 
 			_ = wfc.SetHarEntryRequest(ep.FullId(a.Name()), req, ep.PII)
 
-			entry, err := a.Invoke(wfc, ep, req)
+			var entry *har.Entry
+			if wfc.IsReplaying() {
+				// the recorded entry of a paginated endpoint already carries the aggregated items.
+				entry, err = wfc.ReplayHarEntry(ep.FullId(a.Name()))
+			} else if ep.Definition.Pagination != nil {
+				entry, err = a.invokePaginated(wfc, ep, req)
+			} else {
+				entry, err = a.Invoke(wfc, ep, req)
+			}
+			if entry == nil && err != nil {
+				wfc.AddBreadcrumb(ep.FullId(a.Name()), ep.Description, err)
+				metricsLabels[MetricIdStatusCode] = "500"
+				_ = a.SetMetrics(beginOf, metricsLabels)
+				return smperror.NewExecutableServerError(smperror.WithErrorAmbit(ep.Name), smperror.WithStep(ep.Id), smperror.WithCode("HTTP"), smperror.WithErrorMessage(err.Error()))
+			}
 			if entry != nil {
 				harResponse = entry.Response
 			}
 			_ = wfc.SetHarEntryResponse(ep.FullId(a.Name()), harResponse, ep.PII)
+			if len(ep.Definition.AlternativeHosts) > 0 {
+				// the request has been replaced by the one sent to the host that answered, the entry keeps a copy of the original one.
+				_ = wfc.UpdateHarEntryRequest(ep.FullId(a.Name()), req)
+				metricsLabels[MetricIdHost] = requestHost(req)
+			}
 			metricsLabels[MetricIdHttpStatusCode] = fmt.Sprint(harResponse.Status)
 			metricsLabels[MetricIdStatusCode] = fmt.Sprint(harResponse.Status)
 
@@ -355,6 +441,36 @@ func (a *EndpointActivity) getResolver(wfc *wfcase.WfCase) (*wfexpressions.Evalu
 func (a *EndpointActivity) Invoke(wfc *wfcase.WfCase, ep Endpoint, req *har.Request) (*har.Entry, error) {
 
 	const semLogContext = "endpoint-activity::invoke"
+
+	// the credentials are resolved once, the hedged requests are authorized concurrently and the evaluator is not safe for concurrent use.
+	var creds []string
+	if ep.Definition.Auth != nil {
+		resolver, err := a.GetEvaluator(wfc)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return nil, err
+		}
+
+		creds, err = resolveAuthCredentials(resolver, ep.Definition.Auth)
+		if err != nil {
+			log.Error().Err(err).Str("endpoint", ep.Id).Msg(semLogContext + " authorization failed")
+			return nil, err
+		}
+	}
+
+	ctx, cancel := wfc.BoundedContext(0)
+	defer cancel()
+
+	opts := a.restClientOptions(wfc, ep)
+	if len(ep.Definition.AlternativeHosts) == 0 {
+		return a.execute(ctx, ep, opts, creds, req)
+	}
+
+	return a.invokeHosts(ctx, wfc, ep, opts, creds, req)
+}
+
+func (a *EndpointActivity) restClientOptions(wfc *wfcase.WfCase, ep Endpoint) []restclient.Option {
+	const semLogContext = "endpoint-activity::invoke"
 	const semLogContextOverrideOption = " using endpoint specific value"
 	opts := []restclient.Option{restclient.WithSpan(wfc.Span)}
 	if ep.Definition.HttpClientOptions != nil {
@@ -376,28 +492,79 @@ func (a *EndpointActivity) Invoke(wfc *wfcase.WfCase, ep Endpoint, req *har.Requ
 		opts = append(opts, restclient.WithRetryOnHttpError(ep.Definition.HttpClientOptions.RetryOnHttpError))
 	}
 
+	return opts
+}
+
+// execute sends the request with a client of its own so that concurrent executions don't share it. The creds are the ones of the auth, if any, and
+// ctx bounds the fetch of the access token.
+func (a *EndpointActivity) execute(ctx context.Context, ep Endpoint, opts []restclient.Option, creds []string, req *har.Request) (*har.Entry, error) {
+
 	cli, err := restclient.GetRestClientProvider(opts...)
 	if err != nil {
 		return nil, err
 	}
 	defer cli.Close()
 
-	resp, err := cli.Execute(req, restclient.ExecutionWithOpName(ep.Id))
+	return a.executeWith(ctx, ep, opts, creds, req, func(r *har.Request) (*har.Entry, error) {
+		return cli.Execute(r, restclient.ExecutionWithOpName(ep.Id))
+	})
+}
+
+// executeWith authorizes the request and sends it by means of send. The access token, if any, is fetched with the client options of the endpoint.
+func (a *EndpointActivity) executeWith(ctx context.Context, ep Endpoint, opts []restclient.Option, creds []string, req *har.Request, send func(req *har.Request) (*har.Entry, error)) (*har.Entry, error) {
+
+	const semLogContext = "endpoint-activity::invoke"
+
+	if ep.Definition.Auth == nil {
+		resp, err := send(req)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return resp, err
+		}
+
+		return a.checkResponse(resp)
+	}
+
+	authReq, token, err := authorizeRequest(ctx, opts, req, ep.Definition.Auth, creds, "")
+	if err != nil {
+		log.Error().Err(err).Str("endpoint", ep.Id).Msg(semLogContext + " authorization failed")
+		return nil, err
+	}
+
+	resp, err := send(authReq)
+	if err == nil && resp.Response.Status == http.StatusUnauthorized && token != "" {
+		// the token might have been revoked before its expiry: it gets refreshed and the request retried once.
+		log.Warn().Str("endpoint", ep.Id).Msg(semLogContext + " token rejected, retrying with a new one")
+		authReq, _, err = authorizeRequest(ctx, opts, req, ep.Definition.Auth, creds, token)
+		if err != nil {
+			log.Error().Err(err).Str("endpoint", ep.Id).Msg(semLogContext + " authorization failed")
+			return resp, err
+		}
+
+		resp, err = send(authReq)
+	}
+
 	if err != nil {
 		log.Error().Err(err).Msg(semLogContext)
 		return resp, err
 	}
+
+	return a.checkResponse(resp)
+}
+
+func (a *EndpointActivity) checkResponse(resp *har.Entry) (*har.Entry, error) {
+	const semLogContext = "endpoint-activity::invoke"
 	log.Trace().Int("status-code", resp.Response.Status).Int("num-headers", len(resp.Response.Headers)).Int64("content-length", resp.Response.BodySize).Msg(semLogContext)
 
 	/* the handling of the IgnoreNonApplicationJsonResponseContent has been moved down the chain in the processing of the response action */
 	ct := resp.Response.Content.MimeType
 
-	if err == nil && !strings.HasPrefix(ct, constants.ContentTypeApplicationJson) && resp.Response.Status != 200 && resp.Response.BodySize > 0 {
+	if !strings.HasPrefix(ct, constants.ContentTypeApplicationJson) && resp.Response.Status != 200 && resp.Response.BodySize > 0 {
 		// err = fmt.Errorf("%s", string(resp.Content.Data))
 		log.Debug().Str("content-type", ct).Msg(semLogContext + " content is not the usual " + constants.ContentTypeApplicationJson)
 	}
 
-	return resp, err
+	return resp, nil
 }
 
 func (a *EndpointActivity) newRequestDefinition(wfc *wfcase.WfCase, ep Endpoint) (*har.Request, error) {
@@ -419,13 +586,13 @@ func (a *EndpointActivity) newRequestDefinition(wfc *wfcase.WfCase, ep Endpoint)
 	ub.WithPort(ep.Definition.PortAsInt())
 	ub.WithScheme(ep.Definition.Scheme)
 
-	s, _, err := varResolver.ResolveVariables(ep.Definition.HostName, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	s, err := resolver.Interpolate(ep.Definition.HostName)
 	if err != nil {
 		return nil, err
 	}
 	ub.WithHostname(s)
 
-	s, _, err = varResolver.ResolveVariables(ep.Definition.Path, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	s, err = resolver.Interpolate(ep.Definition.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -434,15 +601,11 @@ func (a *EndpointActivity) newRequestDefinition(wfc *wfcase.WfCase, ep Endpoint)
 	opts = append(opts, har.WithMethod(ep.Definition.Method))
 	opts = append(opts, har.WithUrl(ub.Url()))
 
-	for _, h := range ep.Definition.Headers {
-		// WAS
-		// r, _, err := varResolver.ResolveVariables(h.Value, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
-		r, err := resolver.InterpolateAndEvalToString(h.Value)
-		if err != nil {
-			return nil, err
-		}
-		opts = append(opts, har.WithHeader(har.NameValuePair{Name: h.Name, Value: r}))
+	headers, err := resolveHeaders(resolver, ep.Definition.Headers)
+	if err != nil {
+		return nil, err
 	}
+	opts = append(opts, headers...)
 
 	for _, qs := range ep.Definition.QueryString {
 		if wfc.EvalBoolExpression(qs.Guard) {
@@ -478,20 +641,54 @@ func (a *EndpointActivity) newRequestDefinition(wfc *wfcase.WfCase, ep Endpoint)
 	return &req, nil
 }
 
+// resolveHeaders resolves the headers of the endpoint, the only values together with the credentials of the auth and the signers where the secret:
+// references are enabled.
+func resolveHeaders(resolver *wfexpressions.Evaluator, headers []config.NameValuePair) ([]har.RequestOption, error) {
+	defer resolver.WithSecrets()()
+
+	var opts []har.RequestOption
+	for _, h := range headers {
+		// WAS
+		// r, _, err := varResolver.ResolveVariables(h.Value, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+		r, err := resolver.InterpolateAndEvalToString(h.Value)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, har.WithHeader(har.NameValuePair{Name: h.Name, Value: r}))
+	}
+
+	return opts, nil
+}
+
 func (a *EndpointActivity) newRequestDefinitionBody(wfc *wfcase.WfCase, ep Endpoint, resolver *wfexpressions.Evaluator) (har.RequestOption, error) {
 
+	switch ep.Definition.Body.Type {
+	case config.PostDataTypeFormUrlEncoded:
+		parts, err := a.resolveBodyParts(wfc, ep.Definition.Body.Parts, resolver)
+		if err != nil {
+			return nil, err
+		}
+		return newFormUrlEncodedBody(parts), nil
+	case config.PostDataTypeMultipart:
+		parts, err := a.resolveBodyParts(wfc, ep.Definition.Body.Parts, resolver)
+		if err != nil {
+			return nil, err
+		}
+		return newMultipartBody(parts)
+	}
+
 	var bodyContent []byte
 	if ep.Definition.Body.ExternalValue != "" {
 		bodyContent, _ = a.Refs.Find(ep.Definition.Body.ExternalValue)
 	} else {
 		bodyContent = []byte(ep.Definition.Body.Value)
 	}
-	s, _, err := varResolver.ResolveVariables(string(bodyContent), varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	s, err := resolver.Interpolate(string(bodyContent))
 	if err != nil {
 		return nil, err
 	}
 
-	if ep.Definition.Body.Type == "simple" {
+	if ep.Definition.Body.Type == config.PostDataTypeSimple {
 		return har.WithBody([]byte(s)), nil
 	}
 
@@ -528,7 +725,17 @@ func (a *EndpointActivity) resolveCacheConfig(wfc *wfcase.WfCase, resolver *wfex
 		}
 	}
 
-	s, _, err := varResolver.ResolveVariables(cfg.Key, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	// the broker, i.e. the linked service holding the credentials, can be given by a secret. The key is resolved without them not to store
+	// secrets in the cache.
+	restore := resolver.WithSecrets()
+	brokerName, err := resolver.Interpolate(cfg.LinkedServiceRef.Name)
+	restore()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.LinkedServiceRef.Name = brokerName
+
+	s, err := resolver.Interpolate(cfg.Key)
 	if err != nil {
 		return cfg, err
 	}
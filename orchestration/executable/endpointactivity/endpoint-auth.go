@@ -0,0 +1,247 @@
+package endpointactivity
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/rs/zerolog/log"
+)
+
+type accessToken struct {
+	Value     string
+	TokenType string
+	ExpiresAt time.Time
+}
+
+func (t accessToken) validFor(d time.Duration) bool {
+	if t.Value == "" {
+		return false
+	}
+
+	// a token without expiry is kept until the server rejects it.
+	return t.ExpiresAt.IsZero() || time.Now().Add(d).Before(t.ExpiresAt)
+}
+
+type tokenCacheEntry struct {
+	mu    sync.Mutex
+	token accessToken
+}
+
+// tokenCache is shared by all the orchestrations of the process. Entries are keyed by token url, client and scopes.
+var tokenCache = struct {
+	mu      sync.Mutex
+	entries map[string]*tokenCacheEntry
+}{entries: make(map[string]*tokenCacheEntry)}
+
+func tokenCacheKey(tokenUrl, clientId string, scopes []string) string {
+	return strings.Join([]string{tokenUrl, clientId, strings.Join(scopes, " ")}, "|")
+}
+
+// getAccessToken returns the cached token unless it is about to expire or it is the one just rejected by the server. In this case the token
+// is fetched again: concurrent callers wait for the single fetch in progress.
+func getAccessToken(key string, refreshBefore time.Duration, rejected string, fetch func() (accessToken, error)) (accessToken, error) {
+	tokenCache.mu.Lock()
+	e, ok := tokenCache.entries[key]
+	if !ok {
+		e = &tokenCacheEntry{}
+		tokenCache.entries[key] = e
+	}
+	tokenCache.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.token.validFor(refreshBefore) && e.token.Value != rejected {
+		return e.token, nil
+	}
+
+	t, err := fetch()
+	if err != nil {
+		return t, err
+	}
+
+	e.token = t
+	return t, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// fetchClientCredentialsToken requests the token with the client options of the endpoint and the timeout of the auth. The rest client cannot abort a request:
+// the fetch is abandoned, and left to complete in the background, once ctx is done.
+func fetchClientCredentialsToken(ctx context.Context, opts []restclient.Option, tokenUrl, clientId, clientSecret string, auth *config.EndpointAuth) (accessToken, error) {
+	const semLogContext = "endpoint-activity::fetch-client-credentials-token"
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(auth.Scopes, " "))
+	}
+
+	if auth.CredentialsInBody {
+		form.Set("client_id", clientId)
+		form.Set("client_secret", clientSecret)
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/x-www-form-urlencoded")
+	headers.Set("Accept", "application/json")
+	if !auth.CredentialsInBody {
+		headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(url.QueryEscape(clientId)+":"+url.QueryEscape(clientSecret))))
+	}
+
+	req, err := har.NewRequest(http.MethodPost, tokenUrl, []byte(form.Encode()), headers, nil, nil)
+	if err != nil {
+		return accessToken{}, err
+	}
+
+	cli, err := restclient.GetRestClientProvider(append(opts[:len(opts):len(opts)], restclient.WithTimeout(auth.GetTimeout()))...)
+	if err != nil {
+		return accessToken{}, err
+	}
+
+	type fetchResult struct {
+		entry *har.Entry
+		err   error
+	}
+
+	done := make(chan fetchResult, 1)
+	issuedAt := time.Now()
+	go func() {
+		defer cli.Close()
+		e, err := cli.Execute(req, restclient.ExecutionWithOpName("token"))
+		done <- fetchResult{entry: e, err: err}
+	}()
+
+	var r fetchResult
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		r.err = ctx.Err()
+	}
+
+	if r.err != nil {
+		log.Error().Err(r.err).Str("token-url", tokenUrl).Msg(semLogContext)
+		return accessToken{}, r.err
+	}
+
+	if r.entry.Response.Status != http.StatusOK {
+		err = fmt.Errorf("token request failed with status %d", r.entry.Response.Status)
+		log.Error().Err(err).Str("token-url", tokenUrl).Str("client-id", clientId).Msg(semLogContext)
+		return accessToken{}, err
+	}
+
+	var b []byte
+	if r.entry.Response.Content != nil {
+		b = r.entry.Response.Content.Data
+	}
+
+	var tr tokenResponse
+	err = json.Unmarshal(b, &tr)
+	if err != nil {
+		return accessToken{}, err
+	}
+
+	if tr.AccessToken == "" {
+		return accessToken{}, errors.New("token response without access_token")
+	}
+
+	t := accessToken{Value: tr.AccessToken, TokenType: tr.TokenType}
+	if t.TokenType == "" || strings.EqualFold(t.TokenType, "bearer") {
+		t.TokenType = "Bearer"
+	}
+
+	if tr.ExpiresIn > 0 {
+		t.ExpiresAt = issuedAt.Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+
+	log.Info().Str("token-url", tokenUrl).Str("client-id", clientId).Int64("expires-in", tr.ExpiresIn).Msg(semLogContext)
+	return t, nil
+}
+
+// resolveAuthCredentials resolves the credentials of the endpoint auth, in the order authorizeRequest expects them. Secret references are enabled.
+func resolveAuthCredentials(resolver *wfexpressions.Evaluator, auth *config.EndpointAuth) ([]string, error) {
+	if auth == nil {
+		return nil, nil
+	}
+
+	switch auth.Type {
+	case config.EndpointAuthTypeBasic:
+		return resolveCredentials(resolver, auth.Username, auth.Password)
+	case config.EndpointAuthTypeApiKey:
+		return resolveCredentials(resolver, auth.ApiKey)
+	case config.EndpointAuthTypeOAuth2ClientCredentials:
+		return resolveCredentials(resolver, auth.TokenUrl, auth.ClientId, auth.ClientSecret)
+	}
+
+	return nil, fmt.Errorf("unsupported auth type %s", auth.Type)
+}
+
+// authorizeRequest returns a copy of the request with the credentials of the endpoint. The request recorded in the har is left untouched so that
+// the credentials do not end up in the logs. The creds are the ones of resolveAuthCredentials, the rejected parameter is the token refused with a 401
+// by a previous attempt, if any. The token is fetched with the client options of the endpoint and ctx bounds the fetch.
+func authorizeRequest(ctx context.Context, opts []restclient.Option, req *har.Request, auth *config.EndpointAuth, creds []string, rejected string) (*har.Request, string, error) {
+
+	authReq := *req
+	authReq.Headers = append([]har.NameValuePair{}, req.Headers...)
+	authReq.QueryString = append([]har.NameValuePair{}, req.QueryString...)
+
+	switch auth.Type {
+	case config.EndpointAuthTypeBasic:
+		authReq.SetHeader("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(creds[0]+":"+creds[1])))
+
+	case config.EndpointAuthTypeApiKey:
+		if auth.QueryParam != "" {
+			har.WithQueryParam(har.NameValuePair{Name: auth.QueryParam, Value: creds[0]})(&authReq)
+		} else {
+			authReq.SetHeader(auth.GetHeader(), creds[0])
+		}
+
+	case config.EndpointAuthTypeOAuth2ClientCredentials:
+		t, err := getAccessToken(tokenCacheKey(creds[0], creds[1], auth.Scopes), auth.GetRefreshBefore(), rejected, func() (accessToken, error) {
+			return fetchClientCredentialsToken(ctx, opts, creds[0], creds[1], creds[2], auth)
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		authReq.SetHeader("Authorization", t.TokenType+" "+t.Value)
+		return &authReq, t.Value, nil
+
+	default:
+		return nil, "", fmt.Errorf("unsupported auth type %s", auth.Type)
+	}
+
+	return &authReq, "", nil
+}
+
+// resolveCredentials resolves values meant to carry credentials, where the secret: references are enabled.
+func resolveCredentials(resolver *wfexpressions.Evaluator, values ...string) ([]string, error) {
+	defer resolver.WithSecrets()()
+
+	var resolved []string
+	for _, v := range values {
+		s, err := resolver.InterpolateAndEvalToString(v)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, s)
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,107 @@
+package endpointactivity
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/secrets"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCredentialsTokenCache(t *testing.T) {
+	var numRequests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&numRequests, 1)
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, n)
+	}))
+	defer srv.Close()
+
+	auth := &config.EndpointAuth{Type: config.EndpointAuthTypeOAuth2ClientCredentials, TokenUrl: srv.URL, ClientId: "client", ClientSecret: "secret", Scopes: []string{"read"}}
+	require.NoError(t, auth.Validate())
+
+	fetch := func() (accessToken, error) {
+		return fetchClientCredentialsToken(context.Background(), nil, auth.TokenUrl, auth.ClientId, auth.ClientSecret, auth)
+	}
+
+	key := tokenCacheKey(auth.TokenUrl, auth.ClientId, auth.Scopes)
+	tok, err := getAccessToken(key, auth.GetRefreshBefore(), "", fetch)
+	require.NoError(t, err)
+	require.Equal(t, "token-1", tok.Value)
+	require.Equal(t, "Bearer", tok.TokenType)
+
+	tok, err = getAccessToken(key, auth.GetRefreshBefore(), "", fetch)
+	require.NoError(t, err)
+	require.Equal(t, "token-1", tok.Value, "the cached token should be reused")
+
+	tok, err = getAccessToken(key, auth.GetRefreshBefore(), "token-1", fetch)
+	require.NoError(t, err)
+	require.Equal(t, "token-2", tok.Value, "a rejected token should be refreshed")
+
+	// a refresh window larger than the token lifetime forces the refresh.
+	tok, err = getAccessToken(key, 2*time.Hour, "", fetch)
+	require.NoError(t, err)
+	require.Equal(t, "token-3", tok.Value)
+}
+
+func TestClientCredentialsTokenCancelled(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	// the fetch is bound to the context of the case rather than to the timeout of the auth only.
+	auth := &config.EndpointAuth{Type: config.EndpointAuthTypeOAuth2ClientCredentials, TokenUrl: srv.URL, ClientId: "client", ClientSecret: "secret"}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := fetchClientCredentialsToken(ctx, nil, auth.TokenUrl, auth.ClientId, auth.ClientSecret, auth)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAuthCredentialsSecrets(t *testing.T) {
+	secrets.SetProvider(secrets.NewFakeProvider(map[string]string{"api-password": "p4ssw0rd", "api-key": "k3y-value", "client-secret": "s3cret"}), time.Minute)
+
+	resolver, err := wfexpressions.NewEvaluator("request", wfexpressions.WithProcessVars(wfexpressions.NewProcessVars()))
+	require.NoError(t, err)
+
+	auth := &config.EndpointAuth{Type: config.EndpointAuthTypeBasic, Username: "user", Password: "{secret:api-password}"}
+	creds, err := resolveAuthCredentials(resolver, auth)
+	require.NoError(t, err)
+	req, _, err := authorizeRequest(context.Background(), nil, &har.Request{URL: "http://example.com/api"}, auth, creds, "")
+	require.NoError(t, err)
+	require.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("user:p4ssw0rd")), req.Headers.GetFirst("Authorization").Value)
+
+	auth = &config.EndpointAuth{Type: config.EndpointAuthTypeApiKey, ApiKey: "{secret:api-key}", QueryParam: "key"}
+	creds, err = resolveAuthCredentials(resolver, auth)
+	require.NoError(t, err)
+	req, _, err = authorizeRequest(context.Background(), nil, &har.Request{URL: "http://example.com/api"}, auth, creds, "")
+	require.NoError(t, err)
+	require.Equal(t, har.NameValuePair{Name: "key", Value: "k3y-value"}, req.QueryString[0])
+
+	auth = &config.EndpointAuth{Type: config.EndpointAuthTypeOAuth2ClientCredentials, TokenUrl: "http://example.com/token", ClientId: "client", ClientSecret: "{secret:client-secret}"}
+	creds, err = resolveAuthCredentials(resolver, auth)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://example.com/token", "client", "s3cret"}, creds)
+
+	// the secret references are enabled for the credentials only.
+	s, err := resolver.Interpolate("{secret:api-key}")
+	require.NoError(t, err)
+	require.Equal(t, "", s)
+}
@@ -0,0 +1,147 @@
+package endpointactivity
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+)
+
+const (
+	ContentTypeFormUrlEncoded         = "application/x-www-form-urlencoded"
+	ContentTypeApplicationOctetStream = "application/octet-stream"
+)
+
+// bodyPart is a resolved config.PostDataPart: the value of a file part holds the raw content.
+type bodyPart struct {
+	name        string
+	value       []byte
+	isFile      bool
+	fileName    string
+	contentType string
+}
+
+// resolveBodyParts evaluates guards and values of the parts. The content of file parts is taken as is from the bundle asset or decoded from the base64 value.
+func (a *EndpointActivity) resolveBodyParts(wfc *wfcase.WfCase, parts []config.PostDataPart, resolver *wfexpressions.Evaluator) ([]bodyPart, error) {
+	var resolved []bodyPart
+	for _, p := range parts {
+		if !wfc.EvalBoolExpression(p.Guard) {
+			continue
+		}
+
+		bp := bodyPart{name: p.Name, isFile: p.IsFile()}
+		var err error
+		switch {
+		case p.ExternalValue != "":
+			bp.value, _ = a.Refs.Find(p.ExternalValue)
+		case p.Base64Value != "":
+			var s string
+			s, err = resolver.InterpolateAndEvalToString(p.Base64Value)
+			if err == nil {
+				bp.value, err = base64.StdEncoding.DecodeString(s)
+			}
+		default:
+			var s string
+			s, err = resolver.InterpolateAndEvalToString(p.Value)
+			bp.value = []byte(s)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("body part %s: %w", p.Name, err)
+		}
+
+		if bp.isFile {
+			bp.fileName, err = resolver.InterpolateAndEvalToString(p.FileName)
+			if err != nil {
+				return nil, fmt.Errorf("body part %s: %w", p.Name, err)
+			}
+
+			if bp.fileName == "" {
+				bp.fileName = p.Name
+			}
+
+			bp.contentType = p.ContentType
+			if bp.contentType == "" {
+				bp.contentType = ContentTypeApplicationOctetStream
+			}
+		}
+
+		resolved = append(resolved, bp)
+	}
+
+	return resolved, nil
+}
+
+func newFormUrlEncodedBody(parts []bodyPart) har.RequestOption {
+	values := url.Values{}
+	params := make([]har.Param, 0, len(parts))
+	for _, p := range parts {
+		values.Add(p.name, string(p.value))
+		params = append(params, har.Param{Name: p.name, Value: string(p.value)})
+	}
+
+	return withPostData(ContentTypeFormUrlEncoded, []byte(values.Encode()), params)
+}
+
+// newMultipartBody encodes the parts as multipart/form-data. In the har params file parts carry file name and content type only since the content could be binary.
+func newMultipartBody(parts []bodyPart) (har.RequestOption, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	params := make([]har.Param, 0, len(parts))
+	for _, p := range parts {
+		if !p.isFile {
+			if err := w.WriteField(p.name, string(p.value)); err != nil {
+				return nil, err
+			}
+			params = append(params, har.Param{Name: p.name, Value: string(p.value)})
+			continue
+		}
+
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(p.name), escapeQuotes(p.fileName)))
+		h.Set(constants.ContentTypeHeader, p.contentType)
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = pw.Write(p.value); err != nil {
+			return nil, err
+		}
+		params = append(params, har.Param{Name: p.name, FileName: p.fileName, ContentType: p.contentType})
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return withPostData(w.FormDataContentType(), buf.Bytes(), params), nil
+}
+
+// withPostData differs from har.WithBody in the mime type: the Content-Type header is set accordingly since the multipart boundary has to match the body.
+func withPostData(mimeType string, data []byte, params []har.Param) har.RequestOption {
+	return func(req *har.Request) {
+		req.PostData = &har.PostData{
+			MimeType: mimeType,
+			Data:     data,
+			Params:   params,
+		}
+		req.SetHeader(constants.ContentTypeHeader, mimeType)
+	}
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
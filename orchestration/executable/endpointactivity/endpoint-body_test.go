@@ -0,0 +1,64 @@
+package endpointactivity
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormUrlEncodedBody(t *testing.T) {
+	req := &har.Request{}
+	newFormUrlEncodedBody([]bodyPart{{name: "grant_type", value: []byte("password")}, {name: "username", value: []byte("mario rossi")}})(req)
+
+	require.Equal(t, ContentTypeFormUrlEncoded, req.PostData.MimeType)
+	require.Equal(t, ContentTypeFormUrlEncoded, req.Headers.GetFirst("Content-Type").Value)
+	require.Len(t, req.PostData.Params, 2)
+
+	v, err := url.ParseQuery(string(req.PostData.Data))
+	require.NoError(t, err)
+	require.Equal(t, "mario rossi", v.Get("username"))
+	require.Equal(t, "password", v.Get("grant_type"))
+}
+
+func TestMultipartBody(t *testing.T) {
+	pdf := []byte{0x25, 0x50, 0x44, 0x46, 0x00, 0xff, 0xfe}
+	opt, err := newMultipartBody([]bodyPart{
+		{name: "description", value: []byte("invoice")},
+		{name: "document", value: pdf, isFile: true, fileName: "invoice.pdf", contentType: "application/pdf"},
+	})
+	require.NoError(t, err)
+
+	req := &har.Request{}
+	opt(req)
+
+	mt, params, err := mime.ParseMediaType(req.PostData.MimeType)
+	require.NoError(t, err)
+	require.Equal(t, "multipart/form-data", mt)
+	require.Equal(t, req.PostData.MimeType, req.Headers.GetFirst("Content-Type").Value)
+
+	r := multipart.NewReader(bytes.NewReader(req.PostData.Data), params["boundary"])
+	p, err := r.NextPart()
+	require.NoError(t, err)
+	require.Equal(t, "description", p.FormName())
+	b, _ := io.ReadAll(p)
+	require.Equal(t, "invoice", string(b))
+
+	p, err = r.NextPart()
+	require.NoError(t, err)
+	require.Equal(t, "document", p.FormName())
+	require.Equal(t, "invoice.pdf", p.FileName())
+	require.Equal(t, "application/pdf", p.Header.Get("Content-Type"))
+	b, _ = io.ReadAll(p)
+	require.Equal(t, pdf, b)
+
+	_, err = r.NextPart()
+	require.Equal(t, io.EOF, err)
+
+	require.Equal(t, har.Param{Name: "document", FileName: "invoice.pdf", ContentType: "application/pdf"}, req.PostData.Params[1])
+}
@@ -0,0 +1,272 @@
+package endpointactivity
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	MetricIdHost = "host"
+
+	latencyTrackerSize = 200
+)
+
+// latencyTracker keeps the latencies of the last successful calls of an endpoint for the percentile based hedging delay.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{samples: make([]time.Duration, 0, latencyTrackerSize)}
+}
+
+func (t *latencyTracker) add(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) < latencyTrackerSize {
+		t.samples = append(t.samples, d)
+		return
+	}
+
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % latencyTrackerSize
+}
+
+// percentile returns the p-th percentile (nearest rank) of the samples and the number of samples it has been computed on.
+func (t *latencyTracker) percentile(p float64) (time.Duration, int) {
+	t.mu.Lock()
+	sorted := append([]time.Duration(nil), t.samples...)
+	t.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return 0, 0
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(p / 100 * float64(len(sorted)))
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+
+	return sorted[rank], len(sorted)
+}
+
+func (ep Endpoint) hedgingDelay() time.Duration {
+	h := ep.Definition.Hedging
+	if h.Percentile > 0 && ep.latencies != nil {
+		if d, n := ep.latencies.percentile(h.Percentile); n >= h.GetMinSamples() {
+			return d
+		}
+	}
+
+	return h.GetDelay()
+}
+
+type hostResult struct {
+	ndx     int
+	entry   *har.Entry
+	err     error
+	elapsed time.Duration
+}
+
+func (r hostResult) succeeded() bool {
+	return r.err == nil && r.entry != nil && r.entry.Response != nil && r.entry.Response.Status < http.StatusInternalServerError
+}
+
+// invokeHosts sends the request to the endpoint host and its alternatives according to the hedging mode. The request gets replaced by the one sent to the
+// host that answered so that the har entry can record it.
+func (a *EndpointActivity) invokeHosts(ctx context.Context, wfc *wfcase.WfCase, ep Endpoint, opts []restclient.Option, creds []string, req *har.Request) (*har.Entry, error) {
+	const semLogContext = "endpoint-activity::invoke-hosts"
+
+	reqs, err := a.hostRequests(wfc, ep, req)
+	if err != nil {
+		log.Error().Err(err).Str("endpoint", ep.Id).Msg(semLogContext)
+		return nil, err
+	}
+
+	var r hostResult
+	if ep.Definition.Hedging.GetMode() == config.EndpointHedgingModeHedge {
+		r = a.invokeHedged(ctx, ep, opts, creds, reqs)
+	} else {
+		r = a.invokeWithFallback(ctx, ep, opts, creds, reqs)
+	}
+
+	if r.ndx > 0 {
+		log.Info().Str("endpoint", ep.Id).Str("url", reqs[r.ndx].URL).Msg(semLogContext + " answered by alternative host")
+		*req = *reqs[r.ndx]
+	}
+
+	return r.entry, r.err
+}
+
+// invokeHedged sends a further request to the next host every time the delay expires, or a request fails, without an answer. The requests are sent by the
+// rest client of the endpoint, that cannot abort a request: the ones in flight when a winner is found, or ctx is done, complete in the background and
+// their outcome is discarded. For this reason the hedged requests always have a timeout.
+func (a *EndpointActivity) invokeHedged(ctx context.Context, ep Endpoint, opts []restclient.Option, creds []string, reqs []*har.Request) hostResult {
+	const semLogContext = "endpoint-activity::invoke-hedged"
+
+	maxRequests := 1 + ep.Definition.Hedging.GetMaxHedgedRequests()
+	if maxRequests > len(reqs) {
+		maxRequests = len(reqs)
+	}
+
+	if ep.Definition.HttpClientOptions == nil || ep.Definition.HttpClientOptions.RestTimeout == 0 {
+		opts = append(opts[:len(opts):len(opts)], restclient.WithTimeout(config.EndpointHedgingDefaultRequestTimeout))
+	}
+
+	// the race context stops the token fetches of the losers.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the channel holds a result per request so that the losers do not block once the race is over.
+	results := make(chan hostResult, maxRequests)
+	launch := func(ndx int) {
+		go func() {
+			begin := time.Now()
+			e, err := a.execute(ctx, ep, opts, creds, reqs[ndx])
+			results <- hostResult{ndx: ndx, entry: e, err: err, elapsed: time.Since(begin)}
+		}()
+	}
+
+	delay := ep.hedgingDelay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	launch(0)
+	launched, pending := 1, 1
+	last := hostResult{ndx: -1}
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.succeeded() {
+				if ep.latencies != nil {
+					ep.latencies.add(r.elapsed)
+				}
+				return r
+			}
+
+			log.Warn().Err(r.err).Str("url", reqs[r.ndx].URL).Msg(semLogContext + " request failed")
+			last = r
+			if launched < maxRequests {
+				launch(launched)
+				launched++
+				pending++
+				timer.Reset(delay)
+			} else if pending == 0 {
+				return last
+			}
+
+		case <-timer.C:
+			if launched < maxRequests {
+				log.Info().Dur("delay", delay).Str("url", reqs[launched].URL).Msg(semLogContext + " sending hedged request")
+				launch(launched)
+				launched++
+				pending++
+				timer.Reset(delay)
+			}
+
+		case <-ctx.Done():
+			log.Warn().Err(ctx.Err()).Str("endpoint", ep.Id).Msg(semLogContext + " race abandoned")
+			return hostResult{err: ctx.Err()}
+		}
+	}
+}
+
+// invokeWithFallback tries the hosts in order while the requests fail with an error (i.e. connection refused) or, with fallback-on-server-error, a status of 500 or above.
+func (a *EndpointActivity) invokeWithFallback(ctx context.Context, ep Endpoint, opts []restclient.Option, creds []string, reqs []*har.Request) hostResult {
+	const semLogContext = "endpoint-activity::invoke-with-fallback"
+
+	onServerError := ep.Definition.Hedging.IsFallbackOnServerError()
+
+	var r hostResult
+	for i, req := range reqs {
+		r.ndx = i
+		r.entry, r.err = a.execute(ctx, ep, opts, creds, req)
+		if r.err == nil && (!onServerError || r.succeeded()) {
+			return r
+		}
+
+		log.Warn().Err(r.err).Str("url", req.URL).Msg(semLogContext + " request failed")
+	}
+
+	return r
+}
+
+// hostRequests returns the request followed by its copies addressed to the alternative hosts. The copies are signed again since signatures might cover the authority.
+func (a *EndpointActivity) hostRequests(wfc *wfcase.WfCase, ep Endpoint, req *har.Request) ([]*har.Request, error) {
+	resolver, err := a.GetEvaluator(wfc)
+	if err != nil {
+		return nil, err
+	}
+
+	reqs := []*har.Request{req}
+	for _, h := range ep.Definition.AlternativeHosts {
+		host, err := resolver.Interpolate(h)
+		if err != nil {
+			return nil, err
+		}
+
+		u, err := replaceHost(req.URL, host)
+		if err != nil {
+			return nil, err
+		}
+
+		r := *req
+		r.URL = u
+		r.Headers = append(har.NameValuePairs(nil), req.Headers...)
+		r.QueryString = append(har.NameValuePairs(nil), req.QueryString...)
+		err = a.signRequest(wfc, ep, &r)
+		if err != nil {
+			return nil, err
+		}
+
+		reqs = append(reqs, &r)
+	}
+
+	return reqs, nil
+}
+
+// replaceHost keeps the port of the url if the host doesn't specify one.
+func replaceHost(u string, host string) (string, error) {
+	if host == "" {
+		return "", errors.New("alternative host cannot be empty")
+	}
+
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+
+	if _, _, err = net.SplitHostPort(host); err != nil && pu.Port() != "" {
+		host = net.JoinHostPort(host, pu.Port())
+	}
+
+	pu.Host = host
+	return pu.String(), nil
+}
+
+// requestHost is the value of the host metric label.
+func requestHost(req *har.Request) string {
+	pu, err := url.Parse(req.URL)
+	if err != nil {
+		return ""
+	}
+
+	return pu.Host
+}
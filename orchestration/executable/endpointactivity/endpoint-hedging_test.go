@@ -0,0 +1,101 @@
+package endpointactivity
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyTrackerPercentile(t *testing.T) {
+	lt := newLatencyTracker()
+	_, n := lt.percentile(95)
+	require.Equal(t, 0, n)
+
+	for i := 1; i <= latencyTrackerSize+100; i++ {
+		lt.add(time.Duration(i) * time.Millisecond)
+	}
+
+	// the oldest 100 samples have been overwritten.
+	d, n := lt.percentile(50)
+	require.Equal(t, latencyTrackerSize, n)
+	require.Equal(t, 201*time.Millisecond, d)
+
+	d, _ = lt.percentile(99.9)
+	require.Equal(t, 300*time.Millisecond, d)
+}
+
+func TestReplaceHost(t *testing.T) {
+	u, err := replaceHost("https://primary.example.com:8443/api/v1/movies?page=2", "replica.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "https://replica.example.com:8443/api/v1/movies?page=2", u)
+
+	u, err = replaceHost("http://primary.example.com/api", "replica.example.com:9090")
+	require.NoError(t, err)
+	require.Equal(t, "http://replica.example.com:9090/api", u)
+
+	_, err = replaceHost("http://primary.example.com/api", "")
+	require.Error(t, err)
+}
+
+func TestInvokeHedged(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", constants.ContentTypeApplicationJson)
+		_, _ = w.Write([]byte(`{"query":"` + r.URL.RawQuery + `"}`))
+	}))
+	defer fast.Close()
+
+	a := &EndpointActivity{}
+	ep := Endpoint{Id: "movies", Definition: &config.EndpointDefinition{Hedging: &config.EndpointHedging{Delay: 20 * time.Millisecond}}, latencies: newLatencyTracker()}
+	reqs := []*har.Request{
+		{Method: http.MethodGet, URL: slow.URL, QueryString: har.NameValuePairs{{Name: "page", Value: "2"}}},
+		{Method: http.MethodGet, URL: fast.URL, QueryString: har.NameValuePairs{{Name: "page", Value: "2"}}},
+	}
+
+	// the race is over with the answer of the fast host, the request in flight on the slow one is left behind.
+	begin := time.Now()
+	r := a.invokeHedged(context.Background(), ep, nil, nil, reqs)
+	require.NoError(t, r.err)
+	require.Less(t, time.Since(begin), time.Second)
+	require.Equal(t, 1, r.ndx)
+	require.Equal(t, http.StatusOK, r.entry.Response.Status)
+	require.JSONEq(t, `{"query":"page=2"}`, string(r.entry.Response.Content.Data))
+
+	_, n := ep.latencies.percentile(50)
+	require.Equal(t, 1, n)
+
+	// the race is abandoned once the case is done with it.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r = a.invokeHedged(ctx, ep, nil, nil, reqs[:1])
+	require.ErrorIs(t, r.err, context.DeadlineExceeded)
+}
+
+func TestHedgingFallbackOnServerError(t *testing.T) {
+	h := &config.EndpointHedging{Mode: config.EndpointHedgingModeFallback, FallbackOnServerError: true}
+	require.NoError(t, h.Validate())
+	require.True(t, h.IsFallbackOnServerError())
+
+	// without an hedging block the fallback happens on connection errors only.
+	var nh *config.EndpointHedging
+	require.False(t, nh.IsFallbackOnServerError())
+
+	h = &config.EndpointHedging{FallbackOnServerError: true}
+	require.Error(t, h.Validate())
+
+	unavailable := hostResult{entry: &har.Entry{Response: &har.Response{Status: http.StatusServiceUnavailable}}}
+	require.False(t, unavailable.succeeded())
+}
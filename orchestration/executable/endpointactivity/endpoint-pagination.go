@@ -0,0 +1,263 @@
+package endpointactivity
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/PaesslerAG/jsonpath"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	PaginationPagesHeaderName = "X-Pagination-Pages"
+	PaginationItemsHeaderName = "X-Pagination-Items"
+)
+
+func PaginationPageEntryId(endpointId string, page int) string {
+	return fmt.Sprintf("%s;page=%d", endpointId, page)
+}
+
+// initPagination sets the continuation var to its initial value so that the first request can be built.
+func (a *EndpointActivity) initPagination(wfc *wfcase.WfCase, ep Endpoint) error {
+	pg := ep.Definition.Pagination
+
+	var initial interface{}
+	if pg.Initial != "" {
+		resolver, err := a.GetEvaluator(wfc)
+		if err != nil {
+			return err
+		}
+
+		initial, err = resolver.InterpolateAndEval(pg.Initial)
+		if err != nil {
+			return err
+		}
+	}
+
+	return wfc.Vars.Set(pg.GetContinuationVar(), initial, false, 0, false)
+}
+
+// invokePaginated executes the first request and the following ones while the continuation yields a value. Every page gets its own har entry while
+// the returned entry carries the aggregated items. A page with a status other than 200 stops the iteration and its response is returned as is.
+func (a *EndpointActivity) invokePaginated(wfc *wfcase.WfCase, ep Endpoint, firstReq *har.Request) (*har.Entry, error) {
+	invoke := func(req *har.Request) (*har.Entry, error) {
+		return a.Invoke(wfc, ep, req)
+	}
+
+	newRequest := func(nextUrl string) (*har.Request, error) {
+		req, err := a.newRequestDefinition(wfc, ep)
+		if err == nil && nextUrl != "" {
+			// the url of the link header already carries the query string.
+			req.URL = nextUrl
+			req.QueryString = []har.NameValuePair{}
+		}
+
+		if err == nil {
+			err = a.signRequest(wfc, ep, req)
+		}
+
+		return req, err
+	}
+
+	return a.paginate(wfc, ep, firstReq, invoke, newRequest)
+}
+
+// paginate is the loop of invokePaginated. The newRequest builds the request of the following page, nextUrl is the absolute url of the link header if any.
+func (a *EndpointActivity) paginate(wfc *wfcase.WfCase, ep Endpoint, firstReq *har.Request, invoke func(req *har.Request) (*har.Entry, error), newRequest func(nextUrl string) (*har.Request, error)) (*har.Entry, error) {
+	const semLogContext = "endpoint-activity::invoke-paginated"
+
+	pg := ep.Definition.Pagination
+	items := make([]interface{}, 0)
+
+	req := firstReq
+	var lastEntry *har.Entry
+	numPages := 0
+	for {
+		entry, err := invoke(req)
+		if entry != nil {
+			pageEntry := *entry
+			pageEntry.Request = req
+			_ = wfc.SetHarEntry(PaginationPageEntryId(ep.FullId(a.Name()), numPages), &pageEntry)
+		}
+
+		if err != nil || entry.Response.Status != http.StatusOK {
+			return entry, err
+		}
+
+		lastEntry = entry
+		pageItems, err := pageItems(pg.Items, entry.Response)
+		if err != nil {
+			log.Error().Err(err).Str("endpoint", ep.Id).Int("page", numPages).Msg(semLogContext)
+			return entry, err
+		}
+
+		items = append(items, pageItems...)
+		if pg.MaxItems > 0 && len(items) >= pg.MaxItems {
+			items = items[:pg.MaxItems]
+			log.Info().Str("endpoint", ep.Id).Int("max-items", pg.MaxItems).Msg(semLogContext + " max items reached")
+			numPages++
+			break
+		}
+
+		_ = wfc.Vars.Set(config.EndpointPaginationPageIndexVar, numPages, false, 0, false)
+		_ = wfc.Vars.Set(config.EndpointPaginationPageItemsVar, len(pageItems), false, 0, false)
+		numPages++
+		if numPages >= pg.GetMaxPages() {
+			log.Info().Str("endpoint", ep.Id).Int("max-pages", pg.GetMaxPages()).Msg(semLogContext + " max pages reached")
+			break
+		}
+
+		nextUrl, hasNext, err := a.nextPage(wfc, ep, req.URL, entry.Response)
+		if err != nil {
+			log.Error().Err(err).Str("endpoint", ep.Id).Int("page", numPages).Msg(semLogContext)
+			return entry, err
+		}
+
+		if !hasNext || len(pageItems) == 0 {
+			break
+		}
+
+		req, err = newRequest(nextUrl)
+		if err != nil {
+			log.Error().Err(err).Str("endpoint", ep.Id).Int("page", numPages).Msg(semLogContext)
+			return entry, err
+		}
+	}
+
+	b, err := json.Marshal(items)
+	if err != nil {
+		return lastEntry, err
+	}
+
+	log.Trace().Str("endpoint", ep.Id).Int("pages", numPages).Int("items", len(items)).Msg(semLogContext)
+
+	aggregated := *lastEntry
+	aggregated.Response = har.NewResponse(http.StatusOK, http.StatusText(http.StatusOK), constants.ContentTypeApplicationJson, b, har.NameValuePairs{
+		{Name: PaginationPagesHeaderName, Value: strconv.Itoa(numPages)},
+		{Name: PaginationItemsHeaderName, Value: strconv.Itoa(len(items))},
+	})
+
+	return &aggregated, nil
+}
+
+// nextPage evaluates the continuation on the page just received. With the link-header option the returned url is the one of the next page resolved
+// against the url of the request of the page.
+func (a *EndpointActivity) nextPage(wfc *wfcase.WfCase, ep Endpoint, reqUrl string, resp *har.Response) (string, bool, error) {
+	pg := ep.Definition.Pagination
+
+	if pg.LinkHeader {
+		u := nextLink(resp.Headers.GetFirst("Link").Value)
+		if u == "" {
+			return "", false, nil
+		}
+
+		u, err := resolveLink(reqUrl, u)
+		return u, err == nil, err
+	}
+
+	var body []byte
+	if resp.Content != nil {
+		body = resp.Content.Data
+	}
+
+	resolver, err := wfexpressions.NewEvaluator(ep.FullId(a.Name()), wfexpressions.WithProcessVars(wfc.Vars), wfexpressions.WithHeaders(resp.Headers), wfexpressions.WithBody(constants.ContentTypeApplicationJson, body, ""))
+	if err != nil {
+		return "", false, err
+	}
+
+	v, err := resolver.InterpolateAndEval(pg.Continuation)
+	if err != nil {
+		return "", false, err
+	}
+
+	switch tv := v.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		if tv == "" || tv == "null" {
+			return "", false, nil
+		}
+	case bool:
+		if !tv {
+			return "", false, nil
+		}
+	}
+
+	err = wfc.Vars.Set(pg.GetContinuationVar(), v, false, 0, false)
+	return "", err == nil, err
+}
+
+func pageItems(path string, resp *har.Response) ([]interface{}, error) {
+	if resp.Content == nil || len(resp.Content.Data) == 0 {
+		return nil, nil
+	}
+
+	var body interface{}
+	err := json.Unmarshal(resp.Content.Data, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := jsonpath.Get(path, body)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "unknown key") {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("items %s is not an array", path)
+	}
+
+	return arr, nil
+}
+
+// resolveLink resolves the target of a link, that may be relative (i.e. "/movies?page=2" or "?page=2"), against the url of the request.
+func resolveLink(reqUrl string, target string) (string, error) {
+	base, err := url.Parse(reqUrl)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid link target %s: %w", target, err)
+	}
+
+	return base.ResolveReference(ref).String(), nil
+}
+
+// nextLink extracts the rel="next" target of a Link header (RFC 8288).
+func nextLink(h string) string {
+	for _, link := range strings.Split(h, ",") {
+		segs := strings.Split(link, ";")
+		if len(segs) < 2 {
+			continue
+		}
+
+		u := strings.TrimSpace(segs[0])
+		if !strings.HasPrefix(u, "<") || !strings.HasSuffix(u, ">") {
+			continue
+		}
+
+		for _, p := range segs[1:] {
+			p = strings.ReplaceAll(strings.TrimSpace(p), " ", "")
+			if p == `rel="next"` || p == "rel=next" {
+				return strings.Trim(u, "<>")
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,170 @@
+package endpointactivity
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePage struct {
+	body    string
+	headers har.NameValuePairs
+}
+
+// fakePages serves the pages by request url and records the urls requested.
+type fakePages struct {
+	pages     map[string]fakePage
+	requested []string
+}
+
+func (p *fakePages) invoke(req *har.Request) (*har.Entry, error) {
+	p.requested = append(p.requested, req.URL)
+	page, ok := p.pages[req.URL]
+	if !ok {
+		return &har.Entry{Request: req, Response: har.NewResponse(http.StatusNotFound, http.StatusText(http.StatusNotFound), constants.ContentTypeTextPlain, nil, nil)}, nil
+	}
+
+	return &har.Entry{Request: req, Response: har.NewResponse(http.StatusOK, http.StatusText(http.StatusOK), constants.ContentTypeApplicationJson, []byte(page.body), page.headers)}, nil
+}
+
+func newPaginationTestActivity(t *testing.T, pg *config.EndpointPagination) (*EndpointActivity, Endpoint, *wfcase.WfCase) {
+	require.NoError(t, pg.Validate())
+
+	a := &EndpointActivity{}
+	a.Cfg = config.NewEndpointActivity().WithName("movies")
+	ep := Endpoint{Id: "list", Definition: &config.EndpointDefinition{Pagination: pg}}
+
+	wfc, err := wfcase.NewWorkflowCase("pagination-test", "", "", "", nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, wfc.Vars.Set(pg.GetContinuationVar(), "", false, 0, false))
+
+	return a, ep, wfc
+}
+
+// continuationRequest builds the requests of the cursor and offset modes from the continuation var as the query string of the endpoint would.
+func continuationRequest(wfc *wfcase.WfCase, pg *config.EndpointPagination) func(nextUrl string) (*har.Request, error) {
+	return func(nextUrl string) (*har.Request, error) {
+		if nextUrl != "" {
+			return &har.Request{URL: nextUrl}, nil
+		}
+
+		v, _ := wfc.Vars.Lookup(pg.GetContinuationVar(), "")
+		return &har.Request{URL: fmt.Sprintf("http://example.com/movies?from=%v", v)}, nil
+	}
+}
+
+func requireAggregated(t *testing.T, entry *har.Entry, items string, numPages int) {
+	require.Equal(t, http.StatusOK, entry.Response.Status)
+	require.JSONEq(t, items, string(entry.Response.Content.Data))
+	require.Equal(t, fmt.Sprint(numPages), entry.Response.Headers.GetFirst(PaginationPagesHeaderName).Value)
+}
+
+func TestPaginateCursor(t *testing.T) {
+	pg := &config.EndpointPagination{Continuation: "{$.next}", Items: "$.items"}
+	a, ep, wfc := newPaginationTestActivity(t, pg)
+
+	pages := &fakePages{pages: map[string]fakePage{
+		"http://example.com/movies?from=":   {body: `{"items":[1,2],"next":"c1"}`},
+		"http://example.com/movies?from=c1": {body: `{"items":[3,4],"next":"c2"}`},
+		"http://example.com/movies?from=c2": {body: `{"items":[5],"next":""}`},
+	}}
+
+	newRequest := continuationRequest(wfc, pg)
+	req, _ := newRequest("")
+	entry, err := a.paginate(wfc, ep, req, pages.invoke, newRequest)
+	require.NoError(t, err)
+	requireAggregated(t, entry, `[1,2,3,4,5]`, 3)
+
+	// every page has its own entry.
+	pageEntry, err := wfc.GetHarEntry(PaginationPageEntryId(ep.FullId(a.Name()), 2))
+	require.NoError(t, err)
+	require.NotNil(t, pageEntry)
+
+	// a page in error stops the iteration and it is returned as is.
+	pages.pages["http://example.com/movies?from=c1"] = fakePage{body: `{"items":[3,4],"next":"missing"}`}
+	entry, err = a.paginate(wfc, ep, req, pages.invoke, newRequest)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, entry.Response.Status)
+}
+
+func TestPaginateOffset(t *testing.T) {
+	pg := &config.EndpointPagination{Continuation: `:page_items == 2 ? (page_index + 1) * 2 : ""`, Items: "$"}
+	a, ep, wfc := newPaginationTestActivity(t, pg)
+
+	pages := &fakePages{pages: map[string]fakePage{
+		"http://example.com/movies?from=":  {body: `[1,2]`},
+		"http://example.com/movies?from=2": {body: `[3,4]`},
+		"http://example.com/movies?from=4": {body: `[5]`},
+	}}
+
+	newRequest := continuationRequest(wfc, pg)
+	req, _ := newRequest("")
+	entry, err := a.paginate(wfc, ep, req, pages.invoke, newRequest)
+	require.NoError(t, err)
+	requireAggregated(t, entry, `[1,2,3,4,5]`, 3)
+}
+
+func TestPaginateLinkHeader(t *testing.T) {
+	pg := &config.EndpointPagination{LinkHeader: true, Items: "$.items"}
+	a, ep, wfc := newPaginationTestActivity(t, pg)
+
+	// the targets of the links are relative to the url of the page.
+	pages := &fakePages{pages: map[string]fakePage{
+		"http://example.com/api/movies":        {body: `{"items":[1,2]}`, headers: har.NameValuePairs{{Name: "Link", Value: `</api/movies?page=2>; rel="next", </api/movies?page=9>; rel="last"`}}},
+		"http://example.com/api/movies?page=2": {body: `{"items":[3,4]}`, headers: har.NameValuePairs{{Name: "Link", Value: `<?page=3>; rel="next"`}}},
+		"http://example.com/api/movies?page=3": {body: `{"items":[5]}`, headers: har.NameValuePairs{{Name: "Link", Value: `<https://example.com/api/movies?page=1>; rel="first"`}}},
+	}}
+
+	entry, err := a.paginate(wfc, ep, &har.Request{URL: "http://example.com/api/movies"}, pages.invoke, continuationRequest(wfc, pg))
+	require.NoError(t, err)
+	requireAggregated(t, entry, `[1,2,3,4,5]`, 3)
+	require.Equal(t, []string{"http://example.com/api/movies", "http://example.com/api/movies?page=2", "http://example.com/api/movies?page=3"}, pages.requested)
+}
+
+func TestPaginateLimits(t *testing.T) {
+	pages := &fakePages{pages: map[string]fakePage{
+		"http://example.com/movies?from=":   {body: `{"items":[1,2],"next":"c1"}`},
+		"http://example.com/movies?from=c1": {body: `{"items":[3,4],"next":"c2"}`},
+		"http://example.com/movies?from=c2": {body: `{"items":[5],"next":""}`},
+	}}
+
+	pg := &config.EndpointPagination{Continuation: "{$.next}", Items: "$.items", MaxPages: 2}
+	a, ep, wfc := newPaginationTestActivity(t, pg)
+	newRequest := continuationRequest(wfc, pg)
+	req, _ := newRequest("")
+	entry, err := a.paginate(wfc, ep, req, pages.invoke, newRequest)
+	require.NoError(t, err)
+	requireAggregated(t, entry, `[1,2,3,4]`, 2)
+	require.Len(t, pages.requested, 2)
+
+	// the items are truncated to max-items and the following pages are not requested.
+	pages.requested = nil
+	pg = &config.EndpointPagination{Continuation: "{$.next}", Items: "$.items", MaxItems: 3}
+	a, ep, wfc = newPaginationTestActivity(t, pg)
+	newRequest = continuationRequest(wfc, pg)
+	req, _ = newRequest("")
+	entry, err = a.paginate(wfc, ep, req, pages.invoke, newRequest)
+	require.NoError(t, err)
+	requireAggregated(t, entry, `[1,2,3]`, 2)
+	require.Equal(t, "3", entry.Response.Headers.GetFirst(PaginationItemsHeaderName).Value)
+	require.Len(t, pages.requested, 2)
+}
+
+func TestResolveLink(t *testing.T) {
+	u, err := resolveLink("https://example.com/api/movies?page=1", "https://other.example.com/movies?page=2")
+	require.NoError(t, err)
+	require.Equal(t, "https://other.example.com/movies?page=2", u)
+
+	u, err = resolveLink("https://example.com/api/movies?page=1", "movies?page=2")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/api/movies?page=2", u)
+
+	require.Equal(t, "/api/movies?page=2", nextLink(`</api/movies?page=2>; rel="next"`))
+	require.Equal(t, "", nextLink(`</api/movies?page=2>; rel="prev"`))
+}
@@ -0,0 +1,76 @@
+package endpointactivity
+
+import (
+	"fmt"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/endpointactivity/signers"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/rs/zerolog/log"
+)
+
+func newRequestSigners(cfgs []config.EndpointSigner, refs config.DataReferences) ([]signers.RequestSigner, error) {
+	var rs []signers.RequestSigner
+	for i, cfg := range cfgs {
+		err := cfg.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := signers.GetRegisteredSignerFactory(cfg.Type); !ok {
+			return nil, fmt.Errorf("signer #%d: unsupported signer type %s", i, cfg.Type)
+		}
+
+		var s signers.RequestSigner
+		if cfg.KeyRef != "" {
+			key, ok := refs.Find(cfg.KeyRef)
+			if !ok || len(key) == 0 {
+				return nil, fmt.Errorf("signer #%d: cannot find key %s", i, cfg.KeyRef)
+			}
+
+			s, err = signers.NewRequestSigner(cfg, key)
+			if err != nil {
+				return nil, fmt.Errorf("signer #%d: %w", i, err)
+			}
+		}
+
+		rs = append(rs, s)
+	}
+
+	return rs, nil
+}
+
+// signRequest applies the signers in the order of declaration on the final request, so a signer can cover the headers added by the previous ones.
+func (a *EndpointActivity) signRequest(wfc *wfcase.WfCase, ep Endpoint, req *har.Request) error {
+	const semLogContext = "endpoint-activity::sign-request"
+
+	for i, cfg := range ep.Definition.Signers {
+		s := ep.signers[i]
+		if s == nil {
+			resolver, err := a.GetEvaluator(wfc)
+			if err != nil {
+				return err
+			}
+
+			key, err := resolveCredentials(resolver, cfg.Key)
+			if err != nil {
+				return err
+			}
+
+			s, err = signers.NewRequestSigner(cfg, []byte(key[0]))
+			if err != nil {
+				log.Error().Err(err).Str("endpoint", ep.Id).Str("signer", cfg.Type).Msg(semLogContext)
+				return err
+			}
+		}
+
+		err := s.Sign(req)
+		if err != nil {
+			log.Error().Err(err).Str("endpoint", ep.Id).Str("signer", cfg.Type).Msg(semLogContext)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package signers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+)
+
+const (
+	HmacDefaultAlgorithm       = "hmac-sha256"
+	HmacDefaultSignatureHeader = "X-Signature"
+)
+
+// HmacSigner signs the string made, one per line, of the method, the path, the sorted query string, the 'name:value' of the signed headers
+// and the hex encoded sha256 of the body.
+type HmacSigner struct {
+	cfg  config.EndpointSigner
+	sign signFunc
+}
+
+func NewHmacSigner(cfg config.EndpointSigner, key []byte) (RequestSigner, error) {
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = HmacDefaultAlgorithm
+	}
+
+	if cfg.SignatureHeader == "" {
+		cfg.SignatureHeader = HmacDefaultSignatureHeader
+	}
+
+	if !strings.HasPrefix(cfg.Algorithm, "hmac-") {
+		return nil, fmt.Errorf("unsupported hmac algorithm %s", cfg.Algorithm)
+	}
+
+	if cfg.TimestampHeader != "" && !containsFold(cfg.Headers, cfg.TimestampHeader) {
+		cfg.Headers = append(cfg.Headers, cfg.TimestampHeader)
+	}
+
+	f, err := newSignFunc(cfg.Algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HmacSigner{cfg: cfg, sign: f}, nil
+}
+
+func (s *HmacSigner) Sign(req *har.Request) error {
+	if s.cfg.TimestampHeader != "" {
+		req.SetHeader(s.cfg.TimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	}
+
+	cs, err := s.CanonicalString(req)
+	if err != nil {
+		return err
+	}
+
+	sig, err := s.sign([]byte(cs))
+	if err != nil {
+		return err
+	}
+
+	req.SetHeader(s.cfg.SignatureHeader, encodeSignature(s.cfg.Encoding, sig))
+	return nil
+}
+
+func (s *HmacSigner) CanonicalString(req *har.Request) (string, error) {
+	u, err := requestURL(req)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.ToUpper(req.Method))
+	sb.WriteString("\n")
+	sb.WriteString(u.EscapedPath())
+	sb.WriteString("\n")
+	sb.WriteString(canonicalQuery(u, req))
+	sb.WriteString("\n")
+	for _, h := range s.cfg.Headers {
+		v, _ := headerValue(req, h)
+		sb.WriteString(strings.ToLower(h))
+		sb.WriteString(":")
+		sb.WriteString(v)
+		sb.WriteString("\n")
+	}
+
+	d := sha256.Sum256(requestBody(req))
+	sb.WriteString(hex.EncodeToString(d[:]))
+	return sb.String(), nil
+}
+
+func containsFold(values []string, v string) bool {
+	for _, s := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+
+	return false
+}
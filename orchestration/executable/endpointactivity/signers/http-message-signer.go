@@ -0,0 +1,133 @@
+package signers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+)
+
+const (
+	HttpMessageSignatureDefaultLabel = "sig1"
+
+	HttpMessageSignatureInputHeader = "Signature-Input"
+	HttpMessageSignatureHeader      = "Signature"
+	HttpContentDigestHeader         = "Content-Digest"
+)
+
+var httpMessageSignatureDefaultComponents = []string{"@method", "@authority", "@path", "content-digest"}
+
+// HttpMessageSigner implements the HTTP Message Signatures (RFC 9421). When 'content-digest' is among the covered components the Content-Digest
+// header (RFC 9530) is computed from the body and added to the request. The '@query' component is computed from the query params sorted by name.
+type HttpMessageSigner struct {
+	cfg  config.EndpointSigner
+	sign signFunc
+}
+
+func NewHttpMessageSigner(cfg config.EndpointSigner, key []byte) (RequestSigner, error) {
+	if cfg.Algorithm == "" {
+		return nil, errors.New("http message signer requires an algorithm")
+	}
+
+	if cfg.Label == "" {
+		cfg.Label = HttpMessageSignatureDefaultLabel
+	}
+
+	if len(cfg.Headers) == 0 {
+		cfg.Headers = httpMessageSignatureDefaultComponents
+	}
+
+	f, err := newSignFunc(cfg.Algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HttpMessageSigner{cfg: cfg, sign: f}, nil
+}
+
+func (s *HttpMessageSigner) Sign(req *har.Request) error {
+	params := s.signatureParams(time.Now())
+	base, err := s.SignatureBase(req, params)
+	if err != nil {
+		return err
+	}
+
+	sig, err := s.sign([]byte(base))
+	if err != nil {
+		return err
+	}
+
+	req.SetHeader(HttpMessageSignatureInputHeader, s.cfg.Label+"="+params)
+	req.SetHeader(HttpMessageSignatureHeader, s.cfg.Label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+}
+
+func (s *HttpMessageSigner) signatureParams(created time.Time) string {
+	var sb strings.Builder
+	sb.WriteString("(")
+	for i, c := range s.cfg.Headers {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(`"` + strings.ToLower(c) + `"`)
+	}
+	sb.WriteString(")")
+	sb.WriteString(fmt.Sprintf(";created=%d", created.Unix()))
+	if s.cfg.KeyId != "" {
+		sb.WriteString(fmt.Sprintf(`;keyid="%s"`, s.cfg.KeyId))
+	}
+	sb.WriteString(fmt.Sprintf(`;alg="%s"`, s.cfg.Algorithm))
+	return sb.String()
+}
+
+// SignatureBase builds the signature base of RFC 9421 section 2.5. Missing headers are an error since the receiver would not be able to verify the signature.
+func (s *HttpMessageSigner) SignatureBase(req *har.Request, params string) (string, error) {
+	u, err := requestURL(req)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, c := range s.cfg.Headers {
+		c = strings.ToLower(c)
+
+		var v string
+		switch c {
+		case "@method":
+			v = strings.ToUpper(req.Method)
+		case "@authority":
+			v = strings.ToLower(u.Host)
+		case "@path":
+			v = u.EscapedPath()
+			if v == "" {
+				v = "/"
+			}
+		case "@query":
+			v = "?" + canonicalQuery(u, req)
+		case "@target-uri":
+			tu := *u
+			tu.RawQuery = canonicalQuery(u, req)
+			v = tu.String()
+		case "content-digest":
+			d := sha256.Sum256(requestBody(req))
+			v = "sha-256=:" + base64.StdEncoding.EncodeToString(d[:]) + ":"
+			req.SetHeader(HttpContentDigestHeader, v)
+		default:
+			var ok bool
+			if v, ok = headerValue(req, c); !ok {
+				return "", fmt.Errorf("cannot sign missing header %s", c)
+			}
+		}
+
+		sb.WriteString(fmt.Sprintf("%q: %s\n", c, v))
+	}
+
+	sb.WriteString(`"@signature-params": `)
+	sb.WriteString(params)
+	return sb.String(), nil
+}
@@ -0,0 +1,60 @@
+package signers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+)
+
+const (
+	JwsDefaultSignatureHeader = "X-JWS-Signature"
+)
+
+// JwsSigner computes a JWS of the body in compact serialization with detached payload (RFC 7515 appendix F): the header carries
+// 'protected-header..signature'.
+type JwsSigner struct {
+	cfg             config.EndpointSigner
+	protectedHeader string
+	sign            signFunc
+}
+
+func NewJwsSigner(cfg config.EndpointSigner, key []byte) (RequestSigner, error) {
+	if cfg.Algorithm == "" {
+		return nil, errors.New("jws signer requires an algorithm")
+	}
+
+	if cfg.SignatureHeader == "" {
+		cfg.SignatureHeader = JwsDefaultSignatureHeader
+	}
+
+	f, err := newSignFunc(cfg.Algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+
+	h := map[string]string{"alg": cfg.Algorithm}
+	if cfg.KeyId != "" {
+		h["kid"] = cfg.KeyId
+	}
+
+	b, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JwsSigner{cfg: cfg, protectedHeader: base64.RawURLEncoding.EncodeToString(b), sign: f}, nil
+}
+
+func (s *JwsSigner) Sign(req *har.Request) error {
+	signingInput := s.protectedHeader + "." + base64.RawURLEncoding.EncodeToString(requestBody(req))
+	sig, err := s.sign([]byte(signingInput))
+	if err != nil {
+		return err
+	}
+
+	req.SetHeader(s.cfg.SignatureHeader, s.protectedHeader+".."+base64.RawURLEncoding.EncodeToString(sig))
+	return nil
+}
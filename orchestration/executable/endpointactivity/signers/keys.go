@@ -0,0 +1,138 @@
+package signers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+)
+
+type signFunc func(data []byte) ([]byte, error)
+
+// newSignFunc maps both the JWS (RFC 7518) and the HTTP message signatures (RFC 9421) algorithm names on the same implementations.
+func newSignFunc(alg string, key []byte) (signFunc, error) {
+	switch alg {
+	case "HS256", "hmac-sha256":
+		return hmacSignFunc(sha256.New, key)
+	case "HS512", "hmac-sha512":
+		return hmacSignFunc(sha512.New, key)
+	}
+
+	priv, err := parsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case "RS256", "rsa-v1_5-sha256":
+		k, ok := priv.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s requires an rsa key", alg)
+		}
+		return func(data []byte) ([]byte, error) {
+			d := sha256.Sum256(data)
+			return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, d[:])
+		}, nil
+
+	case "PS256", "PS512", "rsa-pss-sha512":
+		k, ok := priv.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s requires an rsa key", alg)
+		}
+		h := crypto.SHA512
+		if alg == "PS256" {
+			h = crypto.SHA256
+		}
+		return func(data []byte) ([]byte, error) {
+			hh := h.New()
+			hh.Write(data)
+			return rsa.SignPSS(rand.Reader, k, h, hh.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}, nil
+
+	case "ES256", "ecdsa-p256-sha256":
+		k, ok := priv.(*ecdsa.PrivateKey)
+		if !ok || k.Curve.Params().BitSize != 256 {
+			return nil, fmt.Errorf("algorithm %s requires a p-256 ecdsa key", alg)
+		}
+		return func(data []byte) ([]byte, error) {
+			d := sha256.Sum256(data)
+			r, s, err := ecdsa.Sign(rand.Reader, k, d[:])
+			if err != nil {
+				return nil, err
+			}
+
+			// both JWS and HTTP message signatures use the fixed size r || s encoding.
+			sig := make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+			return sig, nil
+		}, nil
+
+	case "EdDSA", "ed25519":
+		k, ok := priv.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("algorithm %s requires an ed25519 key", alg)
+		}
+		return func(data []byte) ([]byte, error) {
+			return ed25519.Sign(k, data), nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported signature algorithm %s", alg)
+}
+
+func hmacSignFunc(h func() hash.Hash, key []byte) (signFunc, error) {
+	if len(key) == 0 {
+		return nil, errors.New("empty hmac key")
+	}
+
+	return func(data []byte) ([]byte, error) {
+		mac := hmac.New(h, key)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	}, nil
+}
+
+func parsePrivateKey(key []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("the signing key is not pem encoded")
+	}
+
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if s, ok := k.(crypto.Signer); ok {
+			return s, nil
+		}
+	}
+
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+
+	return nil, errors.New("unsupported private key format")
+}
+
+func encodeSignature(encoding string, sig []byte) string {
+	if encoding == config.EndpointSignerEncodingHex {
+		return hex.EncodeToString(sig)
+	}
+
+	return base64.StdEncoding.EncodeToString(sig)
+}
@@ -0,0 +1,62 @@
+package signers
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+)
+
+func requestURL(req *har.Request) (*url.URL, error) {
+	return url.Parse(req.URL)
+}
+
+// canonicalQuery merges the query string of the url with the query params of the request (they are kept apart until the request is sent)
+// and returns them sorted by name.
+func canonicalQuery(u *url.URL, req *har.Request) string {
+	q := u.Query()
+	for _, p := range req.QueryString {
+		q.Add(p.Name, p.Value)
+	}
+
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		vs := q[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			if sb.Len() > 0 {
+				sb.WriteString("&")
+			}
+			sb.WriteString(url.QueryEscape(k))
+			sb.WriteString("=")
+			sb.WriteString(url.QueryEscape(v))
+		}
+	}
+
+	return sb.String()
+}
+
+func requestBody(req *har.Request) []byte {
+	if req.PostData == nil {
+		return nil
+	}
+
+	return req.PostData.Data
+}
+
+func headerValue(req *har.Request, n string) (string, bool) {
+	for _, h := range req.Headers {
+		if strings.EqualFold(h.Name, n) {
+			return strings.TrimSpace(h.Value), true
+		}
+	}
+
+	return "", false
+}
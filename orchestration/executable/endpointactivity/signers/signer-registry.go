@@ -0,0 +1,58 @@
+package signers
+
+import (
+	"errors"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestSigner adds the signature headers to the final request of an endpoint.
+type RequestSigner interface {
+	Sign(req *har.Request) error
+}
+
+type SignerFactory func(cfg config.EndpointSigner, key []byte) (RequestSigner, error)
+
+type SignerRegistry map[string]SignerFactory
+
+var signerRegistry = SignerRegistry{
+	config.EndpointSignerTypeHmac:                 NewHmacSigner,
+	config.EndpointSignerTypeJws:                  NewJwsSigner,
+	config.EndpointSignerTypeHttpMessageSignature: NewHttpMessageSigner,
+}
+
+func RegisterSignerFactory(tp string, f SignerFactory) error {
+	const semLogContext = "signer-registry::add"
+
+	if _, ok := signerRegistry[tp]; ok {
+		log.Warn().Str("signer-type", tp).Msg(semLogContext + " signer type already registered")
+		return nil
+	}
+
+	signerRegistry[tp] = f
+	return nil
+}
+
+func GetRegisteredSignerFactory(tp string) (SignerFactory, bool) {
+	const semLogContext = "signer-registry::get"
+
+	f, ok := signerRegistry[tp]
+	if !ok {
+		err := errors.New("signer type not registered")
+		log.Warn().Err(err).Str("signer-type", tp).Msg(semLogContext)
+		return nil, false
+	}
+
+	return f, true
+}
+
+func NewRequestSigner(cfg config.EndpointSigner, key []byte) (RequestSigner, error) {
+	f, ok := GetRegisteredSignerFactory(cfg.Type)
+	if !ok {
+		return nil, errors.New("unsupported signer type " + cfg.Type)
+	}
+
+	return f(cfg, key)
+}
@@ -0,0 +1,101 @@
+package signers_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/endpointactivity/signers"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest() *har.Request {
+	req := &har.Request{Method: "POST", URL: "https://partner.example.com/api/v1/payments?b=2", QueryString: []har.NameValuePair{{Name: "a", Value: "1"}}}
+	har.WithBody([]byte(`{"amount":10}`))(req)
+	har.WithHeader(har.NameValuePair{Name: "Content-Type", Value: "application/json"})(req)
+	return req
+}
+
+func getHeader(req *har.Request, n string) string {
+	for _, h := range req.Headers {
+		if strings.EqualFold(h.Name, n) {
+			return h.Value
+		}
+	}
+
+	return ""
+}
+
+func TestHmacSigner(t *testing.T) {
+	s, err := signers.NewRequestSigner(config.EndpointSigner{Type: config.EndpointSignerTypeHmac, Headers: []string{"Content-Type"}, Encoding: config.EndpointSignerEncodingHex}, []byte("secret"))
+	require.NoError(t, err)
+
+	req := newRequest()
+	require.NoError(t, s.Sign(req))
+
+	d := sha256.Sum256([]byte(`{"amount":10}`))
+	canonical := "POST\n/api/v1/payments\na=1&b=2\ncontent-type:application/json\n" + hex.EncodeToString(d[:])
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(canonical))
+	require.Equal(t, hex.EncodeToString(mac.Sum(nil)), getHeader(req, signers.HmacDefaultSignatureHeader))
+}
+
+func TestJwsSigner(t *testing.T) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	b, err := x509.MarshalPKCS8PrivateKey(k)
+	require.NoError(t, err)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b})
+
+	s, err := signers.NewRequestSigner(config.EndpointSigner{Type: config.EndpointSignerTypeJws, Algorithm: "ES256", KeyId: "k1"}, pemKey)
+	require.NoError(t, err)
+
+	req := newRequest()
+	require.NoError(t, s.Sign(req))
+
+	parts := strings.Split(getHeader(req, signers.JwsDefaultSignatureHeader), ".")
+	require.Len(t, parts, 3)
+	require.Empty(t, parts[1], "payload should be detached")
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	require.Len(t, sig, 64)
+
+	d := sha256.Sum256([]byte(parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"amount":10}`))))
+	require.True(t, ecdsa.Verify(&k.PublicKey, d[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])))
+}
+
+func TestHttpMessageSigner(t *testing.T) {
+	s, err := signers.NewRequestSigner(config.EndpointSigner{Type: config.EndpointSignerTypeHttpMessageSignature, Algorithm: "hmac-sha256", KeyId: "k1"}, []byte("secret"))
+	require.NoError(t, err)
+
+	req := newRequest()
+	require.NoError(t, s.Sign(req))
+
+	require.True(t, strings.HasPrefix(getHeader(req, signers.HttpContentDigestHeader), "sha-256=:"))
+	input := getHeader(req, signers.HttpMessageSignatureInputHeader)
+	require.True(t, strings.HasPrefix(input, `sig1=("@method" "@authority" "@path" "content-digest");created=`))
+	require.True(t, strings.HasSuffix(input, `;keyid="k1";alg="hmac-sha256"`))
+
+	base := strings.Join([]string{
+		`"@method": POST`,
+		`"@authority": partner.example.com`,
+		`"@path": /api/v1/payments`,
+		`"content-digest": ` + getHeader(req, signers.HttpContentDigestHeader),
+		`"@signature-params": ` + strings.TrimPrefix(input, "sig1="),
+	}, "\n")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(base))
+	require.Equal(t, "sig1=:"+base64.StdEncoding.EncodeToString(mac.Sum(nil))+":", getHeader(req, signers.HttpMessageSignatureHeader))
+}
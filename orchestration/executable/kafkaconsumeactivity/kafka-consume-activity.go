@@ -0,0 +1,580 @@
+package kafkaconsumeactivity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-kafka-common/kafkalks"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	MetricIdActivityType           = "type"
+	MetricIdActivityName           = "name"
+	MetricIdEndpointDefinitionPath = "endpoint"
+	MetricIdStatusCode             = "status-code"
+	MetricIdBrokerName             = "broker-name"
+	MetricIdTopicName              = "topic-name"
+)
+
+// the headers of the har request carry the resolved parameters of the read. The values are reported for documentation purposes.
+const (
+	RequestHeaderKafkaKey         = "X-Kafka-Key"
+	RequestHeaderKafkaPartition   = "X-Kafka-Partition"
+	RequestHeaderKafkaStartOffset = "X-Kafka-Start-Offset"
+	RequestHeaderKafkaEndOffset   = "X-Kafka-End-Offset"
+	RequestHeaderKafkaReadMode    = "X-Kafka-Read-Mode"
+	RequestHeaderKafkaMaxMessages = "X-Kafka-Max-Messages"
+
+	ResponseHeaderKafkaTimedOut = "X-Kafka-Timed-Out"
+)
+
+type ConsumedMessageHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type ConsumedMessage struct {
+	Topic     string                  `json:"topic"`
+	Partition int32                   `json:"partition"`
+	Offset    int64                   `json:"offset"`
+	Key       string                  `json:"key,omitempty"`
+	Timestamp string                  `json:"timestamp,omitempty"`
+	Headers   []ConsumedMessageHeader `json:"headers,omitempty"`
+	Value     json.RawMessage         `json:"value,omitempty"`
+}
+
+type consumeParams struct {
+	topic       string
+	key         string
+	partition   int32
+	startOffset string
+	endOffset   string
+}
+
+type KafkaConsumeActivity struct {
+	executable.Activity
+	definition config.KafkaConsumeActivityDefinition
+}
+
+func NewKafkaConsumeActivity(item config.Configurable, refs config.DataReferences) (*KafkaConsumeActivity, error) {
+	const semLogContext = string(config.KafkaConsumeActivityType) + "::new"
+	var err error
+
+	ka := &KafkaConsumeActivity{}
+	ka.Cfg = item
+	ka.Refs = refs
+
+	kaCfg := item.(*config.KafkaConsumeActivity)
+	if kaCfg.BrokerName == "" {
+		err = errors.New("broker-name is mandatory")
+		log.Error().Err(err).Str(constants.SemLogActivity, kaCfg.Name()).Msg(semLogContext)
+		return nil, err
+	}
+
+	ka.definition, err = config.UnmarshalKafkaConsumeActivityDefinition(kaCfg.Definition, refs)
+	if err != nil {
+		log.Error().Err(err).Str(constants.SemLogActivity, kaCfg.Name()).Msg(semLogContext)
+		return nil, err
+	}
+
+	return ka, nil
+}
+
+func (a *KafkaConsumeActivity) Execute(wfc *wfcase.WfCase) error {
+
+	const semLogContext = string(config.KafkaConsumeActivityType) + "::execute"
+	var err error
+
+	if !a.IsEnabled(wfc) {
+		log.Trace().Str(constants.SemLogActivity, a.Name()).Str("type", string(config.KafkaConsumeActivityType)).Msg(semLogContext + " activity not enabled")
+		return nil
+	}
+
+	log.Info().Str(constants.SemLogActivity, a.Name()).Msg(semLogContext + " start")
+	defer log.Info().Str(constants.SemLogActivity, a.Name()).Msg(semLogContext + " end")
+
+	tcfg, ok := a.Cfg.(*config.KafkaConsumeActivity)
+	if !ok {
+		err = fmt.Errorf("this is weird %T is not %s config type", a.Cfg, config.KafkaConsumeActivityType)
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		log.Error().Err(err).Msg(semLogContext)
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	err = tcfg.WfCaseDeadlineExceeded(wfc.RequestTiming, wfc.RequestDeadline)
+	if err != nil {
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	activityBegin := time.Now()
+	defer func(begin time.Time) {
+		wfc.RequestTiming += time.Since(begin)
+		log.Info().Str(constants.SemLogActivity, a.Name()).Float64("wfc-timing.s", wfc.RequestTiming.Seconds()).Float64("deadline.s", wfc.RequestDeadline.Seconds()).Msg(semLogContext + " - wfc timing")
+	}(activityBegin)
+
+	_, _, err = a.MetricsGroup()
+	if err != nil {
+		log.Error().Err(err).Interface("metrics-config", a.Cfg.MetricsConfig()).Msg(semLogContext + " cannot found metrics group")
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	expressionCtx, err := wfc.ResolveHarEntryReferenceByName(a.Cfg.ExpressionContextNameStringReference())
+	if err != nil {
+		log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
+		return err
+	}
+	log.Trace().Str(constants.SemLogActivity, a.Name()).Str("expr-scope", expressionCtx.Name).Msg(semLogContext)
+
+	if len(tcfg.ProcessVars) > 0 {
+		err := wfc.SetVars(expressionCtx, tcfg.ProcessVars, "", false)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+			return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+		}
+	}
+
+	beginOf := time.Now()
+	metricsLabels := a.MetricsLabels(tcfg.BrokerName)
+	defer func() { a.SetMetrics(beginOf, metricsLabels) }()
+
+	evaluator, err := a.GetEvaluator(wfc)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		metricsLabels[MetricIdStatusCode] = "500"
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	params, err := a.resolveConsumeParams(evaluator)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		metricsLabels[MetricIdStatusCode] = "500"
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+	metricsLabels[MetricIdTopicName] = params.topic
+	metricsLabels[MetricIdEndpointDefinitionPath] = fmt.Sprintf("%s://%s.tpm-symphony/topics/%s", "kafka", tcfg.BrokerName, params.topic)
+
+	req, err := a.newRequestDefinition(tcfg.BrokerName, params)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		metricsLabels[MetricIdStatusCode] = "500"
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithStep(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	span, owned := a.getRequestSpan(wfc.Span)
+	_ = wfc.SetHarEntryRequest(a.Name(), req, tcfg.PII)
+	resp, err := a.Consume(tcfg.BrokerName, params)
+	if owned {
+		span.Finish()
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
+		resp = har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), constants.ContentTypeTextPlain, []byte(err.Error()), nil)
+	}
+
+	_ = wfc.SetHarEntryResponse(a.Name(), resp, tcfg.PII)
+	metricsLabels[MetricIdStatusCode] = fmt.Sprint(resp.Status)
+
+	remappedStatusCode, err := a.ProcessResponseActionByStatusCode(resp.Status, a.Name(), a.Name(), wfc, nil, wfcase.HarEntryReference{Name: a.Name(), UseResponse: true}, a.definition.OnResponseActions, false)
+	if remappedStatusCode > 0 {
+		metricsLabels[MetricIdStatusCode] = fmt.Sprint(remappedStatusCode)
+	}
+	if err != nil {
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		return err
+	}
+
+	wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), nil)
+	return nil
+}
+
+// Consume reads the topic from the start offsets up to the high watermarks sampled at the beginning of the read. A response with status 404 is
+// returned if no message matches.
+func (a *KafkaConsumeActivity) Consume(brokerName string, params consumeParams) (*har.Response, error) {
+	const semLogContext = string(config.KafkaConsumeActivityType) + "::consume"
+
+	lks, err := kafkalks.GetKafkaLinkedService(brokerName)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	groupId := a.definition.GroupId
+	if groupId == "" {
+		groupId = fmt.Sprintf("tpm-chorus-%s", a.Name())
+	}
+
+	consumer, err := lks.NewConsumer(groupId, false)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+	defer consumer.Close()
+
+	deadline := time.Now().Add(a.definition.Timeout)
+	assignments, endOffsets, err := a.computeAssignments(consumer, params, deadline)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	var messages []ConsumedMessage
+	timedOut := false
+	if len(assignments) > 0 {
+		err = consumer.Assign(assignments)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return nil, err
+		}
+
+		messages, timedOut, err = a.readMessages(consumer, params, endOffsets, deadline)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return nil, err
+		}
+	}
+
+	log.Trace().Str("topic", params.topic).Int("num-messages", len(messages)).Bool("timed-out", timedOut).Msg(semLogContext)
+
+	sc := http.StatusOK
+	if len(messages) == 0 {
+		sc = http.StatusNotFound
+		messages = []ConsumedMessage{}
+	}
+
+	b, err := json.Marshal(messages)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	return har.NewResponse(sc, http.StatusText(sc), constants.ContentTypeApplicationJson, b, []har.NameValuePair{{Name: ResponseHeaderKafkaTimedOut, Value: fmt.Sprint(timedOut)}}), nil
+}
+
+func (a *KafkaConsumeActivity) computeAssignments(consumer *kafka.Consumer, params consumeParams, deadline time.Time) ([]kafka.TopicPartition, map[int32]int64, error) {
+	const semLogContext = string(config.KafkaConsumeActivityType) + "::compute-assignments"
+
+	var partitions []int32
+	if params.partition >= 0 {
+		partitions = append(partitions, params.partition)
+	} else {
+		md, err := consumer.GetMetadata(&params.topic, false, remainingMillis(deadline))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tmd, ok := md.Topics[params.topic]
+		if !ok {
+			return nil, nil, fmt.Errorf("cannot find metadata for topic %s", params.topic)
+		}
+
+		if tmd.Error.Code() != kafka.ErrNoError {
+			return nil, nil, tmd.Error
+		}
+
+		for _, p := range tmd.Partitions {
+			partitions = append(partitions, p.ID)
+		}
+	}
+
+	var assignments []kafka.TopicPartition
+	endOffsets := make(map[int32]int64)
+	for _, p := range partitions {
+		low, high, err := consumer.QueryWatermarkOffsets(params.topic, p, remainingMillis(deadline))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		start := low
+		if params.startOffset != config.KafkaConsumeStartOffsetEarliest {
+			start, err = strconv.ParseInt(params.startOffset, 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid start-offset %s", params.startOffset)
+			}
+			if start < low {
+				start = low
+			}
+		}
+
+		end := high
+		if params.endOffset != "" {
+			end, err = strconv.ParseInt(params.endOffset, 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid end-offset %s", params.endOffset)
+			}
+			if end > high {
+				end = high
+			}
+		}
+
+		log.Trace().Int32("partition", p).Int64("low", low).Int64("high", high).Int64("start", start).Int64("end", end).Msg(semLogContext)
+		if start >= end {
+			continue
+		}
+
+		assignments = append(assignments, kafka.TopicPartition{Topic: &params.topic, Partition: p, Offset: kafka.Offset(start)})
+		endOffsets[p] = end
+	}
+
+	return assignments, endOffsets, nil
+}
+
+// pollInterval bounds the wait of a single read: when no message arrives the positions of the partitions are compared with the end offsets since the
+// messages right before the high watermark may never be delivered (i.e. transaction markers or compacted records).
+const pollInterval = 250 * time.Millisecond
+
+func (a *KafkaConsumeActivity) readMessages(consumer *kafka.Consumer, params consumeParams, endOffsets map[int32]int64, deadline time.Time) ([]ConsumedMessage, bool, error) {
+	const semLogContext = string(config.KafkaConsumeActivityType) + "::read-messages"
+
+	var messages []ConsumedMessage
+	for len(endOffsets) > 0 {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return messages, true, nil
+		}
+
+		msg, err := consumer.ReadMessage(min(remaining, pollInterval))
+		if err != nil {
+			var kErr kafka.Error
+			if !errors.As(err, &kErr) || kErr.Code() != kafka.ErrTimedOut {
+				log.Error().Err(err).Msg(semLogContext)
+				return messages, false, err
+			}
+
+			positions, err := consumer.Position(assignedPartitions(params.topic, endOffsets))
+			if err != nil {
+				log.Error().Err(err).Msg(semLogContext)
+				return messages, false, err
+			}
+
+			unassignCompleted(consumer, completedPartitions(positions, endOffsets))
+			continue
+		}
+
+		p := msg.TopicPartition.Partition
+		end, ok := endOffsets[p]
+		if !ok || int64(msg.TopicPartition.Offset) >= end {
+			continue
+		}
+
+		if int64(msg.TopicPartition.Offset) >= end-1 {
+			unassignCompleted(consumer, completedPartitions([]kafka.TopicPartition{{Topic: &params.topic, Partition: p, Offset: msg.TopicPartition.Offset + 1}}, endOffsets))
+		}
+
+		if params.key != "" && string(msg.Key) != params.key {
+			continue
+		}
+
+		m := newConsumedMessage(msg)
+		if a.definition.ReadMode == config.KafkaConsumeReadModeLast {
+			messages = []ConsumedMessage{m}
+			continue
+		}
+
+		messages = append(messages, m)
+		if len(messages) >= a.definition.MaxMessages {
+			break
+		}
+	}
+
+	return messages, false, nil
+}
+
+func assignedPartitions(topic string, endOffsets map[int32]int64) []kafka.TopicPartition {
+	tps := make([]kafka.TopicPartition, 0, len(endOffsets))
+	for p := range endOffsets {
+		tps = append(tps, kafka.TopicPartition{Topic: &topic, Partition: p})
+	}
+
+	return tps
+}
+
+// completedPartitions returns the partitions whose position, the offset of the next message to read, has reached the end offset and removes them from
+// endOffsets. The position of a partition with no message read yet is not valid and the partition is not completed.
+func completedPartitions(positions []kafka.TopicPartition, endOffsets map[int32]int64) []kafka.TopicPartition {
+	var completed []kafka.TopicPartition
+	for _, tp := range positions {
+		end, ok := endOffsets[tp.Partition]
+		if !ok || tp.Offset < 0 || int64(tp.Offset) < end {
+			continue
+		}
+
+		delete(endOffsets, tp.Partition)
+		completed = append(completed, kafka.TopicPartition{Topic: tp.Topic, Partition: tp.Partition})
+	}
+
+	return completed
+}
+
+func unassignCompleted(consumer *kafka.Consumer, completed []kafka.TopicPartition) {
+	const semLogContext = string(config.KafkaConsumeActivityType) + "::unassign-completed"
+	if len(completed) == 0 {
+		return
+	}
+
+	if err := consumer.IncrementalUnassign(completed); err != nil {
+		log.Warn().Err(err).Msg(semLogContext)
+	}
+}
+
+func newConsumedMessage(msg *kafka.Message) ConsumedMessage {
+	m := ConsumedMessage{
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Key:       string(msg.Key),
+	}
+
+	if msg.TopicPartition.Topic != nil {
+		m.Topic = *msg.TopicPartition.Topic
+	}
+
+	if !msg.Timestamp.IsZero() {
+		m.Timestamp = msg.Timestamp.Format(time.RFC3339Nano)
+	}
+
+	for _, h := range msg.Headers {
+		m.Headers = append(m.Headers, ConsumedMessageHeader{Name: h.Key, Value: string(h.Value)})
+	}
+
+	// Non json payloads are reported as json strings.
+	if len(msg.Value) > 0 {
+		if json.Valid(msg.Value) {
+			m.Value = msg.Value
+		} else {
+			b, _ := json.Marshal(string(msg.Value))
+			m.Value = b
+		}
+	}
+
+	return m
+}
+
+func remainingMillis(deadline time.Time) int {
+	ms := time.Until(deadline).Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	return int(ms)
+}
+
+func (a *KafkaConsumeActivity) resolveConsumeParams(resolver *wfexpressions.Evaluator) (consumeParams, error) {
+
+	params := consumeParams{partition: -1}
+
+	var err error
+	params.topic, err = resolver.Interpolate(a.definition.TopicName)
+	if err != nil {
+		return params, err
+	}
+
+	if a.definition.Key != "" {
+		params.key, err = resolver.Interpolate(a.definition.Key)
+		if err != nil {
+			return params, err
+		}
+	}
+
+	params.startOffset, err = resolver.Interpolate(a.definition.StartOffset)
+	if err != nil {
+		return params, err
+	}
+
+	if a.definition.EndOffset != "" {
+		params.endOffset, err = resolver.Interpolate(a.definition.EndOffset)
+		if err != nil {
+			return params, err
+		}
+	}
+
+	if a.definition.Partition != nil {
+		params.partition = *a.definition.Partition
+	}
+
+	return params, nil
+}
+
+func (a *KafkaConsumeActivity) getRequestSpan(parentSpan opentracing.Span) (opentracing.Span, bool) {
+	if a.definition.TraceOpName != "" {
+		var span opentracing.Span
+		if parentSpan != nil {
+			span = opentracing.StartSpan(a.definition.TraceOpName, opentracing.ChildOf(parentSpan.Context()))
+		} else {
+			span = opentracing.StartSpan(a.definition.TraceOpName)
+		}
+
+		return span, true
+	}
+
+	return parentSpan, false
+}
+
+func (a *KafkaConsumeActivity) newRequestDefinition(brokerName string, params consumeParams) (*har.Request, error) {
+
+	var opts []har.RequestOption
+
+	ub := har.UrlBuilder{}
+	ub.WithScheme("activity")
+	ub.WithHostname(brokerName)
+	ub.WithPath(fmt.Sprintf("/%s/%s/topics/%s", string(config.KafkaConsumeActivityType), a.Name(), params.topic))
+
+	opts = append(opts, har.WithMethod(http.MethodGet))
+	opts = append(opts, har.WithUrl(ub.Url()))
+	if params.key != "" {
+		opts = append(opts, har.WithHeader(har.NameValuePair{Name: RequestHeaderKafkaKey, Value: params.key}))
+	}
+	if params.partition >= 0 {
+		opts = append(opts, har.WithHeader(har.NameValuePair{Name: RequestHeaderKafkaPartition, Value: fmt.Sprint(params.partition)}))
+	}
+	opts = append(opts, har.WithHeader(har.NameValuePair{Name: RequestHeaderKafkaStartOffset, Value: params.startOffset}))
+	if params.endOffset != "" {
+		opts = append(opts, har.WithHeader(har.NameValuePair{Name: RequestHeaderKafkaEndOffset, Value: params.endOffset}))
+	}
+	opts = append(opts, har.WithHeader(har.NameValuePair{Name: RequestHeaderKafkaReadMode, Value: a.definition.ReadMode}))
+	opts = append(opts, har.WithHeader(har.NameValuePair{Name: RequestHeaderKafkaMaxMessages, Value: fmt.Sprint(a.definition.MaxMessages)}))
+
+	req := har.Request{
+		HTTPVersion: "1.1",
+		Cookies:     []har.Cookie{},
+		QueryString: []har.NameValuePair{},
+		HeadersSize: -1,
+		Headers:     []har.NameValuePair{},
+		BodySize:    -1,
+	}
+	for _, o := range opts {
+		o(&req)
+	}
+
+	return &req, nil
+}
+
+func (a *KafkaConsumeActivity) MetricsLabels(brokerName string) prometheus.Labels {
+
+	metricsLabels := prometheus.Labels{
+		MetricIdActivityType:           string(a.Cfg.Type()),
+		MetricIdActivityName:           a.Name(),
+		MetricIdEndpointDefinitionPath: fmt.Sprintf("%s://%s.tpm-symphony/topics/%s", "kafka", brokerName, a.definition.TopicName),
+		MetricIdTopicName:              a.definition.TopicName,
+		MetricIdBrokerName:             brokerName,
+		MetricIdStatusCode:             "-1",
+	}
+
+	return metricsLabels
+}
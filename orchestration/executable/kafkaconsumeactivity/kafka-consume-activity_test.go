@@ -0,0 +1,52 @@
+package kafkaconsumeactivity
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompletedPartitions(t *testing.T) {
+	topic := "movies"
+	endOffsets := map[int32]int64{0: 10, 1: 20, 2: 30, 3: 40}
+
+	// partition 0 reached the end past a transaction marker at offset 9, partition 1 is behind, partition 2 has not been read yet and
+	// partition 3 has been read up to the last message.
+	positions := []kafka.TopicPartition{
+		{Topic: &topic, Partition: 0, Offset: 10},
+		{Topic: &topic, Partition: 1, Offset: 15},
+		{Topic: &topic, Partition: 2, Offset: kafka.OffsetInvalid},
+		{Topic: &topic, Partition: 3, Offset: 40},
+	}
+
+	completed := completedPartitions(positions, endOffsets)
+	require.Len(t, completed, 2)
+	require.Equal(t, int32(0), completed[0].Partition)
+	require.Equal(t, int32(3), completed[1].Partition)
+	require.Equal(t, map[int32]int64{1: 20, 2: 30}, endOffsets)
+
+	// partitions already completed are not reported twice.
+	completed = completedPartitions(positions, endOffsets)
+	require.Empty(t, completed)
+
+	require.Len(t, assignedPartitions(topic, endOffsets), 2)
+}
+
+func TestNewConsumedMessage(t *testing.T) {
+	topic := "movies"
+	m := newConsumedMessage(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 1, Offset: 7},
+		Key:            []byte("k1"),
+		Value:          []byte("not json"),
+		Headers:        []kafka.Header{{Key: "h1", Value: []byte("v1")}},
+	})
+
+	require.Equal(t, "movies", m.Topic)
+	require.Equal(t, int64(7), m.Offset)
+	require.Equal(t, `"not json"`, string(m.Value))
+	require.Equal(t, []ConsumedMessageHeader{{Name: "h1", Value: "v1"}}, m.Headers)
+
+	m = newConsumedMessage(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Value: []byte(`{"id":1}`)})
+	require.Equal(t, `{"id":1}`, string(m.Value))
+}
@@ -12,7 +12,6 @@ import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
-	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-kafka-common/kafkalks"
 	"github.com/opentracing/opentracing-go"
@@ -186,7 +185,21 @@ func (a *KafkaActivity) Execute(wfc *wfcase.WfCase) error {
 
 		_ = wfc.SetHarEntryRequest(ep.Id, req, ep.PII)
 
-		entry, err := a.Produce(wfc, ep, req)
+		var entry *har.Entry
+		if wfc.IsReplaying() {
+			entry, err = wfc.ReplayHarEntry(ep.Id)
+		} else {
+			entry, err = a.Produce(wfc, ep, req)
+		}
+		if entry == nil && err != nil {
+			wfc.AddBreadcrumb(ep.Id, ep.Description, err)
+			metricsLabels[MetricIdStatusCode] = "500"
+			_ = a.SetMetrics(beginOf, metricsLabels)
+			if owned {
+				span.Finish()
+			}
+			return smperror.NewExecutableServerError(smperror.WithErrorAmbit(ep.Name), smperror.WithStep(ep.Id), smperror.WithErrorMessage(err.Error()))
+		}
 		var resp *har.Response
 		if entry != nil {
 			resp = entry.Response
@@ -428,7 +441,7 @@ func (a *KafkaActivity) newRequestDefinition(wfc *wfcase.WfCase, ep Producer) (*
 
 	ub.WithHostname(fmt.Sprintf("%s", a.BrokerName))
 
-	s, _, err := varResolver.ResolveVariables(ep.Definition.TopicName, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	s, err := resolver.Interpolate(ep.Definition.TopicName)
 	if err != nil {
 		return nil, err
 	}
@@ -436,13 +449,11 @@ func (a *KafkaActivity) newRequestDefinition(wfc *wfcase.WfCase, ep Producer) (*
 	opts = append(opts, har.WithMethod(http.MethodPost))
 	opts = append(opts, har.WithUrl(ub.Url()))
 
-	for _, h := range ep.Definition.Headers {
-		r, _, err := varResolver.ResolveVariables(h.Value, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
-		if err != nil {
-			return nil, err
-		}
-		opts = append(opts, har.WithHeader(har.NameValuePair{Name: h.Name, Value: r}))
+	headers, err := resolveHeaders(resolver, ep.Definition.Headers)
+	if err != nil {
+		return nil, err
 	}
+	opts = append(opts, headers...)
 
 	if !ep.Definition.Body.IsZero() {
 		opt, err := a.newRequestDefinitionMessageBody(wfc, ep, resolver)
@@ -477,6 +488,22 @@ func (a *KafkaActivity) newRequestDefinition(wfc *wfcase.WfCase, ep Producer) (*
 	return &req, nil
 }
 
+// resolveHeaders resolves the headers of the message, where the secret: references are enabled.
+func resolveHeaders(resolver *wfexpressions.Evaluator, headers []config.NameValuePair) ([]har.RequestOption, error) {
+	defer resolver.WithSecrets()()
+
+	var opts []har.RequestOption
+	for _, h := range headers {
+		r, err := resolver.Interpolate(h.Value)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, har.WithHeader(har.NameValuePair{Name: h.Name, Value: r}))
+	}
+
+	return opts, nil
+}
+
 func (a *KafkaActivity) newRequestDefinitionMessageBody(wfc *wfcase.WfCase, ep Producer, resolver *wfexpressions.Evaluator) (har.RequestOption, error) {
 
 	var bodyContent []byte
@@ -486,7 +513,7 @@ func (a *KafkaActivity) newRequestDefinitionMessageBody(wfc *wfcase.WfCase, ep P
 		bodyContent = []byte(ep.Definition.Body.Value)
 	}
 
-	s, _, err := varResolver.ResolveVariables(string(bodyContent), varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	s, err := resolver.Interpolate(string(bodyContent))
 	if err != nil {
 		return nil, err
 	}
@@ -513,7 +540,7 @@ func (a *KafkaActivity) newRequestDefinitionMessageKey(wfc *wfcase.WfCase, ep Pr
 	if ep.Definition.Key != "" {
 		// messageKey, _ := a.Refs.Find(ep.Definition.Key)
 		messageKey := ep.Definition.Key
-		s, _, err = varResolver.ResolveVariables(string(messageKey), varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+		s, err = resolver.Interpolate(string(messageKey))
 		if err != nil {
 			return nil, err
 		}
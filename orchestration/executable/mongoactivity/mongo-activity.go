@@ -2,6 +2,8 @@ package mongoactivity
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cachelks"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-cache-common/cacheoperation"
@@ -13,12 +15,13 @@ import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
-	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-mongo-common/jsonops"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-mongo-common/mongolks"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"net/http"
 	"strconv"
 	"time"
@@ -27,6 +30,7 @@ import (
 type MongoActivity struct {
 	executable.Activity
 	definition config.MongoActivityDefinition
+	txScope    string
 }
 
 func NewMongoActivity(item config.Configurable, refs config.DataReferences) (*MongoActivity, error) {
@@ -46,6 +50,20 @@ func NewMongoActivity(item config.Configurable, refs config.DataReferences) (*Mo
 	return ma, nil
 }
 
+func (a *MongoActivity) LksName() string {
+	return a.definition.LksName
+}
+
+// SetTransactionScope binds the activity to a transaction scope of the orchestration. Only the write operations can take part in a transaction.
+func (a *MongoActivity) SetTransactionScope(scope string) error {
+	if !config.IsMongoTransactionalOpType(a.definition.OpType) {
+		return fmt.Errorf("op-type %s of activity %s cannot take part in transaction scope %s", a.definition.OpType, a.Name(), scope)
+	}
+
+	a.txScope = scope
+	return nil
+}
+
 func (a *MongoActivity) Execute(wfc *wfcase.WfCase) error {
 
 	const semLogContext = string(config.MongoActivityType) + "::execute"
@@ -116,6 +134,11 @@ func (a *MongoActivity) Execute(wfc *wfcase.WfCase) error {
 	if err != nil {
 		log.Error().Err(err).Msg(semLogContext)
 	}
+	if cacheEnabled && wfc.IsReplaying() {
+		log.Info().Str(constants.SemLogActivity, a.Name()).Msg(semLogContext + " cache bypassed in replay")
+		cacheEnabled = false
+	}
+
 	if cacheEnabled {
 		cacheCfg, err = a.resolveCacheConfig(wfc, resolver, a.definition.CacheConfig, a.Refs)
 		if err != nil {
@@ -162,7 +185,15 @@ func (a *MongoActivity) Execute(wfc *wfcase.WfCase) error {
 
 		_ = wfc.SetHarEntryRequest(a.Name(), req, tcfg.PII)
 
-		harResponse, mongoError, err = a.Invoke(wfc, op)
+		if wfc.IsReplaying() {
+			harResponse, mongoError, err = a.replay(wfc)
+		} else if a.txScope != "" {
+			harResponse, mongoError, err = a.InvokeInTransaction(wfc, op)
+		} else if a.definition.Pagination != nil {
+			harResponse, mongoError, err = a.InvokePaginated(wfc, resolver, statementConfig)
+		} else {
+			harResponse, mongoError, err = a.Invoke(wfc, op)
+		}
 		if err != nil {
 			log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
 		}
@@ -242,9 +273,14 @@ func (a *MongoActivity) resolveStatementParts(wfc *wfcase.WfCase, m map[jsonops.
 		return nil, err
 	}
 
+	return ResolveStatementParts(wfc, resolver, m)
+}
+
+// ResolveStatementParts resolves the variables and the templates of the statement parts of a mongo definition.
+func ResolveStatementParts(wfc *wfcase.WfCase, resolver *wfexpressions.Evaluator, m map[jsonops.MongoJsonOperationStatementPart][]byte) (map[jsonops.MongoJsonOperationStatementPart][]byte, error) {
 	newMap := map[jsonops.MongoJsonOperationStatementPart][]byte{}
 	for n, b := range m {
-		s, _, err := varResolver.ResolveVariables(string(b), varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+		s, err := resolver.Interpolate(string(b))
 		if err != nil {
 			return nil, err
 		}
@@ -280,46 +316,207 @@ func (a *MongoActivity) Invoke(wfc *wfcase.WfCase, op jsonops.Operation) (*har.R
 		return r, sc.StatusCode, err
 	}
 
+	a.setOperationResultVars(wfc, sc.MatchedCount, sc.UpsertedCount, sc.ModifiedCount, sc.DeletedCount, sc.ObjectID)
+
+	r = &har.Response{
+		Status:      sc.StatusCode,
+		HTTPVersion: "1.1",
+		StatusText:  http.StatusText(sc.StatusCode),
+		HeadersSize: -1,
+		BodySize:    int64(len(resp)),
+		Cookies:     []har.Cookie{},
+		Headers:     []har.NameValuePair{},
+		Content: &har.Content{
+			MimeType: constants.ContentTypeApplicationJson,
+			Size:     int64(len(resp)),
+			Data:     resp,
+		},
+	}
+
+	return r, 0, nil
+}
+
+// replay serves the recorded response of the activity. The operation result vars (i.e. matched count) are not available in replay.
+func (a *MongoActivity) replay(wfc *wfcase.WfCase) (*har.Response, int, error) {
+	e, err := wfc.ReplayHarEntry(a.Name())
+	if err != nil {
+		r := har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), "text/plain", []byte(err.Error()), nil)
+		return r, http.StatusInternalServerError, err
+	}
+
+	mongoError := 0
+	if e.Response.Status >= http.StatusBadRequest {
+		mongoError = e.Response.Status
+	}
+
+	return e.Response, mongoError, nil
+}
+
+// InvokePaginated fetches the page identified by the continuation token of the pagination config. The token of the following page is
+// returned in the ContinuationTokenHeaderName header and set in the configured process var.
+func (a *MongoActivity) InvokePaginated(wfc *wfcase.WfCase, resolver *wfexpressions.Evaluator, statementConfig map[jsonops.MongoJsonOperationStatementPart][]byte) (*har.Response, int, error) {
+
+	const semLogContext = "mongo-activity::invoke-paginated"
+
+	var token string
+	var err error
+	if a.definition.Pagination.ContinuationToken != "" {
+		token, err = resolver.Interpolate(a.definition.Pagination.ContinuationToken)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			r := har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), "text/plain", []byte(err.Error()), nil)
+			return r, http.StatusInternalServerError, err
+		}
+	}
+
+	ctx, cancel := wfc.BoundedContext(DefaultFindPageTimeout)
+	defer cancel()
+
+	b, nextToken, err := FindPage(ctx, a.definition, statementConfig, token)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		r := har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), "text/plain", []byte(err.Error()), nil)
+		return r, http.StatusInternalServerError, err
+	}
+
+	if a.definition.Pagination.ContinuationTokenVarName != "" {
+		err = wfc.Vars.Set(a.definition.Pagination.ContinuationTokenVarName, nextToken, false, 0, false)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+		}
+	}
+
+	return har.NewResponse(http.StatusOK, http.StatusText(http.StatusOK), constants.ContentTypeApplicationJson, b, []har.NameValuePair{{Name: ContinuationTokenHeaderName, Value: nextToken}}), 0, nil
+}
+
+// InvokeInTransaction executes the write model of the operation with the session of the transaction scope. The transaction is started by the first
+// activity of the scope and it is committed or aborted by the orchestration.
+func (a *MongoActivity) InvokeInTransaction(wfc *wfcase.WfCase, op jsonops.Operation) (*har.Response, int, error) {
+
+	const semLogContext = "mongo-activity::invoke-in-transaction"
+	lks, err := mongolks.GetLinkedService(context.Background(), a.definition.LksName)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		r := har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), "text/plain", []byte(err.Error()), nil)
+		return r, http.StatusInternalServerError, err
+	}
+
+	c := lks.GetCollection(a.definition.CollectionId, "")
+	if c == nil {
+		err = errors.New("cannot find requested collection")
+		log.Error().Err(err).Str("collection", a.definition.CollectionId).Msg(semLogContext)
+		r := har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), "text/plain", []byte(err.Error()), nil)
+		return r, http.StatusInternalServerError, err
+	}
+
+	tx, err := wfc.GetOrBeginMongoTransaction(a.txScope, c.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		r := har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), "text/plain", []byte(err.Error()), nil)
+		return r, http.StatusInternalServerError, err
+	}
+
+	wm, err := op.NewWriteModel()
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		r := har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), "text/plain", []byte(err.Error()), nil)
+		return r, http.StatusInternalServerError, err
+	}
+
+	res, objectID, err := writeInTransaction(tx.Ctx, c, wm)
+	if err != nil {
+		// the transaction cannot be committed anymore, even if the error is handled by the on-response actions.
+		tx.Failed = true
+		log.Error().Err(err).Str("tx-scope", a.txScope).Msg(semLogContext)
+		r := har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), "text/plain", []byte(err.Error()), nil)
+		return r, http.StatusInternalServerError, err
+	}
+
+	a.setOperationResultVars(wfc, res.MatchedCount, res.UpsertedCount, res.ModifiedCount, res.DeletedCount, objectID)
+
+	st := http.StatusOK
+	switch a.definition.OpType {
+	case jsonops.UpdateOneOperationType, jsonops.UpdateManyOperationType, jsonops.ReplaceOneOperationType:
+		if res.MatchedCount == 0 && res.UpsertedCount == 0 {
+			st = http.StatusNotFound
+		}
+	case jsonops.DeleteManyOperationType:
+		if res.DeletedCount == 0 {
+			st = http.StatusNotFound
+		}
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		config.MongoOperationResultMatchedCountPropertyVarName:   res.MatchedCount,
+		config.MongoOperationResultModifiedCountPropertyVarName:  res.ModifiedCount,
+		config.MongoOperationResultUpsertedCountPropertyVarNName: res.UpsertedCount,
+		config.MongoOperationResultDeletedCountPropertyVarName:   res.DeletedCount,
+		"inserted-count": res.InsertedCount,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		r := har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), "text/plain", []byte(err.Error()), nil)
+		return r, http.StatusInternalServerError, err
+	}
+
+	return har.NewResponse(st, http.StatusText(st), constants.ContentTypeApplicationJson, b, nil), 0, nil
+}
+
+// txCollection is the part of the collection used by the writes in transaction.
+type txCollection interface {
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...options.Lister[options.BulkWriteOptions]) (*mongo.BulkWriteResult, error)
+	InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error)
+}
+
+// writeInTransaction executes the write model and returns the id of the inserted or upserted document, if any. The inserts go through InsertOne
+// since the result of a bulk write does not carry the inserted ids.
+func writeInTransaction(ctx context.Context, c txCollection, wm mongo.WriteModel) (*mongo.BulkWriteResult, interface{}, error) {
+	if m, ok := wm.(*mongo.InsertOneModel); ok {
+		ir, err := c.InsertOne(ctx, m.Document)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &mongo.BulkWriteResult{InsertedCount: 1, Acknowledged: ir.Acknowledged}, ir.InsertedID, nil
+	}
+
+	res, err := c.BulkWrite(ctx, []mongo.WriteModel{wm})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var objectID interface{}
+	for _, id := range res.UpsertedIDs {
+		objectID = id
+	}
+
+	return res, objectID, nil
+}
+
+func (a *MongoActivity) setOperationResultVars(wfc *wfcase.WfCase, matchedCount, upsertedCount, modifiedCount, deletedCount, objectID interface{}) {
 	on200ActionNdx := a.definition.OnResponseActions.FindByStatusCode(http.StatusOK)
 	if on200ActionNdx >= 0 && len(a.definition.OnResponseActions[on200ActionNdx].Properties) > 0 {
 		onResponseProperties := a.definition.OnResponseActions[on200ActionNdx].Properties
 		if varName, ok := onResponseProperties[config.MongoOperationResultMatchedCountPropertyVarName]; ok {
-			wfc.Vars.V[varName] = sc.MatchedCount
+			wfc.Vars.V[varName] = matchedCount
 		}
 
 		if varName, ok := onResponseProperties[config.MongoOperationResultUpsertedCountPropertyVarNName]; ok {
-			wfc.Vars.V[varName] = sc.UpsertedCount
+			wfc.Vars.V[varName] = upsertedCount
 		}
 
 		if varName, ok := onResponseProperties[config.MongoOperationResultModifiedCountPropertyVarName]; ok {
-			wfc.Vars.V[varName] = sc.ModifiedCount
+			wfc.Vars.V[varName] = modifiedCount
 		}
 
 		if varName, ok := onResponseProperties[config.MongoOperationResultDeletedCountPropertyVarName]; ok {
-			wfc.Vars.V[varName] = sc.DeletedCount
+			wfc.Vars.V[varName] = deletedCount
 		}
 
 		if varName, ok := onResponseProperties[config.MongoOperationResultObjectIDPropertyVarName]; ok {
-			wfc.Vars.V[varName] = sc.ObjectID
+			wfc.Vars.V[varName] = objectID
 		}
 	}
-
-	r = &har.Response{
-		Status:      sc.StatusCode,
-		HTTPVersion: "1.1",
-		StatusText:  http.StatusText(sc.StatusCode),
-		HeadersSize: -1,
-		BodySize:    int64(len(resp)),
-		Cookies:     []har.Cookie{},
-		Headers:     []har.NameValuePair{},
-		Content: &har.Content{
-			MimeType: constants.ContentTypeApplicationJson,
-			Size:     int64(len(resp)),
-			Data:     resp,
-		},
-	}
-
-	return r, 0, nil
 }
 
 func (a *MongoActivity) newRequestDefinition(wfc *wfcase.WfCase, op jsonops.Operation) (*har.Request, error) {
@@ -352,6 +549,10 @@ func (a *MongoActivity) newRequestDefinition(wfc *wfcase.WfCase, op jsonops.Oper
 	return &req, nil
 }
 
+const (
+	ContinuationTokenHeaderName = "X-Continuation-Token"
+)
+
 const (
 	MetricIdActivityType = "type"
 	MetricIdActivityName = "name"
@@ -487,7 +688,17 @@ func (a *MongoActivity) resolveCacheConfig(wfc *wfcase.WfCase, resolver *wfexpre
 		}
 	}
 
-	s, _, err := varResolver.ResolveVariables(cfg.Key, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	// the broker, i.e. the linked service holding the credentials, can be given by a secret. The key is resolved without them not to store
+	// secrets in the cache.
+	restore := resolver.WithSecrets()
+	brokerName, err := resolver.Interpolate(cfg.LinkedServiceRef.Name)
+	restore()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.LinkedServiceRef.Name = brokerName
+
+	s, err := resolver.Interpolate(cfg.Key)
 	if err != nil {
 		return cfg, err
 	}
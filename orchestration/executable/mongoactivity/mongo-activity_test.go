@@ -0,0 +1,44 @@
+package mongoactivity
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type fakeTxCollection struct {
+	inserted []any
+	bulks    [][]mongo.WriteModel
+}
+
+func (c *fakeTxCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...options.Lister[options.BulkWriteOptions]) (*mongo.BulkWriteResult, error) {
+	c.bulks = append(c.bulks, models)
+	return &mongo.BulkWriteResult{UpsertedCount: 1, UpsertedIDs: map[int64]any{0: "upserted-id"}, Acknowledged: true}, nil
+}
+
+func (c *fakeTxCollection) InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error) {
+	c.inserted = append(c.inserted, document)
+	return &mongo.InsertOneResult{InsertedID: "inserted-id", Acknowledged: true}, nil
+}
+
+func TestWriteInTransaction(t *testing.T) {
+	c := &fakeTxCollection{}
+
+	doc := bson.D{{Key: "name", Value: "acme"}}
+	res, id, err := writeInTransaction(context.Background(), c, mongo.NewInsertOneModel().SetDocument(doc))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), res.InsertedCount)
+	require.Equal(t, "inserted-id", id)
+	require.Equal(t, []any{doc}, c.inserted)
+	require.Empty(t, c.bulks)
+
+	res, id, err = writeInTransaction(context.Background(), c, mongo.NewUpdateOneModel().SetFilter(bson.D{}).SetUpdate(doc).SetUpsert(true))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), res.UpsertedCount)
+	require.Equal(t, "upserted-id", id)
+	require.Len(t, c.bulks, 1)
+}
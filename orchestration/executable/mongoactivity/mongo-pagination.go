@@ -0,0 +1,401 @@
+package mongoactivity
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-mongo-common/jsonops"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-mongo-common/mongolks"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// DefaultFindPageTimeout bounds the read of a page, or of a chunk of a cursor, of the cases with no request deadline.
+	DefaultFindPageTimeout = time.Minute
+
+	// chunkCursorCloseTimeout bounds the release of the server cursor, that is attempted even if the context of the cursor is done.
+	chunkCursorCloseTimeout = 5 * time.Second
+)
+
+// ContinuationToken is the opaque token handed back to the caller to fetch the following page of a paginated find or aggregate.
+type ContinuationToken struct {
+	Skip int64 `json:"skip"`
+}
+
+func (t ContinuationToken) Encode() string {
+	b, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeContinuationToken(s string) (ContinuationToken, error) {
+	var t ContinuationToken
+	if s == "" {
+		return t, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return t, fmt.Errorf("invalid continuation token: %w", err)
+	}
+
+	err = json.Unmarshal(b, &t)
+	if err != nil || t.Skip < 0 {
+		return t, errors.New("invalid continuation token")
+	}
+
+	return t, nil
+}
+
+type statementQuery struct {
+	filter     bson.D
+	sort       bson.D
+	projection bson.D
+	pipeline   bson.A
+}
+
+// newStatementQuery reads the statement parts validated by config.UnmarshalMongoActivityDefinition. The order of the result is made
+// deterministic with an _id tiebreaker so that the skip of the continuation token does not repeat or lose documents across pages.
+func newStatementQuery(opType jsonops.MongoJsonOperationType, parts map[jsonops.MongoJsonOperationStatementPart][]byte) (statementQuery, error) {
+	var q statementQuery
+	var err error
+
+	switch opType {
+	case jsonops.FindManyOperationType:
+		q.filter, err = unmarshalStatementDocument(parts[config.MongoStatementPartQuery])
+		if err == nil {
+			q.sort, err = unmarshalStatementDocument(parts[config.MongoStatementPartSort])
+		}
+
+		if err == nil {
+			q.projection, err = unmarshalStatementDocument(parts[config.MongoStatementPartProjection])
+		}
+
+		if q.filter == nil {
+			q.filter = bson.D{}
+		}
+		q.sort = withIdTiebreaker(q.sort)
+
+	case jsonops.AggregateOneOperationType:
+		pipeline := parts[config.MongoStatementPartPipeline]
+		if len(bytes.TrimSpace(pipeline)) == 0 {
+			return q, errors.New("paginated aggregate requires the pipeline statement part")
+		}
+
+		err = bson.UnmarshalExtJSON(pipeline, false, &q.pipeline)
+		if err == nil {
+			q.pipeline, err = withStableSortStage(q.pipeline)
+		}
+
+	default:
+		err = fmt.Errorf("op-type %s does not support pagination", opType)
+	}
+
+	return q, err
+}
+
+// withIdTiebreaker appends the _id to the sort keys, if missing, so that documents with the same sort values keep the same order between queries.
+func withIdTiebreaker(sort bson.D) bson.D {
+	for _, e := range sort {
+		if e.Key == "_id" {
+			return sort
+		}
+	}
+
+	return append(append(bson.D{}, sort...), bson.E{Key: "_id", Value: 1})
+}
+
+// orderPreservingStages are the aggregation stages that do not change the order of the documents produced by a previous $sort.
+var orderPreservingStages = map[string]struct{}{
+	"$match":       {},
+	"$project":     {},
+	"$addFields":   {},
+	"$set":         {},
+	"$unset":       {},
+	"$replaceRoot": {},
+	"$replaceWith": {},
+	"$lookup":      {},
+	"$redact":      {},
+	"$skip":        {},
+	"$limit":       {},
+}
+
+// withStableSortStage adds the _id tiebreaker to the $sort stage that determines the order of the output of the pipeline. If there is none,
+// a sort on _id is appended: the _id of the output documents may be projected away or grouped but it is still a deterministic order.
+func withStableSortStage(pipeline bson.A) (bson.A, error) {
+	stages := make(bson.A, len(pipeline))
+	for i := len(pipeline) - 1; i >= 0; i-- {
+		stage, ok := pipeline[i].(bson.D)
+		if !ok || len(stage) != 1 {
+			return nil, fmt.Errorf("invalid stage #%d in aggregate pipeline", i)
+		}
+		stages[i] = stage
+	}
+
+	for i := len(stages) - 1; i >= 0; i-- {
+		stage := stages[i].(bson.D)
+		if stage[0].Key == "$sort" {
+			sort, ok := stage[0].Value.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("invalid $sort stage #%d in aggregate pipeline", i)
+			}
+
+			stages[i] = bson.D{{Key: "$sort", Value: withIdTiebreaker(sort)}}
+			return stages, nil
+		}
+
+		if _, ok := orderPreservingStages[stage[0].Key]; !ok {
+			break
+		}
+	}
+
+	return append(stages, bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}}), nil
+}
+
+func unmarshalStatementDocument(b []byte) (bson.D, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, nil
+	}
+
+	var d bson.D
+	err := bson.UnmarshalExtJSON(b, false, &d)
+	return d, err
+}
+
+func getCollection(ctx context.Context, lksName string, collectionId string) (*mongo.Collection, error) {
+	const semLogContext = "mongo-activity::get-collection"
+
+	lks, err := mongolks.GetLinkedService(ctx, lksName)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	c := lks.GetCollection(collectionId, "")
+	if c == nil {
+		err = errors.New("cannot find requested collection")
+		log.Error().Err(err).Str("collection", collectionId).Msg(semLogContext)
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func openCursor(ctx context.Context, c *mongo.Collection, opType jsonops.MongoJsonOperationType, q statementQuery, skip, limit int64, batchSize int32) (*mongo.Cursor, error) {
+	if opType == jsonops.AggregateOneOperationType {
+		pipeline := append(bson.A{}, q.pipeline...)
+		if skip > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$skip", Value: skip}})
+		}
+		if limit > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+		}
+		return c.Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(batchSize))
+	}
+
+	opts := options.Find().SetBatchSize(batchSize)
+	if q.sort != nil {
+		opts.SetSort(q.sort)
+	}
+	if q.projection != nil {
+		opts.SetProjection(q.projection)
+	}
+	if skip > 0 {
+		opts.SetSkip(skip)
+	}
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	return c.Find(ctx, q.filter, opts)
+}
+
+// readDocuments reads up to n documents from the cursor and returns them as a json array. The bool is true if the cursor has been exhausted.
+func readDocuments(ctx context.Context, cursor *mongo.Cursor, n int) ([]byte, int, bool, error) {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+
+	numDocs := 0
+	for numDocs < n {
+		if !cursor.Next(ctx) {
+			if cursor.Err() != nil {
+				return nil, numDocs, true, cursor.Err()
+			}
+
+			buf.WriteString("]")
+			return buf.Bytes(), numDocs, true, nil
+		}
+
+		b, err := bson.MarshalExtJSON(cursor.Current, false, false)
+		if err != nil {
+			return nil, numDocs, false, err
+		}
+
+		if numDocs > 0 {
+			buf.WriteString(",")
+		}
+		buf.Write(b)
+		numDocs++
+	}
+
+	buf.WriteString("]")
+	return buf.Bytes(), numDocs, false, nil
+}
+
+// FindPage executes the statement and returns the page identified by the token together with the token of the following one (empty if there
+// are no more documents or the max-documents limit has been reached). The page is read within ctx.
+func FindPage(ctx context.Context, def config.MongoActivityDefinition, parts map[jsonops.MongoJsonOperationStatementPart][]byte, token string) ([]byte, string, error) {
+	const semLogContext = "mongo-activity::find-page"
+
+	if def.Pagination == nil {
+		return nil, "", errors.New("pagination not configured")
+	}
+
+	ct, err := DecodeContinuationToken(token)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, "", err
+	}
+
+	q, err := newStatementQuery(def.OpType, parts)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, "", err
+	}
+
+	pageSize := int64(def.Pagination.PageSize)
+	if def.Pagination.MaxDocuments > 0 {
+		remaining := int64(def.Pagination.MaxDocuments) - ct.Skip
+		if remaining <= 0 {
+			return []byte("[]"), "", nil
+		}
+
+		if remaining < pageSize {
+			pageSize = remaining
+		}
+	}
+
+	c, err := getCollection(ctx, def.LksName, def.CollectionId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One more document is requested to know whether a following page exists.
+	cursor, err := openCursor(ctx, c, def.OpType, q, ct.Skip, pageSize+1, int32(pageSize+1))
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	b, numDocs, exhausted, err := readDocuments(ctx, cursor, int(pageSize))
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, "", err
+	}
+
+	nextToken := ""
+	if !exhausted && cursor.Next(ctx) {
+		nextSkip := ct.Skip + int64(numDocs)
+		if def.Pagination.MaxDocuments <= 0 || nextSkip < int64(def.Pagination.MaxDocuments) {
+			nextToken = ContinuationToken{Skip: nextSkip}.Encode()
+		}
+	}
+
+	log.Trace().Int64("skip", ct.Skip).Int("num-docs", numDocs).Bool("has-next", nextToken != "").Msg(semLogContext)
+	return b, nextToken, nil
+}
+
+// ChunkCursor streams the result of a find or aggregate in chunks of page-size documents. It keeps the server cursor open
+// between chunks so the result set is never materialized as a whole.
+type ChunkCursor struct {
+	ctx       context.Context
+	cursor    *mongo.Cursor
+	chunkSize int
+	maxDocs   int
+	numDocs   int
+	exhausted bool
+}
+
+// OpenChunkCursor opens the cursor within ctx, that bounds the whole read of the result set. Each chunk is read within DefaultFindPageTimeout as
+// well.
+func OpenChunkCursor(ctx context.Context, def config.MongoActivityDefinition, parts map[jsonops.MongoJsonOperationStatementPart][]byte) (*ChunkCursor, error) {
+	const semLogContext = "mongo-activity::open-chunk-cursor"
+
+	if def.Pagination == nil {
+		return nil, errors.New("pagination not configured")
+	}
+
+	q, err := newStatementQuery(def.OpType, parts)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	c, err := getCollection(ctx, def.LksName, def.CollectionId)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := openCursor(ctx, c, def.OpType, q, 0, int64(def.Pagination.MaxDocuments), int32(def.Pagination.PageSize))
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	return &ChunkCursor{ctx: ctx, cursor: cursor, chunkSize: def.Pagination.PageSize, maxDocs: def.Pagination.MaxDocuments}, nil
+}
+
+// NextChunk returns the next chunk as a json array. A nil chunk signals the end of the result set.
+func (cc *ChunkCursor) NextChunk() ([]byte, error) {
+	const semLogContext = "mongo-activity::next-chunk"
+
+	if cc.exhausted {
+		return nil, nil
+	}
+
+	n := cc.chunkSize
+	if cc.maxDocs > 0 && cc.maxDocs-cc.numDocs < n {
+		n = cc.maxDocs - cc.numDocs
+	}
+
+	if n <= 0 {
+		cc.exhausted = true
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(cc.ctx, DefaultFindPageTimeout)
+	defer cancel()
+
+	b, numDocs, exhausted, err := readDocuments(ctx, cc.cursor, n)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	cc.numDocs += numDocs
+	cc.exhausted = exhausted
+	if numDocs == 0 {
+		return nil, nil
+	}
+
+	return b, nil
+}
+
+func (cc *ChunkCursor) Close() {
+	if cc.cursor != nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(cc.ctx), chunkCursorCloseTimeout)
+		defer cancel()
+
+		_ = cc.cursor.Close(ctx)
+		cc.cursor = nil
+	}
+}
@@ -0,0 +1,66 @@
+package mongoactivity
+
+import (
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-mongo-common/jsonops"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestContinuationToken(t *testing.T) {
+	ct, err := DecodeContinuationToken("")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), ct.Skip)
+
+	ct, err = DecodeContinuationToken(ContinuationToken{Skip: 40}.Encode())
+	require.NoError(t, err)
+	require.Equal(t, int64(40), ct.Skip)
+
+	_, err = DecodeContinuationToken("not a token!")
+	require.Error(t, err)
+
+	_, err = DecodeContinuationToken(ContinuationToken{Skip: -1}.Encode())
+	require.Error(t, err)
+}
+
+func TestStatementQueryStableSort(t *testing.T) {
+	idSort := bson.D{{Key: "_id", Value: int32(1)}}
+
+	q, err := newStatementQuery(jsonops.FindManyOperationType, map[jsonops.MongoJsonOperationStatementPart][]byte{
+		"query": []byte(`{"status": "active"}`),
+		"sort":  []byte(`{"name": -1}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, bson.D{{Key: "status", Value: "active"}}, q.filter)
+	require.Equal(t, bson.D{{Key: "name", Value: int32(-1)}, {Key: "_id", Value: 1}}, q.sort)
+
+	q, err = newStatementQuery(jsonops.FindManyOperationType, map[jsonops.MongoJsonOperationStatementPart][]byte{
+		"sort": []byte(`{"_id": 1}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, bson.D{}, q.filter)
+	require.Equal(t, idSort, q.sort)
+
+	// the tiebreaker is added to the sort that determines the order of the output.
+	q, err = newStatementQuery(jsonops.AggregateOneOperationType, map[jsonops.MongoJsonOperationStatementPart][]byte{
+		"pipeline": []byte(`[{"$sort": {"name": 1}}, {"$project": {"name": 1}}]`),
+	})
+	require.NoError(t, err)
+	require.Len(t, q.pipeline, 2)
+	require.Equal(t, bson.D{{Key: "$sort", Value: bson.D{{Key: "name", Value: int32(1)}, {Key: "_id", Value: 1}}}}, q.pipeline[0])
+
+	// a sort followed by a group does not determine the order of the output.
+	q, err = newStatementQuery(jsonops.AggregateOneOperationType, map[jsonops.MongoJsonOperationStatementPart][]byte{
+		"pipeline": []byte(`[{"$sort": {"name": 1}}, {"$group": {"_id": "$name"}}]`),
+	})
+	require.NoError(t, err)
+	require.Len(t, q.pipeline, 3)
+	require.Equal(t, bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}}, q.pipeline[2])
+
+	_, err = newStatementQuery(jsonops.AggregateOneOperationType, map[jsonops.MongoJsonOperationStatementPart][]byte{})
+	require.Error(t, err)
+
+	_, err = newStatementQuery(jsonops.FindOneOperationType, nil)
+	require.Error(t, err)
+}
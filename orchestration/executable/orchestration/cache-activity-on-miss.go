@@ -0,0 +1,86 @@
+package orchestration
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/cacheactivity"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/rs/zerolog/log"
+)
+
+func newCacheActivity(item config.Configurable, refs config.DataReferences, mapOfNestedOrcs map[string]Orchestration) (*cacheactivity.CacheActivity, error) {
+	ca, err := cacheactivity.NewCacheActivity(item, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	if ca.OrchestrationId() != "" {
+		no, ok := mapOfNestedOrcs[ca.OrchestrationId()]
+		if !ok {
+			return nil, fmt.Errorf("unknown cache on-miss orchestration id %s", ca.OrchestrationId())
+		}
+
+		ca.SetOnMiss(newCacheOnMissFunc(ca.Name(), no))
+	}
+
+	return ca, nil
+}
+
+// newCacheOnMissFunc executes the nested orchestration in a child case. The har entries of the child are merged in the parent
+// case as it happens for the nested-orchestration-activity.
+func newCacheOnMissFunc(activityName string, no Orchestration) cacheactivity.OnMissFunc {
+	return func(wfc *wfcase.WfCase, expressionCtx wfcase.HarEntryReference) (*har.Response, error) {
+		const semLogContext = string(config.CacheActivityType) + "::on-miss"
+
+		wfcChild, err := wfc.NewChild(
+			expressionCtx,
+			no.Cfg.Id,
+			no.Cfg.Version,
+			no.Cfg.SHA,
+			no.Cfg.Description,
+			no.Cfg.Dictionaries,
+			no.Cfg.References,
+			nil,
+			nil,
+			nil)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return nil, err
+		}
+
+		wfcChild.RequestDeadline = no.Cfg.GetPropertyAsDuration(config.OrchestrationPropertyRequestDeadline, time.Duration(0))
+		wfcChild.Replay = wfc.Replay.Child(wfc.ComputeFirstAvailableIndexedHarEntryId(activityName + "-on-miss"))
+
+		runner := NestedOrchestrationActivity{orchestration: no}
+		_, err = runner.executeNestedOrchestration(wfcChild)
+
+		var resp *har.Response
+		harData := wfcChild.GetHarData(wfcase.ReportLogHAR, nil)
+		if harData != nil {
+			entryId := wfc.ComputeFirstAvailableIndexedHarEntryId(activityName + "-on-miss")
+			for _, e := range harData.Log.Entries {
+				if strings.HasPrefix(e.Comment, "request") {
+					e.Comment = entryId
+					resp = e.Response
+				} else {
+					e.Comment = fmt.Sprintf("%s@%s", entryId, e.Comment)
+				}
+				wfc.Entries[e.Comment] = e
+			}
+		}
+
+		if resp == nil && err == nil {
+			err = fmt.Errorf("orchestration %s did not produce a response", no.Cfg.Id)
+		}
+
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+		}
+
+		return resp, err
+	}
+}
@@ -1,16 +1,19 @@
 package orchestration
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/mongoactivity"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/responseactivity"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
@@ -26,6 +29,7 @@ import (
 
 const (
 	ChorusLoopActivityIteratorValueVarName = "_chorus_loop_iterator"
+	ChorusLoopActivityItemValueVarName     = "_chorus_loop_item"
 )
 
 type LoopControlFlow struct {
@@ -35,6 +39,17 @@ type LoopControlFlow struct {
 	end          int
 	step         int
 	inputRequest *har.Request
+
+	// mongo-cursor control flow: the context of the cursor is released on close.
+	cursor       *mongoactivity.ChunkCursor
+	cancelCursor context.CancelFunc
+	chunk        []byte
+	err          error
+
+	// foreach control flow: item and index are the ones of the last iteration returned by Next.
+	items []interface{}
+	item  interface{}
+	index int
 }
 
 func (cf *LoopControlFlow) HasNext(wfc *wfcase.WfCase) bool {
@@ -44,6 +59,32 @@ func (cf *LoopControlFlow) HasNext(wfc *wfcase.WfCase) bool {
 		}
 	}
 
+	if cf.cursor != nil {
+		if cf.chunk == nil && cf.err == nil {
+			cf.chunk, cf.err = cf.cursor.NextChunk()
+		}
+		return cf.chunk != nil
+	}
+
+	switch cf.cfg.Typ {
+	case config.LoopControlFlowForEach:
+		if cf.cfg.MaxIterations > 0 && cf.current >= cf.cfg.MaxIterations {
+			return false
+		}
+		return cf.current < len(cf.items)
+
+	case config.LoopControlFlowWhile:
+		if !wfc.EvalBoolExpression(cf.cfg.Condition) {
+			return false
+		}
+
+		if cf.current >= cf.cfg.MaxIterations {
+			cf.err = fmt.Errorf("while control flow exceeded max-iterations (%d)", cf.cfg.MaxIterations)
+			return false
+		}
+		return true
+	}
+
 	if cf.step > 0 {
 		return cf.current < cf.end
 	} else {
@@ -55,38 +96,95 @@ func (cf *LoopControlFlow) Next(wfc *wfcase.WfCase, evaluator *wfexpressions.Eva
 	const semLogContext = "loop-activity::loop-control-flow-next"
 
 	_ = wfc.Vars.Set(ChorusLoopActivityIteratorValueVarName, cf.current, false, 0, false)
+	cf.index = cf.current
 
-	var b []byte
-	if cf.cfg.XForm.Id != "" {
-		var err error
-		switch cf.cfg.XForm.Typ {
+	if cf.cursor != nil {
+		b := cf.chunk
+		cf.chunk = nil
+		cf.current++
+		return b, nil
+	}
 
-		case config.XFormKazaamDynamic:
-			b, err = cf.resolveAndExecuteKazaamTransformation(wfc, &cf.cfg.XForm, evaluator)
-		case config.XFormKazaam:
-			b, err = evaluator.BodyAsByteArray()
-			b, err = cf.executeKazaamTransformation(cf.cfg.XForm.Id, b)
-		case config.XFormJQ:
-			b, err = evaluator.BodyAsByteArray()
-			b, err = cf.executeJQTransformation(cf.cfg.XForm.Id, b)
+	var b []byte
+	var err error
+	if cf.cfg.Typ == config.LoopControlFlowForEach {
+		cf.item = cf.items[cf.current]
+		_ = wfc.Vars.Set(ChorusLoopActivityItemValueVarName, cf.item, false, 0, false)
+
+		// the item is the input of the iteration and the transformation, if any, applies to it.
+		b, err = json.Marshal(cf.item)
+		if err == nil && cf.cfg.XForm.Id != "" {
+			b, err = cf.transform(wfc, evaluator, b)
 		}
-
-		if err != nil {
-			log.Error().Err(err).Msg(semLogContext)
-			return nil, err
+	} else if cf.cfg.XForm.Id != "" {
+		b, err = evaluator.BodyAsByteArray()
+		if err == nil {
+			b, err = cf.transform(wfc, evaluator, b)
 		}
 	}
 
-	if cf.step > 0 {
-		cf.current += cf.step
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
 
-	} else {
+	switch {
+	case cf.cfg.Typ == config.LoopControlFlowForEach || cf.cfg.Typ == config.LoopControlFlowWhile:
+		cf.current++
+	case cf.step > 0:
+		cf.current += cf.step
+	default:
 		cf.current -= cf.step
 	}
 
 	return b, nil
 }
 
+// SetIterationVars sets the index, and the item of a foreach, of the current iteration in the process vars of the loop body case.
+func (cf *LoopControlFlow) SetIterationVars(wfcChild *wfcase.WfCase) {
+	indexVar := cf.cfg.IndexVar
+	if indexVar == "" {
+		indexVar = ChorusLoopActivityIteratorValueVarName
+	}
+	_ = wfcChild.Vars.Set(indexVar, cf.index, false, 0, false)
+
+	if cf.cfg.Typ == config.LoopControlFlowForEach {
+		itemVar := cf.cfg.ItemVar
+		if itemVar == "" {
+			itemVar = ChorusLoopActivityItemValueVarName
+		}
+		_ = wfcChild.Vars.Set(itemVar, cf.item, false, 0, false)
+	}
+}
+
+func (cf *LoopControlFlow) transform(wfc *wfcase.WfCase, evaluator *wfexpressions.Evaluator, data []byte) ([]byte, error) {
+	switch cf.cfg.XForm.Typ {
+	case config.XFormKazaamDynamic:
+		return cf.resolveAndExecuteKazaamTransformation(wfc, &cf.cfg.XForm, evaluator, data)
+	case config.XFormKazaam:
+		return cf.executeKazaamTransformation(cf.cfg.XForm.Id, data)
+	case config.XFormJQ:
+		return cf.executeJQTransformation(cf.cfg.XForm.Id, data)
+	}
+
+	return nil, nil
+}
+
+// Err reports the error, if any, that stopped the iteration.
+func (cf *LoopControlFlow) Err() error {
+	return cf.err
+}
+
+func (cf *LoopControlFlow) Close() {
+	if cf.cursor != nil {
+		cf.cursor.Close()
+	}
+
+	if cf.cancelCursor != nil {
+		cf.cancelCursor()
+	}
+}
+
 func (a *LoopControlFlow) executeKazaamTransformation(kazaamId string, data []byte) ([]byte, error) {
 	return kz.GetRegistry().Transform(kazaamId, data)
 }
@@ -95,7 +193,7 @@ func (a *LoopControlFlow) executeJQTransformation(jqId string, data []byte) ([]b
 	return jq.GetRegistry().Transform(jqId, data)
 }
 
-func (a *LoopControlFlow) resolveAndExecuteKazaamTransformation(wfc *wfcase.WfCase, xForm *xforms.TransformReference, resolver *wfexpressions.Evaluator) ([]byte, error) {
+func (a *LoopControlFlow) resolveAndExecuteKazaamTransformation(wfc *wfcase.WfCase, xForm *xforms.TransformReference, resolver *wfexpressions.Evaluator, data []byte) ([]byte, error) {
 	const semLogContext = "loop-activity::resolve-and-execute-kazaam-transformation"
 
 	// Missing template functions.
@@ -105,16 +203,10 @@ func (a *LoopControlFlow) resolveAndExecuteKazaamTransformation(wfc *wfcase.WfCa
 		return nil, err
 	}
 
-	data, err := resolver.BodyAsByteArray()
-	if err != nil {
-		log.Error().Err(err).Msg(semLogContext)
-		return nil, err
-	}
-
 	return kz.ApplyKazaamTransformation(resolvedTransformation, data)
 }
 
-func InitLoopControlFlow(cfg config.LoopControlFlowDefinition, evaluator *wfexpressions.Evaluator) (*LoopControlFlow, error) {
+func InitLoopControlFlow(wfc *wfcase.WfCase, cfg config.LoopControlFlowDefinition, refs config.DataReferences, evaluator *wfexpressions.Evaluator) (*LoopControlFlow, error) {
 	const semLogContext = string(config.LoopActivityType) + "::init-control-flow"
 	var err error
 
@@ -156,6 +248,22 @@ func InitLoopControlFlow(cfg config.LoopControlFlowDefinition, evaluator *wfexpr
 			return nil, err
 		}
 
+	case config.LoopControlFlowMongoCursor:
+		c.cursor, c.cancelCursor, err = openLoopChunkCursor(wfc, cfg.Cursor.Mongo, refs, evaluator)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			return nil, err
+		}
+
+	case config.LoopControlFlowForEach:
+		c.items, err = selectLoopItems(cfg.Items, evaluator)
+		if err != nil {
+			log.Error().Err(err).Str("items", cfg.Items).Msg(semLogContext)
+			return nil, err
+		}
+
+	case config.LoopControlFlowWhile:
+
 	default:
 		err = errors.New("control flow type not recognized: " + cfg.Typ)
 	}
@@ -163,6 +271,59 @@ func InitLoopControlFlow(cfg config.LoopControlFlowDefinition, evaluator *wfexpr
 	return c, err
 }
 
+// openLoopChunkCursor opens the cursor of the loop. It is read across the iterations: it is bound by the case, not by a timeout of its own, and its
+// context is released by the returned cancel function.
+func openLoopChunkCursor(wfc *wfcase.WfCase, def config.MongoActivityDefinition, refs config.DataReferences, evaluator *wfexpressions.Evaluator) (*mongoactivity.ChunkCursor, context.CancelFunc, error) {
+	statementConfig, err := def.LoadStatementConfig(refs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	statementConfig, err = mongoactivity.ResolveStatementParts(wfc, evaluator, statementConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := wfc.BoundedContext(0)
+	cc, err := mongoactivity.OpenChunkCursor(ctx, def, statementConfig)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	return cc, cancel, nil
+}
+
+// selectLoopItems returns the array selected by the json-path in the body of the loop input. A missing or null selection yields no items.
+func selectLoopItems(path string, evaluator *wfexpressions.Evaluator) ([]interface{}, error) {
+	b, err := evaluator.BodyAsByteArray()
+	if err != nil {
+		return nil, err
+	}
+
+	var body interface{}
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := getLoopJsonPath(path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch items := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return items, nil
+	default:
+		return nil, fmt.Errorf("items %s is not an array but %T", path, v)
+	}
+}
+
 func convToInt(val interface{}) (int, error) {
 	const semLogContext = string(config.LoopActivityType) + "::conv-to-int"
 
@@ -270,11 +431,12 @@ func (a *LoopActivity) Execute(wfc *wfcase.WfCase) error {
 		return err
 	}
 
-	controlFlow, err := InitLoopControlFlow(a.definition.ControlFlow, evaluator)
+	controlFlow, err := InitLoopControlFlow(wfc, a.definition.ControlFlow, a.Refs, evaluator)
 	if err != nil {
 		log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
 		return err
 	}
+	defer controlFlow.Close()
 
 	beginOf := time.Now()
 	metricsLabels := a.MetricsLabels()
@@ -291,90 +453,98 @@ func (a *LoopActivity) Execute(wfc *wfcase.WfCase) error {
 
 	st := http.StatusOK
 
-	var loopBodyResponses [][]byte
+	var loopBodyResponses []loopBodyResponse
 	var activityError error
 	var loopBodyStatusCode int
-	for controlFlow.HasNext(wfc) {
-
-		log.Info().Int("loop", controlFlow.current).Msg(semLogContext)
-		var loopBodyInputRequest []byte
-		loopBodyInputRequest, err = controlFlow.Next(wfc, evaluator)
-		if err != nil {
-			wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
-			metricsLabels[MetricIdStatusCode] = "500"
-			return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithStep(a.Name()), smperror.WithErrorMessage(err.Error()))
-		}
-
-		wfcChild, err := wfc.NewChild(
-			expressionCtx,
-			a.bodyOrchestration.Cfg.Id,
-			a.bodyOrchestration.Cfg.Version,
-			a.bodyOrchestration.Cfg.SHA,
-			a.bodyOrchestration.Cfg.Description,
-			a.bodyOrchestration.Cfg.Dictionaries,
-			a.bodyOrchestration.Cfg.References,
-			tcfg.ProcessVars,
-			loopBodyInputRequest,
-			nil)
+	var numSucceeded, numFailed, numDiscarded int
 
-		if err != nil {
-			wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
-			metricsLabels[MetricIdStatusCode] = "500"
-			return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithStep(a.Name()), smperror.WithErrorMessage(err.Error()))
+	// processIteration merges the iteration in the case and applies the on-response actions. It reports whether the loop has to stop.
+	processIteration := func(it *loopIteration) bool {
+		resp, iterationStatusCode := a.mergeIterationCase(wfc, it)
+		if resp != nil {
+			loopBodyResponses = append(loopBodyResponses, loopBodyResponse{data: resp, failed: it.err != nil})
 		}
 
-		wfcChild.RequestDeadline = a.bodyOrchestration.Cfg.GetPropertyAsDuration(config.OrchestrationPropertyRequestDeadline, time.Duration(0))
-		if wfcChild.RequestDeadline != 0 {
-			log.Info().Float64("deadline-secs", wfcChild.RequestDeadline.Seconds()).Msg(semLogContext + " - setting workflow case request deadline")
+		if it.err != nil {
+			numFailed++
 		} else {
-			log.Info().Msg(semLogContext + " - no workflow case request deadline has been set")
-		}
-
-		err = a.executeNestedOrchestration(wfcChild)
-		harData := wfcChild.GetHarData(wfcase.ReportLogHAR, nil)
-		if harData != nil {
-			entryId := wfc.ComputeFirstAvailableIndexedHarEntryId(a.Name() + "-body")
-			for _, e := range harData.Log.Entries {
-				if strings.HasPrefix(e.Comment, "request") {
-					e.Comment = fmt.Sprintf("%s", entryId)
-					if e.Response != nil && e.Response.Content != nil {
-						if strings.HasPrefix(e.Response.Content.MimeType, constants.ContentTypeApplicationJson) {
-							loopBodyResponses = append(loopBodyResponses, e.Response.Content.Data)
-						} else {
-							log.Warn().Msg(semLogContext + " non application/json response")
-						}
-					}
-				} else {
-					e.Comment = fmt.Sprintf("%s@%s", entryId, e.Comment)
-				}
-				wfc.Entries[e.Comment] = e
-			}
+			numSucceeded++
 		}
 
-		st = http.StatusOK
-		if err != nil {
-			st = http.StatusInternalServerError
-			var smpErr *smperror.SymphonyError
-			if errors.As(err, &smpErr) {
-				st = smpErr.StatusCode
+		// st keeps the status code of the iteration that failed first.
+		if activityError == nil {
+			st = iterationStatusCode
+		}
+
+		var actionErr error
+		loopBodyStatusCode, actionErr = a.ProcessResponseActionByStatusCode(iterationStatusCode, a.Name(), a.Name(), wfc, it.wfc, wfcase.HarEntryReference{Name: "request", UseResponse: true}, a.definition.OnResponseActions, false)
+		if actionErr == nil {
+			return false
+		}
+
+		if activityError == nil {
+			activityError = actionErr
+		}
+		return a.definition.GetOnError() == config.LoopErrorPolicyFailFast
+	}
+
+	if a.definition.Parallelism > 1 {
+		var discarded []*loopIteration
+		discarded, err = a.executeIterationsInParallel(wfc, expressionCtx, tcfg, controlFlow, evaluator, processIteration)
+
+		// the iterations following the one that stopped the loop are recorded in the har only and counted apart from the processed ones.
+		for _, it := range discarded {
+			_, _ = a.mergeIterationCase(wfc, it)
+		}
+		numDiscarded = len(discarded)
+	} else {
+		for controlFlow.HasNext(wfc) {
+			var it *loopIteration
+			it, err = a.newIteration(wfc, expressionCtx, tcfg, controlFlow, evaluator, wfc.ComputeFirstAvailableIndexedHarEntryId(a.Name()+"-body"))
+			if err != nil {
+				break
+			}
+
+			it.err = a.executeNestedOrchestration(it.wfc)
+			if processIteration(it) {
+				break
 			}
 		}
+	}
 
-		loopBodyStatusCode, err = a.ProcessResponseActionByStatusCode(st, a.Name(), a.Name(), wfc, wfcChild, wfcase.HarEntryReference{Name: "request", UseResponse: true}, a.definition.OnResponseActions, false)
-		if err != nil {
-			activityError = err
-			break
+	if err != nil {
+		wfc.AddBreadcrumb(a.Name(), a.Cfg.Description(), err)
+		metricsLabels[MetricIdStatusCode] = "500"
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(a.Name()), smperror.WithStep(a.Name()), smperror.WithErrorMessage(err.Error()))
+	}
+
+	if activityError == nil && controlFlow.Err() != nil {
+		activityError = controlFlow.Err()
+		st = http.StatusInternalServerError
+	}
+
+	var aggregate []byte
+	if activityError == nil {
+		aggregate, activityError = aggregateLoopResponses(a.definition.Aggregate, loopBodyResponses)
+		if activityError != nil {
+			log.Error().Err(activityError).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext + " - aggregate")
+			st = http.StatusInternalServerError
 		}
 	}
 
 	var harResponse *har.Response
 	if activityError == nil {
-		harResponse, _ = a.newSuccessResponse(loopBodyResponses)
+		harResponse, _ = a.newSuccessResponse(aggregate)
 	} else {
 		harResponse, _ = a.newErrorResponse(st, activityError)
 	}
 
 	if harResponse != nil {
+		harResponse.Headers = append(harResponse.Headers,
+			har.NameValuePair{Name: LoopSucceededIterationsHeaderName, Value: fmt.Sprint(numSucceeded)},
+			har.NameValuePair{Name: LoopFailedIterationsHeaderName, Value: fmt.Sprint(numFailed)},
+			har.NameValuePair{Name: LoopDiscardedIterationsHeaderName, Value: fmt.Sprint(numDiscarded)},
+		)
 		_ = wfc.SetHarEntryResponse(a.Name(), harResponse, tcfg.PII)
 		metricsLabels[MetricIdStatusCode] = fmt.Sprint(harResponse.Status)
 	}
@@ -392,6 +562,171 @@ func (a *LoopActivity) Execute(wfc *wfcase.WfCase) error {
 	return nil
 }
 
+// loopIteration is the case of an iteration of the loop body and the id of the har entry its entries get merged under.
+type loopIteration struct {
+	wfc       *wfcase.WfCase
+	entryId   string
+	err       error
+	completed bool
+}
+
+func (a *LoopActivity) newIteration(wfc *wfcase.WfCase, expressionCtx wfcase.HarEntryReference, tcfg *config.LoopActivity, controlFlow *LoopControlFlow, evaluator *wfexpressions.Evaluator, entryId string) (*loopIteration, error) {
+	const semLogContext = string(config.LoopActivityType) + "::new-iteration"
+
+	log.Info().Int("loop", controlFlow.current).Msg(semLogContext)
+	loopBodyInputRequest, err := controlFlow.Next(wfc, evaluator)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	wfcChild, err := wfc.NewChild(
+		expressionCtx,
+		a.bodyOrchestration.Cfg.Id,
+		a.bodyOrchestration.Cfg.Version,
+		a.bodyOrchestration.Cfg.SHA,
+		a.bodyOrchestration.Cfg.Description,
+		a.bodyOrchestration.Cfg.Dictionaries,
+		a.bodyOrchestration.Cfg.References,
+		tcfg.ProcessVars,
+		loopBodyInputRequest,
+		nil)
+
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	controlFlow.SetIterationVars(wfcChild)
+	wfcChild.RequestDeadline = a.bodyOrchestration.Cfg.GetPropertyAsDuration(config.OrchestrationPropertyRequestDeadline, time.Duration(0))
+	wfcChild.Replay = wfc.Replay.Child(entryId)
+	if wfcChild.RequestDeadline != 0 {
+		log.Info().Float64("deadline-secs", wfcChild.RequestDeadline.Seconds()).Msg(semLogContext + " - setting workflow case request deadline")
+	} else {
+		log.Info().Msg(semLogContext + " - no workflow case request deadline has been set")
+	}
+
+	return &loopIteration{wfc: wfcChild, entryId: entryId}, nil
+}
+
+// executeIterationsInParallel runs the loop bodies, at most parallelism at a time, each one in its own case. The control flow and the case of the loop are accessed by
+// the calling goroutine only. It returns the iterations that have not been processed because the loop has been stopped.
+func (a *LoopActivity) executeIterationsInParallel(wfc *wfcase.WfCase, expressionCtx wfcase.HarEntryReference, tcfg *config.LoopActivity, controlFlow *LoopControlFlow, evaluator *wfexpressions.Evaluator, process func(it *loopIteration) bool) ([]*loopIteration, error) {
+
+	// the entries are merged as the iterations complete so the ids are assigned upfront.
+	firstEntryId := wfc.ComputeFirstAvailableIndexedHarEntryId(a.Name() + "-body")
+	firstIndex, _ := strconv.Atoi(firstEntryId[strings.LastIndex(firstEntryId, "#")+1:])
+
+	numIterations := 0
+	next := func() (*loopIteration, error) {
+		if !controlFlow.HasNext(wfc) {
+			return nil, nil
+		}
+
+		it, err := a.newIteration(wfc, expressionCtx, tcfg, controlFlow, evaluator, fmt.Sprintf("%s-body#%d", a.Name(), firstIndex+numIterations))
+		numIterations++
+		return it, err
+	}
+
+	return runIterationsInParallel(wfc.Ctx, a.definition.Parallelism, next, a.executeNestedOrchestration, process)
+}
+
+// runIterationsInParallel keeps up to parallelism iterations running. The completed iterations are processed in iteration order, as the sequential loop does,
+// and the loop stops at the first one for which process returns true: the iterations still running are cancelled and, together with the ones completed
+// after it, returned as discarded. The iterations are cancelled as well when parent, the context of the case of the loop, is done.
+func runIterationsInParallel(parent context.Context, parallelism int, next func() (*loopIteration, error), run func(wfc *wfcase.WfCase) error, process func(it *loopIteration) bool) ([]*loopIteration, error) {
+	const semLogContext = string(config.LoopActivityType) + "::run-iterations-in-parallel"
+
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	done := make(chan *loopIteration)
+	var iterations []*loopIteration
+	var err error
+	numRunning, numProcessed := 0, 0
+	stopped := false
+	for {
+		for !stopped && err == nil && ctx.Err() == nil && numRunning < parallelism {
+			var it *loopIteration
+			it, err = next()
+			if err != nil || it == nil {
+				break
+			}
+
+			it.wfc.Ctx = ctx
+			iterations = append(iterations, it)
+			numRunning++
+			go func() {
+				it.err = run(it.wfc)
+				done <- it
+			}()
+		}
+
+		if err != nil && !stopped {
+			stopped = true
+			cancel()
+		}
+
+		if numRunning == 0 {
+			break
+		}
+
+		it := <-done
+		numRunning--
+		it.completed = true
+		for !stopped && numProcessed < len(iterations) && iterations[numProcessed].completed {
+			stopped = process(iterations[numProcessed])
+			numProcessed++
+			if stopped {
+				log.Info().Int("iteration", numProcessed-1).Msg(semLogContext + " - loop stopped, cancelling the running iterations")
+				cancel()
+			}
+		}
+	}
+
+	return iterations[numProcessed:], err
+}
+
+// mergeIterationCase copies the har entries of the iteration in the case of the loop. It returns the json response of the loop body, if any, and its status code.
+func (a *LoopActivity) mergeIterationCase(wfc *wfcase.WfCase, it *loopIteration) ([]byte, int) {
+	const semLogContext = string(config.LoopActivityType) + "::merge-iteration-case"
+
+	var resp []byte
+	harData := it.wfc.GetHarData(wfcase.ReportLogHAR, nil)
+	if harData != nil {
+		for _, e := range harData.Log.Entries {
+			if strings.HasPrefix(e.Comment, "request") {
+				e.Comment = it.entryId
+				if e.Response != nil && e.Response.Content != nil {
+					if strings.HasPrefix(e.Response.Content.MimeType, constants.ContentTypeApplicationJson) {
+						resp = e.Response.Content.Data
+					} else {
+						log.Warn().Msg(semLogContext + " non application/json response")
+					}
+				}
+			} else {
+				e.Comment = fmt.Sprintf("%s@%s", it.entryId, e.Comment)
+			}
+			wfc.Entries[e.Comment] = e
+		}
+	}
+
+	st := http.StatusOK
+	if it.err != nil {
+		st = http.StatusInternalServerError
+		var smpErr *smperror.SymphonyError
+		if errors.As(it.err, &smpErr) {
+			st = smpErr.StatusCode
+		}
+	}
+
+	return resp, st
+}
+
 func (a *LoopActivity) executeNestedOrchestration(wfc *wfcase.WfCase) error {
 	const semLogContext = string(config.LoopActivityType) + "::execute-orchestration"
 
@@ -541,18 +876,7 @@ func (a *LoopActivity) newErrorResponse(st int, err error) (*har.Response, error
 	return r, nil
 }
 
-func (a *LoopActivity) newSuccessResponse(loopResponses [][]byte) (*har.Response, error) {
-
-	var sb bytes.Buffer
-	sb.WriteString("[")
-	for i, resp := range loopResponses {
-		if i > 0 {
-			sb.WriteString(",")
-		}
-		sb.WriteString(string(resp))
-	}
-	sb.WriteString("]")
-	b := sb.Bytes()
+func (a *LoopActivity) newSuccessResponse(b []byte) (*har.Response, error) {
 
 	var r *har.Response
 	r = &har.Response{
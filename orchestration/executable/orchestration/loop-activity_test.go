@@ -0,0 +1,154 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestIterations returns a next function that yields n iterations and the counter of the iterations it has created.
+func newTestIterations(t *testing.T, n int) (func() (*loopIteration, error), *int) {
+	created := 0
+	return func() (*loopIteration, error) {
+		if created == n {
+			return nil, nil
+		}
+
+		wfc, err := wfcase.NewWorkflowCase("loop-body", "", "", "", nil, nil, nil, nil)
+		require.NoError(t, err)
+		require.NoError(t, wfc.Vars.Set(ChorusLoopActivityIteratorValueVarName, created, false, 0, false))
+
+		it := &loopIteration{wfc: wfc, entryId: fmt.Sprintf("loop-body#%d", created)}
+		created++
+		return it, nil
+	}, &created
+}
+
+func iterationIndex(wfc *wfcase.WfCase) int {
+	v, _ := wfc.Vars.Lookup(ChorusLoopActivityIteratorValueVarName, -1)
+	return v.(int)
+}
+
+func TestRunIterationsInParallel(t *testing.T) {
+	next, created := newTestIterations(t, 6)
+
+	var running, maxRunning atomic.Int32
+	run := func(wfc *wfcase.WfCase) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for m := maxRunning.Load(); n > m && !maxRunning.CompareAndSwap(m, n); m = maxRunning.Load() {
+		}
+
+		// the first iterations are the slowest ones.
+		time.Sleep(time.Duration(6-iterationIndex(wfc)) * 5 * time.Millisecond)
+		return nil
+	}
+
+	var processed []string
+	discarded, err := runIterationsInParallel(nil, 3, next, run, func(it *loopIteration) bool {
+		processed = append(processed, it.entryId)
+		return false
+	})
+	require.NoError(t, err)
+	require.Empty(t, discarded)
+	require.Equal(t, 6, *created)
+	require.LessOrEqual(t, maxRunning.Load(), int32(3))
+
+	// the iterations are processed in iteration order whatever the order of completion.
+	require.Equal(t, []string{"loop-body#0", "loop-body#1", "loop-body#2", "loop-body#3", "loop-body#4", "loop-body#5"}, processed)
+}
+
+func TestRunIterationsInParallelStop(t *testing.T) {
+	next, created := newTestIterations(t, 10)
+
+	// the second iteration fails, the ones after it wait to be cancelled.
+	run := func(wfc *wfcase.WfCase) error {
+		switch iterationIndex(wfc) {
+		case 0:
+			return nil
+		case 1:
+			time.Sleep(10 * time.Millisecond)
+			return errors.New("iteration failed")
+		}
+
+		select {
+		case <-wfc.Ctx.Done():
+			return wfc.CancellationErr()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	}
+
+	// as in the sequential loop the trigger is the outcome of the on-response actions of the iteration, not its error as such.
+	var processed []string
+	discarded, err := runIterationsInParallel(nil, 3, next, run, func(it *loopIteration) bool {
+		processed = append(processed, it.entryId)
+		return it.err != nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"loop-body#0", "loop-body#1"}, processed)
+
+	// the slot of the first iteration has been taken by the fourth one, no iteration is dispatched after the stop and the running ones are cancelled.
+	require.Equal(t, 4, *created)
+	require.Len(t, discarded, 2)
+	for i, it := range discarded {
+		require.Equal(t, fmt.Sprintf("loop-body#%d", i+2), it.entryId)
+		require.ErrorIs(t, it.err, context.Canceled)
+	}
+}
+
+func TestRunIterationsInParallelCancelledParent(t *testing.T) {
+	next, created := newTestIterations(t, 10)
+
+	run := func(wfc *wfcase.WfCase) error {
+		select {
+		case <-wfc.Ctx.Done():
+			return wfc.CancellationErr()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	}
+
+	// the iterations running when the case of the loop gets cancelled are cancelled with it and no other one is dispatched.
+	parent, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	var processed []string
+	discarded, err := runIterationsInParallel(parent, 3, next, run, func(it *loopIteration) bool {
+		processed = append(processed, it.entryId)
+		return false
+	})
+	require.NoError(t, err)
+	require.Empty(t, discarded)
+	require.Equal(t, 3, *created)
+	require.Len(t, processed, 3)
+}
+
+func TestExecuteCancelledCase(t *testing.T) {
+	a := &fakeTxActivity{name: "a1"}
+	o := &Orchestration{
+		Cfg:         &config.Orchestration{Id: "cancel-test", StartActivity: a.name},
+		Executables: map[string]executable.Executable{a.name: a},
+	}
+
+	wfc, err := wfcase.NewWorkflowCase("cancel-test", "", "", "", nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wfc.Ctx = ctx
+	_, err = o.Execute(wfc)
+	require.NoError(t, err)
+
+	cancel()
+	_, err = o.Execute(wfc)
+	require.Error(t, err)
+	require.ErrorIs(t, wfc.CancellationErr(), context.Canceled)
+}
@@ -0,0 +1,220 @@
+package orchestration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms/jq"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms/kz"
+	"github.com/PaesslerAG/jsonpath"
+)
+
+const (
+	LoopSucceededIterationsHeaderName = "X-Loop-Succeeded"
+	LoopFailedIterationsHeaderName    = "X-Loop-Failed"
+	LoopDiscardedIterationsHeaderName = "X-Loop-Discarded"
+)
+
+// loopBodyResponse is the json response of an iteration.
+type loopBodyResponse struct {
+	data   []byte
+	failed bool
+}
+
+// aggregateLoopResponses produces the body of the loop response according to the aggregate strategy.
+func aggregateLoopResponses(def config.LoopAggregateDefinition, responses []loopBodyResponse) ([]byte, error) {
+	switch def.GetStrategy() {
+	case config.LoopAggregateArray:
+		return joinLoopResponses(responses, true), nil
+	case config.LoopAggregateReduce:
+		return reduceLoopResponses(def.XForm, joinLoopResponses(responses, false))
+	}
+
+	values := make([]interface{}, 0, len(responses))
+	for _, r := range responses {
+		if r.failed {
+			continue
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(r.data, &v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	var agg interface{}
+	var err error
+	switch def.GetStrategy() {
+	case config.LoopAggregateMergeObjects:
+		agg, err = mergeLoopResponses(values)
+	case config.LoopAggregateCount:
+		agg, err = countLoopValues(def.Path, values)
+	case config.LoopAggregateSum, config.LoopAggregateMin, config.LoopAggregateMax:
+		agg, err = foldLoopValues(def.GetStrategy(), def.Path, values)
+	case config.LoopAggregateGroupBy:
+		agg, err = groupLoopResponses(def.Path, values)
+	default:
+		err = fmt.Errorf("unsupported aggregate strategy %s", def.Strategy)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(agg)
+}
+
+// joinLoopResponses returns the json array of the responses, the ones of failed iterations are included on request.
+func joinLoopResponses(responses []loopBodyResponse, withFailed bool) []byte {
+	var sb bytes.Buffer
+	sb.WriteString("[")
+	n := 0
+	for _, resp := range responses {
+		if resp.failed && !withFailed {
+			continue
+		}
+
+		if n > 0 {
+			sb.WriteString(",")
+		}
+		sb.Write(resp.data)
+		n++
+	}
+	sb.WriteString("]")
+	return sb.Bytes()
+}
+
+func reduceLoopResponses(xForm xforms.TransformReference, data []byte) ([]byte, error) {
+	switch xForm.Typ {
+	case config.XFormKazaam:
+		return kz.GetRegistry().Transform(xForm.Id, data)
+	case config.XFormJQ:
+		return jq.GetRegistry().Transform(xForm.Id, data)
+	}
+
+	return nil, fmt.Errorf("unsupported reduce x-form type %s", xForm.Typ)
+}
+
+// mergeLoopResponses merges the top level properties of the responses, the later ones win.
+func mergeLoopResponses(values []interface{}) (interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, v := range values {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot merge response of type %T", v)
+		}
+
+		for k, pv := range obj {
+			merged[k] = pv
+		}
+	}
+
+	return merged, nil
+}
+
+func countLoopValues(path string, values []interface{}) (interface{}, error) {
+	if path == "" {
+		return len(values), nil
+	}
+
+	n := 0
+	for _, v := range values {
+		selected, err := selectLoopValues(path, v)
+		if err != nil {
+			return nil, err
+		}
+		n += len(selected)
+	}
+
+	return n, nil
+}
+
+// foldLoopValues computes sum, min or max of the numbers selected by path. min and max of no numbers are null.
+func foldLoopValues(strategy string, path string, values []interface{}) (interface{}, error) {
+	var agg interface{}
+	sum := 0.0
+	for _, v := range values {
+		selected, err := selectLoopValues(path, v)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range selected {
+			f, ok := s.(float64)
+			if !ok {
+				return nil, fmt.Errorf("value %v selected by %s is not a number", s, path)
+			}
+
+			switch strategy {
+			case config.LoopAggregateSum:
+				sum += f
+			case config.LoopAggregateMin:
+				if agg == nil || f < agg.(float64) {
+					agg = f
+				}
+			case config.LoopAggregateMax:
+				if agg == nil || f > agg.(float64) {
+					agg = f
+				}
+			}
+		}
+	}
+
+	if strategy == config.LoopAggregateSum {
+		return sum, nil
+	}
+
+	return agg, nil
+}
+
+// groupLoopResponses groups the responses by the string value of the key selected by path.
+func groupLoopResponses(path string, values []interface{}) (interface{}, error) {
+	groups := make(map[string][]interface{})
+	for _, v := range values {
+		selected, err := selectLoopValues(path, v)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(selected) != 1 {
+			return nil, fmt.Errorf("group-by %s should select exactly one value, found %d", path, len(selected))
+		}
+
+		k := fmt.Sprint(selected[0])
+		groups[k] = append(groups[k], v)
+	}
+
+	return groups, nil
+}
+
+// selectLoopValues returns the values selected by path, arrays are flattened. A missing or null selection yields no values.
+func selectLoopValues(path string, v interface{}) ([]interface{}, error) {
+	selected, err := getLoopJsonPath(path, v)
+	if err != nil {
+		return nil, err
+	}
+
+	switch s := selected.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return s, nil
+	default:
+		return []interface{}{s}, nil
+	}
+}
+
+// getLoopJsonPath evaluates the path and yields nil for missing keys.
+func getLoopJsonPath(path string, v interface{}) (interface{}, error) {
+	selected, err := jsonpath.Get(path, v)
+	if err != nil && strings.HasPrefix(err.Error(), "unknown key") {
+		return nil, nil
+	}
+
+	return selected, err
+}
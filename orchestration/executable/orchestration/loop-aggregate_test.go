@@ -0,0 +1,41 @@
+package orchestration
+
+import (
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateLoopResponses(t *testing.T) {
+	responses := []loopBodyResponse{
+		{data: []byte(`{"id":"a","kind":"x","amount":10,"tags":[1,2]}`)},
+		{data: []byte(`{"ambit":"ep","message":"not found"}`), failed: true},
+		{data: []byte(`{"id":"b","kind":"y","amount":2.5}`)},
+		{data: []byte(`{"id":"c","kind":"x","amount":-1,"tags":[3]}`)},
+	}
+
+	testCases := []struct {
+		def      config.LoopAggregateDefinition
+		expected string
+	}{
+		{def: config.LoopAggregateDefinition{}, expected: `[{"id":"a","kind":"x","amount":10,"tags":[1,2]},{"ambit":"ep","message":"not found"},{"id":"b","kind":"y","amount":2.5},{"id":"c","kind":"x","amount":-1,"tags":[3]}]`},
+		{def: config.LoopAggregateDefinition{Strategy: config.LoopAggregateMergeObjects}, expected: `{"amount":-1,"id":"c","kind":"x","tags":[3]}`},
+		{def: config.LoopAggregateDefinition{Strategy: config.LoopAggregateSum, Path: "$.amount"}, expected: `11.5`},
+		{def: config.LoopAggregateDefinition{Strategy: config.LoopAggregateMin, Path: "$.amount"}, expected: `-1`},
+		{def: config.LoopAggregateDefinition{Strategy: config.LoopAggregateMax, Path: "$.amount"}, expected: `10`},
+		{def: config.LoopAggregateDefinition{Strategy: config.LoopAggregateMax, Path: "$.missing"}, expected: `null`},
+		{def: config.LoopAggregateDefinition{Strategy: config.LoopAggregateCount}, expected: `3`},
+		{def: config.LoopAggregateDefinition{Strategy: config.LoopAggregateCount, Path: "$.tags"}, expected: `3`},
+		{def: config.LoopAggregateDefinition{Strategy: config.LoopAggregateGroupBy, Path: "$.kind"}, expected: `{"x":[{"amount":10,"id":"a","kind":"x","tags":[1,2]},{"amount":-1,"id":"c","kind":"x","tags":[3]}],"y":[{"amount":2.5,"id":"b","kind":"y"}]}`},
+	}
+
+	for _, tc := range testCases {
+		b, err := aggregateLoopResponses(tc.def, responses)
+		require.NoError(t, err, tc.def.Strategy)
+		require.JSONEq(t, tc.expected, string(b), tc.def.Strategy)
+	}
+
+	_, err := aggregateLoopResponses(config.LoopAggregateDefinition{Strategy: config.LoopAggregateSum, Path: "$.id"}, responses)
+	require.Error(t, err)
+}
@@ -136,6 +136,7 @@ func (a *NestedOrchestrationActivity) Execute(wfc *wfcase.WfCase) error {
 	}
 
 	wfcChild.RequestDeadline = a.orchestration.Cfg.GetPropertyAsDuration(config.OrchestrationPropertyRequestDeadline, time.Duration(0))
+	wfcChild.Replay = wfc.Replay.Child(wfc.ComputeFirstAvailableIndexedHarEntryId(a.Name()))
 	if wfcChild.RequestDeadline != 0 {
 		log.Info().Float64("deadline-secs", wfcChild.RequestDeadline.Seconds()).Msg(semLogContext + " - setting workflow case request deadline")
 	} else {
@@ -0,0 +1,82 @@
+package orchestration
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCallActivity stands for an activity with an outbound call: the response is served by the cassette when the case is replaying.
+type fakeCallActivity struct {
+	fakeTxActivity
+	body     string
+	replayed bool
+}
+
+func (a *fakeCallActivity) Execute(wfc *wfcase.WfCase) error {
+	if wfc.IsReplaying() {
+		e, err := wfc.ReplayHarEntry(a.name)
+		if err != nil {
+			return err
+		}
+
+		a.replayed = true
+		return wfc.SetHarEntry(a.name, e)
+	}
+
+	return wfc.SetHarEntry(a.name, &har.Entry{
+		Request:  &har.Request{Method: http.MethodGet, URL: "http://example.com/movies"},
+		Response: har.NewResponse(http.StatusOK, http.StatusText(http.StatusOK), constants.ContentTypeApplicationJson, []byte(a.body), nil),
+	})
+}
+
+func newHarTestOrchestration(properties map[string]interface{}, a *fakeCallActivity) (*Orchestration, error) {
+	o := &Orchestration{
+		Cfg:         &config.Orchestration{Id: "har-test", StartActivity: a.name, Properties: properties},
+		Executables: map[string]executable.Executable{a.name: a},
+	}
+
+	return o, o.loadHarReplayFile()
+}
+
+func TestHarRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	o, err := newHarTestOrchestration(map[string]interface{}{config.OrchestrationPropertyHarRecordFolder: dir}, &fakeCallActivity{fakeTxActivity: fakeTxActivity{name: "get-movies"}, body: `{"title":"Casablanca"}`})
+	require.NoError(t, err)
+	wfc, err := wfcase.NewWorkflowCase("case-1", "", "", "", nil, nil, nil, nil)
+	require.NoError(t, err)
+	_, err = o.Execute(wfc)
+	require.NoError(t, err)
+
+	fn := filepath.Join(dir, "case-1.har")
+	_, err = os.Stat(fn)
+	require.NoError(t, err)
+
+	a := &fakeCallActivity{fakeTxActivity: fakeTxActivity{name: "get-movies"}}
+	o, err = newHarTestOrchestration(map[string]interface{}{config.OrchestrationPropertyHarReplayFile: fn}, a)
+	require.NoError(t, err)
+
+	// the har is loaded once and every case replays it from the start.
+	require.NoError(t, os.Remove(fn))
+	for _, id := range []string{"case-2", "case-3"} {
+		a.replayed = false
+		wfc, err = wfcase.NewWorkflowCase(id, "", "", "", nil, nil, nil, nil)
+		require.NoError(t, err)
+		_, err = o.Execute(wfc)
+		require.NoError(t, err)
+		require.True(t, a.replayed)
+		require.JSONEq(t, `{"title":"Casablanca"}`, string(wfc.Entries["get-movies#0"].Response.Content.Data))
+	}
+
+	_, err = newHarTestOrchestration(map[string]interface{}{config.OrchestrationPropertyHarReplayFile: filepath.Join(dir, "missing.har")}, a)
+	require.Error(t, err)
+}
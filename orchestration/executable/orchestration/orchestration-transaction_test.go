@@ -0,0 +1,149 @@
+package orchestration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type fakeMongoSession struct {
+	committed bool
+	aborted   bool
+	ended     bool
+}
+
+func (s *fakeMongoSession) Client() *mongo.Client { return nil }
+
+func (s *fakeMongoSession) CommitTransaction(ctx context.Context) error {
+	s.committed = true
+	return nil
+}
+
+func (s *fakeMongoSession) AbortTransaction(ctx context.Context) error {
+	s.aborted = true
+	return nil
+}
+
+func (s *fakeMongoSession) EndSession(ctx context.Context) {
+	s.ended = true
+}
+
+// fakeTxActivity joins the transaction of its scope, if any, as the mongo activities do.
+type fakeTxActivity struct {
+	name     string
+	scope    string
+	sessions map[string]*fakeMongoSession
+	failOp   bool
+	err      error
+}
+
+func (a *fakeTxActivity) Execute(wfc *wfcase.WfCase) error {
+	if a.scope != "" {
+		tx, ok := wfc.GetMongoTransaction(a.scope)
+		if !ok {
+			sess := &fakeMongoSession{}
+			a.sessions[a.scope] = sess
+			tx = &wfcase.MongoTransaction{Scope: a.scope, Session: sess, Ctx: context.Background()}
+			wfc.SetMongoTransaction(tx)
+		}
+		tx.Failed = tx.Failed || a.failOp
+	}
+
+	return a.err
+}
+
+func (a *fakeTxActivity) Next(wfc *wfcase.WfCase, policy string) (string, error) { return "", nil }
+func (a *fakeTxActivity) AddInput(p executable.Path) error                       { return nil }
+func (a *fakeTxActivity) AddOutput(p executable.Path) error                      { return nil }
+func (a *fakeTxActivity) IsValid() bool                                          { return true }
+func (a *fakeTxActivity) Type() string                                           { return "fake" }
+func (a *fakeTxActivity) Name() string                                           { return a.name }
+func (a *fakeTxActivity) Boundary() string                                       { return "" }
+func (a *fakeTxActivity) IsEnabled(wfc *wfcase.WfCase) bool                      { return true }
+
+func newTransactionTestOrchestration(sessions map[string]*fakeMongoSession, activities ...*fakeTxActivity) *Orchestration {
+	o := &Orchestration{
+		Cfg: &config.Orchestration{
+			Transactions: []config.TransactionScope{
+				{Name: "tx-1", Activities: []string{"a1", "a2"}},
+				{Name: "tx-2", Activities: []string{"b1"}},
+			},
+		},
+		Executables: make(map[string]executable.Executable),
+	}
+
+	for _, a := range activities {
+		a.sessions = sessions
+		o.Executables[a.name] = a
+	}
+
+	return o
+}
+
+func TestTransactionScopes(t *testing.T) {
+	sessions := make(map[string]*fakeMongoSession)
+	o := newTransactionTestOrchestration(sessions, &fakeTxActivity{name: "a1", scope: "tx-1"}, &fakeTxActivity{name: "a2", scope: "tx-1"})
+
+	wfc, err := wfcase.NewWorkflowCase("tx-test", "", "", "", nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	// the transaction is kept open while the next activity is in the scope and committed at the exit.
+	require.NoError(t, o.Executables["a1"].Execute(wfc))
+	require.NoError(t, o.endTransactionScope(wfc, "a1", "a2"))
+	require.False(t, sessions["tx-1"].committed)
+
+	require.NoError(t, o.Executables["a2"].Execute(wfc))
+	require.NoError(t, o.endTransactionScope(wfc, "a2", "end"))
+	require.True(t, sessions["tx-1"].committed)
+	require.True(t, sessions["tx-1"].ended)
+	_, ok := wfc.GetMongoTransaction("tx-1")
+	require.False(t, ok)
+
+	// a transaction with a failed operation is aborted at the exit of the scope.
+	o.Executables["a1"].(*fakeTxActivity).failOp = true
+	require.NoError(t, o.Executables["a1"].Execute(wfc))
+	require.Error(t, o.endTransactionScope(wfc, "a1", "end"))
+	require.False(t, sessions["tx-1"].committed)
+	require.True(t, sessions["tx-1"].aborted)
+
+	// the open transactions are aborted when the orchestration errors.
+	o.Executables["a1"].(*fakeTxActivity).failOp = false
+	require.NoError(t, o.Executables["a1"].Execute(wfc))
+	wfc.AbortMongoTransactions()
+	require.True(t, sessions["tx-1"].aborted)
+	require.True(t, sessions["tx-1"].ended)
+}
+
+func TestExecuteBoundaryTransactions(t *testing.T) {
+	sessions := make(map[string]*fakeMongoSession)
+	o := newTransactionTestOrchestration(sessions,
+		&fakeTxActivity{name: "a1", scope: "tx-1"},
+		&fakeTxActivity{name: "b1", scope: "tx-2"},
+		&fakeTxActivity{name: "c1", err: errors.New("c1 failed")},
+	)
+
+	wfc, err := wfcase.NewWorkflowCase("tx-test", "", "", "", nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	// the transaction of tx-2 belongs to the execution of another part of the case and it is not ended by the boundary.
+	require.NoError(t, o.Executables["b1"].Execute(wfc))
+
+	require.NoError(t, o.ExecuteBoundary(wfc, config.ExecBoundary{Name: "on-exit", Activities: []string{"a1"}}))
+	require.True(t, sessions["tx-1"].committed)
+	require.False(t, sessions["tx-2"].committed)
+	require.False(t, sessions["tx-2"].aborted)
+	_, ok := wfc.GetMongoTransaction("tx-2")
+	require.True(t, ok)
+
+	// an error in the boundary aborts the open transactions.
+	require.Error(t, o.ExecuteBoundary(wfc, config.ExecBoundary{Name: "on-exit", Activities: []string{"a1", "c1"}}))
+	require.False(t, sessions["tx-1"].committed)
+	require.True(t, sessions["tx-1"].aborted)
+	require.True(t, sessions["tx-2"].aborted)
+}
@@ -3,14 +3,17 @@ package orchestration
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
 
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable"
-	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/cacheactivity"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/decisiontableactivity"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/echoactivity"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/endpointactivity"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/factory"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/jsonschemaactivity"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/kafkaconsumeactivity"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/kafkactivity"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/mongoactivity"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/nopactivity"
@@ -19,12 +22,17 @@ import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/scriptactivity"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/executable/transformactivity"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
 	"github.com/rs/zerolog/log"
 )
 
 type Orchestration struct {
 	Cfg         *config.Orchestration
 	Executables map[string]executable.Executable
+
+	// replay is the cassette of the har-replay-file property, loaded once. Each case replays a rewound copy of it.
+	replay *wfcase.HarCassette
 }
 
 func NewOrchestration(cfg *config.Orchestration) (Orchestration, error) {
@@ -64,6 +72,8 @@ func NewOrchestration(cfg *config.Orchestration) (Orchestration, error) {
 			ex, err = endpointactivity.NewEndpointActivity(cfgItem, cfg.References)
 		case config.KafkaActivityType:
 			ex, err = kafkactivity.NewKafkaActivity(cfgItem, cfg.References)
+		case config.KafkaConsumeActivityType:
+			ex, err = kafkaconsumeactivity.NewKafkaConsumeActivity(cfgItem, cfg.References)
 		case config.NestedOrchestrationActivityType:
 			ex, err = NewNestedOrchestrationActivity(cfgItem, cfg.References, mapOfNestedOrcs)
 		case config.MongoActivityType:
@@ -77,7 +87,9 @@ func NewOrchestration(cfg *config.Orchestration) (Orchestration, error) {
 		case config.LoopActivityType:
 			ex, err = NewLoopActivity(cfgItem, cfg.References, mapOfNestedOrcs)
 		case config.CacheActivityType:
-			ex, err = cacheactivity.NewCacheActivity(cfgItem, cfg.References)
+			ex, err = newCacheActivity(cfgItem, cfg.References, mapOfNestedOrcs)
+		case config.DecisionTableActivityType:
+			ex, err = decisiontableactivity.NewDecisionTableActivity(cfgItem, cfg.References)
 		default:
 			factory, ok := factory.GetRegisteredActivityFactory(cfgItem.Type())
 			if !ok {
@@ -124,6 +136,30 @@ func NewOrchestration(cfg *config.Orchestration) (Orchestration, error) {
 		ex.AddInput(p)
 	}
 
+	err := compileExpressions(cfg)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return o, err
+	}
+
+	err = validateProcessVars(cfg)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return o, err
+	}
+
+	err = o.loadHarReplayFile()
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return o, err
+	}
+
+	err = o.bindTransactionScopes()
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return o, err
+	}
+
 	if !o.IsValid() {
 		return o, fmt.Errorf("the configured orchestration is invalid")
 	}
@@ -131,6 +167,97 @@ func NewOrchestration(cfg *config.Orchestration) (Orchestration, error) {
 	return o, nil
 }
 
+// compileExpressions fills the expression cache with the path constraints and the expressions of the activities so that syntax errors, and type errors of
+// the cel ones, fail at load. Nested orchestrations compile their own.
+func compileExpressions(cfg *config.Orchestration) error {
+	lang := cfg.GetPropertyAsString(config.OrchestrationPropertyExpressionLanguage, wfexpressions.ExpressionLanguageGval)
+	if lang != wfexpressions.ExpressionLanguageGval && lang != wfexpressions.ExpressionLanguageCel {
+		return fmt.Errorf("unsupported expression language %s", lang)
+	}
+
+	for _, p := range cfg.Paths {
+		if p.Constraint == "" {
+			continue
+		}
+
+		if _, err := wfexpressions.CompileExpressionWithLanguage(lang, p.Constraint); err != nil {
+			return fmt.Errorf("invalid constraint %s of path %s -> %s: %w", p.Constraint, p.SourceName, p.TargetName, err)
+		}
+	}
+
+	for _, a := range cfg.Activities {
+		ea, ok := a.(interface{ Expressions() []string })
+		if !ok {
+			continue
+		}
+
+		for _, expr := range ea.Expressions() {
+			if _, err := wfexpressions.CompileExpressionWithLanguage(lang, expr); err != nil {
+				return fmt.Errorf("invalid expression %s of activity %s: %w", expr, a.Name(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateProcessVars checks the declared types of the process vars of the activities so that misconfigured types fail at load.
+func validateProcessVars(cfg *config.Orchestration) error {
+	for _, a := range cfg.Activities {
+		va, ok := a.(interface{ ValidateProcessVars() error })
+		if !ok {
+			continue
+		}
+
+		if err := va.ValidateProcessVars(); err != nil {
+			return fmt.Errorf("activity %s: %w", a.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (o *Orchestration) bindTransactionScopes() error {
+
+	bound := make(map[string]string)
+	for _, ts := range o.Cfg.Transactions {
+		if ts.Name == "" || len(ts.Activities) == 0 {
+			return fmt.Errorf("transaction scope %s: name and activities are mandatory", ts.Name)
+		}
+
+		lksName := ""
+		for _, n := range ts.Activities {
+			if other, ok := bound[n]; ok {
+				return fmt.Errorf("activity %s belongs to transaction scopes %s and %s", n, other, ts.Name)
+			}
+			bound[n] = ts.Name
+
+			ex, ok := o.Executables[n]
+			if !ok {
+				return fmt.Errorf("transaction scope %s: could not find activity %s", ts.Name, n)
+			}
+
+			ma, ok := ex.(*mongoactivity.MongoActivity)
+			if !ok {
+				return fmt.Errorf("transaction scope %s: activity %s is not a %s", ts.Name, n, config.MongoActivityType)
+			}
+
+			if lksName == "" {
+				lksName = ma.LksName()
+			} else if lksName != ma.LksName() {
+				return fmt.Errorf("transaction scope %s: activities must share the same linked service (%s, %s)", ts.Name, lksName, ma.LksName())
+			}
+
+			err := ma.SetTransactionScope(ts.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func (o *Orchestration) IsValid() bool {
 	const semLogContext = "orchestration::is-valid"
 
@@ -173,15 +300,26 @@ func (o *Orchestration) Execute(wfc *wfcase.WfCase) (executable.Executable, erro
 	const semLogContext = "orchestration::execute"
 
 	pathSelectionPolicy := o.Cfg.GetPropertyAsString(config.OrchestrationPropertyPathSelectionPolicy, config.ExactlyOne)
+	strictMode := o.Cfg.GetPropertyAsBool(config.OrchestrationPropertyStrictMode, false)
+	wfc.Vars.WithExpressionLanguage(o.Cfg.GetPropertyAsString(config.OrchestrationPropertyExpressionLanguage, wfexpressions.ExpressionLanguageGval))
 	log.Info().Str("id", o.Cfg.Id).Str("path-selection-policy", pathSelectionPolicy).Msg(semLogContext + " start")
 	defer log.Info().Str("id", o.Cfg.Id).Msg(semLogContext + " end")
 
+	// Open transactions are aborted if the execution does not get to the end of their scope.
+	defer wfc.AbortMongoTransactions()
+
+	o.setupHarFixtures(wfc)
+
+	if folder := o.Cfg.GetPropertyAsString(config.OrchestrationPropertyHarRecordFolder, ""); folder != "" {
+		// failed executions are recorded as well to be reproduced.
+		defer func() { _ = wfc.WriteHarFixture(filepath.Join(folder, wfc.Id+".har")) }()
+	}
+
+	var err error
 	na := o.Cfg.StartActivity
 	var a executable.Executable
 	currentBoundary := config.DefaultActivityBoundary
 	for na != "" {
-		var err error
-
 		a = o.Executables[na]
 		if a.Boundary() != currentBoundary {
 			log.Info().Str("current-boundary", currentBoundary).Str("next-boundary", a.Boundary()).Msg(semLogContext + " boundary limit")
@@ -196,6 +334,13 @@ func (o *Orchestration) Execute(wfc *wfcase.WfCase) (executable.Executable, erro
 					}
 				}*/
 
+		err = wfc.CancellationErr()
+		if err != nil {
+			log.Warn().Err(err).Str("id", o.Cfg.Id).Str("activity", na).Msg(semLogContext + " case cancelled")
+			return a, smperror.NewExecutableServerError(smperror.WithErrorAmbit(o.Cfg.Id), smperror.WithStep(na), smperror.WithErrorMessage(err.Error()))
+		}
+
+		wfc.StrictMode = wfexpressions.StrictMode{Enabled: strictMode || o.isStrictActivity(na), Activity: na}
 		err = a.Execute(wfc)
 		if err != nil {
 			return a, err
@@ -205,19 +350,80 @@ func (o *Orchestration) Execute(wfc *wfcase.WfCase) (executable.Executable, erro
 		if err != nil {
 			return a, err
 		}
+
+		err = o.endTransactionScope(wfc, a.Name(), na)
+		if err != nil {
+			return a, err
+		}
 	}
 
 	return a, nil
 }
 
+// loadHarReplayFile reads the har of the har-replay-file property, if any.
+func (o *Orchestration) loadHarReplayFile() error {
+	const semLogContext = "orchestration::load-har-replay-file"
+
+	fn := o.Cfg.GetPropertyAsString(config.OrchestrationPropertyHarReplayFile, "")
+	if fn == "" {
+		return nil
+	}
+
+	cassette, err := wfcase.NewHarCassetteFromFile(fn)
+	if err != nil {
+		log.Error().Err(err).Str("file", fn).Msg(semLogContext)
+		return err
+	}
+
+	o.replay = cassette
+	log.Info().Str("file", fn).Msg(semLogContext)
+	return nil
+}
+
+// setupHarFixtures sets the cassette of the har-replay-file property on the case unless the case is already replaying (i.e. the child case of
+// a nested orchestration gets the entries of the har of the parent).
+func (o *Orchestration) setupHarFixtures(wfc *wfcase.WfCase) {
+	if o.replay == nil || wfc.IsReplaying() {
+		return
+	}
+
+	wfc.Replay = o.replay.Rewind()
+}
+
+func (o *Orchestration) isStrictActivity(n string) bool {
+	if sa, ok := o.Cfg.FindActivityByName(n).(interface{ IsStrictMode() bool }); ok {
+		return sa.IsStrictMode()
+	}
+
+	return false
+}
+
+// endTransactionScope commits the transaction of the scope of the current activity when the next one is outside of it.
+func (o *Orchestration) endTransactionScope(wfc *wfcase.WfCase, currentActivity, nextActivity string) error {
+	ts, ok := o.Cfg.FindTransactionScopeByActivityName(currentActivity)
+	if !ok || ts.Contains(nextActivity) {
+		return nil
+	}
+
+	err := wfc.EndMongoTransaction(ts.Name, true)
+	if err != nil {
+		return smperror.NewExecutableServerError(smperror.WithErrorAmbit(ts.Name), smperror.WithStep(currentActivity), smperror.WithErrorMessage(err.Error()))
+	}
+
+	return nil
+}
+
 func (o *Orchestration) ExecuteBoundary(wfc *wfcase.WfCase, boundary config.ExecBoundary) error {
 
 	const semLogContext = "orchestration::execute-boundary"
 
 	log.Trace().Str("boundary", boundary.Name).Msg(semLogContext)
 	withError := false
+	strictMode := o.Cfg.GetPropertyAsBool(config.OrchestrationPropertyStrictMode, false)
+	wfc.Vars.WithExpressionLanguage(o.Cfg.GetPropertyAsString(config.OrchestrationPropertyExpressionLanguage, wfexpressions.ExpressionLanguageGval))
 	for _, na := range boundary.Activities {
 		a := o.Executables[na]
+		wfc.StrictMode = wfexpressions.StrictMode{Enabled: strictMode || o.isStrictActivity(na), Activity: na}
 		err := a.Execute(wfc)
 		if err != nil {
 			withError = true
@@ -226,11 +432,36 @@ func (o *Orchestration) ExecuteBoundary(wfc *wfcase.WfCase, boundary config.Exec
 	}
 
 	if withError {
+		wfc.AbortMongoTransactions()
 		return errors.New("boundary errors")
 	}
+
+	for _, scope := range o.boundaryTransactionScopes(boundary) {
+		err := wfc.EndMongoTransaction(scope, true)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext)
+			wfc.AbortMongoTransactions()
+			return err
+		}
+	}
+
 	return nil
 }
 
+// boundaryTransactionScopes returns the transaction scopes of the activities of the boundary. The scopes whose transaction has not been started
+// by any activity are ended as a no-op.
+func (o *Orchestration) boundaryTransactionScopes(boundary config.ExecBoundary) []string {
+	var scopes []string
+	for _, na := range boundary.Activities {
+		ts, ok := o.Cfg.FindTransactionScopeByActivityName(na)
+		if ok && !slices.Contains(scopes, ts.Name) {
+			scopes = append(scopes, ts.Name)
+		}
+	}
+
+	return scopes
+}
+
 func (o *Orchestration) ShowInfo() {
 	const semLogContext = "orchestration::show"
 	log.Info().Str("id", o.Cfg.Id).Msg(semLogContext)
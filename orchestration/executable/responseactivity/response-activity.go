@@ -16,9 +16,9 @@ import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
 	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
+	"strings"
 	"time"
 
-	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/rs/zerolog/log"
 )
 
@@ -30,6 +30,11 @@ const (
 	MetricIdCacheMiss    = "cache-miss"
 )
 
+const (
+	ContentTypeApplicationOctetStream = "application/octet-stream"
+	ContentDispositionHeader          = "Content-Disposition"
+)
+
 type templateData struct {
 	simpleResponse      []byte
 	onCacheMissResponse []byte
@@ -58,6 +63,12 @@ func NewResponseActivity(item config.Configurable, refs config.DataReferences) (
 	for _, cfgResp := range tcfg.Responses {
 		t := templateData{}
 
+		if cfgResp.Passthrough != "" && (cfgResp.RefSimpleResponse != "" || cfgResp.Cache.Mode != "") {
+			err := errors.New("passthrough response cannot have a simple response or a cache configuration")
+			log.Error().Str("response-id", cfgResp.Id).Err(err).Msg(semLogContext)
+			return nil, err
+		}
+
 		if cfgResp.RefSimpleResponse != "" {
 			t.simpleResponse, _ = refs.Find(cfgResp.RefSimpleResponse)
 			if len(t.simpleResponse) == 0 {
@@ -192,6 +203,17 @@ func (a *ResponseActivity) ResponseJSON(wfc *wfcase.WfCase) (*har.Response, erro
 		return nil, err
 	}
 
+	if r.Passthrough != "" {
+		resp, err := a.passthroughResponse(wfc, &r, hs)
+		if err != nil {
+			log.Error().Err(err).Str("passthrough", r.Passthrough).Msg(semLogContext)
+			return nil, err
+		}
+
+		metricsLabels[MetricIdStatusCode] = fmt.Sprint(resp.Status)
+		return resp, nil
+	}
+
 	var body []byte
 
 	body, respType, err := a.handleResponseCache(&r, resolver, r.Cache)
@@ -273,6 +295,48 @@ func (a *ResponseActivity) ResponseJSON(wfc *wfcase.WfCase) (*har.Response, erro
 	return resp, nil
 }
 
+// passthroughResponse returns the body of a previous response without re-encoding it. The Content-Disposition of the downstream response is kept while a
+// Content-Type among the configured headers overrides the original one.
+func (a *ResponseActivity) passthroughResponse(wfc *wfcase.WfCase, r *config.Response, hs []har.NameValuePair) (*har.Response, error) {
+	e, err := wfc.GetHarEntry(r.Passthrough)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Response == nil {
+		return nil, fmt.Errorf("har entry %s has no response", r.Passthrough)
+	}
+
+	statusCode := e.Response.Status
+	if r.StatusCode > 0 {
+		statusCode = r.StatusCode
+	}
+
+	mimeType := ContentTypeApplicationOctetStream
+	var body []byte
+	if e.Response.Content != nil {
+		body = e.Response.Content.Data
+		if e.Response.Content.MimeType != "" {
+			mimeType = e.Response.Content.MimeType
+		}
+	}
+
+	var headers []har.NameValuePair
+	if cd := e.Response.Headers.GetFirst(ContentDispositionHeader); cd.Value != "" && har.NameValuePairs(hs).GetFirst(ContentDispositionHeader).Value == "" {
+		headers = append(headers, har.NameValuePair{Name: ContentDispositionHeader, Value: cd.Value})
+	}
+
+	for _, h := range hs {
+		if strings.EqualFold(h.Name, constants.ContentTypeHeader) {
+			mimeType = h.Value
+			continue
+		}
+		headers = append(headers, h)
+	}
+
+	return har.NewResponse(statusCode, http.StatusText(statusCode), mimeType, body, headers), nil
+}
+
 const (
 	ResponseNotCached = 0
 	ResponseCached    = 1
@@ -332,7 +396,7 @@ func (a *ResponseActivity) selectResponse(cfg *config.ResponseActivity, wfc *wfc
 
 func (a *ResponseActivity) computeBody(wfc *wfcase.WfCase, bodyTemplate []byte, resolver *wfexpressions.Evaluator) ([]byte, error) {
 
-	s, _, err := varResolver.ResolveVariables(string(bodyTemplate), varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	s, err := resolver.Interpolate(string(bodyTemplate))
 	if err != nil {
 		return nil, err
 	}
@@ -351,7 +415,7 @@ func (a *ResponseActivity) computeHeaders(headers []config.NameValuePair, resolv
 	var resolvedHeaders []har.NameValuePair
 	if len(headers) > 0 {
 		for _, h := range headers {
-			r, _, err := varResolver.ResolveVariables(h.Value, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+			r, err := resolver.Interpolate(h.Value)
 			if err != nil {
 				return nil, err
 			}
@@ -368,7 +432,7 @@ func (a *ResponseActivity) getCachedResponse(resolver *wfexpressions.Evaluator,
 	const semLogContext = string(config.ResponseActivityType) + "::get-cached-response"
 
 	var err error
-	cacheKey, _, err = varResolver.ResolveVariables(cacheKey, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	cacheKey, err = resolver.Interpolate(cacheKey)
 	if err != nil {
 		return nil, err
 	}
@@ -404,7 +468,7 @@ func (a *ResponseActivity) setCachedResponse(resolver *wfexpressions.Evaluator,
 	const semLogContext = string(config.ResponseActivityType) + "::set-cached-response"
 
 	var err error
-	cacheKey, _, err = varResolver.ResolveVariables(cacheKey, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	cacheKey, err = resolver.Interpolate(cacheKey)
 	if err != nil {
 		return err
 	}
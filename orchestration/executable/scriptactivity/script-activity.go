@@ -1,6 +1,7 @@
 package scriptactivity
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -15,47 +16,20 @@ import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
-	tengo "github.com/d5/tengo/v2"
-	"github.com/d5/tengo/v2/stdlib"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 )
 
-const (
-	TengoTypePrefixBuiltinFunction  = "builtin-function"
-	TengoTypePrefixCompiledFunction = "compiled-function"
-	TengoTypePrefixImmutableArray   = "immutable-array"
-	TengoTypePrefixImmutableMap     = "immutable-map"
-	TengoTypePrefixFreeVar          = "free-var"
-	TengoTypePrefixUndefined        = "undefined"
-	TengoTypePrefixUserFunction     = "user-function"
-)
-
-var TengoMetaTypes = map[string]struct{}{
-	TengoTypePrefixBuiltinFunction:  struct{}{},
-	TengoTypePrefixCompiledFunction: struct{}{},
-	TengoTypePrefixImmutableArray:   struct{}{},
-	TengoTypePrefixImmutableMap:     struct{}{},
-	TengoTypePrefixFreeVar:          struct{}{},
-	TengoTypePrefixUserFunction:     struct{}{},
-}
-
-func isValueTypeSupportedType(t string) bool {
-	ndx := strings.Index(t, ":")
-	if ndx >= 0 {
-		t = t[:ndx]
-	}
-
-	if _, ok := TengoMetaTypes[t]; ok {
-		return false
-	}
-
-	return true
-}
-
 type ScriptActivity struct {
 	executable.Activity
 	definition config.ScriptActivityDefinition
+	engine     ScriptEngine
+
+	// compiled is the script compiled at load time, nil if the script text is a template to be evaluated on each execution.
+	compiled CompiledScript
+
+	// templates caches the scripts compiled from the evaluated text of a template.
+	templates *compiledScriptCache
 }
 
 func NewScriptActivity(item config.Configurable, refs config.DataReferences) (*ScriptActivity, error) {
@@ -76,9 +50,37 @@ func NewScriptActivity(item config.Configurable, refs config.DataReferences) (*S
 		return nil, err
 	}
 
+	ea.engine, err = GetScriptEngine(ea.definition.GetEngine())
+	if err != nil {
+		return nil, err
+	}
+
+	if !isTemplate(ea.definition.ScriptText) {
+		ea.compiled, err = ea.engine.Compile(ea.definition, ea.definition.ScriptText)
+		if err != nil {
+			err = fmt.Errorf("cannot compile script %s of activity %s: %w", ea.definition.Script, item.Name(), err)
+			return nil, err
+		}
+	} else {
+		ea.templates = newCompiledScriptCache()
+		if ea.definition.GetEngine() == config.ScriptEngineTengo {
+			// the modules imported by a template are checked anyway, the ones of the evaluated text get resolved again on compilation.
+			_, err = newTengoModuleMap(ea.definition, ea.definition.ScriptText)
+			if err != nil {
+				err = fmt.Errorf("cannot resolve modules of script %s of activity %s: %w", ea.definition.Script, item.Name(), err)
+				return nil, err
+			}
+		}
+	}
+
 	return ea, nil
 }
 
+// isTemplate reports whether the script text contains template actions.
+func isTemplate(text []byte) bool {
+	return bytes.Contains(text, []byte("{{"))
+}
+
 func (a *ScriptActivity) Execute(wfc *wfcase.WfCase) error {
 	const semLogContext = string(config.ScriptActivityType) + "::execute"
 	var err error
@@ -156,7 +158,7 @@ func (a *ScriptActivity) Execute(wfc *wfcase.WfCase) error {
 }
 
 func (a *ScriptActivity) executeScript(wfc *wfcase.WfCase, err error, semLogContext string) (int, *wfexpressions.ProcessVars) {
-	script, bdy, err := a.computeScript(wfc)
+	script, inputs, bdy, err := a.computeScript(wfc)
 	if err != nil {
 		log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
 		return http.StatusInternalServerError, nil
@@ -165,7 +167,14 @@ func (a *ScriptActivity) executeScript(wfc *wfcase.WfCase, err error, semLogCont
 	req, _ := a.newRequestDefinition([]byte(bdy))
 	_ = wfc.SetHarEntryRequest(a.Name(), req, config.PersonallyIdentifiableInformation{})
 
-	compiled, err := script.RunContext(context.Background())
+	ctx := context.Background()
+	if a.definition.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.definition.Timeout)
+		defer cancel()
+	}
+
+	out, err := script.Run(ctx, inputs)
 	if err != nil {
 		log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
 		resp := har.NewResponse(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), constants.ContentTypeTextPlain, []byte(err.Error()), nil)
@@ -173,15 +182,9 @@ func (a *ScriptActivity) executeScript(wfc *wfcase.WfCase, err error, semLogCont
 		return http.StatusInternalServerError, nil
 	}
 
-	scriptTengoOutVars := compiled.GetAll()
-	var scriptOutVars *wfexpressions.ProcessVars
-	if len(scriptTengoOutVars) > 0 {
-		scriptOutVars = wfexpressions.NewProcessVars()
-		for _, v := range scriptTengoOutVars {
-			if isValueTypeSupportedType(v.ValueType()) {
-				scriptOutVars.V[v.Name()] = v.Value()
-			}
-		}
+	scriptOutVars := wfexpressions.NewProcessVars()
+	for n, v := range out {
+		scriptOutVars.V[n] = v
 	}
 
 	resp, _ := a.newResponseDefinition(scriptOutVars)
@@ -230,38 +233,59 @@ func (a *ScriptActivity) newRequestDefinition(body []byte) (*har.Request, error)
 	return &req, nil
 }
 
-func (a *ScriptActivity) computeScript(wfc *wfcase.WfCase) (*tengo.Script, string, error) {
+// computeScript returns the script compiled at load time or, for templates, the script compiled from the evaluated text together with its inputs: the params,
+// the process vars and the body of the expression context.
+func (a *ScriptActivity) computeScript(wfc *wfcase.WfCase) (CompiledScript, map[string]interface{}, string, error) {
 	const semLogContext = "script-activity::compute-body"
 
 	evaluator, err := a.GetEvaluator(wfc)
 	if err != nil {
 		log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
-		return nil, "", err
+		return nil, nil, "", err
 	}
 
-	text, err := evaluator.EvaluateTemplate(string(a.definition.ScriptText), wfc.TemplateFunctions())
-	if err != nil {
-		log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
-		return nil, "", err
+	script := a.compiled
+	text := a.definition.ScriptText
+	if script == nil {
+		text, err = evaluator.EvaluateTemplate(string(a.definition.ScriptText), wfc.TemplateFunctions())
+		if err != nil {
+			log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
+			return nil, nil, "", err
+		}
+
+		script, err = a.templates.compile(a.engine, a.definition, text)
+		if err != nil {
+			log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
+			return nil, nil, "", err
+		}
+	}
+
+	inputs := map[string]interface{}{
+		ScriptInputVars: copyScriptInput(wfc.Vars.V),
+	}
+
+	b, _ := evaluator.BodyAsByteArray()
+	if len(b) > 0 {
+		var body interface{}
+		if json.Unmarshal(b, &body) == nil {
+			inputs[ScriptInputBody] = body
+		} else {
+			inputs[ScriptInputBody] = string(b)
+		}
 	}
 
 	var sb strings.Builder
 	sb.WriteString("\n================= Script Text: \n")
 	sb.WriteString(string(text))
 
-	script := tengo.NewScript(text)
-	if len(a.definition.StdLibModules) > 0 {
-		script.SetImports(stdlib.GetModuleMap(a.definition.StdLibModules...))
-	}
-
 	var paramsMap map[string]interface{}
 	for _, p := range a.definition.Params {
 		paramVal, err := evaluator.InterpolateAndEval(p.Value)
 		if err != nil {
 			log.Error().Err(err).Str(constants.SemLogActivity, a.Name()).Msg(semLogContext)
-			return nil, "", err
+			return nil, nil, "", err
 		}
-		_ = script.Add(p.Name, paramVal)
+		inputs[p.Name] = paramVal
 		if paramsMap == nil {
 			paramsMap = make(map[string]interface{})
 		}
@@ -271,14 +295,14 @@ func (a *ScriptActivity) computeScript(wfc *wfcase.WfCase) (*tengo.Script, strin
 	if len(paramsMap) > 0 {
 		b, err := json.Marshal(paramsMap)
 		if err != nil {
-			return nil, "", err
+			return nil, nil, "", err
 		}
 
 		sb.WriteString("\n================= Script Params: \n")
 		sb.WriteString(string(b))
 	}
 
-	return script, sb.String(), nil
+	return script, inputs, sb.String(), nil
 }
 
 func (a *ScriptActivity) processResponseActions(
@@ -318,7 +342,7 @@ func (a *ScriptActivity) processResponseActions(
 					return 500, smperror.NewExecutableError(smperror.WithErrorStatusCode(500), smperror.WithErrorAmbit(ambitName), smperror.WithStep(stepName), smperror.WithCode("500"), smperror.WithErrorMessage("error selecting transformation"), smperror.WithDescription(err.Error()))
 				}
 
-				err = wfc.Vars.Set(v.Name, val, v.GlobalScope, v.Ttl, v.DltHeader)
+				err = wfc.Vars.DeclareAndSet(v.Name, v, val)
 				if err != nil {
 					log.Error().Err(err).Msg(semLogContext)
 					return 500, smperror.NewExecutableError(smperror.WithErrorStatusCode(500), smperror.WithErrorAmbit(ambitName), smperror.WithStep(stepName), smperror.WithCode("500"), smperror.WithErrorMessage("error selecting transformation"), smperror.WithDescription(err.Error()))
@@ -0,0 +1,76 @@
+package scriptactivity
+
+import (
+	"context"
+	"errors"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/dop251/goja"
+)
+
+// javascriptEngine runs ECMAScript 5.1 (with most of ES6) scripts in strict mode. The runtime has no require, console or host objects and std-lib modules
+// are not supported.
+type javascriptEngine struct{}
+
+func (javascriptEngine) Compile(def config.ScriptActivityDefinition, text []byte) (CompiledScript, error) {
+	if len(def.StdLibModules) > 0 {
+		return nil, errors.New("javascript scripts do not support std-lib modules")
+	}
+
+	prog, err := goja.Compile(def.Script, string(text), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &javascriptScript{program: prog, maxCallStackSize: def.MaxCallStackSize}, nil
+}
+
+type javascriptScript struct {
+	program          *goja.Program
+	maxCallStackSize int
+}
+
+// Run executes the program in a new runtime that gets interrupted when the context is done. The returned vars are the ones declared with var at top level.
+func (s *javascriptScript) Run(ctx context.Context, inputs map[string]interface{}) (map[string]interface{}, error) {
+	vm := goja.New()
+	if s.maxCallStackSize > 0 {
+		vm.SetMaxCallStackSize(s.maxCallStackSize)
+	}
+
+	for n, v := range inputs {
+		if err := vm.Set(n, v); err != nil {
+			return nil, err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			vm.Interrupt(ctx.Err())
+		case <-done:
+		}
+	}()
+
+	_, err := vm.RunProgram(s.program)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]interface{})
+	global := vm.GlobalObject()
+	for _, k := range global.Keys() {
+		if isScriptInput(k) {
+			continue
+		}
+
+		v := global.Get(k)
+		if _, isFunction := goja.AssertFunction(v); isFunction {
+			continue
+		}
+		out[k] = v.Export()
+	}
+
+	return out, nil
+}
@@ -0,0 +1,199 @@
+package scriptactivity
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	starlarkjson "go.starlark.net/lib/json"
+	starlarkmath "go.starlark.net/lib/math"
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// starlarkModules are the modules that can be listed in the std-lib of a starlark script. Starlark has no access to files, network or environment.
+var starlarkModules = map[string]starlark.Value{
+	"json": starlarkjson.Module,
+	"math": starlarkmath.Module,
+	"time": starlarktime.Module,
+}
+
+type starlarkEngine struct{}
+
+func (starlarkEngine) Compile(def config.ScriptActivityDefinition, text []byte) (CompiledScript, error) {
+	predeclared := map[string]struct{}{ScriptInputVars: {}, ScriptInputBody: {}}
+	for _, p := range def.Params {
+		predeclared[p.Name] = struct{}{}
+	}
+
+	modules := make(starlark.StringDict)
+	for _, m := range def.StdLibModules {
+		v, ok := starlarkModules[m]
+		if !ok {
+			return nil, fmt.Errorf("unsupported starlark module %s", m)
+		}
+		modules[m] = v
+		predeclared[m] = struct{}{}
+	}
+
+	opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true}
+	_, prog, err := starlark.SourceProgramOptions(opts, def.Script, text, func(name string) bool {
+		_, ok := predeclared[name]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &starlarkScript{name: def.Script, program: prog, modules: modules, maxSteps: def.MaxSteps}, nil
+}
+
+type starlarkScript struct {
+	name     string
+	program  *starlark.Program
+	modules  starlark.StringDict
+	maxSteps uint64
+}
+
+// Run initializes the program in a new thread that gets cancelled when the context is done.
+func (s *starlarkScript) Run(ctx context.Context, inputs map[string]interface{}) (map[string]interface{}, error) {
+	predeclared := make(starlark.StringDict, len(s.modules)+len(inputs))
+	for n, m := range s.modules {
+		predeclared[n] = m
+	}
+
+	for n, v := range inputs {
+		sv, err := toStarlarkValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %w", n, err)
+		}
+		predeclared[n] = sv
+	}
+
+	thread := &starlark.Thread{Name: s.name}
+	if s.maxSteps > 0 {
+		thread.SetMaxExecutionSteps(s.maxSteps)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	globals, err := s.program.Init(thread, predeclared)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]interface{})
+	for n, v := range globals {
+		if gv, ok := fromStarlarkValue(v); ok {
+			out[n] = gv
+		}
+	}
+
+	return out, nil
+}
+
+func toStarlarkValue(v interface{}) (starlark.Value, error) {
+	switch tv := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(tv), nil
+	case int:
+		return starlark.MakeInt(tv), nil
+	case int32:
+		return starlark.MakeInt64(int64(tv)), nil
+	case int64:
+		return starlark.MakeInt64(tv), nil
+	case float64:
+		return starlark.Float(tv), nil
+	case string:
+		return starlark.String(tv), nil
+	case []interface{}:
+		elems := make([]starlark.Value, 0, len(tv))
+		for _, e := range tv {
+			sv, err := toStarlarkValue(e)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, sv)
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		d := starlark.NewDict(len(tv))
+		for k, e := range tv {
+			sv, err := toStarlarkValue(e)
+			if err != nil {
+				return nil, err
+			}
+			_ = d.SetKey(starlark.String(k), sv)
+		}
+		return d, nil
+	}
+
+	return nil, fmt.Errorf("unsupported starlark value of type %T", v)
+}
+
+// fromStarlarkValue converts the plain values, functions and modules are not.
+func fromStarlarkValue(v starlark.Value) (interface{}, bool) {
+	switch tv := v.(type) {
+	case starlark.NoneType:
+		return nil, true
+	case starlark.Bool:
+		return bool(tv), true
+	case starlark.Int:
+		if i, ok := tv.Int64(); ok {
+			return i, true
+		}
+		return tv.String(), true
+	case starlark.Float:
+		return float64(tv), true
+	case starlark.String:
+		return string(tv), true
+	case *starlark.List:
+		a := make([]interface{}, 0, tv.Len())
+		for i := 0; i < tv.Len(); i++ {
+			e, ok := fromStarlarkValue(tv.Index(i))
+			if !ok {
+				return nil, false
+			}
+			a = append(a, e)
+		}
+		return a, true
+	case starlark.Tuple:
+		a := make([]interface{}, 0, len(tv))
+		for _, te := range tv {
+			e, ok := fromStarlarkValue(te)
+			if !ok {
+				return nil, false
+			}
+			a = append(a, e)
+		}
+		return a, true
+	case *starlark.Dict:
+		m := make(map[string]interface{}, tv.Len())
+		for _, item := range tv.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, false
+			}
+
+			e, ok := fromStarlarkValue(item[1])
+			if !ok {
+				return nil, false
+			}
+			m[string(k)] = e
+		}
+		return m, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,110 @@
+package scriptactivity
+
+import (
+	"context"
+	"strings"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	tengo "github.com/d5/tengo/v2"
+)
+
+const (
+	TengoTypePrefixBuiltinFunction  = "builtin-function"
+	TengoTypePrefixCompiledFunction = "compiled-function"
+	TengoTypePrefixImmutableArray   = "immutable-array"
+	TengoTypePrefixImmutableMap     = "immutable-map"
+	TengoTypePrefixFreeVar          = "free-var"
+	TengoTypePrefixUndefined        = "undefined"
+	TengoTypePrefixUserFunction     = "user-function"
+)
+
+var TengoMetaTypes = map[string]struct{}{
+	TengoTypePrefixBuiltinFunction:  struct{}{},
+	TengoTypePrefixCompiledFunction: struct{}{},
+	TengoTypePrefixImmutableArray:   struct{}{},
+	TengoTypePrefixImmutableMap:     struct{}{},
+	TengoTypePrefixFreeVar:          struct{}{},
+	TengoTypePrefixUserFunction:     struct{}{},
+}
+
+func isValueTypeSupportedType(t string) bool {
+	ndx := strings.Index(t, ":")
+	if ndx >= 0 {
+		t = t[:ndx]
+	}
+
+	if _, ok := TengoMetaTypes[t]; ok {
+		return false
+	}
+
+	return true
+}
+
+type tengoEngine struct{}
+
+func (tengoEngine) Compile(def config.ScriptActivityDefinition, text []byte) (CompiledScript, error) {
+	script, err := newTengoScript(def, text)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := script.Compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tengoScript{compiled: compiled}, nil
+}
+
+// newTengoScript sets the std-lib and user modules and the limits of the script. The params and the inputs are declared with an undefined value to be set on the compiled script.
+func newTengoScript(def config.ScriptActivityDefinition, text []byte) (*tengo.Script, error) {
+	modules, err := newTengoModuleMap(def, text)
+	if err != nil {
+		return nil, err
+	}
+
+	script := tengo.NewScript(text)
+	script.SetImports(modules)
+
+	if def.MaxAllocs > 0 {
+		script.SetMaxAllocs(def.MaxAllocs)
+	}
+
+	if def.MaxConstObjects > 0 {
+		script.SetMaxConstObjects(def.MaxConstObjects)
+	}
+
+	for _, p := range def.Params {
+		_ = script.Add(p.Name, nil)
+	}
+	_ = script.Add(ScriptInputVars, nil)
+	_ = script.Add(ScriptInputBody, nil)
+
+	return script, nil
+}
+
+type tengoScript struct {
+	compiled *tengo.Compiled
+}
+
+// Run executes a clone of the compiled script. Inputs that tengo cannot convert are left undefined.
+func (s *tengoScript) Run(ctx context.Context, inputs map[string]interface{}) (map[string]interface{}, error) {
+	compiled := s.compiled.Clone()
+	for n, v := range inputs {
+		_ = compiled.Set(n, v)
+	}
+
+	err := compiled.RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]interface{})
+	for _, v := range compiled.GetAll() {
+		if isValueTypeSupportedType(v.ValueType()) && !isScriptInput(v.Name()) {
+			out[v.Name()] = v.Value()
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package scriptactivity
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+)
+
+const (
+	// ScriptInputVars and ScriptInputBody are the names the process vars and the body of the expression context are exposed with, together with the params,
+	// to the scripts of every engine.
+	ScriptInputVars = "_vars"
+	ScriptInputBody = "_body"
+)
+
+// ScriptEngine compiles the script text of a script activity.
+type ScriptEngine interface {
+	Compile(def config.ScriptActivityDefinition, text []byte) (CompiledScript, error)
+}
+
+// CompiledScript runs a compiled script on its own state so that it can be shared by concurrent cases. Run gets the params and the inputs and returns the
+// global variables of the script that can be converted to plain values, inputs excluded.
+type CompiledScript interface {
+	Run(ctx context.Context, inputs map[string]interface{}) (map[string]interface{}, error)
+}
+
+var scriptEngines = map[string]ScriptEngine{
+	config.ScriptEngineTengo:      tengoEngine{},
+	config.ScriptEngineStarlark:   starlarkEngine{},
+	config.ScriptEngineJavascript: javascriptEngine{},
+}
+
+func GetScriptEngine(name string) (ScriptEngine, error) {
+	e, ok := scriptEngines[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported script engine %s", name)
+	}
+
+	return e, nil
+}
+
+const compiledScriptCacheSize = 100
+
+// compiledScriptCache keeps the scripts compiled from the evaluated text of templates. The cache is cleared when full.
+type compiledScriptCache struct {
+	mu      sync.Mutex
+	scripts map[[sha256.Size]byte]CompiledScript
+}
+
+func newCompiledScriptCache() *compiledScriptCache {
+	return &compiledScriptCache{scripts: make(map[[sha256.Size]byte]CompiledScript)}
+}
+
+func (c *compiledScriptCache) compile(engine ScriptEngine, def config.ScriptActivityDefinition, text []byte) (CompiledScript, error) {
+	k := sha256.Sum256(text)
+
+	c.mu.Lock()
+	script, ok := c.scripts[k]
+	c.mu.Unlock()
+	if ok {
+		return script, nil
+	}
+
+	script, err := engine.Compile(def, text)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.scripts) >= compiledScriptCacheSize {
+		c.scripts = make(map[[sha256.Size]byte]CompiledScript)
+	}
+	c.scripts[k] = script
+
+	return script, nil
+}
+
+func isScriptInput(name string) bool {
+	return name == ScriptInputVars || name == ScriptInputBody
+}
+
+// copyScriptInput deep copies maps and arrays so that engines that wrap the values, instead of converting them, cannot modify the case.
+func copyScriptInput(v interface{}) interface{} {
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(tv))
+		for k, mv := range tv {
+			m[k] = copyScriptInput(mv)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(tv))
+		for i, av := range tv {
+			a[i] = copyScriptInput(av)
+		}
+		return a
+	}
+
+	return v
+}
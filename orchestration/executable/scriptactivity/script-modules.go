@@ -0,0 +1,119 @@
+package scriptactivity
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	tengo "github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
+)
+
+const (
+	TengoModuleFileExtension = ".tengo"
+)
+
+var tengoImportRegexp = regexp.MustCompile(`import\(\s*"([^"]+)"\s*\)`)
+
+// tengoModuleFiles caches the sources of the modules read from the shared library folders.
+var tengoModuleFiles = struct {
+	mu      sync.Mutex
+	sources map[string][]byte
+}{sources: make(map[string][]byte)}
+
+func readTengoModuleFile(folder, name string) ([]byte, error) {
+	if filepath.IsAbs(name) || strings.Contains(name, "..") {
+		return nil, fmt.Errorf("invalid module name %s", name)
+	}
+
+	fn := filepath.Join(folder, name+TengoModuleFileExtension)
+
+	tengoModuleFiles.mu.Lock()
+	defer tengoModuleFiles.mu.Unlock()
+
+	if b, ok := tengoModuleFiles.sources[fn]; ok {
+		return b, nil
+	}
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	tengoModuleFiles.sources[fn] = b
+	return b, nil
+}
+
+func isTengoStdLibModule(name string) bool {
+	_, isSource := stdlib.SourceModules[name]
+	_, isBuiltin := stdlib.BuiltinModules[name]
+	return isSource || isBuiltin
+}
+
+// newTengoModuleMap returns the std-lib modules of the definition and the user modules the script imports, directly or through other modules. Bundle modules
+// take precedence over the ones of the modules folder. Imports that cannot be resolved are left to the compiler.
+func newTengoModuleMap(def config.ScriptActivityDefinition, text []byte) (*tengo.ModuleMap, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	bundle := make(map[string][]byte)
+	for _, m := range def.Modules {
+		bundle[m.Name] = m.Source
+	}
+
+	modules := stdlib.GetModuleMap(def.StdLibModules...)
+	state := make(map[string]int)
+
+	var visit func(src []byte, path []string) error
+	visit = func(src []byte, path []string) error {
+		for _, m := range tengoImportRegexp.FindAllSubmatch(src, -1) {
+			name := string(m[1])
+			switch state[name] {
+			case visiting:
+				return fmt.Errorf("import cycle %s", strings.Join(append(path, name), " -> "))
+			case visited:
+				continue
+			}
+
+			if isTengoStdLibModule(name) {
+				continue
+			}
+
+			source, ok := bundle[name]
+			if !ok && def.ModulesFolder != "" {
+				var err error
+				source, err = readTengoModuleFile(def.ModulesFolder, name)
+				if err != nil {
+					return fmt.Errorf("cannot resolve module %s: %w", name, err)
+				}
+				ok = true
+			}
+
+			if !ok {
+				continue
+			}
+
+			state[name] = visiting
+			if err := visit(source, append(path, name)); err != nil {
+				return err
+			}
+			state[name] = visited
+			modules.AddSourceModule(name, source)
+		}
+
+		return nil
+	}
+
+	err := visit(text, []string{def.Script})
+	if err != nil {
+		return nil, err
+	}
+
+	return modules, nil
+}
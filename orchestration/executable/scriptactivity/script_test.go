@@ -3,10 +3,14 @@ package scriptactivity
 import (
 	"context"
 	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
 	"github.com/d5/tengo/v2"
 	"github.com/d5/tengo/v2/stdlib"
 	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 var jsonDataWithLegati = []byte(`
@@ -181,3 +185,119 @@ func TestScriptCompare(t *testing.T) {
 	ndx := c.Get("result")
 	t.Log("computed index: ", ndx)
 }
+
+func TestTengoScriptLimits(t *testing.T) {
+	def := config.ScriptActivityDefinition{
+		StdLibModules: []string{"fmt"},
+		Params:        []config.ScriptActivityParam{{Name: "n"}},
+		MaxAllocs:     100,
+	}
+
+	ts, err := newTengoScript(def, []byte(`r := []; for i := 0; i < n; i++ { r = append(r, i) }`))
+	require.NoError(t, err)
+	compiled, err := ts.Compile()
+	require.NoError(t, err)
+
+	script := compiled.Clone()
+	require.NoError(t, script.Set("n", 10))
+	require.NoError(t, script.RunContext(context.Background()))
+	require.Len(t, script.Get("r").Array(), 10)
+
+	script = compiled.Clone()
+	require.NoError(t, script.Set("n", 1000))
+	require.ErrorIs(t, script.RunContext(context.Background()), tengo.ErrObjectAllocLimit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ts, err = newTengoScript(config.ScriptActivityDefinition{}, []byte(`for { }`))
+	require.NoError(t, err)
+	compiled, err = ts.Compile()
+	require.NoError(t, err)
+	require.ErrorIs(t, compiled.Clone().RunContext(ctx), context.DeadlineExceeded)
+
+	ts, err = newTengoScript(config.ScriptActivityDefinition{}, []byte(`x := undefinedVar + 1`))
+	require.NoError(t, err)
+	_, err = ts.Compile()
+	require.Error(t, err)
+
+	require.True(t, isTemplate([]byte(`x := "{{ .name }}"`)))
+	require.False(t, isTemplate([]byte(`x := 1`)))
+}
+
+func TestScriptEngines(t *testing.T) {
+	scripts := map[string][2]string{
+		config.ScriptEngineTengo: {
+			`total := 0; for o in _body.orders { total += o.amount }; label := prefix + _vars.customer`,
+			`for { }`,
+		},
+		config.ScriptEngineStarlark: {
+			"total = 0\nfor o in _body[\"orders\"]:\n    total += o[\"amount\"]\nlabel = prefix + _vars[\"customer\"]\n",
+			"while True:\n    pass\n",
+		},
+		config.ScriptEngineJavascript: {
+			`var total = 0; _body.orders.forEach(function (o) { total += o.amount; }); var label = prefix + _vars.customer;`,
+			`for (;;) {}`,
+		},
+	}
+
+	inputs := map[string]interface{}{
+		"prefix":        "c-",
+		ScriptInputVars: map[string]interface{}{"customer": "acme"},
+		ScriptInputBody: map[string]interface{}{"orders": []interface{}{map[string]interface{}{"amount": 1.5}, map[string]interface{}{"amount": 2.0}}},
+	}
+
+	for engineName, s := range scripts {
+		engine, err := GetScriptEngine(engineName)
+		require.NoError(t, err)
+
+		def := config.ScriptActivityDefinition{Engine: engineName, Script: "test-script", Params: []config.ScriptActivityParam{{Name: "prefix"}}}
+		compiled, err := engine.Compile(def, []byte(s[0]))
+		require.NoError(t, err, engineName)
+
+		out, err := compiled.Run(context.Background(), inputs)
+		require.NoError(t, err, engineName)
+		require.Equal(t, 3.5, out["total"], engineName)
+		require.Equal(t, "c-acme", out["label"], engineName)
+		require.NotContains(t, out, ScriptInputBody, engineName)
+
+		compiled, err = engine.Compile(def, []byte(s[1]))
+		require.NoError(t, err, engineName)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err = compiled.Run(ctx, inputs)
+		cancel()
+		require.Error(t, err, engineName)
+	}
+
+	_, err := GetScriptEngine("lua")
+	require.Error(t, err)
+}
+
+func TestTengoScriptModules(t *testing.T) {
+	folder := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "money.tengo"), []byte(`fmt := import("fmt"); export { cents: func(v) { return int(v * 100) } }`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "ping.tengo"), []byte(`pong := import("pong"); export { }`), 0644))
+
+	def := config.ScriptActivityDefinition{
+		Script:        "test-script",
+		StdLibModules: []string{"fmt"},
+		Modules:       []config.ScriptModule{{Name: "pong", Source: []byte(`ping := import("ping"); export { }`)}, {Name: "util", Source: []byte(`money := import("money"); export { total: func(a, b) { return money.cents(a + b) } }`)}},
+		ModulesFolder: folder,
+	}
+
+	compiled, err := tengoEngine{}.Compile(def, []byte(`util := import("util"); total := util.total(1.5, 2.25)`))
+	require.NoError(t, err)
+
+	out, err := compiled.Run(context.Background(), nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 375, out["total"])
+
+	_, err = tengoEngine{}.Compile(def, []byte(`ping := import("ping")`))
+	require.ErrorContains(t, err, "import cycle test-script -> ping -> pong -> ping")
+
+	_, err = tengoEngine{}.Compile(def, []byte(`m := import("missing")`))
+	require.Error(t, err)
+
+	_, err = tengoEngine{}.Compile(def, []byte(`m := import("../money")`))
+	require.Error(t, err)
+}
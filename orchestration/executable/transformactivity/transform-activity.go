@@ -14,7 +14,6 @@ import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms/jq"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms/kz"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
-	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-mongo-common/util"
 	"github.com/prometheus/client_golang/prometheus"
@@ -195,7 +194,7 @@ func (a *TransformActivity) executeMergeTransformation(wfc *wfcase.WfCase, merge
 
 func (a *TransformActivity) executeTemplateTransformation(wfc *wfcase.WfCase, bodyTemplate []byte, resolver *wfexpressions.Evaluator) ([]byte, error) {
 
-	s, _, err := varResolver.ResolveVariables(string(bodyTemplate), varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+	s, err := resolver.Interpolate(string(bodyTemplate))
 	if err != nil {
 		return nil, err
 	}
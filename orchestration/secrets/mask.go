@@ -0,0 +1,122 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	MaskedValue = "******"
+
+	// minMaskedValueLength avoids masking short values that would match all over the text.
+	minMaskedValueLength = 4
+)
+
+var maskedValues = struct {
+	mu     sync.RWMutex
+	values map[string]struct{}
+}{values: make(map[string]struct{})}
+
+func addMaskedValue(v string) {
+	if len(v) < minMaskedValueLength {
+		return
+	}
+
+	maskedValues.mu.Lock()
+	defer maskedValues.mu.Unlock()
+	maskedValues.values[v] = struct{}{}
+
+	// the value is also masked as it appears in a json document, i.e. in the json logs and in the bodies of the har entries.
+	for _, escaped := range jsonEscaped(v) {
+		maskedValues.values[escaped] = struct{}{}
+	}
+}
+
+// jsonEscaped returns the value escaped as a json string, with and without the escaping of the html characters.
+func jsonEscaped(v string) []string {
+	var escaped []string
+	for _, escapeHTML := range []bool{true, false} {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(escapeHTML)
+		if err := enc.Encode(v); err != nil {
+			continue
+		}
+
+		// the encoder adds the quotes and a trailing newline.
+		e := strings.TrimSuffix(buf.String(), "\n")
+		escaped = append(escaped, e[1:len(e)-1])
+	}
+
+	return escaped
+}
+
+// HasMaskedValues tells if any secret has been resolved so far, i.e. if there is something to mask.
+func HasMaskedValues() bool {
+	maskedValues.mu.RLock()
+	defer maskedValues.mu.RUnlock()
+	return len(maskedValues.values) > 0
+}
+
+// Mask replaces the values of the secrets resolved so far.
+func Mask(s string) string {
+	maskedValues.mu.RLock()
+	defer maskedValues.mu.RUnlock()
+
+	for v := range maskedValues.values {
+		s = strings.ReplaceAll(s, v, MaskedValue)
+	}
+
+	return s
+}
+
+// MaskBytes is the []byte counterpart of Mask. The data is returned as it is if it does not contain any secret.
+func MaskBytes(data []byte) []byte {
+	maskedValues.mu.RLock()
+	defer maskedValues.mu.RUnlock()
+
+	for v := range maskedValues.values {
+		if bytes.Contains(data, []byte(v)) {
+			data = bytes.ReplaceAll(data, []byte(v), []byte(MaskedValue))
+		}
+	}
+
+	return data
+}
+
+// Contains tells if the text contains the value of any secret resolved so far.
+func Contains(s string) bool {
+	maskedValues.mu.RLock()
+	defer maskedValues.mu.RUnlock()
+
+	for v := range maskedValues.values {
+		if strings.Contains(s, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type maskingWriter struct {
+	w io.Writer
+}
+
+// NewMaskingWriter masks the secrets in what is written to w. The application wraps the output of its loggers with it, e.g.
+//
+//	log.Logger = log.Logger.Output(secrets.NewMaskingWriter(os.Stderr))
+func NewMaskingWriter(w io.Writer) io.Writer {
+	return &maskingWriter{w: w}
+}
+
+func (mw *maskingWriter) Write(p []byte) (int, error) {
+	if _, err := mw.w.Write(MaskBytes(p)); err != nil {
+		return 0, err
+	}
+
+	// the length of the original data is returned since the masked one may differ.
+	return len(p), nil
+}
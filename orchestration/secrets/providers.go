@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileProvider reads the secrets from the files of a directory, e.g. the mount point of kubernetes secrets. The trailing new lines are trimmed.
+type FileProvider struct {
+	Dir string
+}
+
+func (p FileProvider) GetSecret(name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.Contains(name, "..") || strings.ContainsRune(name, os.PathSeparator) {
+		return "", fmt.Errorf("invalid secret name %s", name)
+	}
+
+	b, err := os.ReadFile(filepath.Join(p.Dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+		}
+		return "", err
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// EnvProvider reads the secrets from environment variables named Prefix followed by the name of the secret, dashes and dots replaced by underscores and
+// upper cased, e.g. the secret payments-api-key with prefix SECRET_ is read from SECRET_PAYMENTS_API_KEY.
+type EnvProvider struct {
+	Prefix string
+}
+
+func (p EnvProvider) GetSecret(name string) (string, error) {
+	n := p.Prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+	v, ok := os.LookupEnv(n)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+
+	return v, nil
+}
+
+// FakeProvider serves the secrets from a map and counts the reads, it is meant for tests.
+type FakeProvider struct {
+	mu      sync.Mutex
+	secrets map[string]string
+	reads   map[string]int
+}
+
+func NewFakeProvider(secrets map[string]string) *FakeProvider {
+	return &FakeProvider{secrets: secrets, reads: make(map[string]int)}
+}
+
+func (p *FakeProvider) GetSecret(name string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reads[name]++
+	v, ok := p.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+
+	return v, nil
+}
+
+// Set changes the value of a secret, e.g. to test a rotation.
+func (p *FakeProvider) Set(name, value string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.secrets[name] = value
+}
+
+// Reads returns the number of times the secret has been read from the provider.
+func (p *FakeProvider) Reads(name string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reads[name]
+}
@@ -0,0 +1,78 @@
+// Package secrets resolves the secret: references of the orchestration definitions (i.e. endpoint and kafka headers, the credentials of the
+// endpoint auth and the keys of the signers) through the provider set at startup. The resolved values are remembered to be masked in the
+// entries of the case and the breadcrumbs. The logs are masked by the application wrapping its own output with NewMaskingWriter.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultTtl is the time a resolved secret is cached when the provider is set without one.
+	DefaultTtl = 5 * time.Minute
+)
+
+var ErrSecretNotFound = errors.New("secret not found")
+
+// Provider reads the current value of a secret.
+type Provider interface {
+	GetSecret(name string) (string, error)
+}
+
+type cachedSecret struct {
+	value     string
+	expiresAt time.Time
+}
+
+var registry = struct {
+	mu       sync.Mutex
+	provider Provider
+	ttl      time.Duration
+	cache    map[string]cachedSecret
+}{cache: make(map[string]cachedSecret)}
+
+// SetProvider sets the provider of the process and clears the cached secrets. The values resolved so far are still masked.
+func SetProvider(p Provider, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultTtl
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.provider = p
+	registry.ttl = ttl
+	registry.cache = make(map[string]cachedSecret)
+}
+
+// Resolve returns the value of the secret, from the cache if not expired.
+func Resolve(name string) (string, error) {
+	const semLogContext = "secrets::resolve"
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if registry.provider == nil {
+		return "", fmt.Errorf("cannot resolve secret %s: no secrets provider has been set", name)
+	}
+
+	if s, ok := registry.cache[name]; ok && time.Now().Before(s.expiresAt) {
+		return s.value, nil
+	}
+
+	v, err := registry.provider.GetSecret(name)
+	if err != nil {
+		log.Error().Err(err).Str("name", name).Msg(semLogContext)
+		return "", err
+	}
+
+	addMaskedValue(v)
+	registry.cache[name] = cachedSecret{value: v, expiresAt: time.Now().Add(registry.ttl)}
+	log.Trace().Str("name", name).Msg(semLogContext + " - secret resolved")
+	return v, nil
+}
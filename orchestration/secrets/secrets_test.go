@@ -0,0 +1,96 @@
+package secrets_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/secrets"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	p := secrets.NewFakeProvider(map[string]string{"api-key": "s3cr3t-value"})
+	secrets.SetProvider(p, 50*time.Millisecond)
+
+	v, err := secrets.Resolve("api-key")
+	require.NoError(t, err)
+	require.Equal(t, "s3cr3t-value", v)
+
+	_, err = secrets.Resolve("api-key")
+	require.NoError(t, err)
+	require.Equal(t, 1, p.Reads("api-key"), "the secret should be served from the cache")
+
+	p.Set("api-key", "r0tated-value")
+	time.Sleep(60 * time.Millisecond)
+	v, err = secrets.Resolve("api-key")
+	require.NoError(t, err)
+	require.Equal(t, "r0tated-value", v)
+	require.Equal(t, 2, p.Reads("api-key"))
+
+	_, err = secrets.Resolve("missing")
+	require.True(t, errors.Is(err, secrets.ErrSecretNotFound))
+
+	require.Equal(t, "Bearer "+secrets.MaskedValue, secrets.Mask("Bearer s3cr3t-value"))
+	require.Equal(t, "Bearer "+secrets.MaskedValue, secrets.Mask("Bearer r0tated-value"))
+	require.True(t, secrets.Contains("key=r0tated-value"))
+	require.False(t, secrets.Contains("key=other"))
+
+	var buf bytes.Buffer
+	w := secrets.NewMaskingWriter(&buf)
+	msg := []byte(`{"level":"info","header":"s3cr3t-value"}`)
+	n, err := w.Write(msg)
+	require.NoError(t, err)
+	require.Equal(t, len(msg), n)
+	require.Equal(t, `{"level":"info","header":"`+secrets.MaskedValue+`"}`, buf.String())
+}
+
+func TestProviders(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "db-password"), []byte("pwd-from-file\n"), 0600))
+
+	fp := secrets.FileProvider{Dir: dir}
+	v, err := fp.GetSecret("db-password")
+	require.NoError(t, err)
+	require.Equal(t, "pwd-from-file", v)
+
+	_, err = fp.GetSecret("../db-password")
+	require.Error(t, err)
+
+	_, err = fp.GetSecret("missing")
+	require.True(t, errors.Is(err, secrets.ErrSecretNotFound))
+
+	t.Setenv("SECRET_PAYMENTS_API_KEY", "key-from-env")
+	ep := secrets.EnvProvider{Prefix: "SECRET_"}
+	v, err = ep.GetSecret("payments-api.key")
+	require.NoError(t, err)
+	require.Equal(t, "key-from-env", v)
+
+	_, err = ep.GetSecret("missing")
+	require.True(t, errors.Is(err, secrets.ErrSecretNotFound))
+}
+
+func TestMaskLogOutput(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(secrets.NewMaskingWriter(&buf))
+
+	p := secrets.NewFakeProvider(map[string]string{"token": `t0ken<with>"quotes"`})
+	secrets.SetProvider(p, 0)
+
+	v, err := secrets.Resolve("token")
+	require.NoError(t, err)
+	require.True(t, secrets.HasMaskedValues())
+
+	// the value is escaped in the json of the log.
+	logger.Info().Str("header", v).Msg("masked")
+	require.Contains(t, buf.String(), `"header":"`+secrets.MaskedValue+`"`)
+	require.NotContains(t, buf.String(), "t0ken")
+
+	// the value is masked whether the html characters have been escaped or not.
+	require.Equal(t, `{"token":"`+secrets.MaskedValue+`"}`, secrets.Mask(`{"token":"t0ken<with>\"quotes\""}`))
+	require.Equal(t, `{"token":"`+secrets.MaskedValue+`"}`, secrets.Mask(`{"token":"t0ken\u003cwith\u003e\"quotes\""}`))
+}
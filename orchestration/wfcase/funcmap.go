@@ -9,10 +9,15 @@ import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/funcs/purefuncs/amt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/funcs/withenvfuncs"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/globals"
-	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
 	"github.com/rs/zerolog/log"
 )
 
+// init declares the builtins in the cel environment. The dict and tmpl closures depend on the case and are bound to the ones of the process vars on evaluation.
+func init() {
+	wfexpressions.RegisterCelFunctions(GetFuncMap(nil), "dict", "tmpl")
+}
+
 func GetFuncMap(wfc *WfCase) map[string]interface{} {
 	builtins := make(map[string]interface{})
 	builtins["dict"] = func(dict string, elems ...string) string {
@@ -47,7 +52,7 @@ func GetFuncMap(wfc *WfCase) map[string]interface{} {
 			return err.Error()
 		}
 
-		s, _, err := varResolver.ResolveVariables(string(tmplBody), varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+		s, err := resolver.Interpolate(string(tmplBody))
 		if err != nil {
 			log.Error().Err(err).Msg(semLogContext)
 			return err.Error()
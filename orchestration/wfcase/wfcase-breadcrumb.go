@@ -1,6 +1,11 @@
 package wfcase
 
-import "github.com/rs/zerolog/log"
+import (
+	"errors"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/secrets"
+	"github.com/rs/zerolog/log"
+)
 
 type BreadcrumbStep struct {
 	Name        string
@@ -11,7 +16,11 @@ type BreadcrumbStep struct {
 type Breadcrumb []BreadcrumbStep
 
 func (wfc *WfCase) AddBreadcrumb(n string, d string, e error) {
-	wfc.Breadcrumb = append(wfc.Breadcrumb, BreadcrumbStep{Name: n, Description: d, Err: e})
+	// the error is replaced only if it carries a secret, not to lose its type otherwise.
+	if e != nil && secrets.Contains(e.Error()) {
+		e = errors.New(secrets.Mask(e.Error()))
+	}
+	wfc.Breadcrumb = append(wfc.Breadcrumb, BreadcrumbStep{Name: n, Description: secrets.Mask(d), Err: e})
 }
 
 func (wfc *WfCase) ShowBreadcrumb() {
@@ -6,9 +6,7 @@ import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
-	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
-	"github.com/PaesslerAG/gval"
 	"github.com/rs/zerolog/log"
 )
 
@@ -17,6 +15,7 @@ func (wfc *WfCase) GetEvaluatorByHarEntryReference(resolverContext HarEntryRefer
 	var resolver *wfexpressions.Evaluator
 	if wfc.ExpressionEvaluator != nil && wfc.ExpressionEvaluator.Name == resolverContext.String() {
 		log.Trace().Str("name", resolverContext.Name).Msg(semLogContext + " resolver cached")
+		wfc.ExpressionEvaluator.WithStrictMode(wfc.StrictMode)
 		return wfc.ExpressionEvaluator, nil
 	}
 
@@ -33,6 +32,9 @@ func (wfc *WfCase) GetEvaluatorByHarEntryReference(resolverContext HarEntryRefer
 	}
 
 	log.Trace().Str("name", resolverContext.Name).Msg(semLogContext + " new resolver created")
+	if resolver != nil {
+		resolver.WithStrictMode(wfc.StrictMode)
+	}
 	wfc.ExpressionEvaluator = resolver
 	return wfc.ExpressionEvaluator, err
 }
@@ -42,7 +44,7 @@ func (wfc *WfCase) getEvaluatorForHarEntryRequest(evalName string, endpointData
 	var err error
 	var resolver *wfexpressions.Evaluator
 
-	opts := []wfexpressions.EvaluatorOption{wfexpressions.WithHeaders(endpointData.Request.Headers), wfexpressions.WithQueryParams(endpointData.Request.QueryString)}
+	opts := []wfexpressions.EvaluatorOption{wfexpressions.WithHeaders(endpointData.Request.Headers), wfexpressions.WithQueryParams(endpointData.Request.QueryString), wfexpressions.WithHarEntries(wfc.GetHarEntry)}
 	if endpointData.Request.PostData != nil {
 		opts = append(opts, wfexpressions.WithBody(endpointData.Request.PostData.MimeType, endpointData.Request.PostData.Data, withTransformationId), wfexpressions.WithParams(endpointData.Request.PostData.Params))
 	}
@@ -64,7 +66,7 @@ func (wfc *WfCase) getEvaluatorForHarEntryResponse(evalName string, endpointData
 	var err error
 	var resolver *wfexpressions.Evaluator
 
-	opts := []wfexpressions.EvaluatorOption{wfexpressions.WithHeaders(endpointData.Response.Headers)}
+	opts := []wfexpressions.EvaluatorOption{wfexpressions.WithHeaders(endpointData.Response.Headers), wfexpressions.WithHarEntries(wfc.GetHarEntry)}
 	if endpointData.Response.Content != nil && len(endpointData.Response.Content.Data) > 0 {
 		// This condition should not consider the body if is not application json and the ignore flag has been set to true
 		if strings.HasPrefix(endpointData.Response.Content.MimeType, constants.ContentTypeApplicationJson) || !ignoreNonApplicationJsonContent {
@@ -112,7 +114,7 @@ func (wfc *WfCase) ResolveStrings(resolverContext HarEntryReference, expr []stri
 
 	var resolved []string
 	for _, s := range expr {
-		val, _, err := varResolver.ResolveVariables(s, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+		val, err := resolver.Interpolate(s)
 		if err != nil {
 			return nil, err
 		}
@@ -163,7 +165,7 @@ func (wfc *WfCase) SetVarsFromCase(sourceWfc *WfCase, resolverContext HarEntryRe
 			// Invertito l'ordine di determinazione della espressione.
 			tempVal, isExpr := IsExpression(v.Value)
 
-			val, _, err := varResolver.ResolveVariables(tempVal, varResolver.SimpleVariableReference, resolver.VarResolverFunc, true)
+			val, err := resolver.Interpolate(tempVal)
 			if err != nil {
 				log.Error().Err(err).Str("var", v.Value).Msg(semLogContext)
 				return err
@@ -172,14 +174,14 @@ func (wfc *WfCase) SetVarsFromCase(sourceWfc *WfCase, resolverContext HarEntryRe
 			// Was isExpression(val) but in doing this I use the evaluated value and I depend on the value of the variables  with potentially weird values.
 			var varValue interface{} = val
 			if isExpr && val != "" {
-				varValue, err = gval.Evaluate(val, sourceWfc.Vars.V)
+				varValue, err = sourceWfc.Vars.Eval(val)
 				if err != nil {
 					log.Error().Err(err).Str("var", val).Msg(semLogContext)
 					return err
 				}
 			}
 
-			err = wfc.Vars.Set(resolvedName, varValue, v.GlobalScope, v.Ttl, v.DltHeader)
+			err = wfc.Vars.DeclareAndSet(resolvedName, v, varValue)
 			if err != nil {
 				log.Error().Err(err).Msg(semLogContext)
 				return err
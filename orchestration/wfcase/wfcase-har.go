@@ -1,10 +1,12 @@
 package wfcase
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/secrets"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/util"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/jsonmask"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
@@ -17,6 +19,9 @@ import (
 
 const (
 	InitialRequestHarEntryId = "request"
+
+	// ProcessVarsHarEntryId is the id of the entry that records the process vars of the case in the har, see WithProcessVarsHarEntry.
+	ProcessVarsHarEntryId = "process-vars"
 )
 
 var InitialRequestHarEntryReference = HarEntryReference{Name: InitialRequestHarEntryId}
@@ -136,9 +141,29 @@ const (
 	ReportLogSimple                     = "simple"
 )
 
-func (wfc *WfCase) GetHarData(detail ReportLogDetail, jm *jsonmask.JsonMask) *har.HAR {
+type harDataOptions struct {
+	processVars bool
+}
+
+type HarDataOption func(opts *harDataOptions)
+
+// WithProcessVarsHarEntry adds to the har an entry with the process vars of the case, so that the case can be rebuilt by NewWorkflowCaseFromHar.
+// The entry is left out by default so that the vars do not reach the har of the reporters and the entries merged from the nested and loop cases.
+func WithProcessVarsHarEntry() HarDataOption {
+	return func(opts *harDataOptions) {
+		opts.processVars = true
+	}
+}
+
+func (wfc *WfCase) GetHarData(detail ReportLogDetail, jm *jsonmask.JsonMask, opts ...HarDataOption) *har.HAR {
 
 	const semLogContext = "wf-case::get-har-data"
+
+	var hOpts harDataOptions
+	for _, o := range opts {
+		o(&hOpts)
+	}
+
 	podName := os.Getenv("HOSTNAME")
 	if podName == "" {
 		log.Warn().Msg(semLogContext + " HOSTNAME env variable not set")
@@ -166,6 +191,8 @@ func (wfc *WfCase) GetHarData(detail ReportLogDetail, jm *jsonmask.JsonMask) *ha
 
 		if incl {
 
+			// the comment carries the id of the entry (i.e. ep-id#0) so that the har can be replayed.
+			e.Comment = n
 			err := e.MaskRequestBody(jm)
 			if err != nil {
 				log.Error().Err(err).Str("request-id", wfc.GetRequestId()).Msg("error masking request sensitive data")
@@ -177,18 +204,149 @@ func (wfc *WfCase) GetHarData(detail ReportLogDetail, jm *jsonmask.JsonMask) *ha
 			}
 
 			log.Trace().Str("id", n).Msg("adding entry to har")
-			har.Log.Entries = append(har.Log.Entries, e)
+			har.Log.Entries = append(har.Log.Entries, maskSecrets(e))
 		} else {
 			log.Trace().Str("id", n).Msg("skipping entry from har")
 		}
 	}
 
+	if hOpts.processVars && detail != ReportLogHARRequest {
+		if e := wfc.processVarsHarEntry(); e != nil {
+			err := e.MaskResponseBody(jm)
+			if err != nil {
+				log.Error().Err(err).Str("request-id", wfc.GetRequestId()).Msg("error masking process vars sensitive data")
+			}
+
+			har.Log.Entries = append(har.Log.Entries, maskSecrets(e))
+		}
+	}
+
 	return &har
 }
 
+// processVarsHarEntry records the process vars as the json body of the response of an entry, so that the case can be rebuilt from the har by
+// NewWorkflowCaseFromHar. The values that cannot be marshalled (i.e. the functions) are left out.
+func (wfc *WfCase) processVarsHarEntry() *har.Entry {
+	const semLogContext = "wf-case::process-vars-har-entry"
+
+	if wfc.Vars == nil || len(wfc.Vars.V) == 0 {
+		return nil
+	}
+
+	vars := make(map[string]json.RawMessage, len(wfc.Vars.V))
+	for n, v := range wfc.Vars.V {
+		b, err := json.Marshal(v)
+		if err != nil {
+			log.Trace().Err(err).Str("name", n).Msg(semLogContext + " - var not recorded")
+			continue
+		}
+		vars[n] = b
+	}
+
+	b, err := json.Marshal(vars)
+	if err != nil {
+		log.Error().Err(err).Str("request-id", wfc.GetRequestId()).Msg(semLogContext)
+		return nil
+	}
+
+	// the vars are masked with the pii domain of the orchestration, i.e. the one of the request of the case.
+	var pii har.PersonallyIdentifiableInformation
+	if req, err := wfc.GetHarEntry(InitialRequestHarEntryId); err == nil && req.PII.Domain != "" {
+		pii = har.PersonallyIdentifiableInformation{Domain: req.PII.Domain, AppliesTo: "resp"}
+	}
+
+	now := time.Now()
+	return &har.Entry{
+		Comment:         ProcessVarsHarEntryId,
+		PII:             pii,
+		StartedDateTime: now.Format(time.RFC3339Nano),
+		StartDateTimeTm: now,
+		Request: &har.Request{
+			Method:      http.MethodGet,
+			URL:         ProcessVarsHarEntryId,
+			HTTPVersion: "1.1",
+			Headers:     []har.NameValuePair{},
+			HeadersSize: -1,
+			Cookies:     []har.Cookie{},
+			QueryString: []har.NameValuePair{},
+		},
+		Response: har.NewResponse(http.StatusOK, http.StatusText(http.StatusOK), constants.ContentTypeApplicationJson, b, nil),
+	}
+}
+
+// maskSecrets returns a copy of the entry with the values of the secrets resolved so far masked. The entry of the case is left as it is.
+func maskSecrets(e *har.Entry) *har.Entry {
+	entry := *e
+	entry.Request = maskRequestSecrets(e.Request)
+	entry.Response = maskResponseSecrets(e.Response)
+	return &entry
+}
+
+// maskRequestSecrets returns a copy of the request with the values of the secrets masked, the request as it is if no secret has been resolved.
+func maskRequestSecrets(r *har.Request) *har.Request {
+	if r == nil || !secrets.HasMaskedValues() {
+		return r
+	}
+
+	req := *r
+	req.URL = secrets.Mask(req.URL)
+	req.Headers = maskNameValuePairs(req.Headers)
+	req.QueryString = maskNameValuePairs(req.QueryString)
+	if req.PostData != nil {
+		pd := *req.PostData
+		pd.Text = secrets.Mask(pd.Text)
+		pd.Data = secrets.MaskBytes(pd.Data)
+		if len(pd.Params) > 0 {
+			params := make([]har.Param, len(pd.Params))
+			for i, p := range pd.Params {
+				p.Value = secrets.Mask(p.Value)
+				params[i] = p
+			}
+			pd.Params = params
+		}
+		req.PostData = &pd
+	}
+
+	return &req
+}
+
+// maskResponseSecrets is the response counterpart of maskRequestSecrets.
+func maskResponseSecrets(r *har.Response) *har.Response {
+	if r == nil || !secrets.HasMaskedValues() {
+		return r
+	}
+
+	resp := *r
+	resp.Headers = maskNameValuePairs(resp.Headers)
+	if resp.Content != nil {
+		content := *resp.Content
+		content.Text = secrets.Mask(content.Text)
+		content.Data = secrets.MaskBytes(content.Data)
+		resp.Content = &content
+	}
+
+	return &resp
+}
+
+func maskNameValuePairs(nvs har.NameValuePairs) har.NameValuePairs {
+	if len(nvs) == 0 {
+		return nvs
+	}
+
+	masked := make(har.NameValuePairs, len(nvs))
+	for i, nv := range nvs {
+		nv.Value = secrets.Mask(nv.Value)
+		masked[i] = nv
+	}
+
+	return masked
+}
+
+// SetHarEntry records the entry under the first available instance of the id. The entries are recorded, as SetHarEntryRequest and SetHarEntryResponse
+// do, with the values of the secrets masked so that they cannot be copied by the e: references and the expressions evaluated against the case.
 func (wfc *WfCase) SetHarEntry(id string, entry *har.Entry) error {
 	instanceId := wfc.ComputeFirstAvailableIndexedHarEntryId(id)
-	wfc.Entries[instanceId] = entry
+	wfc.Entries[instanceId] = maskSecrets(entry)
 	return nil
 }
 
@@ -205,7 +363,7 @@ func (wfc *WfCase) SetHarEntryRequest(id string, req *har.Request, pii config.Pe
 			StartDateTimeTm: now,
 		}
 	}
-	e.Request = req
+	e.Request = maskRequestSecrets(req)
 	e.PII = har.PersonallyIdentifiableInformation{
 		Domain:    pii.Domain,
 		AppliesTo: pii.AppliesTo,
@@ -251,7 +409,7 @@ func (wfc *WfCase) SetHarEntryResponse(id string, resp *har.Response, pii config
 		Ssl:     -1,
 	}
 
-	e.Response = resp
+	e.Response = maskResponseSecrets(resp)
 	e.PII = har.PersonallyIdentifiableInformation{
 		Domain:    pii.Domain,
 		AppliesTo: pii.AppliesTo,
@@ -293,6 +451,20 @@ func (wfc *WfCase) SetHarEntryResponse(id string, resp *har.Response, pii config
 	return nil
 }
 
+// UpdateHarEntryRequest replaces the request of the last instance of the id, e.g. with the one actually sent when it differs from the recorded one.
+func (wfc *WfCase) UpdateHarEntryRequest(id string, req *har.Request) error {
+	const semLogContext = "wf-case::update-har-entry-request"
+
+	instanceId, err := wfc.ComputeLastUsedIndexedHarEntryId(id)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return err
+	}
+
+	wfc.Entries[instanceId].Request = maskRequestSecrets(req)
+	return nil
+}
+
 // NewHarRequestFromHarEntryReference the body parameter overrides the body that can  be taken from previous activity response. Used in the loop activity.
 func (wfc *WfCase) NewHarRequestFromHarEntryReference(ctxName HarEntryReference, method, url string, body []byte) (*har.Request, error) {
 	const semLogContext = "wf-case::get-request-from-context"
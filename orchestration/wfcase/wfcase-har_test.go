@@ -0,0 +1,40 @@
+package wfcase_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/secrets"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHarEntrySecrets(t *testing.T) {
+	secrets.SetProvider(secrets.NewFakeProvider(map[string]string{"api-key": "s3cr3t-api-key"}), 0)
+	key, err := secrets.Resolve("api-key")
+	require.NoError(t, err)
+
+	wfc, err := wfcase.NewWorkflowCase("secrets-test", "", "", "", nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	// the request sent keeps the secret, the one recorded in the case has it masked.
+	req := &har.Request{Method: http.MethodGet, URL: "http://example.com/api?key=" + key, Headers: har.NameValuePairs{{Name: "X-Api-Key", Value: key}}}
+	require.NoError(t, wfc.SetHarEntryRequest("ep01", req, config.PersonallyIdentifiableInformation{}))
+	require.NoError(t, wfc.SetHarEntryResponse("ep01", har.NewResponse(http.StatusOK, http.StatusText(http.StatusOK), constants.ContentTypeApplicationJson, []byte(`{"echo":"`+key+`"}`), nil), config.PersonallyIdentifiableInformation{}))
+	require.Equal(t, key, req.Headers.GetFirst("X-Api-Key").Value)
+
+	e := wfc.Entries["ep01#0"]
+	require.Equal(t, "http://example.com/api?key="+secrets.MaskedValue, e.Request.URL)
+	require.Equal(t, secrets.MaskedValue, e.Request.Headers.GetFirst("X-Api-Key").Value)
+	require.Equal(t, `{"echo":"`+secrets.MaskedValue+`"}`, string(e.Response.Content.Data))
+
+	// the request sent to an alternative host replaces the recorded one.
+	require.NoError(t, wfc.UpdateHarEntryRequest("ep01", &har.Request{Method: http.MethodGet, URL: "http://alt.example.com/api?key=" + key}))
+	require.Equal(t, "http://alt.example.com/api?key="+secrets.MaskedValue, wfc.Entries["ep01#0"].Request.URL)
+
+	require.NoError(t, wfc.SetHarEntry("ep02", &har.Entry{Request: req}))
+	require.Equal(t, secrets.MaskedValue, wfc.Entries["ep02#0"].Request.Headers.GetFirst("X-Api-Key").Value)
+}
@@ -0,0 +1,265 @@
+package wfcase
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	HarContentEncodingBase64 = "base64"
+)
+
+// HarCassette serves the entries of a har produced by GetHarData in place of the outbound calls of endpoint, mongo, kafka and cache activities.
+// Entries are matched by id: the n-th call of the activity with id 'ep-id' gets the recorded entry 'ep-id#n'. Entries of nested orchestrations and loops
+// are recorded as 'activity#n@ep-id#m' and are served to the child cases by the cassette returned by Child.
+type HarCassette struct {
+	mu      sync.Mutex
+	entries map[string]*har.Entry
+	cursors map[string]int
+}
+
+func NewHarCassette(h *har.HAR) *HarCassette {
+	c := &HarCassette{entries: make(map[string]*har.Entry), cursors: make(map[string]int)}
+	if h != nil && h.Log != nil {
+		for _, e := range h.Log.Entries {
+			decodeHarContent(e)
+			c.entries[e.Comment] = e
+		}
+	}
+
+	return c
+}
+
+func NewHarCassetteFromFile(fn string) (*HarCassette, error) {
+	h, err := readHarFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHarCassette(h), nil
+}
+
+func readHarFile(fn string) (*har.HAR, error) {
+	const semLogContext = "har-cassette::read-har-file"
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		log.Error().Err(err).Str("file", fn).Msg(semLogContext)
+		return nil, err
+	}
+
+	var h har.HAR
+	err = json.Unmarshal(b, &h)
+	if err != nil {
+		log.Error().Err(err).Str("file", fn).Msg(semLogContext)
+		return nil, err
+	}
+
+	return &h, nil
+}
+
+// NewWorkflowCaseFromHar rebuilds a case from the entries of a har produced by GetHarData, e.g. to evaluate expressions against a recorded execution. The
+// process vars are restored from the ProcessVarsHarEntryId entry if the har has one (i.e. it has been written by WriteHarFixture), the values of vars are
+// added and take precedence over the recorded ones.
+func NewWorkflowCaseFromHar(h *har.HAR, vars map[string]interface{}) (*WfCase, error) {
+	if h == nil || h.Log == nil {
+		return nil, errors.New("the har has no log")
+	}
+
+	wfc, err := NewWorkflowCase(h.Log.Comment, "", "", "recorded case", nil, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range h.Log.Entries {
+		if e.Comment == "" {
+			return nil, errors.New("har entries have no id, the har has not been produced by GetHarData")
+		}
+
+		decodeHarContent(e)
+		if e.Comment == ProcessVarsHarEntryId {
+			if err = restoreProcessVars(wfc, e); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		wfc.Entries[e.Comment] = e
+	}
+
+	for n, v := range vars {
+		wfc.Vars.V[n] = v
+	}
+
+	return wfc, nil
+}
+
+func restoreProcessVars(wfc *WfCase, e *har.Entry) error {
+	if e.Response == nil || e.Response.Content == nil || len(e.Response.Content.Data) == 0 {
+		return nil
+	}
+
+	var vars map[string]interface{}
+	if err := json.Unmarshal(e.Response.Content.Data, &vars); err != nil {
+		return fmt.Errorf("invalid %s entry: %w", ProcessVarsHarEntryId, err)
+	}
+
+	for n, v := range vars {
+		wfc.Vars.V[n] = v
+	}
+
+	return nil
+}
+
+func NewWorkflowCaseFromHarFile(fn string, vars map[string]interface{}) (*WfCase, error) {
+	h, err := readHarFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWorkflowCaseFromHar(h, vars)
+}
+
+// Rewind returns a cassette with the entries of c positioned at the first recorded instance of every id, for a new case to replay the har from
+// the start. The entries are shared, they are copied by Next.
+func (c *HarCassette) Rewind() *HarCassette {
+	if c == nil {
+		return nil
+	}
+
+	return &HarCassette{entries: c.entries, cursors: make(map[string]int)}
+}
+
+// Child returns the cassette of a child case whose entries have been merged under the entryId of the parent (i.e. the loop or the nested orchestration activity).
+func (c *HarCassette) Child(entryId string) *HarCassette {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	child := &HarCassette{entries: make(map[string]*har.Entry), cursors: make(map[string]int)}
+	prefix := entryId + "@"
+	for id, e := range c.entries {
+		if strings.HasPrefix(id, prefix) {
+			child.entries[strings.TrimPrefix(id, prefix)] = e
+		}
+	}
+
+	return child
+}
+
+// Next returns a copy of the next recorded entry of the id.
+func (c *HarCassette) Next(id string) (*har.Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if HarEntryIdIsIndexed(id) {
+		id = id[:strings.Index(id, "#")]
+	}
+
+	instanceId := fmt.Sprintf("%s#%d", id, c.cursors[id])
+	e, ok := c.entries[instanceId]
+	if !ok || e.Response == nil {
+		return nil, fmt.Errorf("cannot find recorded entry %s", instanceId)
+	}
+
+	c.cursors[id]++
+	entry := *e
+	return &entry, nil
+}
+
+func (wfc *WfCase) IsReplaying() bool {
+	return wfc.Replay != nil
+}
+
+// ReplayHarEntry returns the recorded entry of the next call of the activity (or endpoint) with the given id.
+func (wfc *WfCase) ReplayHarEntry(id string) (*har.Entry, error) {
+	const semLogContext = "wf-case::replay-har-entry"
+
+	e, err := wfc.Replay.Next(id)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return nil, err
+	}
+
+	log.Trace().Str("id", id).Int("status", e.Response.Status).Msg(semLogContext)
+	return e, nil
+}
+
+// WriteHarFixture records the har of the case, process vars included, to be replayed. Contents that are not valid utf-8 text are base64 encoded.
+func (wfc *WfCase) WriteHarFixture(fn string) error {
+	const semLogContext = "wf-case::write-har-fixture"
+
+	h := wfc.GetHarData(ReportLogHAR, nil, WithProcessVarsHarEntry())
+	for i, e := range h.Log.Entries {
+		h.Log.Entries[i] = encodeHarContent(e)
+	}
+
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return err
+	}
+
+	err = os.WriteFile(fn, b, 0644)
+	if err != nil {
+		log.Error().Err(err).Str("file", fn).Msg(semLogContext)
+		return err
+	}
+
+	log.Info().Str("file", fn).Int("num-entries", len(h.Log.Entries)).Msg(semLogContext)
+	return nil
+}
+
+// encodeHarContent returns a copy of the entry with binary contents base64 encoded. The entries of the case are left untouched.
+func encodeHarContent(e *har.Entry) *har.Entry {
+	if e.Response == nil || e.Response.Content == nil || utf8.Valid(e.Response.Content.Data) {
+		return e
+	}
+
+	entry := *e
+	resp := *e.Response
+	content := *e.Response.Content
+	content.Text = base64.StdEncoding.EncodeToString(content.Data)
+	content.Encoding = HarContentEncodingBase64
+	content.Data = nil
+	resp.Content = &content
+	entry.Response = &resp
+	return &entry
+}
+
+// decodeHarContent restores the Data of the contents since the har marshals the text only.
+func decodeHarContent(e *har.Entry) {
+	if e.Request != nil && e.Request.PostData != nil && len(e.Request.PostData.Data) == 0 {
+		e.Request.PostData.Data = []byte(e.Request.PostData.Text)
+	}
+
+	if e.Response == nil || e.Response.Content == nil || len(e.Response.Content.Data) != 0 {
+		return
+	}
+
+	content := e.Response.Content
+	if content.Encoding == HarContentEncodingBase64 {
+		b, err := base64.StdEncoding.DecodeString(content.Text)
+		if err == nil {
+			content.Data = b
+			content.Encoding = ""
+			return
+		}
+
+		log.Error().Err(err).Str("id", e.Comment).Msg("har-cassette::decode-content")
+	}
+
+	content.Data = []byte(content.Text)
+}
@@ -0,0 +1,85 @@
+package wfcase_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/stretchr/testify/require"
+)
+
+var recordedHar = []byte(`
+{
+  "log": {
+    "version": "1.1",
+    "creator": { "name": "tpm-symphony", "version": "test" },
+    "entries": [
+      { "comment": "request#0", "request": { "method": "POST", "url": "/api" }, "response": { "status": 200, "content": { "mimeType": "application/json", "text": "{}" } } },
+      { "comment": "ep01#0", "response": { "status": 200, "content": { "mimeType": "application/json", "text": "{\"page\":1}" } } },
+      { "comment": "ep01#1", "response": { "status": 404, "content": { "mimeType": "application/json", "text": "{\"page\":2}" } } },
+      { "comment": "doc#0", "response": { "status": 200, "content": { "mimeType": "application/pdf", "encoding": "base64", "text": "JVBERgD//g==" } } },
+      { "comment": "loop-body#1@ep02#0", "response": { "status": 202, "content": { "mimeType": "application/json", "text": "{\"item\":2}" } } }
+    ]
+  }
+}
+`)
+
+func TestHarCassette(t *testing.T) {
+	var h har.HAR
+	require.NoError(t, json.Unmarshal(recordedHar, &h))
+
+	c := wfcase.NewHarCassette(&h)
+
+	e, err := c.Next("ep01")
+	require.NoError(t, err)
+	require.Equal(t, 200, e.Response.Status)
+	require.Equal(t, `{"page":1}`, string(e.Response.Content.Data))
+
+	e, err = c.Next("ep01")
+	require.NoError(t, err)
+	require.Equal(t, 404, e.Response.Status)
+
+	_, err = c.Next("ep01")
+	require.Error(t, err)
+
+	e, err = c.Next("doc")
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x25, 0x50, 0x44, 0x46, 0x00, 0xff, 0xfe}, e.Response.Content.Data)
+
+	_, err = c.Child("loop-body#0").Next("ep02")
+	require.Error(t, err)
+
+	e, err = c.Child("loop-body#1").Next("ep02")
+	require.NoError(t, err)
+	require.Equal(t, 202, e.Response.Status)
+
+	var nilCassette *wfcase.HarCassette
+	require.Nil(t, nilCassette.Child("loop-body#0"))
+}
+
+func TestNewWorkflowCaseFromHar(t *testing.T) {
+	wfc, err := wfcase.NewWorkflowCase("recorded", "", "", "", nil, nil, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, wfc.Vars.Set("kind", "x", false, 0, false))
+	require.NoError(t, wfc.Vars.Set("threshold", 5, false, 0, false))
+	wfc.Vars.V["ch"] = make(chan int)
+
+	// the vars are recorded only on request.
+	for _, e := range wfc.GetHarData(wfcase.ReportLogHAR, nil).Log.Entries {
+		require.NotEqual(t, wfcase.ProcessVarsHarEntryId, e.Comment)
+	}
+
+	// the har goes through json as when written to a file.
+	b, err := json.Marshal(wfc.GetHarData(wfcase.ReportLogHAR, nil, wfcase.WithProcessVarsHarEntry()))
+	require.NoError(t, err)
+	var h har.HAR
+	require.NoError(t, json.Unmarshal(b, &h))
+
+	replayed, err := wfcase.NewWorkflowCaseFromHar(&h, map[string]interface{}{"threshold": 10.0})
+	require.NoError(t, err)
+	require.Equal(t, "x", replayed.Vars.V["kind"])
+	require.Equal(t, 10.0, replayed.Vars.V["threshold"])
+	require.NotContains(t, replayed.Vars.V, "ch")
+	require.NotContains(t, replayed.Entries, wfcase.ProcessVarsHarEntryId)
+}
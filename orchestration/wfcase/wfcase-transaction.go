@@ -0,0 +1,157 @@
+package wfcase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	// DefaultMongoTransactionTimeout bounds the transactions of the cases with no request deadline. It matches the default transactionLifetimeLimitSeconds of mongo.
+	DefaultMongoTransactionTimeout = time.Minute
+
+	// mongoTransactionAbortTimeout bounds the abort and the release of the session, that are attempted even if the deadline of the transaction has expired.
+	mongoTransactionAbortTimeout = 5 * time.Second
+)
+
+// MongoSession is the part of the mongo session used by the transactions of the case.
+type MongoSession interface {
+	Client() *mongo.Client
+	CommitTransaction(ctx context.Context) error
+	AbortTransaction(ctx context.Context) error
+	EndSession(ctx context.Context)
+}
+
+// MongoTransaction holds the session shared by the mongo activities of a transaction scope. The Ctx has to be used
+// by the operations that want to take part in the transaction: it carries the session and the deadline of the case.
+type MongoTransaction struct {
+	Scope   string
+	Session MongoSession
+	Ctx     context.Context
+
+	// Failed is set when one of the operations of the transaction errors: the transaction is then aborted even if the
+	// execution leaves its scope normally.
+	Failed bool
+
+	cancel context.CancelFunc
+}
+
+func (wfc *WfCase) GetMongoTransaction(scope string) (*MongoTransaction, bool) {
+	tx, ok := wfc.Transactions[scope]
+	return tx, ok
+}
+
+// GetOrBeginMongoTransaction returns the transaction of the scope, if any, or starts a new one on the provided client.
+// The transaction expires with the deadline of the case or after DefaultMongoTransactionTimeout if the case has none.
+func (wfc *WfCase) GetOrBeginMongoTransaction(scope string, client *mongo.Client) (*MongoTransaction, error) {
+	const semLogContext = "wf-case::begin-mongo-transaction"
+
+	if tx, ok := wfc.Transactions[scope]; ok {
+		if tx.Session.Client() != client {
+			err := errors.New("activities of the same transaction scope must share the same linked service")
+			log.Error().Err(err).Str("scope", scope).Msg(semLogContext)
+			return nil, err
+		}
+		return tx, nil
+	}
+
+	timeout := DefaultMongoTransactionTimeout
+	if wfc.RequestDeadline != 0 {
+		timeout = wfc.RequestDeadline - wfc.RequestTiming
+		if timeout <= 0 {
+			err := fmt.Errorf("cannot begin transaction of scope %s: request deadline exceeded", scope)
+			log.Error().Err(err).Str("scope", scope).Msg(semLogContext)
+			return nil, err
+		}
+	}
+
+	sess, err := client.StartSession()
+	if err != nil {
+		log.Error().Err(err).Str("scope", scope).Msg(semLogContext)
+		return nil, err
+	}
+
+	err = sess.StartTransaction()
+	if err != nil {
+		log.Error().Err(err).Str("scope", scope).Msg(semLogContext)
+		endSession(sess)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	wfc.SetMongoTransaction(&MongoTransaction{Scope: scope, Session: sess, Ctx: mongo.NewSessionContext(ctx, sess), cancel: cancel})
+
+	log.Info().Str("scope", scope).Float64("timeout.s", timeout.Seconds()).Msg(semLogContext)
+	return wfc.Transactions[scope], nil
+}
+
+// SetMongoTransaction binds the transaction to the case under its scope.
+func (wfc *WfCase) SetMongoTransaction(tx *MongoTransaction) {
+	if wfc.Transactions == nil {
+		wfc.Transactions = make(map[string]*MongoTransaction)
+	}
+	wfc.Transactions[tx.Scope] = tx
+}
+
+// EndMongoTransaction commits or aborts the transaction of the scope and releases the session. Ending a scope that
+// never started a transaction (i.e. none of its activities has been executed) is a no-op. A transaction with a failed
+// operation is aborted and an error is returned even if the commit is requested.
+func (wfc *WfCase) EndMongoTransaction(scope string, commit bool) error {
+	const semLogContext = "wf-case::end-mongo-transaction"
+
+	tx, ok := wfc.Transactions[scope]
+	if !ok {
+		return nil
+	}
+	delete(wfc.Transactions, scope)
+	defer func() {
+		endSession(tx.Session)
+		if tx.cancel != nil {
+			tx.cancel()
+		}
+	}()
+
+	var err error
+	if commit && tx.Failed {
+		err = fmt.Errorf("transaction of scope %s has been aborted since one of its operations failed", scope)
+		commit = false
+	}
+
+	var endErr error
+	if commit {
+		endErr = tx.Session.CommitTransaction(tx.Ctx)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), mongoTransactionAbortTimeout)
+		endErr = tx.Session.AbortTransaction(ctx)
+		cancel()
+	}
+
+	if endErr != nil {
+		err = endErr
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str("scope", scope).Bool("commit", commit).Msg(semLogContext)
+		return err
+	}
+
+	log.Info().Str("scope", scope).Bool("commit", commit).Msg(semLogContext)
+	return nil
+}
+
+// AbortMongoTransactions aborts every open transaction. Used when the orchestration terminates with an error.
+func (wfc *WfCase) AbortMongoTransactions() {
+	for scope := range wfc.Transactions {
+		_ = wfc.EndMongoTransaction(scope, false)
+	}
+}
+
+func endSession(sess MongoSession) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTransactionAbortTimeout)
+	defer cancel()
+	sess.EndSession(ctx)
+}
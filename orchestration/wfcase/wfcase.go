@@ -1,6 +1,7 @@
 package wfcase
 
 import (
+	"context"
 	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
@@ -61,8 +62,20 @@ type WfCase struct {
 
 	ExpressionEvaluator *wfexpressions.Evaluator
 
+	// StrictMode applies to the evaluators of the activity in execution. It is set by the orchestration before each activity.
+	StrictMode wfexpressions.StrictMode
+
 	RequestDeadline time.Duration
 	RequestTiming   time.Duration
+
+	Transactions map[string]*MongoTransaction
+
+	// Replay when set the outbound calls are served from the entries of a recorded har.
+	Replay *HarCassette
+
+	// Ctx when set is cancelled once the outcome of the case is no longer needed (i.e. an iteration of a parallel loop that failed fast): the
+	// orchestration stops before its next activity. It is shared with the child cases.
+	Ctx context.Context
 }
 
 func NewWorkflowCase(id string, version, sha string, descr string, dicts config.Dictionaries, refs config.DataReferences, systemVars map[string]interface{}, span opentracing.Span) (*WfCase, error) {
@@ -92,6 +105,7 @@ func NewWorkflowCase(id string, version, sha string, descr string, dicts config.
 		Span:        span}
 
 	v := wfexpressions.NewProcessVars()
+	v.WithHarEntryLookup(c.GetHarEntry)
 	for fn, fb := range GetFuncMap(c) {
 		v.V[fn] = fb
 	}
@@ -123,6 +137,7 @@ func (wfc *WfCase) NewChild(expressionCtx HarEntryReference, id string, version,
 		return nil, err
 	}
 
+	childWfc.Ctx = wfc.Ctx
 	err = childWfc.SetVarsFromCase(wfc, expressionCtx, vars, "", false)
 	if err != nil {
 		log.Error().Err(err).Msg(semLogContext)
@@ -153,6 +168,35 @@ func (wfc *WfCase) NewChild(expressionCtx HarEntryReference, id string, version,
 	}
 */
 
+// CancellationErr returns the error of the context of the case once it has been cancelled.
+func (wfc *WfCase) CancellationErr() error {
+	if wfc.Ctx == nil {
+		return nil
+	}
+
+	return wfc.Ctx.Err()
+}
+
+// BoundedContext returns a context derived from the one of the case that expires with the request deadline of the case or, if the case has none,
+// after timeout. A zero timeout leaves the context of a case without deadline unbounded.
+func (wfc *WfCase) BoundedContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	parent := wfc.Ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	if wfc.RequestDeadline != 0 {
+		timeout = max(wfc.RequestDeadline-wfc.RequestTiming, 0)
+		return context.WithTimeout(parent, timeout)
+	}
+
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+
+	return context.WithCancel(parent)
+}
+
 func (wfc *WfCase) DeadlineExceeded(additionalTiming time.Duration) bool {
 	const semLogContext = "wf-case::get-request-id"
 
@@ -0,0 +1,444 @@
+package wfexpressions
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ExpressionLanguageCel = "cel"
+
+	// CelExpressionPrefix and GvalExpressionPrefix select the language of a single expression regardless of the default one of the orchestration,
+	// e.g. ':cel:amount > 10 && kind in ["a", "b"]'.
+	CelExpressionPrefix  = "cel:"
+	GvalExpressionPrefix = "gval:"
+
+	// celMaxVariadicArgs is the number of variadic arguments the overloads of a variadic function are declared for.
+	celMaxVariadicArgs = 4
+
+	// celCaseFuncsVarName is the hidden variable the case bound functions take their implementation from: a macro adds it as first argument of their
+	// calls, e.g. dict(a, b) is evaluated as dict(_chorus_case_funcs, a, b), so that the program of an expression does not depend on the case.
+	celCaseFuncsVarName = "_chorus_case_funcs"
+)
+
+// celFunctions are the functions declared in the cel environment. The case bound ones (i.e. dict, tmpl) take their implementation from the process vars
+// at evaluation time, see celCaseFuncsVarName.
+var celFunctions = struct {
+	mu        sync.Mutex
+	funcs     map[string]interface{}
+	caseBound map[string]struct{}
+	env       *cel.Env
+}{funcs: make(map[string]interface{}), caseBound: make(map[string]struct{})}
+
+// RegisterCelFunctions declares the functions available to the cel expressions. The signatures are derived from the go functions: strings and booleans are
+// type checked, numbers and interfaces are declared as dyn and converted when invoked. Note the entry function of the gval expressions is not available,
+// the e: references work the same in both languages.
+func RegisterCelFunctions(funcs map[string]interface{}, caseBound ...string) {
+	celFunctions.mu.Lock()
+	defer celFunctions.mu.Unlock()
+
+	for n, f := range funcs {
+		celFunctions.funcs[n] = f
+	}
+
+	for _, n := range caseBound {
+		celFunctions.caseBound[n] = struct{}{}
+	}
+
+	celFunctions.env = nil
+
+	// The expressions compiled so far have been checked against the previous functions.
+	compiledExpressions.mu.Lock()
+	defer compiledExpressions.mu.Unlock()
+	for k := range compiledExpressions.expressions {
+		if k.language == ExpressionLanguageCel {
+			delete(compiledExpressions.expressions, k)
+		}
+	}
+}
+
+// celEnvironment returns the environment with the registered functions.
+func celEnvironment() (*cel.Env, error) {
+	const semLogContext = "cel::environment"
+
+	celFunctions.mu.Lock()
+	defer celFunctions.mu.Unlock()
+
+	if celFunctions.env != nil {
+		return celFunctions.env, nil
+	}
+
+	names := make([]string, 0, len(celFunctions.funcs))
+	for n := range celFunctions.funcs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	opts := []cel.EnvOption{cel.CrossTypeNumericComparisons(true), cel.Variable(celCaseFuncsVarName, cel.DynType)}
+	for _, n := range names {
+		_, isCaseBound := celFunctions.caseBound[n]
+		opt, err := celFunction(n, celFunctions.funcs[n], isCaseBound)
+		if err != nil {
+			log.Warn().Err(err).Str("function", n).Msg(semLogContext + " - function not available in cel expressions")
+			continue
+		}
+		opts = append(opts, opt)
+
+		if isCaseBound {
+			opts = append(opts, cel.Macros(cel.GlobalVarArgMacro(n, celCaseBoundMacro(n))))
+		}
+	}
+
+	env, err := cel.NewEnv(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	celFunctions.env = env
+	return env, nil
+}
+
+// celCaseBoundMacro adds the hidden variable of the case functions as first argument of the calls of a case bound function.
+func celCaseBoundMacro(name string) cel.MacroFactory {
+	return func(eh cel.MacroExprFactory, target ast.Expr, args []ast.Expr) (ast.Expr, *common.Error) {
+		return eh.NewCall(name, append([]ast.Expr{eh.NewIdent(celCaseFuncsVarName)}, args...)...), nil
+	}
+}
+
+// celFunction declares a go function. Variadic functions get an overload for each number of arguments up to celMaxVariadicArgs variadic ones.
+// The case bound functions get a leading dyn argument for the case functions.
+func celFunction(name string, f interface{}, caseBound bool) (cel.EnvOption, error) {
+	fv := reflect.ValueOf(f)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%s is not a function", name)
+	}
+
+	if ft.NumOut() == 0 || ft.NumOut() > 2 || (ft.NumOut() == 2 && ft.Out(1) != reflect.TypeOf((*error)(nil)).Elem()) {
+		return nil, fmt.Errorf("function %s must return a value and optionally an error", name)
+	}
+
+	minArgs, maxArgs := ft.NumIn(), ft.NumIn()
+	if ft.IsVariadic() {
+		minArgs = ft.NumIn() - 1
+		maxArgs = minArgs + celMaxVariadicArgs
+	}
+
+	var overloads []cel.FunctionOpt
+	for arity := minArgs; arity <= maxArgs; arity++ {
+		var argTypes []*cel.Type
+		binding := celFunctionBinding(name, fv)
+		if caseBound {
+			argTypes = append(argTypes, cel.DynType)
+			binding = celCaseBoundBinding(name)
+		}
+
+		for i := 0; i < arity; i++ {
+			argTypes = append(argTypes, celType(celArgType(ft, i)))
+		}
+
+		overloads = append(overloads, cel.Overload(fmt.Sprintf("%s_%d", name, arity), argTypes, celType(ft.Out(0)), cel.FunctionBinding(binding)))
+	}
+
+	return cel.Function(name, overloads...), nil
+}
+
+func celArgType(ft reflect.Type, i int) reflect.Type {
+	if ft.IsVariadic() && i >= ft.NumIn()-1 {
+		return ft.In(ft.NumIn() - 1).Elem()
+	}
+	return ft.In(i)
+}
+
+// celType maps the go types to the cel ones. Numbers are dyn since cel does not convert ints to doubles and literals like 2 would not match a float64 param.
+func celType(t reflect.Type) *cel.Type {
+	switch t.Kind() {
+	case reflect.String:
+		return cel.StringType
+	case reflect.Bool:
+		return cel.BoolType
+	}
+	return cel.DynType
+}
+
+func celFunctionBinding(name string, fv reflect.Value) func(args ...ref.Val) ref.Val {
+	ft := fv.Type()
+	return func(args ...ref.Val) ref.Val {
+		in := make([]reflect.Value, len(args))
+		for i, a := range args {
+			v, err := celArgToNative(a, celArgType(ft, i))
+			if err != nil {
+				return types.NewErr("%s: argument %d: %v", name, i+1, err)
+			}
+			in[i] = v
+		}
+
+		out := fv.Call(in)
+		if len(out) == 2 && !out[1].IsNil() {
+			return types.NewErr("%s: %v", name, out[1].Interface())
+		}
+
+		return types.DefaultTypeAdapter.NativeToValue(out[0].Interface())
+	}
+}
+
+// celCaseBoundBinding invokes the implementation of the function found in the case functions of the first argument.
+func celCaseBoundBinding(name string) func(args ...ref.Val) ref.Val {
+	return func(args ...ref.Val) ref.Val {
+		funcs, _ := args[0].(celCaseFuncs)
+		f, ok := funcs[name]
+		if !ok {
+			return types.NewErr("function %s is not available in this context", name)
+		}
+
+		return celFunctionBinding(name, reflect.ValueOf(f))(args[1:]...)
+	}
+}
+
+func celArgToNative(a ref.Val, t reflect.Type) (reflect.Value, error) {
+	switch t.Kind() {
+	case reflect.Interface:
+		v := celToNative(a)
+		switch n := v.(type) {
+		case nil:
+			return reflect.Zero(t), nil
+		case int64:
+			// The numbers of the gval expressions are float64 and the functions are written with that in mind.
+			v = float64(n)
+		case uint64:
+			v = float64(n)
+		}
+		return reflect.ValueOf(v), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		var f float64
+		switch n := a.(type) {
+		case types.Int:
+			f = float64(n)
+		case types.Uint:
+			f = float64(n)
+		case types.Double:
+			f = float64(n)
+		default:
+			return reflect.Value{}, fmt.Errorf("expected a number, got %s", a.Type())
+		}
+		return reflect.ValueOf(f).Convert(t), nil
+	}
+
+	v, err := a.ConvertToNative(t)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(v), nil
+}
+
+// celToNative converts the cel values to the plain go ones: lists and maps become []interface{} and map[string]interface{}.
+func celToNative(v ref.Val) interface{} {
+	switch tv := v.(type) {
+	case types.Null:
+		return nil
+	case traits.Mapper:
+		m := make(map[string]interface{})
+		it := tv.Iterator()
+		for it.HasNext() == types.True {
+			k := it.Next()
+			m[fmt.Sprint(celToNative(k))] = celToNative(tv.Get(k))
+		}
+		return m
+	case traits.Lister:
+		var l []interface{}
+		it := tv.Iterator()
+		for it.HasNext() == types.True {
+			l = append(l, celToNative(it.Next()))
+		}
+		return l
+	}
+	return v.Value()
+}
+
+// celExpression is a parsed cel expression. It is type checked, and its program built, once for each combination of the declared types of its identifiers.
+type celExpression struct {
+	env      *cel.Env
+	parsed   *cel.Ast
+	idents   []string
+	programs sync.Map
+}
+
+// compileCelExpression parses and type checks the expression. The identifiers are checked against the declared types of the process vars found in the
+// evaluation context, the undeclared ones are dyn.
+func compileCelExpression(expr string) (CompiledExpression, error) {
+	env, err := celEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, iss := env.Parse(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+
+	idents := make(map[string]struct{})
+	ast.PostOrderVisit(parsed.NativeRep().Expr(), ast.NewExprVisitor(func(e ast.Expr) {
+		if e.Kind() == ast.IdentKind && e.AsIdent() != celCaseFuncsVarName {
+			idents[e.AsIdent()] = struct{}{}
+		}
+	}))
+
+	c := &celExpression{env: env, parsed: parsed}
+	for n := range idents {
+		c.idents = append(c.idents, n)
+	}
+	sort.Strings(c.idents)
+
+	// The untyped program reports the errors that do not depend on the types at compile time.
+	if _, err = c.program(nil); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, vars interface{}) (interface{}, error) {
+		prg, err := c.program(varTypesFromContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		return evalCelProgram(ctx, prg, vars)
+	}, nil
+}
+
+func (c *celExpression) program(varTypes map[string]VarType) (cel.Program, error) {
+	var signature strings.Builder
+	for _, n := range c.idents {
+		if t, ok := varTypes[n]; ok && t.Type != "" {
+			signature.WriteString(n + ":" + t.Type + ";")
+		}
+	}
+
+	if prg, ok := c.programs.Load(signature.String()); ok {
+		return prg.(cel.Program), nil
+	}
+
+	env := c.env
+	if len(c.idents) > 0 {
+		decls := make([]cel.EnvOption, 0, len(c.idents))
+		for _, n := range c.idents {
+			decls = append(decls, cel.Variable(n, celVarType(varTypes[n])))
+		}
+
+		var err error
+		env, err = env.Extend(decls...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	checked, iss := env.Check(c.parsed)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+
+	prg, err := env.Program(checked)
+	if err != nil {
+		return nil, err
+	}
+
+	c.programs.Store(signature.String(), prg)
+	return prg, nil
+}
+
+// celVarType maps the declared types of the process vars to the cel ones. Decimals, dates and datetimes are stored as strings and are declared as such:
+// comparing them to numbers is a type error instead of a comparison of strings.
+func celVarType(t VarType) *cel.Type {
+	switch t.Type {
+	case config.ProcessVarTypeString, config.ProcessVarTypeDecimal, config.ProcessVarTypeDate, config.ProcessVarTypeDateTime:
+		return cel.StringType
+	case config.ProcessVarTypeInt:
+		return cel.IntType
+	case config.ProcessVarTypeFloat:
+		return cel.DoubleType
+	case config.ProcessVarTypeBool:
+		return cel.BoolType
+	case config.ProcessVarTypeObject:
+		return cel.MapType(cel.StringType, cel.DynType)
+	case config.ProcessVarTypeArray:
+		return cel.ListType(cel.DynType)
+	}
+	return cel.DynType
+}
+
+func evalCelProgram(ctx context.Context, prg cel.Program, vars interface{}) (interface{}, error) {
+	out, _, err := prg.ContextEval(ctx, celActivation(vars))
+	if err != nil {
+		return nil, err
+	}
+
+	return celToNative(out), nil
+}
+
+// celCaseFuncs are the functions of the process vars. They are passed to the case bound functions as the value of the hidden variable celCaseFuncsVarName.
+type celCaseFuncs map[string]interface{}
+
+var celCaseFuncsType = cel.OpaqueType("chorus.CaseFuncs")
+
+func (f celCaseFuncs) ConvertToNative(typeDesc reflect.Type) (any, error) {
+	return nil, fmt.Errorf("case functions cannot be converted to %v", typeDesc)
+}
+
+func (f celCaseFuncs) ConvertToType(typeVal ref.Type) ref.Val {
+	return types.NewErr("case functions cannot be converted to %s", typeVal.TypeName())
+}
+
+func (f celCaseFuncs) Equal(other ref.Val) ref.Val {
+	return types.False
+}
+
+func (f celCaseFuncs) Type() ref.Type {
+	return celCaseFuncsType
+}
+
+func (f celCaseFuncs) Value() any {
+	return map[string]interface{}(f)
+}
+
+// celActivation collects the values of the process vars. The functions are not variables, they are collected in the case functions.
+func celActivation(vars interface{}) map[string]interface{} {
+	var values map[string]interface{}
+	switch tv := vars.(type) {
+	case PVValues:
+		values = tv
+	case *ProcessVars:
+		if tv != nil {
+			values = tv.V
+		}
+	case map[string]interface{}:
+		values = tv
+	}
+
+	activation := make(map[string]interface{}, len(values)+1)
+	funcs := make(celCaseFuncs)
+	for n, v := range values {
+		if v != nil && reflect.TypeOf(v).Kind() == reflect.Func {
+			funcs[n] = v
+			continue
+		}
+		activation[n] = v
+	}
+	activation[celCaseFuncsVarName] = funcs
+
+	return activation
+}
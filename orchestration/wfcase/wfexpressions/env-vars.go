@@ -0,0 +1,82 @@
+package wfexpressions
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EnvVarsPolicy restricts the environment variables that can be read by the variable references, e.g. {env:REGION} or the bare {REGION}.
+type EnvVarsPolicy struct {
+	// SecureMode disables the bare references: only the env: references to the allowed variables are resolved.
+	SecureMode bool `yaml:"secure-mode,omitempty" mapstructure:"secure-mode,omitempty" json:"secure-mode,omitempty"`
+
+	// Allowed are the names of the variables that can be read, a trailing * matches the names with that prefix. Outside secure mode an empty list allows
+	// all the variables, in secure mode none.
+	Allowed []string `yaml:"allowed,omitempty" mapstructure:"allowed,omitempty" json:"allowed,omitempty"`
+}
+
+// envVars is the policy of the process. The default keeps the lookup of any variable for backward compatibility.
+var envVars = struct {
+	mu     sync.RWMutex
+	policy EnvVarsPolicy
+}{}
+
+// SetEnvVarsPolicy sets the policy of the process. It is meant to be called at startup, before any orchestration is executed, with the policy read
+// from the config of the application, e.g.
+//
+//	env-vars:
+//	  secure-mode: true
+//	  allowed: [ "REGION", "APP_*" ]
+func SetEnvVarsPolicy(p EnvVarsPolicy) {
+	envVars.mu.Lock()
+	defer envVars.mu.Unlock()
+
+	envVars.policy = p
+}
+
+func GetEnvVarsPolicy() EnvVarsPolicy {
+	envVars.mu.RLock()
+	defer envVars.mu.RUnlock()
+
+	return envVars.policy
+}
+
+func (p EnvVarsPolicy) IsAllowed(name string) bool {
+	if len(p.Allowed) == 0 {
+		return !p.SecureMode
+	}
+
+	for _, a := range p.Allowed {
+		if prefix, ok := strings.CutSuffix(a, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if a == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookupEnv reads an environment variable if allowed by the policy. Bare references are the ones without the env: prefix. Denied lookups are logged since
+// they usually are either a misconfiguration or an attempt to read credentials.
+func lookupEnv(name string, bare bool) (string, bool) {
+	const semLogContext = "env-vars::lookup"
+
+	policy := GetEnvVarsPolicy()
+	if bare && policy.SecureMode {
+		log.Warn().Str("name", name).Msg(semLogContext + " - bare environment variable reference denied in secure mode, use the env: prefix")
+		return "", false
+	}
+
+	if !policy.IsAllowed(name) {
+		log.Warn().Str("name", name).Msg(semLogContext + " - environment variable not in the allow-list")
+		return "", false
+	}
+
+	return os.LookupEnv(name)
+}
@@ -0,0 +1,118 @@
+package wfexpressions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/PaesslerAG/gval"
+)
+
+const (
+	ExpressionLanguageGval = "gval"
+
+	// compiledExpressionsCacheSize bounds the cache since the values of the process vars are interpolated before evaluation and every request may produce
+	// a different text. The cache is cleared when full.
+	compiledExpressionsCacheSize = 4096
+)
+
+// gvalLanguage is the language, custom functions included, the gval expressions are compiled with. It is created once, gval.Evaluate creates it on each call.
+var gvalLanguage = gval.NewLanguage(gval.Full(), gval.Function(HarEntryFunctionName, harEntryFunction))
+
+// CompiledExpression evaluates an expression, whatever the language, against the process vars.
+type CompiledExpression func(ctx context.Context, vars interface{}) (interface{}, error)
+
+type compiledExpressionKey struct {
+	language string
+	text     string
+}
+
+var compiledExpressions = struct {
+	mu          sync.RWMutex
+	expressions map[compiledExpressionKey]CompiledExpression
+}{expressions: make(map[compiledExpressionKey]CompiledExpression)}
+
+type expressionLanguageContextKey struct{}
+
+// ExpressionLanguage strips the language prefix of the expression, if any, and returns the language the expression has to be evaluated with.
+func ExpressionLanguage(defaultLanguage string, expr string) (string, string) {
+	switch {
+	case strings.HasPrefix(expr, CelExpressionPrefix):
+		return ExpressionLanguageCel, strings.TrimPrefix(expr, CelExpressionPrefix)
+	case strings.HasPrefix(expr, GvalExpressionPrefix):
+		return ExpressionLanguageGval, strings.TrimPrefix(expr, GvalExpressionPrefix)
+	case defaultLanguage == "":
+		return ExpressionLanguageGval, expr
+	}
+
+	return defaultLanguage, expr
+}
+
+// CompileExpression compiles an expression with gval unless prefixed by the language.
+func CompileExpression(expr string) (CompiledExpression, error) {
+	return CompileExpressionWithLanguage(ExpressionLanguageGval, expr)
+}
+
+// CompileExpressionWithLanguage returns the compiled expression from the process wide cache, compiling it on the first request. The language is the one
+// of the prefix of the expression or the default one.
+func CompileExpressionWithLanguage(defaultLanguage string, expr string) (CompiledExpression, error) {
+	lang, text := ExpressionLanguage(defaultLanguage, expr)
+	k := compiledExpressionKey{language: lang, text: text}
+
+	compiledExpressions.mu.RLock()
+	eval, ok := compiledExpressions.expressions[k]
+	compiledExpressions.mu.RUnlock()
+	if ok {
+		return eval, nil
+	}
+
+	var err error
+	switch lang {
+	case ExpressionLanguageGval:
+		var gvalEval gval.Evaluable
+		gvalEval, err = gvalLanguage.NewEvaluable(text)
+		eval = CompiledExpression(gvalEval)
+	case ExpressionLanguageCel:
+		eval, err = compileCelExpression(text)
+	default:
+		err = fmt.Errorf("unsupported expression language %s", lang)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	compiledExpressions.mu.Lock()
+	defer compiledExpressions.mu.Unlock()
+	if len(compiledExpressions.expressions) >= compiledExpressionsCacheSize {
+		compiledExpressions.expressions = make(map[compiledExpressionKey]CompiledExpression)
+	}
+	compiledExpressions.expressions[k] = eval
+
+	return eval, nil
+}
+
+// EvaluateExpression is the cached counterpart of gval.Evaluate.
+func EvaluateExpression(expr string, vars interface{}) (interface{}, error) {
+	return EvaluateExpressionContext(context.Background(), expr, vars)
+}
+
+// EvaluateExpressionContext evaluates the expression with the default language set in the context, gval if missing.
+func EvaluateExpressionContext(ctx context.Context, expr string, vars interface{}) (interface{}, error) {
+	lang, _ := ctx.Value(expressionLanguageContextKey{}).(string)
+	eval, err := CompileExpressionWithLanguage(lang, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return eval(ctx, vars)
+}
+
+func withExpressionLanguage(ctx context.Context, lang string) context.Context {
+	if lang == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, expressionLanguageContextKey{}, lang)
+}
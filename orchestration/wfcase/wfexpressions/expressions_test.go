@@ -0,0 +1,122 @@
+package wfexpressions_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateExpression(t *testing.T) {
+	for _, vars := range []map[string]interface{}{{"amount": 20, "kind": "x"}, {"amount": 5, "kind": "x"}} {
+		v, err := wfexpressions.EvaluateExpression(`amount > 10 && kind == "x"`, vars)
+		require.NoError(t, err)
+		require.Equal(t, vars["amount"] == 20, v)
+	}
+
+	_, err := wfexpressions.CompileExpression(`amount >`)
+	require.Error(t, err)
+}
+
+func TestCelExpression(t *testing.T) {
+	wfexpressions.RegisterCelFunctions(map[string]interface{}{
+		"left":   func(s string, n float64) string { return s[:int(n)] },
+		"concat": func(elems ...interface{}) string { return fmt.Sprint(elems...) },
+		"dict":   func(dict string, elems ...string) string { return "" },
+	}, "dict")
+
+	vars := map[string]interface{}{
+		"amount": 20.0,
+		"kind":   "x",
+		"items":  []interface{}{map[string]interface{}{"code": "a"}, map[string]interface{}{"code": "b"}},
+		"dict":   func(dict string, elems ...string) string { return dict + ":" + strings.Join(elems, ",") },
+	}
+
+	v, err := wfexpressions.EvaluateExpression(`cel:amount > 10 && kind in ["x", "y"]`, vars)
+	require.NoError(t, err)
+	require.Equal(t, true, v)
+
+	v, err = wfexpressions.EvaluateExpression(`cel:items.exists(i, i.code == "b") && size(items) == 2`, vars)
+	require.NoError(t, err)
+	require.Equal(t, true, v)
+
+	v, err = wfexpressions.EvaluateExpression(`cel:left("hello", 2) + concat(1, "-", 2)`, vars)
+	require.NoError(t, err)
+	require.Equal(t, "he1-2", v)
+
+	v, err = wfexpressions.EvaluateExpression(`cel:dict("codes", kind, "y")`, vars)
+	require.NoError(t, err)
+	require.Equal(t, "codes:x,y", v)
+
+	// The default language is overridden by the prefix of the expression.
+	eval, err := wfexpressions.CompileExpressionWithLanguage(wfexpressions.ExpressionLanguageCel, `gval:amount > 10 && kind == "x"`)
+	require.NoError(t, err)
+	v, err = eval(context.Background(), vars)
+	require.NoError(t, err)
+	require.Equal(t, true, v)
+
+	// Type errors are detected at compile time.
+	_, err = wfexpressions.CompileExpressionWithLanguage(wfexpressions.ExpressionLanguageCel, `left(amount > 10, 2)`)
+	require.Error(t, err)
+}
+
+func TestCelCaseBoundFunctions(t *testing.T) {
+	wfexpressions.RegisterCelFunctions(map[string]interface{}{
+		"dict":  func(dict string, elems ...string) string { return "" },
+		"twice": func(s string) string { return s + s },
+	}, "dict")
+
+	// The same compiled expression is evaluated with the functions of different cases.
+	for _, prefix := range []string{"a", "b"} {
+		vars := map[string]interface{}{
+			"dict": func(dict string, elems ...string) string { return prefix + dict + ":" + strings.Join(elems, ",") },
+		}
+
+		v, err := wfexpressions.EvaluateExpression(`cel:dict(dict("codes", "x"))`, vars)
+		require.NoError(t, err)
+		require.Equal(t, prefix+prefix+"codes:x:", v)
+	}
+
+	_, err := wfexpressions.EvaluateExpression(`cel:dict("codes", "x")`, map[string]interface{}{})
+	require.Error(t, err)
+
+	v, err := wfexpressions.EvaluateExpression(`cel:twice("a")`, nil)
+	require.NoError(t, err)
+	require.Equal(t, "aa", v)
+
+	// The expressions compiled with the previous functions are discarded.
+	wfexpressions.RegisterCelFunctions(map[string]interface{}{"twice": func(s string) string { return s + "," + s }})
+	v, err = wfexpressions.EvaluateExpression(`cel:twice("a")`, nil)
+	require.NoError(t, err)
+	require.Equal(t, "a,a", v)
+}
+
+func TestCelTypedVars(t *testing.T) {
+	vars := wfexpressions.NewProcessVars()
+	vars.WithExpressionLanguage(wfexpressions.ExpressionLanguageCel)
+	require.NoError(t, vars.DeclareAndSet("n", config.ProcessVar{Name: "n", Type: config.ProcessVarTypeInt}, 2))
+	require.NoError(t, vars.DeclareAndSet("amount", config.ProcessVar{Name: "amount", Type: config.ProcessVarTypeDecimal}, "10.50"))
+
+	v, err := vars.Eval(`n + 1`)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), v)
+
+	// Decimals are strings: the comparison with a number is a type error, not a comparison of strings.
+	_, err = vars.EvalToBool(`amount > 10`)
+	require.Error(t, err)
+
+	ok, err := vars.EvalToBool(`amount == "10.5"`)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// The same expression is checked against the types of the vars it is evaluated with.
+	_, err = vars.Eval(`n + "x"`)
+	require.Error(t, err)
+	v, err = wfexpressions.EvaluateExpression(`cel:n + "x"`, map[string]interface{}{"n": "y"})
+	require.NoError(t, err)
+	require.Equal(t, "yx", v)
+}
@@ -0,0 +1,147 @@
+package wfexpressions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/PaesslerAG/jsonpath"
+)
+
+const (
+	// HarEntryVariablePrefix is the prefix of the variable references to any entry of the case, e.g. {e:get-customer:response:body:$.name} or
+	// {e:get-customer#1:response:status} for a given instance of the entry.
+	HarEntryVariablePrefix = "e:"
+
+	// HarEntryFunctionName is the name of the gval function that resolves the same references, e.g. entry("get-customer:response:status") == 200.
+	HarEntryFunctionName = "entry"
+
+	HarEntryPartRequest  = "request"
+	HarEntryPartResponse = "response"
+	HarEntryPartBody     = "body"
+	HarEntryPartHeaders  = "headers"
+	HarEntryPartStatus   = "status"
+)
+
+// HarEntryLookup returns the entry of the case with the given id. Ids that are not indexed refer to the last instance of the entry.
+type HarEntryLookup func(entryId string) (*har.Entry, error)
+
+type harEntryLookupContextKey struct{}
+
+// ResolveHarEntryReference resolves a reference of the form <entry-id>:<request|response>:<body|headers|status>[:<selector>]. The selector of a body is a
+// JSONPath, whole body if missing, the one of the headers is the name of a header, all the headers if missing. Status is available for responses only.
+func ResolveHarEntryReference(lookup HarEntryLookup, ref string) (interface{}, error) {
+	if lookup == nil {
+		return nil, errors.New("har entries are not available in this context")
+	}
+
+	parts := strings.SplitN(ref, ":", 4)
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("invalid har entry reference %s", ref)
+	}
+
+	selector := ""
+	if len(parts) == 4 {
+		selector = parts[3]
+	}
+
+	entry, err := lookup(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, fmt.Errorf("cannot find har entry %s", parts[0])
+	}
+
+	switch parts[1] {
+	case HarEntryPartRequest:
+		if entry.Request == nil {
+			return nil, fmt.Errorf("har entry %s has no request", parts[0])
+		}
+
+		switch parts[2] {
+		case HarEntryPartBody:
+			var data []byte
+			if entry.Request.PostData != nil {
+				data = entry.Request.PostData.Data
+			}
+			return selectHarEntryBody(data, selector)
+		case HarEntryPartHeaders:
+			return selectHarEntryHeaders(entry.Request.Headers, selector), nil
+		}
+
+	case HarEntryPartResponse:
+		if entry.Response == nil {
+			return nil, fmt.Errorf("har entry %s has no response", parts[0])
+		}
+
+		switch parts[2] {
+		case HarEntryPartBody:
+			var data []byte
+			if entry.Response.Content != nil {
+				data = entry.Response.Content.Data
+			}
+			return selectHarEntryBody(data, selector)
+		case HarEntryPartHeaders:
+			return selectHarEntryHeaders(entry.Response.Headers, selector), nil
+		case HarEntryPartStatus:
+			return entry.Response.Status, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported har entry reference %s", ref)
+}
+
+// selectHarEntryBody applies the selector to the JSON body. Bodies that are not JSON can only be selected as a whole string.
+func selectHarEntryBody(data []byte, selector string) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		if selector == "" || selector == "$" {
+			return string(data), nil
+		}
+		return nil, err
+	}
+
+	if selector == "" {
+		return v, nil
+	}
+
+	return jsonpath.Get(selector, v)
+}
+
+func selectHarEntryHeaders(headers har.NameValuePairs, selector string) interface{} {
+	if selector != "" {
+		return headers.GetFirst(selector).Value
+	}
+
+	m := make(map[string]interface{}, len(headers))
+	for _, h := range headers {
+		if _, ok := m[h.Name]; !ok {
+			m[h.Name] = h.Value
+		}
+	}
+
+	return m
+}
+
+func withHarEntryLookup(ctx context.Context, lookup HarEntryLookup) context.Context {
+	if lookup == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, harEntryLookupContextKey{}, lookup)
+}
+
+// harEntryFunction is the gval counterpart of the e: variable references. The entries are taken from the evaluation context.
+func harEntryFunction(ctx context.Context, ref string) (interface{}, error) {
+	lookup, _ := ctx.Value(harEntryLookupContextKey{}).(HarEntryLookup)
+	return ResolveHarEntryReference(lookup, ref)
+}
@@ -0,0 +1,357 @@
+package wfexpressions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/shopspring/decimal"
+)
+
+// VarType is the declared type of a process var. The values are stored as:
+//   - string: string, numbers and booleans are formatted, objects and arrays are marshalled to json
+//   - int: int64, floats with a fractional part are errors
+//   - float: float64
+//   - decimal: the canonical string of the number, not to lose precision
+//   - bool: bool, strings are parsed as in strconv.ParseBool
+//   - date, datetime: string formatted with the layout
+//   - object: map[string]interface{}, strings are unmarshalled
+//   - array: []interface{}, strings are unmarshalled
+//
+// Nil values are kept as they are.
+//
+// Decimals and dates are strings to the expressions. In gval two decimals concatenate on + and compare as strings (i.e. "9.5" > "10.5"), a decimal
+// compared to a number literal is converted to float64. The amt functions do the arithmetic and the comparisons without losing precision, e.g.
+// amtCmp("cent", a, "decimal-2", b, "decimal-2"). In cel they are declared as strings and mixing them with numbers is a type error.
+// Dates compare as the times they represent only if the layout is ISO like (e.g. the default ones): with 02/01/2006 "31/12/2024" > "01/01/2025".
+// The datetimes of the RFC3339 default compare as times if they have the same offset.
+type VarType struct {
+	Type   string
+	Layout string
+}
+
+type varTypesContextKey struct{}
+
+// withVarTypes makes the declared types of the process vars available to the evaluation of the expressions. The cel expressions are type checked against them.
+func withVarTypes(ctx context.Context, varTypes map[string]VarType) context.Context {
+	if len(varTypes) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, varTypesContextKey{}, varTypes)
+}
+
+func varTypesFromContext(ctx context.Context) map[string]VarType {
+	varTypes, _ := ctx.Value(varTypesContextKey{}).(map[string]VarType)
+	return varTypes
+}
+
+func NewVarType(pv config.ProcessVar) (VarType, error) {
+	if err := pv.ValidateType(); err != nil {
+		return VarType{}, err
+	}
+
+	return VarType{Type: pv.Type, Layout: pv.LayoutWithDefault()}, nil
+}
+
+func (t VarType) Coerce(value interface{}) (interface{}, error) {
+	if value == nil || t.Type == "" {
+		return value, nil
+	}
+
+	var v interface{}
+	var err error
+	switch t.Type {
+	case config.ProcessVarTypeString:
+		v, err = coerceToString(value)
+	case config.ProcessVarTypeInt:
+		v, err = coerceToInt(value)
+	case config.ProcessVarTypeFloat:
+		v, err = coerceToFloat(value)
+	case config.ProcessVarTypeDecimal:
+		v, err = coerceToDecimal(value)
+	case config.ProcessVarTypeBool:
+		v, err = coerceToBool(value)
+	case config.ProcessVarTypeDate, config.ProcessVarTypeDateTime:
+		v, err = coerceToTime(value, t.Layout)
+	case config.ProcessVarTypeObject:
+		v, err = coerceToObject(value)
+	case config.ProcessVarTypeArray:
+		v, err = coerceToArray(value)
+	default:
+		err = fmt.Errorf("unsupported type")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot coerce %v (%T) to %s: %w", value, value, t.Type, err)
+	}
+
+	return v, nil
+}
+
+// JsonSchema returns the json schema of the values of the type.
+func (t VarType) JsonSchema() map[string]interface{} {
+	switch t.Type {
+	case config.ProcessVarTypeString:
+		return map[string]interface{}{"type": "string"}
+	case config.ProcessVarTypeInt:
+		return map[string]interface{}{"type": "integer"}
+	case config.ProcessVarTypeFloat:
+		return map[string]interface{}{"type": "number"}
+	case config.ProcessVarTypeDecimal:
+		return map[string]interface{}{"type": "string", "format": "decimal"}
+	case config.ProcessVarTypeBool:
+		return map[string]interface{}{"type": "boolean"}
+	case config.ProcessVarTypeDate:
+		return map[string]interface{}{"type": "string", "format": "date", "x-layout": t.Layout}
+	case config.ProcessVarTypeDateTime:
+		return map[string]interface{}{"type": "string", "format": "date-time", "x-layout": t.Layout}
+	case config.ProcessVarTypeObject:
+		return map[string]interface{}{"type": "object"}
+	case config.ProcessVarTypeArray:
+		return map[string]interface{}{"type": "array"}
+	}
+
+	return map[string]interface{}{}
+}
+
+func coerceToString(value interface{}) (interface{}, error) {
+	switch tv := value.(type) {
+	case string:
+		return tv, nil
+	case []byte:
+		return string(tv), nil
+	case bool:
+		return strconv.FormatBool(tv), nil
+	case time.Time:
+		return tv.Format(time.RFC3339), nil
+	case fmt.Stringer:
+		return tv.String(), nil
+	}
+
+	if f, ok := toFloat(value); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func coerceToInt(value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	}
+
+	f, ok := toFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("not a number")
+	}
+
+	if f != math.Trunc(f) {
+		return nil, fmt.Errorf("not an integer")
+	}
+
+	return int64(f), nil
+}
+
+func coerceToFloat(value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	}
+
+	f, ok := toFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("not a number")
+	}
+
+	return f, nil
+}
+
+func coerceToDecimal(value interface{}) (interface{}, error) {
+	var d decimal.Decimal
+	var err error
+	switch tv := value.(type) {
+	case string:
+		d, err = decimal.NewFromString(strings.TrimSpace(tv))
+	case decimal.Decimal:
+		d = tv
+	default:
+		f, ok := toFloat(value)
+		if !ok {
+			return nil, fmt.Errorf("not a number")
+		}
+		d = decimal.NewFromFloat(f)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d.String(), nil
+}
+
+func coerceToBool(value interface{}) (interface{}, error) {
+	switch tv := value.(type) {
+	case bool:
+		return tv, nil
+	case string:
+		return strconv.ParseBool(strings.TrimSpace(tv))
+	}
+
+	return nil, fmt.Errorf("not a boolean")
+}
+
+func coerceToTime(value interface{}, layout string) (interface{}, error) {
+	switch tv := value.(type) {
+	case time.Time:
+		return tv.Format(layout), nil
+	case string:
+		tm, err := time.Parse(layout, strings.TrimSpace(tv))
+		if err != nil {
+			return nil, err
+		}
+		return tm.Format(layout), nil
+	}
+
+	return nil, fmt.Errorf("not a date")
+}
+
+func coerceToObject(value interface{}) (interface{}, error) {
+	switch tv := value.(type) {
+	case map[string]interface{}:
+		return tv, nil
+	case string:
+		var m map[string]interface{}
+		err := json.Unmarshal([]byte(tv), &m)
+		return m, err
+	case []byte:
+		var m map[string]interface{}
+		err := json.Unmarshal(tv, &m)
+		return m, err
+	}
+
+	if reflect.ValueOf(value).Kind() == reflect.Map {
+		return roundTripJson[map[string]interface{}](value)
+	}
+
+	return nil, fmt.Errorf("not an object")
+}
+
+func coerceToArray(value interface{}) (interface{}, error) {
+	switch tv := value.(type) {
+	case []interface{}:
+		return tv, nil
+	case string:
+		var a []interface{}
+		err := json.Unmarshal([]byte(tv), &a)
+		return a, err
+	case []byte:
+		var a []interface{}
+		err := json.Unmarshal(tv, &a)
+		return a, err
+	}
+
+	if k := reflect.ValueOf(value).Kind(); k == reflect.Slice || k == reflect.Array {
+		return roundTripJson[[]interface{}](value)
+	}
+
+	return nil, fmt.Errorf("not an array")
+}
+
+func roundTripJson[T any](value interface{}) (interface{}, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var v T
+	err = json.Unmarshal(b, &v)
+	return v, err
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch tv := value.(type) {
+	case json.Number:
+		f, err := tv.Float64()
+		return f, err == nil
+	case bool, string:
+		return 0, false
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+
+	return 0, false
+}
+
+// Declare sets the type of a variable, the values set afterward are coerced to it. A variable cannot be redeclared with a different type.
+func (vs *ProcessVars) Declare(n string, t VarType) error {
+	if t.Type == "" {
+		return nil
+	}
+
+	if vs.types == nil {
+		vs.types = make(map[string]VarType)
+	}
+
+	if declared, ok := vs.types[n]; ok && declared != t {
+		return fmt.Errorf("process var %s declared as %s cannot be redeclared as %s", n, declared.Type, t.Type)
+	}
+
+	vs.types[n] = t
+	return nil
+}
+
+// DeclareAndSet sets the variable as defined in the config, with the given name and value, declaring its type first, if any.
+func (vs *ProcessVars) DeclareAndSet(n string, pv config.ProcessVar, value interface{}) error {
+	if pv.Type != "" {
+		t, err := NewVarType(pv)
+		if err != nil {
+			return err
+		}
+
+		if err = vs.Declare(n, t); err != nil {
+			return err
+		}
+	}
+
+	return vs.Set(n, value, pv.GlobalScope, pv.Ttl, pv.DltHeader)
+}
+
+// Schema returns the json schema of the declared variables of the case.
+func (vs *ProcessVars) Schema() map[string]interface{} {
+	props := make(map[string]interface{}, len(vs.types))
+	for n, t := range vs.types {
+		props[n] = t.JsonSchema()
+	}
+
+	return map[string]interface{}{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"type":       "object",
+		"properties": props,
+	}
+}
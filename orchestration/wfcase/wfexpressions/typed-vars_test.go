@@ -0,0 +1,94 @@
+package wfexpressions_test
+
+import (
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/funcs/purefuncs/amt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypedProcessVars(t *testing.T) {
+	vars := wfexpressions.NewProcessVars()
+
+	sets := []struct {
+		pv       config.ProcessVar
+		value    interface{}
+		expected interface{}
+	}{
+		{config.ProcessVar{Name: "s", Type: config.ProcessVarTypeString}, 12.5, "12.5"},
+		{config.ProcessVar{Name: "i", Type: config.ProcessVarTypeInt}, " 42", int64(42)},
+		{config.ProcessVar{Name: "i", Type: config.ProcessVarTypeInt}, 7.0, int64(7)},
+		{config.ProcessVar{Name: "f", Type: config.ProcessVarTypeFloat}, "3.25", 3.25},
+		{config.ProcessVar{Name: "d", Type: config.ProcessVarTypeDecimal}, "0010.50", "10.5"},
+		{config.ProcessVar{Name: "b", Type: config.ProcessVarTypeBool}, "true", true},
+		{config.ProcessVar{Name: "dt", Type: config.ProcessVarTypeDate, Layout: "02/01/2006"}, "31/12/2024", "31/12/2024"},
+		{config.ProcessVar{Name: "o", Type: config.ProcessVarTypeObject}, `{"a": 1}`, map[string]interface{}{"a": 1.0}},
+		{config.ProcessVar{Name: "a", Type: config.ProcessVarTypeArray}, []string{"x"}, []interface{}{"x"}},
+		{config.ProcessVar{Name: "u"}, "12", "12"},
+	}
+
+	for _, s := range sets {
+		require.NoError(t, vars.DeclareAndSet(s.pv.Name, s.pv, s.value), s.pv.Name)
+		require.Equal(t, s.expected, vars.V[s.pv.Name], s.pv.Name)
+	}
+
+	// Values set without a definition are coerced to the declared type.
+	require.NoError(t, vars.Set("i", "11", false, 0, false))
+	require.Equal(t, int64(11), vars.V["i"])
+
+	require.Error(t, vars.Set("i", 1.5, false, 0, false))
+	require.Error(t, vars.Set("dt", "2024-12-31", false, 0, false))
+	require.Error(t, vars.DeclareAndSet("i", config.ProcessVar{Name: "i", Type: config.ProcessVarTypeFloat}, 1.0))
+	require.Error(t, config.ProcessVar{Name: "x", Type: "money"}.ValidateType())
+
+	schema := vars.Schema()
+	require.Equal(t, map[string]interface{}{"type": "integer"}, schema["properties"].(map[string]interface{})["i"])
+	require.NotContains(t, schema["properties"], "u")
+}
+
+// TestStringTypedVarsInExpressions pins the behaviour of the types stored as strings in the gval expressions.
+func TestStringTypedVarsInExpressions(t *testing.T) {
+	vars := wfexpressions.NewProcessVars()
+	require.NoError(t, vars.DeclareAndSet("a", config.ProcessVar{Name: "a", Type: config.ProcessVarTypeDecimal}, 9.5))
+	require.NoError(t, vars.DeclareAndSet("b", config.ProcessVar{Name: "b", Type: config.ProcessVarTypeDecimal}, "10.50"))
+
+	// Two decimals are strings: + concatenates and the comparison is the one of the strings.
+	v, err := vars.Eval(`a + b`)
+	require.NoError(t, err)
+	require.Equal(t, "9.510.5", v)
+
+	ok, err := vars.EvalToBool(`a > b`)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// A decimal compared to a number is converted to a number.
+	ok, err = vars.EvalToBool(`a < 10 && b > 10`)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// The amt functions compare the amounts.
+	ok, err = amt.AmtCmp(amt.Cent, vars.V["a"].(string), amt.DecimalCent, vars.V["b"].(string), amt.DecimalCent)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Dates compare as times with ISO layouts only.
+	require.NoError(t, vars.DeclareAndSet("d1", config.ProcessVar{Name: "d1", Type: config.ProcessVarTypeDate}, "2024-12-31"))
+	require.NoError(t, vars.DeclareAndSet("d2", config.ProcessVar{Name: "d2", Type: config.ProcessVarTypeDate}, "2025-01-01"))
+	require.NoError(t, vars.DeclareAndSet("e1", config.ProcessVar{Name: "e1", Type: config.ProcessVarTypeDate, Layout: "02/01/2006"}, "31/12/2024"))
+	require.NoError(t, vars.DeclareAndSet("e2", config.ProcessVar{Name: "e2", Type: config.ProcessVarTypeDate, Layout: "02/01/2006"}, "01/01/2025"))
+
+	ok, err = vars.EvalToBool(`d1 < d2`)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = vars.EvalToBool(`e1 < e2`)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// In cel the decimals are declared as strings.
+	vars.WithExpressionLanguage(wfexpressions.ExpressionLanguageCel)
+	_, err = vars.EvalToBool(`a < 10`)
+	require.Error(t, err)
+}
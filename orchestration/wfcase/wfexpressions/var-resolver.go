@@ -1,20 +1,22 @@
 package wfexpressions
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 	"reflect"
+	"regexp"
 	"strings"
 	"text/template"
 
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/constants"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/globals"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/secrets"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms/kz"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/templateutil"
 	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
-	"github.com/PaesslerAG/gval"
 	"github.com/google/uuid"
 
 	"github.com/PaesslerAG/jsonpath"
@@ -23,6 +25,12 @@ import (
 
 type EvaluatorOption func(r *Evaluator) error
 
+// StrictMode when enabled turns the variable references that cannot be resolved, and have no onf default, into errors reporting the activity.
+type StrictMode struct {
+	Enabled  bool
+	Activity string
+}
+
 type Evaluator struct {
 	Name string
 
@@ -33,6 +41,28 @@ type Evaluator struct {
 	params      har.Params
 
 	tempVarsw []string
+
+	strict     StrictMode
+	unresolved []string
+
+	entries HarEntryLookup
+
+	// secrets enables the secret: references, off unless in the places meant for credentials.
+	secrets bool
+}
+
+// WithSecrets enables the secret: references until the returned function is called. The evaluators are shared by the activity so the callers restore
+// them as soon as the credentials are resolved, e.g.
+//
+//	defer resolver.WithSecrets()()
+func (pvr *Evaluator) WithSecrets() func() {
+	prev := pvr.secrets
+	pvr.secrets = true
+	return func() { pvr.secrets = prev }
+}
+
+func (pvr *Evaluator) WithStrictMode(m StrictMode) {
+	pvr.strict = m
 }
 
 func (pvr *Evaluator) ClearTempVariables() {
@@ -45,7 +75,7 @@ func (pvr *Evaluator) VariableLookup(varName string, defaultValue string) (inter
 
 	var varValue interface{}
 	var ok bool
-	if len(pvr.vars.V) > 0 {
+	if pvr.vars != nil && len(pvr.vars.V) > 0 {
 		varValue, ok = pvr.vars.Lookup(varName, defaultValue)
 	}
 	/*
@@ -215,6 +245,20 @@ func WithParams(p []har.Param) EvaluatorOption {
 	}
 }
 
+func WithStrictMode(m StrictMode) EvaluatorOption {
+	return func(r *Evaluator) error {
+		r.WithStrictMode(m)
+		return nil
+	}
+}
+
+func WithHarEntries(lookup HarEntryLookup) EvaluatorOption {
+	return func(r *Evaluator) error {
+		r.entries = lookup
+		return nil
+	}
+}
+
 func NewEvaluator(aName string, opts ...EvaluatorOption) (*Evaluator, error) {
 	pvr := &Evaluator{Name: aName}
 
@@ -228,21 +272,68 @@ func NewEvaluator(aName string, opts ...EvaluatorOption) (*Evaluator, error) {
 	return pvr, nil
 }
 
-var resolverTypePrefix = []string{"$.", "$[", "h:", "p:", "v:", "g:"}
+// SecretVariablePrefix is the prefix of the references to the secrets of the provider, e.g. {secret:payments-api-key}.
+const SecretVariablePrefix = "secret:"
 
+var resolverTypePrefix = []string{"$.", "$[", "h:", "p:", "v:", "g:", HarEntryVariablePrefix, SecretVariablePrefix}
+
+// Interpolate resolves the variable references of s. In strict mode the references that cannot be resolved are reported with a SymphonyError.
 func (pvr *Evaluator) Interpolate(s string) (string, error) {
+	prev := pvr.unresolved
+	pvr.unresolved = nil
+	defer func() { pvr.unresolved = prev }()
+
+	// the ids of the indexed entries (i.e. get-customer#0) are not matched by the references of the resolver: the e: references are resolved first
+	// and their values put back once the other references are resolved, so that they are not interpolated in turn.
+	s, harEntryValues := pvr.resolveHarEntryReferences(s)
 	val, _, err := varResolver.ResolveVariables(s, varResolver.SimpleVariableReference, pvr.VarResolverFunc, true)
 	if err != nil {
 		return "", err
 	}
 
+	for i, v := range harEntryValues {
+		val = strings.Replace(val, harEntryPlaceholder(i), v, 1)
+	}
+
+	if len(pvr.unresolved) > 0 {
+		return "", smperror.NewExecutableServerError(
+			smperror.WithErrorAmbit(pvr.strict.Activity),
+			smperror.WithStep(pvr.Name),
+			smperror.WithErrorMessage("unresolved variable reference"),
+			smperror.WithDescription(fmt.Sprintf("could not resolve variables %s of expression %s", strings.Join(pvr.unresolved, ", "), s)))
+	}
+
 	return val, nil
 }
 
+// harEntryReferenceRegexp matches the e: references, the entry id possibly indexed.
+var harEntryReferenceRegexp = regexp.MustCompile(`{(!?` + HarEntryVariablePrefix + `[^{}]+)}`)
+
+// resolveHarEntryReferences replaces the e: references of s with placeholders and returns the resolved values. The deferred references are put back
+// as they are.
+func (pvr *Evaluator) resolveHarEntryReferences(s string) (string, []string) {
+	var values []string
+	s = harEntryReferenceRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		resolved, deferred := pvr.VarResolverFunc(s, m[1:len(m)-1])
+		if deferred {
+			resolved = varResolver.SimpleVariableReference.ToVar(resolved)
+		}
+
+		values = append(values, resolved)
+		return harEntryPlaceholder(len(values) - 1)
+	})
+
+	return s, values
+}
+
+func harEntryPlaceholder(i int) string {
+	return fmt.Sprintf("\x00%d\x00", i)
+}
+
 func (pvr *Evaluator) InterpolateMany(expr []string) ([]string, error) {
 	var resolved []string
 	for _, s := range expr {
-		val, _, err := varResolver.ResolveVariables(s, varResolver.SimpleVariableReference, pvr.VarResolverFunc, true)
+		val, err := pvr.Interpolate(s)
 		if err != nil {
 			return nil, err
 		}
@@ -271,7 +362,7 @@ func (pvr *Evaluator) InterpolateAndEvalToString(s string) (string, error) {
 
 func (pvr *Evaluator) InterpolateAndEval(s string) (interface{}, error) {
 	const semLogContext = "wf-evaluator::interpolate-eval"
-	val, _, err := varResolver.ResolveVariables(s, varResolver.SimpleVariableReference, pvr.VarResolverFunc, true)
+	val, err := pvr.Interpolate(s)
 	if err != nil {
 		return "", err
 	}
@@ -295,11 +386,26 @@ func (pvr *Evaluator) IsExpression(e string) (string, bool) {
 	return e, false
 }
 
+func (pvr *Evaluator) evalContext() context.Context {
+	lookup := pvr.entries
+	lang := ""
+	var varTypes map[string]VarType
+	if pvr.vars != nil {
+		if lookup == nil {
+			lookup = pvr.vars.entries
+		}
+		lang = pvr.vars.language
+		varTypes = pvr.vars.types
+	}
+
+	return withVarTypes(withExpressionLanguage(withHarEntryLookup(context.Background(), lookup), lang), varTypes)
+}
+
 func (pvr *Evaluator) Eval(s string) (interface{}, error) {
 	const semLogContext = "wf-evaluator::eval"
 
 	if s != "" {
-		varValue, err := gval.Evaluate(s, pvr.vars.V)
+		varValue, err := EvaluateExpressionContext(pvr.evalContext(), s, pvr.vars.V)
 		if err != nil {
 			log.Error().Err(err).Msg(semLogContext)
 		}
@@ -313,7 +419,7 @@ func (pvr *Evaluator) EvalToBool(s string) (bool, error) {
 	boolVal := true
 
 	if s != "" {
-		exprValue, err := gval.Evaluate(s, pvr.vars.V)
+		exprValue, err := EvaluateExpressionContext(pvr.evalContext(), s, pvr.vars.V)
 		if err != nil {
 			return false, err
 		}
@@ -348,6 +454,9 @@ func (pvr *Evaluator) VarResolverFunc(_, s string) (string, bool) {
 	if variable.Prefix == varResolver.VariablePrefixNotSpecified {
 		pfix, err = pvr.getPrefix(variable.Name)
 		if err != nil {
+			// the evaluator has no data for the prefix (i.e. no body for a json-path): the variable cannot be resolved.
+			log.Info().Err(err).Str("var-name", s).Msg(semLogContext + " could not resolve variable!")
+			pvr.addUnresolved(variable, s)
 			return "", variable.Deferred
 		}
 	}
@@ -418,6 +527,7 @@ func (pvr *Evaluator) VarResolverFunc(_, s string) (string, bool) {
 		vComp := strings.Split(s[2:], ",")
 		varValue, err = globals.GetGlobalVar("", variable.Name, "")
 		if err == nil {
+			ok = true
 			if reflect.ValueOf(varValue).Kind() == reflect.Func {
 				varValue = pvr.evaluateFunction(varValue, variable.Name, vComp[1:]...)
 				skipVariableOpts = true
@@ -429,12 +539,30 @@ func (pvr *Evaluator) VarResolverFunc(_, s string) (string, bool) {
 			log.Error().Err(err).Msg(semLogContext)
 		}
 
+	case HarEntryVariablePrefix:
+		varValue, err = ResolveHarEntryReference(pvr.entries, strings.TrimPrefix(variable.Name, HarEntryVariablePrefix))
+		if err == nil && varValue != nil {
+			ok = true
+		}
+
+	case SecretVariablePrefix:
+		if pvr.secrets {
+			varValue, err = secrets.Resolve(strings.TrimPrefix(variable.Name, SecretVariablePrefix))
+			ok = err == nil
+		} else {
+			log.Warn().Str("var-name", variable.Name).Msg(semLogContext + " secret references are resolved in headers and credentials only")
+		}
+
+	case string(varResolver.VariablePrefixEnv):
+		varValue, ok = lookupEnv(variable.Name, false)
+
 	default:
-		varValue, ok = os.LookupEnv(s)
+		varValue, ok = lookupEnv(variable.Name, true)
 	}
 
 	if !ok {
 		log.Info().Str("var-name", s).Msg(semLogContext + " could not resolve variable!")
+		pvr.addUnresolved(variable, s)
 	}
 
 	if err != nil {
@@ -596,6 +724,27 @@ func (pvr *Evaluator) resolveJsonPathExpr(v interface{}) (string, error) {
 }
 */
 
+// ReferencePrefix returns the prefix a variable reference (i.e. the text between the braces) is resolved with, env for the environment variables.
+func (pvr *Evaluator) ReferencePrefix(s string) (string, error) {
+	variable, err := varResolver.ParseVariable(strings.TrimPrefix(s, "!"))
+	if err != nil {
+		return "", err
+	}
+
+	if variable.Prefix != varResolver.VariablePrefixNotSpecified {
+		return string(variable.Prefix), nil
+	}
+
+	return pvr.getPrefix(variable.Name)
+}
+
+// addUnresolved records the reference in strict mode unless it provides a default value.
+func (pvr *Evaluator) addUnresolved(variable varResolver.Variable, s string) {
+	if pvr.strict.Enabled && !variable.IsTagPresent(varResolver.FormatOptOnf) {
+		pvr.unresolved = append(pvr.unresolved, s)
+	}
+}
+
 func (pvr *Evaluator) getPrefix(s string) (string, error) {
 
 	matchedPrefix := "env"
@@ -626,11 +775,17 @@ func (pvr *Evaluator) getPrefix(s string) (string, error) {
 			isValid = true
 		}
 	case "v:":
-		if pvr.vars.V != nil {
+		if pvr.vars != nil && pvr.vars.V != nil {
 			isValid = true
 		}
 	case "g:":
 		isValid = true
+	case HarEntryVariablePrefix:
+		if pvr.entries != nil {
+			isValid = true
+		}
+	case SecretVariablePrefix:
+		isValid = true
 	case "env":
 		isValid = true
 	}
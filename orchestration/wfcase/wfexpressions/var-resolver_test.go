@@ -2,9 +2,16 @@ package wfexpressions_test
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/secrets"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/smperror"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
 	"github.com/PaesslerAG/jsonpath"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 	"testing"
+	"time"
 )
 
 var j1 = []byte(`
@@ -87,3 +94,142 @@ func TestJsonPath(t *testing.T) {
 	}
 
 }
+
+func TestStrictMode(t *testing.T) {
+	vars := wfexpressions.NewProcessVars()
+	require.NoError(t, vars.Set("customer", "acme", false, 0, false))
+
+	pvr, err := wfexpressions.NewEvaluator("request", wfexpressions.WithProcessVars(vars), wfexpressions.WithBody("application/json", j1, ""))
+	require.NoError(t, err)
+
+	s, err := pvr.Interpolate(`{v:customer}-{v:custmer}-{$.missing}`)
+	require.NoError(t, err)
+	require.Equal(t, "acme--", s)
+
+	pvr.WithStrictMode(wfexpressions.StrictMode{Enabled: true, Activity: "get-customer"})
+	s, err = pvr.Interpolate(`{v:customer}-{v:custmer,onf=none}-{$["can-ale"]}`)
+	require.NoError(t, err)
+	require.Equal(t, "acme-none-APPP", s)
+
+	_, err = pvr.Interpolate(`{v:customer}-{v:custmer}-{$.missing}`)
+	var smpErr *smperror.SymphonyError
+	require.ErrorAs(t, err, &smpErr)
+	require.Equal(t, "get-customer", smpErr.Ambit)
+	require.Contains(t, smpErr.Description, "v:custmer, $.missing")
+
+	// the evaluator has no data for the prefix of the references.
+	pvr, err = wfexpressions.NewEvaluator("request")
+	require.NoError(t, err)
+	pvr.WithStrictMode(wfexpressions.StrictMode{Enabled: true, Activity: "get-customer"})
+	for _, ref := range []string{"{$.x}", "{h:x}", "{v:x}", "{" + wfexpressions.HarEntryVariablePrefix + "x:response:body}"} {
+		_, err = pvr.Interpolate(ref)
+		require.ErrorAs(t, err, &smpErr, ref)
+		require.Contains(t, smpErr.Description, ref[1:len(ref)-1])
+	}
+
+	pvr.WithStrictMode(wfexpressions.StrictMode{})
+	s, err = pvr.Interpolate("{$.x}-{h:x}-{v:x}-{" + wfexpressions.HarEntryVariablePrefix + "x:response:body}")
+	require.NoError(t, err)
+	require.Equal(t, "---", s)
+}
+
+func TestHarEntryReferences(t *testing.T) {
+	entries := map[string]*har.Entry{
+		"request#0": {
+			Request: &har.Request{Headers: har.NameValuePairs{{Name: "X-Request-Id", Value: "r-1"}}},
+		},
+		"get-customer#0": {
+			Request:  &har.Request{},
+			Response: &har.Response{Status: 200, Content: &har.Content{MimeType: "application/json", Data: []byte(`{"name":"acme","accounts":[{"id":"a1"},{"id":"a2"}]}`)}},
+		},
+		"get-customer#1": {
+			Request:  &har.Request{},
+			Response: &har.Response{Status: 404},
+		},
+		"get-template#0": {
+			Request:  &har.Request{},
+			Response: &har.Response{Status: 200, Content: &har.Content{MimeType: "application/json", Data: []byte(`{"text":"hello {v:customer}"}`)}},
+		},
+	}
+
+	lookup := func(entryId string) (*har.Entry, error) {
+		e, ok := entries[entryId]
+		if !ok {
+			return nil, fmt.Errorf("cannot find entry %s", entryId)
+		}
+		return e, nil
+	}
+
+	vars := wfexpressions.NewProcessVars()
+	vars.WithHarEntryLookup(lookup)
+	pvr, err := wfexpressions.NewEvaluator("request", wfexpressions.WithProcessVars(vars), wfexpressions.WithHarEntries(lookup))
+	require.NoError(t, err)
+
+	s, err := pvr.Interpolate(`{e:get-customer#0:response:body:$.name}/{e:get-customer#0:response:body:$.accounts[1].id}/{e:request#0:request:headers:X-Request-Id}/{e:get-customer#1:response:status}`)
+	require.NoError(t, err)
+	require.Equal(t, "acme/a2/r-1/404", s)
+
+	// the values of the entries are not interpolated in turn.
+	require.NoError(t, vars.Set("customer", "acme", false, 0, false))
+	s, err = pvr.Interpolate(`{v:customer}: {e:get-template#0:response:body:$.text}`)
+	require.NoError(t, err)
+	require.Equal(t, "acme: hello {v:customer}", s)
+
+	ok, err := vars.EvalToBool(`entry("get-customer#0:response:status") == 200 && entry("get-customer#0:response:body:$.name") == "acme"`)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = vars.Eval(`entry("get-customer#0:response:trailers")`)
+	require.Error(t, err)
+}
+
+func TestEnvVarsPolicy(t *testing.T) {
+	t.Setenv("CHORUS_REGION", "eu")
+	t.Setenv("CHORUS_SECRET", "s3cr3t")
+	defer wfexpressions.SetEnvVarsPolicy(wfexpressions.GetEnvVarsPolicy())
+
+	pvr, err := wfexpressions.NewEvaluator("request", wfexpressions.WithProcessVars(wfexpressions.NewProcessVars()))
+	require.NoError(t, err)
+
+	wfexpressions.SetEnvVarsPolicy(wfexpressions.EnvVarsPolicy{})
+	s, err := pvr.Interpolate(`{CHORUS_REGION}-{env:CHORUS_SECRET}`)
+	require.NoError(t, err)
+	require.Equal(t, "eu-s3cr3t", s)
+
+	wfexpressions.SetEnvVarsPolicy(wfexpressions.EnvVarsPolicy{Allowed: []string{"CHORUS_REG*"}})
+	s, err = pvr.Interpolate(`{CHORUS_REGION}-{env:CHORUS_SECRET}`)
+	require.NoError(t, err)
+	require.Equal(t, "eu-", s)
+
+	// the policy as read from the config of the application.
+	var policy wfexpressions.EnvVarsPolicy
+	require.NoError(t, yaml.Unmarshal([]byte("secure-mode: true\nallowed: [ CHORUS_REGION ]\n"), &policy))
+	wfexpressions.SetEnvVarsPolicy(policy)
+	s, err = pvr.Interpolate(`{CHORUS_REGION}-{env:CHORUS_REGION}-{env:CHORUS_SECRET}`)
+	require.NoError(t, err)
+	require.Equal(t, "-eu-", s)
+}
+
+func TestSecretReferences(t *testing.T) {
+	secrets.SetProvider(secrets.NewFakeProvider(map[string]string{"api-key": "k3y-value"}), time.Minute)
+
+	pvr, err := wfexpressions.NewEvaluator("request", wfexpressions.WithProcessVars(wfexpressions.NewProcessVars()))
+	require.NoError(t, err)
+
+	// the interpolated value is trimmed.
+	s, err := pvr.Interpolate(`Bearer {secret:api-key}`)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer", s)
+
+	restore := pvr.WithSecrets()
+	s, err = pvr.Interpolate(`Bearer {secret:api-key}`)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer k3y-value", s)
+	require.Equal(t, "Bearer "+secrets.MaskedValue, secrets.Mask(s))
+
+	// the references are disabled again once restored.
+	restore()
+	s, err = pvr.Interpolate(`Bearer {secret:api-key}`)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer", s)
+}
@@ -1,10 +1,10 @@
 package wfexpressions
 
 import (
+	"context"
 	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/config"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/globals"
-	"github.com/PaesslerAG/gval"
 	"github.com/rs/zerolog/log"
 	"reflect"
 	"regexp"
@@ -33,6 +33,15 @@ type PVMetadata struct {
 type ProcessVars struct {
 	V PVValues
 	M map[string]PVMetadata
+
+	// entries give access to the har entries of the case in the expressions through the entry function.
+	entries HarEntryLookup
+
+	// language is the default language of the expressions, gval if empty.
+	language string
+
+	// types are the declared types of the variables, the values are coerced on set.
+	types map[string]VarType
 }
 type PVValues map[string]interface{}
 
@@ -43,6 +52,19 @@ func NewProcessVars() *ProcessVars {
 	}
 }
 
+func (vs *ProcessVars) WithHarEntryLookup(lookup HarEntryLookup) {
+	vs.entries = lookup
+}
+
+// WithExpressionLanguage sets the default language of the expressions evaluated against the vars. The expressions prefixed by cel: or gval: are not affected.
+func (vs *ProcessVars) WithExpressionLanguage(lang string) {
+	vs.language = lang
+}
+
+func (vs *ProcessVars) evalContext() context.Context {
+	return withVarTypes(withExpressionLanguage(withHarEntryLookup(context.Background(), vs.entries), vs.language), vs.types)
+}
+
 func (vs *ProcessVars) ClearTemporary(temps []string) {
 	if vs != nil && len(vs.V) > 0 {
 		for _, n := range temps {
@@ -139,6 +161,13 @@ func (vs ProcessVars) InterpolateEvaluateAndSet(n string, expr string, resolver
 func (vs *ProcessVars) Set(n string, value interface{}, globalScope bool, ttl time.Duration, asDltHeader bool) error {
 	var err error
 
+	if t, ok := vs.types[n]; ok {
+		value, err = t.Coerce(value)
+		if err != nil {
+			return fmt.Errorf("process var %s: %w", n, err)
+		}
+	}
+
 	if globalScope {
 		err = globals.SetGlobalVar("", n, value, ttl)
 	} else {
@@ -156,7 +185,7 @@ func (vs *ProcessVars) Set(n string, value interface{}, globalScope bool, ttl ti
 type EvaluationMode string
 
 func (vs *ProcessVars) Eval(v string) (interface{}, error) {
-	return gval.Evaluate(v, vs.V)
+	return EvaluateExpressionContext(vs.evalContext(), v, vs.V)
 }
 
 func (vs *ProcessVars) Lookup(v string, defaultValue interface{}) (interface{}, bool) {
@@ -178,7 +207,7 @@ func (vs *ProcessVars) EvalToBool(v string) (bool, error) {
 	boolVal := true
 
 	if v != "" {
-		exprValue, err := gval.Evaluate(v, vs.V)
+		exprValue, err := EvaluateExpressionContext(vs.evalContext(), v, vs.V)
 		if err != nil {
 			return false, err
 		}
@@ -196,7 +225,7 @@ func (vs *ProcessVars) EvalToString(v string) (string, error) {
 	const semLogContext = "process-vars::eval-2-string"
 	s := ""
 	if v != "" {
-		exprValue, err := gval.Evaluate(v, vs.V)
+		exprValue, err := EvaluateExpressionContext(vs.evalContext(), v, vs.V)
 		if err != nil {
 			return s, err
 		}
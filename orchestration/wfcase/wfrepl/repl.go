@@ -0,0 +1,411 @@
+package wfrepl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfexpressions"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms/jq"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/xforms/kz"
+	varResolver "github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util/vars"
+)
+
+const (
+	Prompt = "chorus> "
+
+	// FileArgPrefix marks the arguments of kazaam, jq and tmpl to be read from a file, e.g. 'jq @filter.jq'.
+	FileArgPrefix = "@"
+)
+
+// Resolution is a variable reference resolved during an interpolation with the prefix that matched it.
+type Resolution struct {
+	Reference string
+	Prefix    string
+	Value     string
+}
+
+// Session evaluates the commands against a case, usually rebuilt from a recorded har with wfcase.NewWorkflowCaseFromHar. The expressions are evaluated in the
+// context of an entry of the case: the request of the initial request, the response of the other entries, the same as the activities do.
+type Session struct {
+	Wfc *wfcase.WfCase
+	Ctx wfcase.HarEntryReference
+	out io.Writer
+}
+
+type command struct {
+	usage string
+	exec  func(s *Session, arg string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ctx":      {usage: "ctx <entry-id> [request|response]  sets the entry the expressions are evaluated against", exec: (*Session).execCtx},
+		"entries":  {usage: "entries  lists the entries of the case", exec: (*Session).execEntries},
+		"vars":     {usage: "vars  lists the process vars", exec: (*Session).execVars},
+		"set":      {usage: "set <name> <value>  sets a process var, values prefixed by ':' are expressions", exec: (*Session).execSet},
+		"interp":   {usage: "interp <text>  interpolates the variable references of the text", exec: (*Session).execInterp},
+		"eval":     {usage: "eval <expr>  interpolates and evaluates an expression, gval or cel: prefixed", exec: (*Session).execEval},
+		"jsonpath": {usage: "jsonpath <path>  selects from the body of the entry", exec: (*Session).execJsonPath},
+		"kazaam":   {usage: "kazaam <spec|@file>  transforms the body of the entry", exec: (*Session).execKazaam},
+		"jq":       {usage: "jq <filter|@file>  transforms the body of the entry", exec: (*Session).execJq},
+		"tmpl":     {usage: "tmpl <template|@file>  interpolates and processes a template", exec: (*Session).execTmpl},
+		"help":     {usage: "help  shows the commands", exec: (*Session).execHelp},
+	}
+}
+
+func NewSession(wfc *wfcase.WfCase, out io.Writer) *Session {
+	return &Session{Wfc: wfc, Ctx: wfcase.InitialRequestHarEntryReference, out: out}
+}
+
+// Run reads the commands from in until the end of the input or quit. Errors of the commands are printed, not returned.
+func (s *Session) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		_, _ = fmt.Fprint(s.out, Prompt)
+		if !scanner.Scan() {
+			_, _ = fmt.Fprintln(s.out)
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "quit" || line == "exit" {
+			return nil
+		}
+
+		if err := s.Exec(line); err != nil {
+			_, _ = fmt.Fprintf(s.out, "error: %v\n", err)
+		}
+	}
+}
+
+func (s *Session) Exec(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+
+	name, arg, _ := strings.Cut(line, " ")
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %s, type help for the list of commands", name)
+	}
+
+	return cmd.exec(s, strings.TrimSpace(arg))
+}
+
+// Interpolate resolves the variable references of the text in the context of the session and reports how each of them has been resolved.
+func (s *Session) Interpolate(text string) (string, []Resolution, error) {
+	evaluator, err := s.evaluator()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var resolutions []Resolution
+	resolved, _, err := varResolver.ResolveVariables(text, varResolver.SimpleVariableReference, func(current, ref string) (string, bool) {
+		v, deferred := evaluator.VarResolverFunc(current, ref)
+		prefix, err := evaluator.ReferencePrefix(ref)
+		if err != nil {
+			prefix = "unresolvable"
+		}
+		resolutions = append(resolutions, Resolution{Reference: ref, Prefix: prefix, Value: v})
+		return v, deferred
+	}, true)
+
+	return resolved, resolutions, err
+}
+
+func (s *Session) evaluator() (*wfexpressions.Evaluator, error) {
+	e, err := s.Wfc.GetHarEntry(s.Ctx.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if (s.Ctx.UseResponse && e.Response == nil) || (!s.Ctx.UseResponse && e.Request == nil) {
+		return nil, fmt.Errorf("entry %s has no %s", s.Ctx.Name, s.ctxPart())
+	}
+
+	return s.Wfc.GetEvaluatorByHarEntryReference(s.Ctx, true, "", false)
+}
+
+func (s *Session) ctxPart() string {
+	if s.Ctx.UseResponse {
+		return wfexpressions.HarEntryPartResponse
+	}
+	return wfexpressions.HarEntryPartRequest
+}
+
+func (s *Session) ctxBody() ([]byte, error) {
+	e, err := s.Wfc.GetHarEntry(s.Ctx.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case s.Ctx.UseResponse && e.Response != nil && e.Response.Content != nil:
+		return e.Response.Content.Data, nil
+	case !s.Ctx.UseResponse && e.Request != nil && e.Request.PostData != nil:
+		return e.Request.PostData.Data, nil
+	}
+
+	return nil, fmt.Errorf("entry %s has no %s body", s.Ctx.Name, s.ctxPart())
+}
+
+func (s *Session) interpolateAndShow(text string) (string, error) {
+	resolved, resolutions, err := s.Interpolate(text)
+	if err != nil {
+		return "", err
+	}
+
+	for _, r := range resolutions {
+		_, _ = fmt.Fprintf(s.out, "  {%s} [%s] -> %q\n", r.Reference, r.Prefix, r.Value)
+	}
+
+	return resolved, nil
+}
+
+func (s *Session) execCtx(arg string) error {
+	id, part, _ := strings.Cut(arg, " ")
+	if id == "" {
+		_, _ = fmt.Fprintf(s.out, "%s %s\n", s.Ctx.Name, s.ctxPart())
+		return nil
+	}
+
+	ref := wfcase.HarEntryReference{Name: id, UseResponse: id != wfcase.InitialRequestHarEntryId}
+	switch strings.TrimSpace(part) {
+	case "":
+	case wfexpressions.HarEntryPartRequest:
+		ref.UseResponse = false
+	case wfexpressions.HarEntryPartResponse:
+		ref.UseResponse = true
+	default:
+		return fmt.Errorf("invalid entry part %s", part)
+	}
+
+	if _, err := s.Wfc.GetHarEntry(id); err != nil {
+		return err
+	}
+
+	s.Ctx = ref
+	return nil
+}
+
+func (s *Session) execEntries(_ string) error {
+	ids := make([]string, 0, len(s.Wfc.Entries))
+	for id := range s.Wfc.Entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		e := s.Wfc.Entries[id]
+		line := id
+		if e.Request != nil {
+			line += fmt.Sprintf("  %s %s", e.Request.Method, e.Request.URL)
+		}
+		if e.Response != nil {
+			line += fmt.Sprintf("  -> %d", e.Response.Status)
+		}
+		_, _ = fmt.Fprintln(s.out, line)
+	}
+
+	return nil
+}
+
+func (s *Session) execVars(_ string) error {
+	names := make([]string, 0, len(s.Wfc.Vars.V))
+	for n, v := range s.Wfc.Vars.V {
+		if v == nil || reflect.TypeOf(v).Kind() != reflect.Func {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		_, _ = fmt.Fprintf(s.out, "%s = %s\n", n, formatValue(s.Wfc.Vars.V[n]))
+	}
+
+	return nil
+}
+
+func (s *Session) execSet(arg string) error {
+	name, value, ok := strings.Cut(arg, " ")
+	if !ok || name == "" {
+		return errors.New("usage: " + commands["set"].usage)
+	}
+
+	expr, isExpr := wfcase.IsExpression(strings.TrimSpace(value))
+	resolved, err := s.interpolateAndShow(expr)
+	if err != nil {
+		return err
+	}
+
+	var v interface{} = resolved
+	if isExpr && resolved != "" {
+		v, err = s.Wfc.Vars.Eval(resolved)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.Wfc.Vars.Set(name, v, false, 0, false)
+}
+
+func (s *Session) execInterp(arg string) error {
+	resolved, err := s.interpolateAndShow(arg)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(s.out, "= %s\n", resolved)
+	return nil
+}
+
+func (s *Session) execEval(arg string) error {
+	expr, _ := wfcase.IsExpression(arg)
+	resolved, err := s.interpolateAndShow(expr)
+	if err != nil {
+		return err
+	}
+
+	evaluator, err := s.evaluator()
+	if err != nil {
+		return err
+	}
+
+	v, err := evaluator.Eval(resolved)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(s.out, "= %s (%T)\n", formatValue(v), v)
+	return nil
+}
+
+func (s *Session) execJsonPath(arg string) error {
+	v, err := wfexpressions.ResolveHarEntryReference(s.Wfc.GetHarEntry, strings.Join([]string{s.Ctx.Name, s.ctxPart(), wfexpressions.HarEntryPartBody, arg}, ":"))
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(s.out, "= %s\n", formatValue(v))
+	return nil
+}
+
+func (s *Session) execKazaam(arg string) error {
+	spec, err := readArg(arg)
+	if err != nil {
+		return err
+	}
+
+	body, err := s.ctxBody()
+	if err != nil {
+		return err
+	}
+
+	out, err := kz.ApplyKazaamTransformation(spec, body)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(s.out, "= %s\n", formatJson(out))
+	return nil
+}
+
+func (s *Session) execJq(arg string) error {
+	filter, err := readArg(arg)
+	if err != nil {
+		return err
+	}
+
+	body, err := s.ctxBody()
+	if err != nil {
+		return err
+	}
+
+	out, err := jq.ApplyJQTransformationToJson(filter, body)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(s.out, "= %s\n", formatJson(out))
+	return nil
+}
+
+func (s *Session) execTmpl(arg string) error {
+	tmpl, err := readArg(arg)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := s.interpolateAndShow(string(tmpl))
+	if err != nil {
+		return err
+	}
+
+	out, err := s.Wfc.ProcessTemplate(resolved)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(s.out, "= %s\n", string(out))
+	return nil
+}
+
+func (s *Session) execHelp(_ string) error {
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		_, _ = fmt.Fprintln(s.out, commands[n].usage)
+	}
+	_, _ = fmt.Fprintln(s.out, "quit  ends the session")
+	return nil
+}
+
+func readArg(arg string) ([]byte, error) {
+	if fn, ok := strings.CutPrefix(arg, FileArgPrefix); ok {
+		return os.ReadFile(fn)
+	}
+
+	if arg == "" {
+		return nil, errors.New("missing argument")
+	}
+
+	return []byte(arg), nil
+}
+
+func formatValue(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err == nil {
+			return string(b)
+		}
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+
+	return fmt.Sprint(v)
+}
+
+func formatJson(data []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return string(data)
+	}
+
+	return formatValue(v)
+}
@@ -0,0 +1,54 @@
+package wfrepl_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-chorus/orchestration/wfcase/wfrepl"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/stretchr/testify/require"
+)
+
+var recordedHar = []byte(`
+{
+  "log": {
+    "version": "1.1",
+    "comment": "sample",
+    "entries": [
+      { "comment": "request#0", "request": { "method": "POST", "url": "/api", "headers": [{ "name": "requestId", "value": "r-1" }], "postData": { "mimeType": "application/json", "text": "{\"customer\":\"acme\"}" } } },
+      { "comment": "ep01#0", "response": { "status": 200, "headers": [], "content": { "mimeType": "application/json", "text": "{\"amount\":20,\"items\":[1,2]}" } } }
+    ]
+  }
+}
+`)
+
+func TestSession(t *testing.T) {
+	var h har.HAR
+	require.NoError(t, json.Unmarshal(recordedHar, &h))
+
+	wfc, err := wfcase.NewWorkflowCaseFromHar(&h, map[string]interface{}{"threshold": 10.0})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	s := wfrepl.NewSession(wfc, &out)
+
+	resolved, resolutions, err := s.Interpolate("{$.customer}-{h:requestId}-{v:threshold}")
+	require.NoError(t, err)
+	// the numbers are float64 and are interpolated with %f as in the orchestrations.
+	require.Equal(t, "acme-r-1-10.000000", resolved)
+	require.Equal(t, []string{"$.", "h:", "v:"}, []string{resolutions[0].Prefix, resolutions[1].Prefix, resolutions[2].Prefix})
+
+	require.NoError(t, s.Exec("ctx ep01"))
+	require.NoError(t, s.Exec("eval {$.amount} > threshold"))
+	require.Contains(t, out.String(), "= true")
+
+	out.Reset()
+	require.NoError(t, s.Exec("jq .items | length"))
+	require.Equal(t, "= 2", strings.TrimSpace(out.String()))
+
+	require.Error(t, s.Exec("ctx missing"))
+	require.Error(t, s.Exec("unknown"))
+}